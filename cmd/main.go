@@ -3,6 +3,7 @@ package main
 import (
 	"boton-back/internal/app"
 	"boton-back/internal/config"
+	"boton-back/internal/lib/logger/sl"
 	"context"
 	"fmt"
 	"log/slog"
@@ -36,7 +37,8 @@ $$ |            \$$$$$$$ |      $$$$$$$$\       $$ |  $$ |      \$$$$$$$ |
 
 	log.Info("Starting http", "env", cfg.Server.Env)
 
-	ctx, _ := context.WithCancel(context.Background())
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
 	application := app.New(
 		ctx,
@@ -45,16 +47,23 @@ $$ |            \$$$$$$$ |      $$$$$$$$\       $$ |  $$ |      \$$$$$$$ |
 	)
 
 	go application.HTTPServer.MustRun()
+	application.Workers.Start(ctx)
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	<-ctx.Done()
+	stop()
 
-	sign := <-stop
+	log.Info("Application stopping")
 
-	log.Info("Application stopped", slog.String("signal", sign.String()))
+	// requests and jobs in flight get the shutdown timeout to finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
 
-	err := application.HTTPServer.Stop(ctx)
-	if err != nil {
-		panic(err)
+	if err := application.HTTPServer.Stop(shutdownCtx); err != nil {
+		log.Error("failed to stop http server", sl.Err(err))
 	}
+
+	application.Jobs.Stop(shutdownCtx)
+	application.Workers.Stop()
+
+	log.Info("Application stopped")
 }
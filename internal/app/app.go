@@ -4,18 +4,35 @@ import (
 	httpserver "boton-back/internal/app/http-server"
 	"boton-back/internal/config"
 	"boton-back/internal/handlers"
+	"boton-back/internal/jobs"
+	"boton-back/internal/lib/blob"
+	"boton-back/internal/lib/broker"
+	"boton-back/internal/lib/cookies"
+	"boton-back/internal/lib/geoip"
 	"boton-back/internal/lib/jwt"
+	"boton-back/internal/lib/mailer"
+	"boton-back/internal/lib/payments"
+	"boton-back/internal/lib/signedurl"
+	"boton-back/internal/mail"
 	"boton-back/internal/middlewares"
+	"boton-back/internal/realtime"
 	"boton-back/internal/repository/postgres"
 	"boton-back/internal/repository/redis"
 	"boton-back/internal/routes"
 	"boton-back/internal/services"
+	"boton-back/internal/workers"
 	"context"
 	"log/slog"
 )
 
+// devMailLimit is the number of emails the memory mailer keeps for the dev viewer.
+const devMailLimit = 100
+
 type App struct {
 	HTTPServer *httpserver.Server
+	Workers    *workers.Runner
+	// Jobs is started by New; it is up to the caller to stop it on shutdown.
+	Jobs *jobs.Queue
 }
 
 func New(ctx context.Context, log *slog.Logger, cfg *config.Config) *App {
@@ -29,21 +46,235 @@ func New(ctx context.Context, log *slog.Logger, cfg *config.Config) *App {
 		panic(err)
 	}
 
+	redisDB.SetEventLogLimits(cfg.Realtime.EventLogSize, cfg.Realtime.EventLogRetention)
+
+	blobs, err := newBlobStore(cfg.Blob)
+	if err != nil {
+		panic(err)
+	}
+
+	renderer, err := mail.NewRenderer()
+	if err != nil {
+		panic(err)
+	}
+
+	mailSender, err := newMailer(cfg.Mail)
+	if err != nil {
+		panic(err)
+	}
+
+	geo, err := newGeoLocator(cfg.Login.GeoIPDBPath)
+	if err != nil {
+		panic(err)
+	}
+
+	queue := jobs.NewQueue(log, redisDB, cfg.Jobs.Concurrency, cfg.Jobs.PollInterval, cfg.Jobs.VisibilityTimeout, cfg.Jobs.MaxAttempts, cfg.Jobs.RetryBackoff)
+
 	jwtGenerator := jwt.NewGenerator(cfg.JWT.Secret, cfg.JWT.AccessExpirationMinutes, cfg.JWT.RefreshExpirationDays)
 
-	authService := services.NewAuthService(log, jwtGenerator, storage, redisDB)
+	mailService := services.NewMailService(log, storage, renderer, queue, mailSender, cfg.Mail.From)
+	jobs.Register(queue, services.SendEmailJob, mailService.Deliver)
+
+	notificationService := services.NewNotificationService(log, storage, redisDB, cfg.Notifications.Retention)
+	deviceService := services.NewDeviceService(log, storage, redisDB, geo, notificationService, mailService, services.LoginPolicy{
+		MaxTravelSpeed:    cfg.Login.MaxTravelSpeed,
+		ConfirmNewDevices: cfg.Login.ConfirmNewDevices,
+		ConfirmationTTL:   cfg.Login.ConfirmationTTL,
+		ConfirmationURL:   cfg.Login.ConfirmationURL,
+	})
+	ledgerService := services.NewLedgerService(log, storage, cfg.Ledger.Currencies)
+	transferService := services.NewTransferService(log, storage, ledgerService, notificationService, cfg.Transfers.DailyLimit)
+	promotionService := services.NewPromotionService(log, storage, ledgerService, cfg.Promotions.NewUserWindow)
+	referralService := services.NewReferralService(log, storage, storage, notificationService, services.ReferralPolicy{
+		Reward:         cfg.Referrals.Reward,
+		Currency:       cfg.Ledger.Currencies[0],
+		MaxPerReferrer: cfg.Referrals.MaxPerReferrer,
+		MinAccountAge:  cfg.Referrals.MinAccountAge,
+		MinFriends:     cfg.Referrals.MinFriends,
+		BatchSize:      cfg.Referrals.BatchSize,
+	})
+	verificationService := services.NewVerificationService(log, storage, redisDB, mailService, cfg.Mail.VerificationTTL, cfg.Mail.VerificationURL)
+	authService := services.NewAuthService(log, jwtGenerator, storage, redisDB, redisDB, notificationService, deviceService, referralService, cfg.Account.UsernamePolicy, cfg.Registration.Mode)
+	inviteService := services.NewInviteService(log, storage, cfg.Registration.UserInviteQuota, cfg.Registration.InviteTTL)
 	userService := services.NewUserService(log, storage)
+	exportService := services.NewExportService(log, storage, blobs, signedurl.NewSigner(cfg.Export.SigningSecret), cfg.Export.Retention, cfg.Export.URLTTL)
+	exportService.RegisterSource("account", storage.ExportAccount)
+	exportService.RegisterSource("exports", storage.ExportDataExports)
+	exportService.RegisterSource("profile", storage.ExportProfile)
+	exportService.RegisterSource("avatar", storage.ExportAvatar)
+	exportService.RegisterSource("friendships", storage.ExportFriendships)
+	exportService.RegisterSource("blocks", storage.ExportBlocks)
+	exportService.RegisterSource("privacy", storage.ExportPrivacySettings)
+	exportService.RegisterSource("notifications", storage.ExportNotifications)
+	exportService.RegisterSource("messages", storage.ExportMessages)
+	exportService.RegisterSource("devices", storage.ExportDevices)
+	exportService.RegisterSource("referrals", storage.ExportReferrals)
+	exportService.RegisterSource("ledger", storage.ExportLedger)
+	exportService.RegisterSource("transfers", storage.ExportTransfers)
+	exportService.RegisterSource("promotions", storage.ExportPromoRedemptions)
+	exportService.RegisterSource("payments", storage.ExportPayments)
+	friendService := services.NewFriendService(log, storage, redisDB, cfg.Friends.SuggestionsCacheTTL, redisDB, notificationService)
+	privacyService := services.NewPrivacyService(log, storage, redisDB)
+	messageService := services.NewMessageService(log, storage, redisDB, cfg.Messages.EditWindow, cfg.Messages.MaxLength)
+	presenceService := services.NewPresenceService(log, storage, redisDB, cfg.Presence.OnlineWindow, cfg.Presence.AwayTimeout)
+	avatarService := services.NewAvatarService(log, storage, blobs, cfg.Avatar.MaxBytes)
+	accountService := services.NewAccountService(log, storage, redisDB, redisDB, cfg.Account.DeletionGracePeriod, exportService, avatarService)
+
+	webhookService := services.NewWebhookService(log, storage, cfg.Webhooks.Timeout, cfg.Webhooks.BatchSize, cfg.Webhooks.MaxAttempts, cfg.Webhooks.RetryBackoff, cfg.Webhooks.DisableAfter)
+	outboxService := services.NewOutboxService(log, storage, broker.NewFanout(newBroker(cfg.Outbox, redisDB), webhookService, mailService, verificationService), cfg.Outbox.BatchSize, cfg.Outbox.MaxAttempts, cfg.Outbox.RetryBackoff)
+
+	hub := realtime.NewHub(log, cfg.Realtime.BufferSize)
+
+	cookieManager := cookies.NewManager(cfg.Cookie.Domain, cfg.Cookie.Path, cfg.Cookie.SameSite, cfg.Cookie.Secure, cfg.JWT.AccessExpirationMinutes, cfg.JWT.RefreshExpirationDays)
 
-	authHandler := handlers.NewAuthHandler(log, authService)
+	authHandler := handlers.NewAuthHandler(log, authService, cookieManager)
 	userHandler := handlers.NewUserHandler(log, userService)
+	accountHandler := handlers.NewAccountHandler(log, accountService, cookieManager)
+	exportHandler := handlers.NewExportHandler(log, exportService)
+	avatarHandler := handlers.NewAvatarHandler(log, avatarService)
+	friendHandler := handlers.NewFriendHandler(log, friendService)
+	privacyHandler := handlers.NewPrivacyHandler(log, privacyService)
+	presenceHandler := handlers.NewPresenceHandler(log, presenceService)
+	realtimeHandler := handlers.NewRealtimeHandler(log, hub, redisDB, cfg.Realtime.AllowedOrigins, cfg.Realtime.PingInterval)
+	notificationHandler := handlers.NewNotificationHandler(log, notificationService)
+	messageHandler := handlers.NewMessageHandler(log, messageService)
+	webhookHandler := handlers.NewWebhookHandler(log, webhookService)
+	deviceHandler := handlers.NewDeviceHandler(log, deviceService)
+	inviteHandler := handlers.NewInviteHandler(log, inviteService)
+	verificationHandler := handlers.NewVerificationHandler(log, verificationService)
+	referralHandler := handlers.NewReferralHandler(log, referralService)
+	ledgerHandler := handlers.NewLedgerHandler(log, ledgerService)
+	transferHandler := handlers.NewTransferHandler(log, transferService)
+	promotionHandler := handlers.NewPromotionHandler(log, promotionService)
 
-	authMiddleware := middlewares.NewAuthMiddleware(jwtGenerator)
+	var devMailHandler *handlers.DevMailHandler
+	if outbox, ok := mailSender.(mailer.Lister); ok && cfg.Server.Env == "local" {
+		devMailHandler = handlers.NewDevMailHandler(log, outbox)
+	}
+
+	// top-ups are only offered with a payment provider configured
+	var (
+		paymentService    *services.PaymentService
+		paymentHandler    *handlers.PaymentHandler
+		devPaymentHandler *handlers.DevPaymentHandler
+	)
+	if paymentProvider := newPaymentProvider(cfg.Payments); paymentProvider != nil {
+		paymentService = services.NewPaymentService(log, storage, paymentProvider, ledgerService, notificationService, services.PaymentPolicy{
+			MinAmount:      cfg.Payments.MinAmount,
+			MaxAmount:      cfg.Payments.MaxAmount,
+			ReturnURL:      cfg.Payments.ReturnURL,
+			ReconcileAfter: cfg.Payments.ReconcileAfter,
+			BatchSize:      cfg.Payments.BatchSize,
+		})
+		paymentHandler = handlers.NewPaymentHandler(log, paymentService)
+
+		if fake, ok := paymentProvider.(*payments.Fake); ok && cfg.Server.Env == "local" {
+			devPaymentHandler = handlers.NewDevPaymentHandler(log, fake, paymentService)
+		}
+	}
+
+	authMiddleware := middlewares.NewAuthMiddleware(jwtGenerator, redisDB)
+	csrfMiddleware := middlewares.NewCSRFMiddleware()
+	searchRateLimit := middlewares.NewRateLimitMiddleware(log, redisDB, "search", cfg.Search.RateLimit, cfg.Search.RateWindow)
+	adminMiddleware := middlewares.NewAdminMiddleware(cfg.Admin.APIKey)
 
-	r := routes.InitRoutes(authHandler, userHandler, authMiddleware)
+	r := routes.InitRoutes(routes.Handlers{
+		Auth:         authHandler,
+		User:         userHandler,
+		Account:      accountHandler,
+		Export:       exportHandler,
+		Avatar:       avatarHandler,
+		Friend:       friendHandler,
+		Privacy:      privacyHandler,
+		Presence:     presenceHandler,
+		Realtime:     realtimeHandler,
+		Notification: notificationHandler,
+		Message:      messageHandler,
+		Webhook:      webhookHandler,
+		Device:       deviceHandler,
+		Invite:       inviteHandler,
+		Verification: verificationHandler,
+		Referral:     referralHandler,
+		Ledger:       ledgerHandler,
+		Transfer:     transferHandler,
+		Promotion:    promotionHandler,
+		Payment:      paymentHandler,
+		DevMail:      devMailHandler,
+		DevPayment:   devPaymentHandler,
+	}, routes.Middlewares{
+		Auth:            authMiddleware,
+		CSRF:            csrfMiddleware,
+		SearchRateLimit: searchRateLimit,
+		Admin:           adminMiddleware,
+	})
 
 	server := httpserver.NewServer(log, cfg.Server.AuthAddress, cfg.Server.AuthTimeout, r)
 
+	queue.Schedule("purge-deleted-accounts", jobs.Every(cfg.Account.PurgeInterval), accountService.PurgeDeletedAccounts)
+	queue.Schedule("prune-notifications", jobs.Every(cfg.Notifications.PruneInterval), notificationService.PruneOld)
+	queue.Schedule("credit-referrals", jobs.Every(cfg.Referrals.CheckInterval), referralService.CreditQualified)
+	if paymentService != nil {
+		queue.Schedule("reconcile-payments", jobs.Every(cfg.Payments.ReconcileInterval), paymentService.Reconcile)
+	}
+	queue.Schedule("process-data-exports", jobs.Every(cfg.Export.ProcessInterval), exportService.ProcessPendingExports)
+	queue.Schedule("cleanup-data-exports", jobs.Every(cfg.Export.ProcessInterval), exportService.CleanupExpiredExports)
+	queue.Schedule("relay-outbox", jobs.Every(cfg.Outbox.RelayInterval), outboxService.Relay)
+	queue.Schedule("deliver-webhooks", jobs.Every(cfg.Webhooks.DeliveryInterval), webhookService.DeliverPending)
+	queue.Start(ctx)
+
+	// the realtime hub and the last seen flush run on every instance
+	runner := workers.NewRunner(log)
+	runner.Every("flush-last-seen", cfg.Presence.FlushInterval, presenceService.FlushLastSeen)
+	runner.Run("realtime-hub", func(ctx context.Context) error {
+		return hub.Run(ctx, redisDB)
+	})
+
 	return &App{
 		HTTPServer: server,
+		Workers:    runner,
+		Jobs:       queue,
+	}
+}
+
+func newBlobStore(cfg config.BlobConfig) (blob.Store, error) {
+	if cfg.Driver == "s3" {
+		return blob.NewS3Store(cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Region, cfg.S3AccessKey, cfg.S3SecretKey)
+	}
+	return blob.NewLocalStore(cfg.LocalDir)
+}
+
+func newBroker(cfg config.OutboxConfig, redisDB *redis.Storage) broker.Broker {
+	if cfg.Broker == "memory" {
+		return broker.NewMemory()
+	}
+	return redis.NewStreamBroker(redisDB, cfg.Stream, cfg.StreamMaxLen)
+}
+
+func newMailer(cfg config.MailConfig) (mailer.Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return mailer.NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword), nil
+	case "memory":
+		return mailer.NewMemory(devMailLimit), nil
+	default:
+		return mailer.NewFile(cfg.FileDir)
+	}
+}
+
+// newPaymentProvider returns the provider top-ups are paid with, or nil when none is
+// configured. The configuration only accepts the fake provider, in local, until a real one is
+// integrated.
+func newPaymentProvider(cfg config.PaymentsConfig) payments.Provider {
+	if cfg.Provider == "fake" {
+		return payments.NewFake(cfg.FakeSecret, cfg.FakeCheckoutURL, cfg.WebhookTolerance)
+	}
+	return nil
+}
+
+// newGeoLocator loads the IP database at path. Without one, logins are not located.
+func newGeoLocator(path string) (services.GeoLocator, error) {
+	if path == "" {
+		return nil, nil
 	}
+	return geoip.Open(path)
 }
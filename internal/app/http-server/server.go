@@ -2,6 +2,7 @@ package http_server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"log/slog"
@@ -10,21 +11,25 @@ import (
 )
 
 type Server struct {
-	log          *slog.Logger
-	port         string
-	handler      *gin.Engine
-	httpServer   *http.Server
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
+	log        *slog.Logger
+	port       string
+	handler    *gin.Engine
+	httpServer *http.Server
 }
 
 func NewServer(log *slog.Logger, port string, timeout time.Duration, handler *gin.Engine) *Server {
 	return &Server{
-		log:          log,
-		port:         port,
-		handler:      handler,
-		ReadTimeout:  timeout,
-		WriteTimeout: timeout,
+		log:     log,
+		port:    port,
+		handler: handler,
+		// no ReadTimeout: it would cancel the requests of long-lived streams such as SSE. Streaming
+		// handlers lift the write deadline of their own connection.
+		httpServer: &http.Server{
+			Addr:              port,
+			Handler:           handler,
+			ReadHeaderTimeout: timeout,
+			WriteTimeout:      timeout,
+		},
 	}
 }
 
@@ -44,7 +49,7 @@ func (s *Server) Run() error {
 
 	log.Info("HTTP http-server started")
 
-	if err := s.handler.Run(s.port); err != nil {
+	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -54,13 +59,13 @@ func (s *Server) Run() error {
 func (s *Server) Stop(ctx context.Context) error {
 	const op = "HTTPServer.Stop"
 
-	s.log.With(slog.String("op", op)).
-		Info("HTTP http-server stopped")
-
 	err := s.httpServer.Shutdown(ctx)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	s.log.With(slog.String("op", op)).
+		Info("HTTP http-server stopped")
+
 	return nil
 }
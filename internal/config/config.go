@@ -1,9 +1,14 @@
 package config
 
 import (
+	"fmt"
 	"github.com/joho/godotenv"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,6 +18,8 @@ type ServerConfig struct {
 	UserAddress string        `env:"USER_ADDRESS,required"`
 	AuthTimeout time.Duration `env:"AUTH_TIMEOUT" envDefault:"5s"`
 	UserTimeout time.Duration `env:"USER_TIMEOUT" envDefault:"5s"`
+	// ShutdownTimeout bounds how long in-flight requests and jobs may take to finish on shutdown
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
 }
 
 type DatabaseConfig struct {
@@ -34,11 +41,193 @@ type RedisConfig struct {
 	Timeout       time.Duration `env:"TIMEOUT" envDefault:"5s"`
 }
 
+type CookieConfig struct {
+	Domain   string        `env:"COOKIE_DOMAIN"`
+	Path     string        `env:"COOKIE_PATH" envDefault:"/"`
+	SameSite http.SameSite `env:"COOKIE_SAME_SITE" envDefault:"lax"` // lax, strict, none
+	Secure   bool          `env:"COOKIE_SECURE" envDefault:"true"`
+}
+
+type AccountConfig struct {
+	DeletionGracePeriod time.Duration `env:"ACCOUNT_DELETION_GRACE_PERIOD" envDefault:"720h"`
+	UsernamePolicy      string        `env:"ACCOUNT_USERNAME_POLICY" envDefault:"reserve"` // reserve, release
+	PurgeInterval       time.Duration `env:"ACCOUNT_PURGE_INTERVAL" envDefault:"1h"`
+}
+
+type BlobConfig struct {
+	Driver      string `env:"BLOB_DRIVER" envDefault:"local"` // local, s3
+	LocalDir    string `env:"BLOB_LOCAL_DIR" envDefault:"./data/blobs"`
+	S3Endpoint  string `env:"BLOB_S3_ENDPOINT"`
+	S3Bucket    string `env:"BLOB_S3_BUCKET"`
+	S3Region    string `env:"BLOB_S3_REGION" envDefault:"us-east-1"`
+	S3AccessKey string `env:"BLOB_S3_ACCESS_KEY"`
+	S3SecretKey string `env:"BLOB_S3_SECRET_KEY"`
+}
+
+type AvatarConfig struct {
+	MaxBytes int64 `env:"AVATAR_MAX_BYTES" envDefault:"5242880"`
+}
+
+type ExportConfig struct {
+	SigningSecret   string        `env:"EXPORT_SIGNING_SECRET"` // defaults to JWT_SECRET
+	URLTTL          time.Duration `env:"EXPORT_URL_TTL" envDefault:"15m"`
+	Retention       time.Duration `env:"EXPORT_RETENTION" envDefault:"72h"`
+	ProcessInterval time.Duration `env:"EXPORT_PROCESS_INTERVAL" envDefault:"10s"`
+}
+
+type FriendsConfig struct {
+	SuggestionsCacheTTL time.Duration `env:"FRIENDS_SUGGESTIONS_CACHE_TTL" envDefault:"10m"` // 0 disables the cache
+}
+
+type SearchConfig struct {
+	RateLimit  int           `env:"SEARCH_RATE_LIMIT" envDefault:"30"`
+	RateWindow time.Duration `env:"SEARCH_RATE_WINDOW" envDefault:"1m"`
+}
+
+type PresenceConfig struct {
+	OnlineWindow  time.Duration `env:"PRESENCE_ONLINE_WINDOW" envDefault:"1m"`
+	AwayTimeout   time.Duration `env:"PRESENCE_AWAY_TIMEOUT" envDefault:"5m"`
+	FlushInterval time.Duration `env:"PRESENCE_FLUSH_INTERVAL" envDefault:"1m"`
+}
+
+type RealtimeConfig struct {
+	AllowedOrigins []string      `env:"REALTIME_ALLOWED_ORIGINS" envDefault:"http://localhost:8080"`
+	PingInterval   time.Duration `env:"REALTIME_PING_INTERVAL" envDefault:"30s"`
+	BufferSize     int           `env:"REALTIME_BUFFER_SIZE" envDefault:"64"`
+	// EventLogSize is the number of latest events kept per user for resuming streams, 0 disables the log
+	EventLogSize      int64         `env:"REALTIME_EVENT_LOG_SIZE" envDefault:"100"`
+	EventLogRetention time.Duration `env:"REALTIME_EVENT_LOG_RETENTION" envDefault:"24h"`
+}
+
+type NotificationsConfig struct {
+	Retention     time.Duration `env:"NOTIFICATIONS_RETENTION" envDefault:"2160h"`
+	PruneInterval time.Duration `env:"NOTIFICATIONS_PRUNE_INTERVAL" envDefault:"1h"`
+}
+
+type MessagesConfig struct {
+	EditWindow time.Duration `env:"MESSAGES_EDIT_WINDOW" envDefault:"15m"`
+	MaxLength  int           `env:"MESSAGES_MAX_LENGTH" envDefault:"4000"`
+}
+
+type OutboxConfig struct {
+	Broker        string        `env:"OUTBOX_BROKER" envDefault:"redis"` // redis, memory
+	Stream        string        `env:"OUTBOX_STREAM" envDefault:"domain-events"`
+	StreamMaxLen  int64         `env:"OUTBOX_STREAM_MAX_LEN" envDefault:"100000"`
+	RelayInterval time.Duration `env:"OUTBOX_RELAY_INTERVAL" envDefault:"1s"`
+	BatchSize     int           `env:"OUTBOX_BATCH_SIZE" envDefault:"100"`
+	MaxAttempts   int           `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"10"`
+	RetryBackoff  time.Duration `env:"OUTBOX_RETRY_BACKOFF" envDefault:"5s"`
+}
+
+type AdminConfig struct {
+	APIKey string `env:"ADMIN_API_KEY"` // empty disables the admin endpoints
+}
+
+type WebhooksConfig struct {
+	Timeout          time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+	BatchSize        int           `env:"WEBHOOK_BATCH_SIZE" envDefault:"20"`
+	MaxAttempts      int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"8"`
+	RetryBackoff     time.Duration `env:"WEBHOOK_RETRY_BACKOFF" envDefault:"30s"`
+	DisableAfter     int           `env:"WEBHOOK_DISABLE_AFTER" envDefault:"25"` // consecutive failed attempts
+	DeliveryInterval time.Duration `env:"WEBHOOK_DELIVERY_INTERVAL" envDefault:"5s"`
+}
+
+type JobsConfig struct {
+	Concurrency       int           `env:"JOBS_CONCURRENCY" envDefault:"10"`
+	PollInterval      time.Duration `env:"JOBS_POLL_INTERVAL" envDefault:"1s"`
+	VisibilityTimeout time.Duration `env:"JOBS_VISIBILITY_TIMEOUT" envDefault:"5m"`
+	MaxAttempts       int           `env:"JOBS_MAX_ATTEMPTS" envDefault:"5"`
+	RetryBackoff      time.Duration `env:"JOBS_RETRY_BACKOFF" envDefault:"10s"`
+}
+
+type MailConfig struct {
+	Driver          string        `env:"MAIL_DRIVER" envDefault:"file"` // smtp, file, memory
+	From            string        `env:"MAIL_FROM" envDefault:"Boton <no-reply@localhost>"`
+	SMTPHost        string        `env:"MAIL_SMTP_HOST"`
+	SMTPPort        int           `env:"MAIL_SMTP_PORT" envDefault:"587"`
+	SMTPUsername    string        `env:"MAIL_SMTP_USERNAME"`
+	SMTPPassword    string        `env:"MAIL_SMTP_PASSWORD"`
+	FileDir         string        `env:"MAIL_FILE_DIR" envDefault:"./data/mail"`
+	VerificationTTL time.Duration `env:"MAIL_VERIFICATION_TTL" envDefault:"24h"`
+	VerificationURL string        `env:"MAIL_VERIFICATION_URL" envDefault:"http://localhost:8080/verify-email"`
+}
+
+type LoginConfig struct {
+	GeoIPDBPath       string        `env:"LOGIN_GEOIP_DB_PATH"`                      // empty disables locations
+	MaxTravelSpeed    float64       `env:"LOGIN_MAX_TRAVEL_SPEED" envDefault:"1000"` // km/h, 0 disables
+	ConfirmNewDevices bool          `env:"LOGIN_CONFIRM_NEW_DEVICES" envDefault:"false"`
+	ConfirmationTTL   time.Duration `env:"LOGIN_CONFIRMATION_TTL" envDefault:"15m"`
+	ConfirmationURL   string        `env:"LOGIN_CONFIRMATION_URL" envDefault:"http://localhost:8080/confirm-login"`
+}
+
+type RegistrationConfig struct {
+	Mode            string        `env:"REGISTRATION_MODE" envDefault:"open"`           // open, invite, closed
+	UserInviteQuota int           `env:"REGISTRATION_USER_INVITE_QUOTA" envDefault:"0"` // live invites a user may hold at once
+	InviteTTL       time.Duration `env:"REGISTRATION_INVITE_TTL" envDefault:"168h"`
+}
+
+type LedgerConfig struct {
+	Currencies []string `env:"LEDGER_CURRENCIES" envDefault:"RUB"` // ISO 4217 codes, the first is the default
+}
+
+type TransfersConfig struct {
+	DailyLimit int64 `env:"TRANSFERS_DAILY_LIMIT" envDefault:"1000000"` // minor units per currency, 0 disables
+}
+
+type PromotionsConfig struct {
+	NewUserWindow time.Duration `env:"PROMOTIONS_NEW_USER_WINDOW" envDefault:"168h"` // how long after registering new-user codes apply
+}
+
+type PaymentsConfig struct {
+	Provider          string        `env:"PAYMENTS_PROVIDER"` // fake (local only); empty turns payments off
+	MinAmount         int64         `env:"PAYMENTS_MIN_AMOUNT" envDefault:"100"`
+	MaxAmount         int64         `env:"PAYMENTS_MAX_AMOUNT" envDefault:"10000000"`
+	ReturnURL         string        `env:"PAYMENTS_RETURN_URL" envDefault:"http://localhost:8080/balance"`
+	WebhookTolerance  time.Duration `env:"PAYMENTS_WEBHOOK_TOLERANCE" envDefault:"5m"`
+	FakeSecret        string        `env:"PAYMENTS_FAKE_SECRET"` // required with the fake provider, must differ from JWT_SECRET
+	FakeCheckoutURL   string        `env:"PAYMENTS_FAKE_CHECKOUT_URL" envDefault:"http://localhost:8080/dev/payments"`
+	ReconcileInterval time.Duration `env:"PAYMENTS_RECONCILE_INTERVAL" envDefault:"5m"`
+	ReconcileAfter    time.Duration `env:"PAYMENTS_RECONCILE_AFTER" envDefault:"15m"` // how long a payment may stay pending before it is checked
+	BatchSize         int           `env:"PAYMENTS_BATCH_SIZE" envDefault:"100"`
+}
+
+type ReferralsConfig struct {
+	Reward         int64         `env:"REFERRAL_REWARD" envDefault:"500"`
+	MaxPerReferrer int           `env:"REFERRAL_MAX_PER_REFERRER" envDefault:"50"` // 0 disables the cap
+	MinAccountAge  time.Duration `env:"REFERRAL_MIN_ACCOUNT_AGE" envDefault:"24h"`
+	MinFriends     int           `env:"REFERRAL_MIN_FRIENDS" envDefault:"1"`
+	CheckInterval  time.Duration `env:"REFERRAL_CHECK_INTERVAL" envDefault:"10m"`
+	BatchSize      int           `env:"REFERRAL_BATCH_SIZE" envDefault:"100"`
+}
+
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	JWT      JWTConfig
+	Server        ServerConfig
+	Database      DatabaseConfig
+	Redis         RedisConfig
+	JWT           JWTConfig
+	Cookie        CookieConfig
+	Account       AccountConfig
+	Blob          BlobConfig
+	Export        ExportConfig
+	Avatar        AvatarConfig
+	Friends       FriendsConfig
+	Search        SearchConfig
+	Presence      PresenceConfig
+	Realtime      RealtimeConfig
+	Notifications NotificationsConfig
+	Messages      MessagesConfig
+	Outbox        OutboxConfig
+	Admin         AdminConfig
+	Webhooks      WebhooksConfig
+	Jobs          JobsConfig
+	Mail          MailConfig
+	Login         LoginConfig
+	Registration  RegistrationConfig
+	Ledger        LedgerConfig
+	Transfers     TransfersConfig
+	Referrals     ReferralsConfig
+	Promotions    PromotionsConfig
+	Payments      PaymentsConfig
 }
 
 const (
@@ -47,6 +236,8 @@ const (
 	prod  = ".env.prod"
 )
 
+var currencyRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+
 func MustLoad() *Config {
 	if err := godotenv.Load(local); err != nil {
 		panic(err)
@@ -64,6 +255,11 @@ func MustLoad() *Config {
 		panic("Invalid TIMEOUT format: " + err.Error())
 	}
 
+	shutdownTimeout, err := time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		panic("Invalid SHUTDOWN_TIMEOUT format: " + err.Error())
+	}
+
 	accessExpStr := os.Getenv("AUTH_ACCESS_EXPIRATION_MINUTES")
 	accessExp, err := time.ParseDuration(accessExpStr)
 	if err != nil {
@@ -94,13 +290,390 @@ func MustLoad() *Config {
 		panic("Invalid REDIS_TIMEOUT format: " + err.Error())
 	}
 
+	cookieSameSite, err := parseSameSite(getEnv("COOKIE_SAME_SITE", "lax"))
+	if err != nil {
+		panic("Invalid COOKIE_SAME_SITE format: " + err.Error())
+	}
+
+	cookieSecure, err := strconv.ParseBool(getEnv("COOKIE_SECURE", "true"))
+	if err != nil {
+		panic("Invalid COOKIE_SECURE format: " + err.Error())
+	}
+
+	deletionGracePeriod, err := time.ParseDuration(getEnv("ACCOUNT_DELETION_GRACE_PERIOD", "720h"))
+	if err != nil {
+		panic("Invalid ACCOUNT_DELETION_GRACE_PERIOD format: " + err.Error())
+	}
+
+	usernamePolicy := getEnv("ACCOUNT_USERNAME_POLICY", "reserve")
+	if usernamePolicy != "reserve" && usernamePolicy != "release" {
+		panic("Invalid ACCOUNT_USERNAME_POLICY: must be reserve or release")
+	}
+
+	purgeInterval, err := time.ParseDuration(getEnv("ACCOUNT_PURGE_INTERVAL", "1h"))
+	if err != nil {
+		panic("Invalid ACCOUNT_PURGE_INTERVAL format: " + err.Error())
+	}
+
+	blobDriver := getEnv("BLOB_DRIVER", "local")
+	if blobDriver != "local" && blobDriver != "s3" {
+		panic("Invalid BLOB_DRIVER: must be local or s3")
+	}
+
+	exportURLTTL, err := time.ParseDuration(getEnv("EXPORT_URL_TTL", "15m"))
+	if err != nil {
+		panic("Invalid EXPORT_URL_TTL format: " + err.Error())
+	}
+
+	exportRetention, err := time.ParseDuration(getEnv("EXPORT_RETENTION", "72h"))
+	if err != nil {
+		panic("Invalid EXPORT_RETENTION format: " + err.Error())
+	}
+
+	exportProcessInterval, err := time.ParseDuration(getEnv("EXPORT_PROCESS_INTERVAL", "10s"))
+	if err != nil {
+		panic("Invalid EXPORT_PROCESS_INTERVAL format: " + err.Error())
+	}
+
+	avatarMaxBytes, err := strconv.ParseInt(getEnv("AVATAR_MAX_BYTES", "5242880"), 10, 64)
+	if err != nil {
+		panic("Invalid AVATAR_MAX_BYTES format: " + err.Error())
+	}
+
+	suggestionsCacheTTL, err := time.ParseDuration(getEnv("FRIENDS_SUGGESTIONS_CACHE_TTL", "10m"))
+	if err != nil {
+		panic("Invalid FRIENDS_SUGGESTIONS_CACHE_TTL format: " + err.Error())
+	}
+
+	searchRateLimit, err := strconv.Atoi(getEnv("SEARCH_RATE_LIMIT", "30"))
+	if err != nil {
+		panic("Invalid SEARCH_RATE_LIMIT format: " + err.Error())
+	}
+
+	searchRateWindow, err := time.ParseDuration(getEnv("SEARCH_RATE_WINDOW", "1m"))
+	if err != nil {
+		panic("Invalid SEARCH_RATE_WINDOW format: " + err.Error())
+	}
+
+	presenceOnlineWindow, err := time.ParseDuration(getEnv("PRESENCE_ONLINE_WINDOW", "1m"))
+	if err != nil {
+		panic("Invalid PRESENCE_ONLINE_WINDOW format: " + err.Error())
+	}
+
+	presenceAwayTimeout, err := time.ParseDuration(getEnv("PRESENCE_AWAY_TIMEOUT", "5m"))
+	if err != nil {
+		panic("Invalid PRESENCE_AWAY_TIMEOUT format: " + err.Error())
+	}
+
+	if presenceAwayTimeout < presenceOnlineWindow {
+		panic("Invalid PRESENCE_AWAY_TIMEOUT: must not be shorter than PRESENCE_ONLINE_WINDOW")
+	}
+
+	presenceFlushInterval, err := time.ParseDuration(getEnv("PRESENCE_FLUSH_INTERVAL", "1m"))
+	if err != nil {
+		panic("Invalid PRESENCE_FLUSH_INTERVAL format: " + err.Error())
+	}
+
+	realtimePingInterval, err := time.ParseDuration(getEnv("REALTIME_PING_INTERVAL", "30s"))
+	if err != nil {
+		panic("Invalid REALTIME_PING_INTERVAL format: " + err.Error())
+	}
+
+	realtimeBufferSize, err := strconv.Atoi(getEnv("REALTIME_BUFFER_SIZE", "64"))
+	if err != nil || realtimeBufferSize < 1 {
+		panic("Invalid REALTIME_BUFFER_SIZE: must be a positive integer")
+	}
+
+	realtimeEventLogSize, err := strconv.ParseInt(getEnv("REALTIME_EVENT_LOG_SIZE", "100"), 10, 64)
+	if err != nil || realtimeEventLogSize < 0 {
+		panic("Invalid REALTIME_EVENT_LOG_SIZE: must be a non-negative integer")
+	}
+
+	realtimeEventLogRetention, err := time.ParseDuration(getEnv("REALTIME_EVENT_LOG_RETENTION", "24h"))
+	if err != nil {
+		panic("Invalid REALTIME_EVENT_LOG_RETENTION format: " + err.Error())
+	}
+
+	notificationsRetention, err := time.ParseDuration(getEnv("NOTIFICATIONS_RETENTION", "2160h"))
+	if err != nil {
+		panic("Invalid NOTIFICATIONS_RETENTION format: " + err.Error())
+	}
+
+	notificationsPruneInterval, err := time.ParseDuration(getEnv("NOTIFICATIONS_PRUNE_INTERVAL", "1h"))
+	if err != nil {
+		panic("Invalid NOTIFICATIONS_PRUNE_INTERVAL format: " + err.Error())
+	}
+
+	messagesEditWindow, err := time.ParseDuration(getEnv("MESSAGES_EDIT_WINDOW", "15m"))
+	if err != nil {
+		panic("Invalid MESSAGES_EDIT_WINDOW format: " + err.Error())
+	}
+
+	messagesMaxLength, err := strconv.Atoi(getEnv("MESSAGES_MAX_LENGTH", "4000"))
+	if err != nil || messagesMaxLength < 1 {
+		panic("Invalid MESSAGES_MAX_LENGTH: must be a positive integer")
+	}
+
+	outboxBroker := getEnv("OUTBOX_BROKER", "redis")
+	if outboxBroker != "redis" && outboxBroker != "memory" {
+		panic("Invalid OUTBOX_BROKER: must be redis or memory")
+	}
+
+	outboxStreamMaxLen, err := strconv.ParseInt(getEnv("OUTBOX_STREAM_MAX_LEN", "100000"), 10, 64)
+	if err != nil || outboxStreamMaxLen < 0 {
+		panic("Invalid OUTBOX_STREAM_MAX_LEN: must be a non-negative integer")
+	}
+
+	outboxRelayInterval, err := time.ParseDuration(getEnv("OUTBOX_RELAY_INTERVAL", "1s"))
+	if err != nil {
+		panic("Invalid OUTBOX_RELAY_INTERVAL format: " + err.Error())
+	}
+
+	outboxBatchSize, err := strconv.Atoi(getEnv("OUTBOX_BATCH_SIZE", "100"))
+	if err != nil || outboxBatchSize < 1 {
+		panic("Invalid OUTBOX_BATCH_SIZE: must be a positive integer")
+	}
+
+	outboxMaxAttempts, err := strconv.Atoi(getEnv("OUTBOX_MAX_ATTEMPTS", "10"))
+	if err != nil || outboxMaxAttempts < 1 {
+		panic("Invalid OUTBOX_MAX_ATTEMPTS: must be a positive integer")
+	}
+
+	outboxRetryBackoff, err := time.ParseDuration(getEnv("OUTBOX_RETRY_BACKOFF", "5s"))
+	if err != nil {
+		panic("Invalid OUTBOX_RETRY_BACKOFF format: " + err.Error())
+	}
+
+	webhookTimeout, err := time.ParseDuration(getEnv("WEBHOOK_TIMEOUT", "10s"))
+	if err != nil {
+		panic("Invalid WEBHOOK_TIMEOUT format: " + err.Error())
+	}
+
+	webhookBatchSize, err := strconv.Atoi(getEnv("WEBHOOK_BATCH_SIZE", "20"))
+	if err != nil || webhookBatchSize < 1 {
+		panic("Invalid WEBHOOK_BATCH_SIZE: must be a positive integer")
+	}
+
+	webhookMaxAttempts, err := strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "8"))
+	if err != nil || webhookMaxAttempts < 1 {
+		panic("Invalid WEBHOOK_MAX_ATTEMPTS: must be a positive integer")
+	}
+
+	webhookRetryBackoff, err := time.ParseDuration(getEnv("WEBHOOK_RETRY_BACKOFF", "30s"))
+	if err != nil {
+		panic("Invalid WEBHOOK_RETRY_BACKOFF format: " + err.Error())
+	}
+
+	webhookDisableAfter, err := strconv.Atoi(getEnv("WEBHOOK_DISABLE_AFTER", "25"))
+	if err != nil || webhookDisableAfter < 1 {
+		panic("Invalid WEBHOOK_DISABLE_AFTER: must be a positive integer")
+	}
+
+	webhookDeliveryInterval, err := time.ParseDuration(getEnv("WEBHOOK_DELIVERY_INTERVAL", "5s"))
+	if err != nil {
+		panic("Invalid WEBHOOK_DELIVERY_INTERVAL format: " + err.Error())
+	}
+
+	jobsConcurrency, err := strconv.Atoi(getEnv("JOBS_CONCURRENCY", "10"))
+	if err != nil || jobsConcurrency < 1 {
+		panic("Invalid JOBS_CONCURRENCY: must be a positive integer")
+	}
+
+	jobsPollInterval, err := time.ParseDuration(getEnv("JOBS_POLL_INTERVAL", "1s"))
+	if err != nil {
+		panic("Invalid JOBS_POLL_INTERVAL format: " + err.Error())
+	}
+
+	jobsVisibilityTimeout, err := time.ParseDuration(getEnv("JOBS_VISIBILITY_TIMEOUT", "5m"))
+	if err != nil {
+		panic("Invalid JOBS_VISIBILITY_TIMEOUT format: " + err.Error())
+	}
+
+	jobsMaxAttempts, err := strconv.Atoi(getEnv("JOBS_MAX_ATTEMPTS", "5"))
+	if err != nil || jobsMaxAttempts < 1 {
+		panic("Invalid JOBS_MAX_ATTEMPTS: must be a positive integer")
+	}
+
+	jobsRetryBackoff, err := time.ParseDuration(getEnv("JOBS_RETRY_BACKOFF", "10s"))
+	if err != nil {
+		panic("Invalid JOBS_RETRY_BACKOFF format: " + err.Error())
+	}
+
+	mailDriver := getEnv("MAIL_DRIVER", "file")
+	if mailDriver != "smtp" && mailDriver != "file" && mailDriver != "memory" {
+		panic("Invalid MAIL_DRIVER: must be smtp, file or memory")
+	}
+
+	if mailDriver == "smtp" && os.Getenv("MAIL_SMTP_HOST") == "" {
+		panic("MAIL_SMTP_HOST is required with the smtp mail driver")
+	}
+
+	mailSMTPPort, err := strconv.Atoi(getEnv("MAIL_SMTP_PORT", "587"))
+	if err != nil || mailSMTPPort < 1 || mailSMTPPort > 65535 {
+		panic("Invalid MAIL_SMTP_PORT: must be a port number")
+	}
+
+	loginMaxTravelSpeed, err := strconv.ParseFloat(getEnv("LOGIN_MAX_TRAVEL_SPEED", "1000"), 64)
+	if err != nil || loginMaxTravelSpeed < 0 {
+		panic("Invalid LOGIN_MAX_TRAVEL_SPEED: must be a non-negative number")
+	}
+
+	loginConfirmNewDevices, err := strconv.ParseBool(getEnv("LOGIN_CONFIRM_NEW_DEVICES", "false"))
+	if err != nil {
+		panic("Invalid LOGIN_CONFIRM_NEW_DEVICES: must be a boolean")
+	}
+
+	loginConfirmationTTL, err := time.ParseDuration(getEnv("LOGIN_CONFIRMATION_TTL", "15m"))
+	if err != nil {
+		panic("Invalid LOGIN_CONFIRMATION_TTL format: " + err.Error())
+	}
+
+	loginConfirmationURL := getEnv("LOGIN_CONFIRMATION_URL", "http://localhost:8080/confirm-login")
+	if u, err := url.Parse(loginConfirmationURL); err != nil || !u.IsAbs() {
+		panic("Invalid LOGIN_CONFIRMATION_URL: must be an absolute url")
+	}
+
+	registrationMode := getEnv("REGISTRATION_MODE", "open")
+	if registrationMode != "open" && registrationMode != "invite" && registrationMode != "closed" {
+		panic("Invalid REGISTRATION_MODE: must be open, invite or closed")
+	}
+
+	userInviteQuota, err := strconv.Atoi(getEnv("REGISTRATION_USER_INVITE_QUOTA", "0"))
+	if err != nil || userInviteQuota < 0 {
+		panic("Invalid REGISTRATION_USER_INVITE_QUOTA: must be a non-negative integer")
+	}
+
+	inviteTTL, err := time.ParseDuration(getEnv("REGISTRATION_INVITE_TTL", "168h"))
+	if err != nil || inviteTTL <= 0 {
+		panic("Invalid REGISTRATION_INVITE_TTL: must be a positive duration")
+	}
+
+	mailVerificationTTL, err := time.ParseDuration(getEnv("MAIL_VERIFICATION_TTL", "24h"))
+	if err != nil || mailVerificationTTL <= 0 {
+		panic("Invalid MAIL_VERIFICATION_TTL: must be a positive duration")
+	}
+
+	mailVerificationURL := getEnv("MAIL_VERIFICATION_URL", "http://localhost:8080/verify-email")
+	if u, err := url.Parse(mailVerificationURL); err != nil || !u.IsAbs() {
+		panic("Invalid MAIL_VERIFICATION_URL: must be an absolute url")
+	}
+
+	var ledgerCurrencies []string
+	for _, currency := range strings.Split(getEnv("LEDGER_CURRENCIES", "RUB"), ",") {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if !currencyRegex.MatchString(currency) {
+			panic("Invalid LEDGER_CURRENCIES: must be a comma-separated list of ISO 4217 codes")
+		}
+		ledgerCurrencies = append(ledgerCurrencies, currency)
+	}
+
+	transfersDailyLimit, err := strconv.ParseInt(getEnv("TRANSFERS_DAILY_LIMIT", "1000000"), 10, 64)
+	if err != nil || transfersDailyLimit < 0 {
+		panic("Invalid TRANSFERS_DAILY_LIMIT: must be a non-negative integer")
+	}
+
+	promotionsNewUserWindow, err := time.ParseDuration(getEnv("PROMOTIONS_NEW_USER_WINDOW", "168h"))
+	if err != nil || promotionsNewUserWindow <= 0 {
+		panic("Invalid PROMOTIONS_NEW_USER_WINDOW: must be a positive duration")
+	}
+
+	// without a provider top-ups are turned off
+	paymentsProvider := os.Getenv("PAYMENTS_PROVIDER")
+	if paymentsProvider != "" && paymentsProvider != "fake" {
+		panic("Invalid PAYMENTS_PROVIDER: must be empty or fake")
+	}
+
+	if paymentsProvider == "fake" && os.Getenv("ENV") != "local" {
+		panic("Invalid PAYMENTS_PROVIDER: the fake provider can only be used in local")
+	}
+
+	paymentsMinAmount, err := strconv.ParseInt(getEnv("PAYMENTS_MIN_AMOUNT", "100"), 10, 64)
+	if err != nil || paymentsMinAmount <= 0 {
+		panic("Invalid PAYMENTS_MIN_AMOUNT: must be a positive integer")
+	}
+
+	paymentsMaxAmount, err := strconv.ParseInt(getEnv("PAYMENTS_MAX_AMOUNT", "10000000"), 10, 64)
+	if err != nil || paymentsMaxAmount < paymentsMinAmount {
+		panic("Invalid PAYMENTS_MAX_AMOUNT: must be an integer no less than PAYMENTS_MIN_AMOUNT")
+	}
+
+	paymentsReturnURL := getEnv("PAYMENTS_RETURN_URL", "http://localhost:8080/balance")
+	if u, err := url.Parse(paymentsReturnURL); err != nil || !u.IsAbs() {
+		panic("Invalid PAYMENTS_RETURN_URL: must be an absolute url")
+	}
+
+	paymentsWebhookTolerance, err := time.ParseDuration(getEnv("PAYMENTS_WEBHOOK_TOLERANCE", "5m"))
+	if err != nil || paymentsWebhookTolerance <= 0 {
+		panic("Invalid PAYMENTS_WEBHOOK_TOLERANCE: must be a positive duration")
+	}
+
+	paymentsFakeSecret := os.Getenv("PAYMENTS_FAKE_SECRET")
+	if paymentsProvider == "fake" && paymentsFakeSecret == "" {
+		panic("PAYMENTS_FAKE_SECRET is required with the fake payments provider")
+	}
+
+	if paymentsFakeSecret != "" && paymentsFakeSecret == os.Getenv("JWT_SECRET") {
+		panic("Invalid PAYMENTS_FAKE_SECRET: must differ from JWT_SECRET")
+	}
+
+	paymentsFakeCheckoutURL := getEnv("PAYMENTS_FAKE_CHECKOUT_URL", "http://localhost:8080/dev/payments")
+	if u, err := url.Parse(paymentsFakeCheckoutURL); err != nil || !u.IsAbs() {
+		panic("Invalid PAYMENTS_FAKE_CHECKOUT_URL: must be an absolute url")
+	}
+
+	paymentsReconcileInterval, err := time.ParseDuration(getEnv("PAYMENTS_RECONCILE_INTERVAL", "5m"))
+	if err != nil || paymentsReconcileInterval <= 0 {
+		panic("Invalid PAYMENTS_RECONCILE_INTERVAL: must be a positive duration")
+	}
+
+	paymentsReconcileAfter, err := time.ParseDuration(getEnv("PAYMENTS_RECONCILE_AFTER", "15m"))
+	if err != nil || paymentsReconcileAfter <= 0 {
+		panic("Invalid PAYMENTS_RECONCILE_AFTER: must be a positive duration")
+	}
+
+	paymentsBatchSize, err := strconv.Atoi(getEnv("PAYMENTS_BATCH_SIZE", "100"))
+	if err != nil || paymentsBatchSize <= 0 {
+		panic("Invalid PAYMENTS_BATCH_SIZE: must be a positive integer")
+	}
+
+	referralReward, err := strconv.ParseInt(getEnv("REFERRAL_REWARD", "500"), 10, 64)
+	if err != nil || referralReward < 0 {
+		panic("Invalid REFERRAL_REWARD: must be a non-negative integer")
+	}
+
+	referralMaxPerReferrer, err := strconv.Atoi(getEnv("REFERRAL_MAX_PER_REFERRER", "50"))
+	if err != nil || referralMaxPerReferrer < 0 {
+		panic("Invalid REFERRAL_MAX_PER_REFERRER: must be a non-negative integer")
+	}
+
+	referralMinAccountAge, err := time.ParseDuration(getEnv("REFERRAL_MIN_ACCOUNT_AGE", "24h"))
+	if err != nil || referralMinAccountAge < 0 {
+		panic("Invalid REFERRAL_MIN_ACCOUNT_AGE: must be a non-negative duration")
+	}
+
+	referralMinFriends, err := strconv.Atoi(getEnv("REFERRAL_MIN_FRIENDS", "1"))
+	if err != nil || referralMinFriends < 0 {
+		panic("Invalid REFERRAL_MIN_FRIENDS: must be a non-negative integer")
+	}
+
+	referralCheckInterval, err := time.ParseDuration(getEnv("REFERRAL_CHECK_INTERVAL", "10m"))
+	if err != nil || referralCheckInterval <= 0 {
+		panic("Invalid REFERRAL_CHECK_INTERVAL: must be a positive duration")
+	}
+
+	referralBatchSize, err := strconv.Atoi(getEnv("REFERRAL_BATCH_SIZE", "100"))
+	if err != nil || referralBatchSize <= 0 {
+		panic("Invalid REFERRAL_BATCH_SIZE: must be a positive integer")
+	}
+
 	return &Config{
 		Server: ServerConfig{
-			Env:         os.Getenv("ENV"),
-			AuthAddress: os.Getenv("AUTH_ADDRESS"),
-			UserAddress: os.Getenv("USER_ADDRESS"),
-			AuthTimeout: authTimeout,
-			UserTimeout: userTimeout,
+			Env:             os.Getenv("ENV"),
+			AuthAddress:     os.Getenv("AUTH_ADDRESS"),
+			UserAddress:     os.Getenv("USER_ADDRESS"),
+			AuthTimeout:     authTimeout,
+			UserTimeout:     userTimeout,
+			ShutdownTimeout: shutdownTimeout,
 		},
 		Database: DatabaseConfig{
 			PostgresConn: os.Getenv("POSTGRES_CONN"),
@@ -118,5 +691,161 @@ func MustLoad() *Config {
 			AccessExpirationMinutes: accessExp,
 			RefreshExpirationDays:   refreshExp,
 		},
+		Cookie: CookieConfig{
+			Domain:   os.Getenv("COOKIE_DOMAIN"),
+			Path:     getEnv("COOKIE_PATH", "/"),
+			SameSite: cookieSameSite,
+			Secure:   cookieSecure,
+		},
+		Account: AccountConfig{
+			DeletionGracePeriod: deletionGracePeriod,
+			UsernamePolicy:      usernamePolicy,
+			PurgeInterval:       purgeInterval,
+		},
+		Blob: BlobConfig{
+			Driver:      blobDriver,
+			LocalDir:    getEnv("BLOB_LOCAL_DIR", "./data/blobs"),
+			S3Endpoint:  os.Getenv("BLOB_S3_ENDPOINT"),
+			S3Bucket:    os.Getenv("BLOB_S3_BUCKET"),
+			S3Region:    getEnv("BLOB_S3_REGION", "us-east-1"),
+			S3AccessKey: os.Getenv("BLOB_S3_ACCESS_KEY"),
+			S3SecretKey: os.Getenv("BLOB_S3_SECRET_KEY"),
+		},
+		Export: ExportConfig{
+			SigningSecret:   getEnv("EXPORT_SIGNING_SECRET", os.Getenv("JWT_SECRET")),
+			URLTTL:          exportURLTTL,
+			Retention:       exportRetention,
+			ProcessInterval: exportProcessInterval,
+		},
+		Avatar: AvatarConfig{
+			MaxBytes: avatarMaxBytes,
+		},
+		Friends: FriendsConfig{
+			SuggestionsCacheTTL: suggestionsCacheTTL,
+		},
+		Search: SearchConfig{
+			RateLimit:  searchRateLimit,
+			RateWindow: searchRateWindow,
+		},
+		Presence: PresenceConfig{
+			OnlineWindow:  presenceOnlineWindow,
+			AwayTimeout:   presenceAwayTimeout,
+			FlushInterval: presenceFlushInterval,
+		},
+		Realtime: RealtimeConfig{
+			AllowedOrigins:    strings.Split(getEnv("REALTIME_ALLOWED_ORIGINS", "http://localhost:8080"), ","),
+			PingInterval:      realtimePingInterval,
+			BufferSize:        realtimeBufferSize,
+			EventLogSize:      realtimeEventLogSize,
+			EventLogRetention: realtimeEventLogRetention,
+		},
+		Notifications: NotificationsConfig{
+			Retention:     notificationsRetention,
+			PruneInterval: notificationsPruneInterval,
+		},
+		Messages: MessagesConfig{
+			EditWindow: messagesEditWindow,
+			MaxLength:  messagesMaxLength,
+		},
+		Outbox: OutboxConfig{
+			Broker:        outboxBroker,
+			Stream:        getEnv("OUTBOX_STREAM", "domain-events"),
+			StreamMaxLen:  outboxStreamMaxLen,
+			RelayInterval: outboxRelayInterval,
+			BatchSize:     outboxBatchSize,
+			MaxAttempts:   outboxMaxAttempts,
+			RetryBackoff:  outboxRetryBackoff,
+		},
+		Admin: AdminConfig{
+			APIKey: os.Getenv("ADMIN_API_KEY"),
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:          webhookTimeout,
+			BatchSize:        webhookBatchSize,
+			MaxAttempts:      webhookMaxAttempts,
+			RetryBackoff:     webhookRetryBackoff,
+			DisableAfter:     webhookDisableAfter,
+			DeliveryInterval: webhookDeliveryInterval,
+		},
+		Jobs: JobsConfig{
+			Concurrency:       jobsConcurrency,
+			PollInterval:      jobsPollInterval,
+			VisibilityTimeout: jobsVisibilityTimeout,
+			MaxAttempts:       jobsMaxAttempts,
+			RetryBackoff:      jobsRetryBackoff,
+		},
+		Mail: MailConfig{
+			Driver:          mailDriver,
+			From:            getEnv("MAIL_FROM", "Boton <no-reply@localhost>"),
+			SMTPHost:        os.Getenv("MAIL_SMTP_HOST"),
+			SMTPPort:        mailSMTPPort,
+			SMTPUsername:    os.Getenv("MAIL_SMTP_USERNAME"),
+			SMTPPassword:    os.Getenv("MAIL_SMTP_PASSWORD"),
+			FileDir:         getEnv("MAIL_FILE_DIR", "./data/mail"),
+			VerificationTTL: mailVerificationTTL,
+			VerificationURL: mailVerificationURL,
+		},
+		Login: LoginConfig{
+			GeoIPDBPath:       os.Getenv("LOGIN_GEOIP_DB_PATH"),
+			MaxTravelSpeed:    loginMaxTravelSpeed,
+			ConfirmNewDevices: loginConfirmNewDevices,
+			ConfirmationTTL:   loginConfirmationTTL,
+			ConfirmationURL:   loginConfirmationURL,
+		},
+		Registration: RegistrationConfig{
+			Mode:            registrationMode,
+			UserInviteQuota: userInviteQuota,
+			InviteTTL:       inviteTTL,
+		},
+		Ledger: LedgerConfig{
+			Currencies: ledgerCurrencies,
+		},
+		Transfers: TransfersConfig{
+			DailyLimit: transfersDailyLimit,
+		},
+		Referrals: ReferralsConfig{
+			Reward:         referralReward,
+			MaxPerReferrer: referralMaxPerReferrer,
+			MinAccountAge:  referralMinAccountAge,
+			MinFriends:     referralMinFriends,
+			CheckInterval:  referralCheckInterval,
+			BatchSize:      referralBatchSize,
+		},
+		Promotions: PromotionsConfig{
+			NewUserWindow: promotionsNewUserWindow,
+		},
+		Payments: PaymentsConfig{
+			Provider:          paymentsProvider,
+			MinAmount:         paymentsMinAmount,
+			MaxAmount:         paymentsMaxAmount,
+			ReturnURL:         paymentsReturnURL,
+			WebhookTolerance:  paymentsWebhookTolerance,
+			FakeSecret:        paymentsFakeSecret,
+			FakeCheckoutURL:   paymentsFakeCheckoutURL,
+			ReconcileInterval: paymentsReconcileInterval,
+			ReconcileAfter:    paymentsReconcileAfter,
+			BatchSize:         paymentsBatchSize,
+		},
+	}
+}
+
+// getEnv returns the value of the environment variable or def if it is unset or empty.
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func parseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown SameSite mode %q", s)
 	}
 }
@@ -0,0 +1,24 @@
+package converter
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+)
+
+func ToFriendDTO(entry models.FriendshipEntry) dto.Friend {
+	return dto.Friend{
+		Id:          entry.UserID,
+		Username:    entry.Username,
+		DisplayName: entry.DisplayName,
+		AvatarURL:   entry.AvatarURL,
+		Since:       entry.Since,
+	}
+}
+
+func ToFriendRequestDTO(entry models.FriendshipEntry) dto.FriendRequest {
+	return dto.FriendRequest{
+		ID:        entry.FriendshipID,
+		User:      ToFriendDTO(entry),
+		CreatedAt: entry.Since,
+	}
+}
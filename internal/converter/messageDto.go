@@ -0,0 +1,33 @@
+package converter
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+)
+
+func ToConversationDTO(entry models.ConversationEntry) dto.Conversation {
+	conversation := dto.Conversation{
+		ID: entry.ConversationID,
+		User: dto.Participant{
+			Id:          entry.UserID,
+			Username:    entry.Username,
+			DisplayName: entry.DisplayName,
+			AvatarURL:   entry.AvatarURL,
+		},
+		LastMessageAt: entry.LastMessageAt,
+		UnreadCount:   entry.UnreadCount,
+		Muted:         entry.Muted,
+		ReadAt:        entry.PeerLastReadAt,
+	}
+
+	if entry.LastMessageID != nil {
+		conversation.LastMessage = &dto.MessagePreview{
+			ID:        *entry.LastMessageID,
+			SenderID:  *entry.LastMessageSenderID,
+			Body:      *entry.LastMessageBody,
+			CreatedAt: *entry.LastMessageSentAt,
+		}
+	}
+
+	return conversation
+}
@@ -0,0 +1,48 @@
+package converter
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+)
+
+const dateLayout = "2006-01-02"
+
+func ToProfileDTO(profile *models.Profile) dto.Profile {
+	return dto.Profile{
+		Username:           profile.Username,
+		DisplayName:        profile.DisplayName,
+		Bio:                profile.Bio,
+		AvatarURL:          profile.AvatarURL,
+		Locale:             profile.Locale,
+		Timezone:           profile.Timezone,
+		Birthday:           formatDate(profile),
+		BirthdayVisibility: profile.BirthdayVisibility,
+	}
+}
+
+// ToPublicProfileDTO projects the profile for another user. showBirthday tells whether
+// the birthday visibility setting admits that user.
+func ToPublicProfileDTO(profile *models.Profile, showBirthday bool) dto.PublicProfile {
+	public := dto.PublicProfile{
+		ID:          profile.UserID,
+		Username:    profile.Username,
+		DisplayName: profile.DisplayName,
+		Bio:         profile.Bio,
+		AvatarURL:   profile.AvatarURL,
+	}
+
+	if showBirthday {
+		public.Birthday = formatDate(profile)
+	}
+
+	return public
+}
+
+func formatDate(profile *models.Profile) *string {
+	if profile.Birthday == nil {
+		return nil
+	}
+
+	s := profile.Birthday.Format(dateLayout)
+	return &s
+}
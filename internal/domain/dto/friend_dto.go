@@ -1,8 +1,20 @@
 package dto
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"time"
+)
 
 type Friend struct {
-	Id       uuid.UUID `json:"id"`
-	Username string    `json:"username" db:"username"`
+	Id          uuid.UUID `json:"id"`
+	Username    string    `json:"username" db:"username"`
+	DisplayName *string   `json:"display_name,omitempty"`
+	AvatarURL   *string   `json:"avatar_url,omitempty"`
+	Since       time.Time `json:"since"`
+}
+
+type FriendRequest struct {
+	ID        uuid.UUID `json:"id"`
+	User      Friend    `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
 }
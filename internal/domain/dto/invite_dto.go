@@ -0,0 +1,11 @@
+package dto
+
+import "time"
+
+// CreateInvite describes a new invite. Unset fields take their defaults: one use, no bound
+// email and the default lifetime.
+type CreateInvite struct {
+	Email     *string    `json:"email"`
+	MaxUses   *int       `json:"max_uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,31 @@
+package dto
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type Conversation struct {
+	ID            uuid.UUID       `json:"id"`
+	User          Participant     `json:"user"`
+	LastMessage   *MessagePreview `json:"last_message,omitempty"`
+	LastMessageAt time.Time       `json:"last_message_at"`
+	UnreadCount   int             `json:"unread_count"`
+	Muted         bool            `json:"muted"`
+	// ReadAt is when the other user last read the conversation.
+	ReadAt *time.Time `json:"read_at,omitempty"`
+}
+
+type Participant struct {
+	Id          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName *string   `json:"display_name,omitempty"`
+	AvatarURL   *string   `json:"avatar_url,omitempty"`
+}
+
+type MessagePreview struct {
+	ID        uuid.UUID `json:"id"`
+	SenderID  uuid.UUID `json:"sender_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,9 @@
+package dto
+
+// CreateTopUp tops up the balance by Amount minor units. An empty Currency means the default
+// one.
+type CreateTopUp struct {
+	Amount         int64  `json:"amount"`
+	Currency       string `json:"currency"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
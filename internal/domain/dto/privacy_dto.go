@@ -0,0 +1,8 @@
+package dto
+
+// UpdatePrivacySettings is a partial update of the privacy settings: nil fields are left untouched.
+type UpdatePrivacySettings struct {
+	FriendRequestsFrom   *string `json:"friend_requests_from"`
+	FriendListVisibility *string `json:"friend_list_visibility"`
+	HideOnlineStatus     *bool   `json:"hide_online_status"`
+}
@@ -0,0 +1,37 @@
+package dto
+
+import "github.com/google/uuid"
+
+// Profile is the profile as its owner sees it.
+type Profile struct {
+	Username           string  `json:"username"`
+	DisplayName        *string `json:"display_name"`
+	Bio                *string `json:"bio"`
+	AvatarURL          *string `json:"avatar_url"`
+	Locale             *string `json:"locale"`
+	Timezone           *string `json:"timezone"`
+	Birthday           *string `json:"birthday"`
+	BirthdayVisibility string  `json:"birthday_visibility"`
+}
+
+// PublicProfile is the projection of a profile other users are allowed to see.
+type PublicProfile struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName *string   `json:"display_name,omitempty"`
+	Bio         *string   `json:"bio,omitempty"`
+	AvatarURL   *string   `json:"avatar_url,omitempty"`
+	Birthday    *string   `json:"birthday,omitempty"`
+}
+
+// UpdateProfile is a partial profile update: nil fields are left untouched,
+// empty strings clear the field.
+type UpdateProfile struct {
+	DisplayName        *string `json:"display_name"`
+	Bio                *string `json:"bio"`
+	AvatarURL          *string `json:"avatar_url"`
+	Locale             *string `json:"locale"`
+	Timezone           *string `json:"timezone"`
+	Birthday           *string `json:"birthday"`
+	BirthdayVisibility *string `json:"birthday_visibility"`
+}
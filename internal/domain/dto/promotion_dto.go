@@ -0,0 +1,23 @@
+package dto
+
+import "time"
+
+// CreatePromoCode describes a new promo code. Unset fields take their defaults: a generated
+// code, the default currency, no validity window or global limit, and one redemption per user.
+type CreatePromoCode struct {
+	Code           *string    `json:"code"`
+	Kind           string     `json:"kind"`
+	Value          int64      `json:"value"`
+	Currency       string     `json:"currency"`
+	MinSpend       int64      `json:"min_spend"`
+	NewUsersOnly   bool       `json:"new_users_only"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+	MaxRedemptions *int       `json:"max_redemptions"`
+	PerUserLimit   *int       `json:"per_user_limit"`
+	Description    string     `json:"description"`
+}
+
+type RedeemPromoCode struct {
+	Code string `json:"code"`
+}
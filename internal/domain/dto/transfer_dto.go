@@ -0,0 +1,11 @@
+package dto
+
+// CreateTransfer sends Amount minor units to the friend with the username Recipient. An empty
+// Currency means the default one.
+type CreateTransfer struct {
+	Recipient      string `json:"recipient"`
+	Amount         int64  `json:"amount"`
+	Currency       string `json:"currency"`
+	Note           string `json:"note"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
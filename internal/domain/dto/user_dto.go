@@ -1,7 +1,22 @@
 package dto
 
+import (
+	"boton-back/internal/domain/models"
+	"github.com/google/uuid"
+)
+
 type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username" db:"username"`
-	Email    string `json:"email" db:"email"`
+	ID       uuid.UUID        `json:"id"`
+	Username string           `json:"username" db:"username"`
+	Email    string           `json:"email" db:"email"`
+	Discount *models.Discount `json:"discount,omitempty" db:"-"`
+}
+
+// LedgerAdjustment is a manual correction of a balance. Amount is in minor units and may be
+// negative; an empty Currency means the default one.
+type LedgerAdjustment struct {
+	Amount         int64  `json:"amount"`
+	Currency       string `json:"currency"`
+	Description    string `json:"description"`
+	IdempotencyKey string `json:"idempotency_key"`
 }
@@ -0,0 +1,16 @@
+package dto
+
+// CreateWebhook subscribes url to the given event types. A secret is generated when none is given.
+type CreateWebhook struct {
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types"`
+}
+
+// UpdateWebhook is a partial update of a subscription: nil fields are left untouched. Enabling a
+// subscription also clears its failure count.
+type UpdateWebhook struct {
+	URL        *string   `json:"url"`
+	EventTypes *[]string `json:"event_types"`
+	Enabled    *bool     `json:"enabled"`
+}
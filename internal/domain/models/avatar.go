@@ -0,0 +1,15 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Avatar describes the current avatar of a user. Its renditions live in the blob store,
+// one per size, under a version derived from the uploaded content.
+type Avatar struct {
+	UserID      uuid.UUID `json:"user_id" db:"user_id"`
+	Version     string    `json:"version" db:"version"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Device is a browser family signing in to an account from a network. Signing in from an
+// unknown one raises a new device alert.
+type Device struct {
+	ID              uuid.UUID `json:"id" db:"id"`
+	UserID          uuid.UUID `json:"-" db:"user_id"`
+	UserAgentFamily string    `json:"user_agent_family" db:"user_agent_family"`
+	IPPrefix        string    `json:"ip_prefix" db:"ip_prefix"`
+	LastIP          string    `json:"last_ip" db:"last_ip"`
+	Country         *string   `json:"country,omitempty" db:"country"`
+	Region          *string   `json:"region,omitempty" db:"region"`
+	Latitude        *float64  `json:"-" db:"latitude"`
+	Longitude       *float64  `json:"-" db:"longitude"`
+	FirstSeenAt     time.Time `json:"first_seen_at" db:"first_seen_at"`
+	LastSeenAt      time.Time `json:"last_seen_at" db:"last_seen_at"`
+}
+
+// LoginClient is what a login request tells about where it comes from.
+type LoginClient struct {
+	IP        string
+	UserAgent string
+}
+
+// PendingLogin is a login from a new device waiting for the user to confirm it by email.
+type PendingLogin struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Device     Device    `json:"device"`
+	Suspicious bool      `json:"suspicious"`
+}
+
+type NewDeviceLoginData struct {
+	DeviceID   uuid.UUID `json:"device_id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	Region     string    `json:"region,omitempty"`
+	Suspicious bool      `json:"suspicious"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Real-time event types delivered to connected clients.
+const (
+	EventFriendRequest  = "friend.request"
+	EventFriendAccepted = "friend.accepted"
+	EventSessionRevoked = "session.revoked"
+	EventNotification   = "notification"
+	EventMessageNew     = "message.new"
+	EventMessageUpdated = "message.updated"
+	EventMessagesRead   = "message.read"
+	// EventSessionExpired ends an event stream whose access token expired. It is not logged.
+	EventSessionExpired = "session.expired"
+)
+
+// Event is a real-time event addressed to one user.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// SessionRevokedData is the payload of EventSessionRevoked. An empty SessionID revokes
+// every session of the user.
+type SessionRevokedData struct {
+	SessionID string `json:"session_id,omitempty"`
+}
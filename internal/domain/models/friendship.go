@@ -0,0 +1,41 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	FriendshipPending  = "pending"
+	FriendshipAccepted = "accepted"
+)
+
+type Friendship struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	RequesterID uuid.UUID  `json:"requester_id" db:"requester_id"`
+	AddresseeID uuid.UUID  `json:"addressee_id" db:"addressee_id"`
+	State       string     `json:"state" db:"state"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty" db:"responded_at"`
+}
+
+// FriendshipEntry is a friendship or request seen from one side: the other user and
+// the moment the entry is ordered by.
+type FriendshipEntry struct {
+	FriendshipID uuid.UUID `db:"friendship_id"`
+	UserID       uuid.UUID `db:"user_id"`
+	Username     string    `db:"username"`
+	DisplayName  *string   `db:"display_name"`
+	AvatarURL    *string   `db:"avatar_url"`
+	Since        time.Time `db:"since"`
+}
+
+// FriendSuggestion is a friend of a friend ranked by the number of friends shared with the user.
+type FriendSuggestion struct {
+	UserID        uuid.UUID `json:"id" db:"user_id"`
+	Username      string    `json:"username" db:"username"`
+	DisplayName   *string   `json:"display_name,omitempty" db:"display_name"`
+	AvatarURL     *string   `json:"avatar_url,omitempty" db:"avatar_url"`
+	Score         int       `json:"score" db:"score"`
+	MutualFriends []string  `json:"mutual_friends" db:"mutual_friends"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Invite lets people register while registration is invite-only. CreatedBy is nil for invites
+// created by admins; Email, if set, is the only address the invite registers.
+type Invite struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	Code      string     `json:"code" db:"code"`
+	CreatedBy *uuid.UUID `json:"created_by,omitempty" db:"created_by"`
+	Email     *string    `json:"email,omitempty" db:"email"`
+	MaxUses   int        `json:"max_uses" db:"max_uses"`
+	Uses      int        `json:"uses" db:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// InviteRedemption is a user who registered with an invite.
+type InviteRedemption struct {
+	UserID     uuid.UUID `json:"user_id" db:"user_id"`
+	Username   string    `json:"username" db:"username"`
+	RedeemedAt time.Time `json:"redeemed_at" db:"redeemed_at"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Kinds of ledger transactions.
+const (
+	LedgerKindAdjustment       = "adjustment"
+	LedgerKindReferralReward   = "referral_reward"
+	LedgerKindTransfer         = "transfer"
+	LedgerKindTransferReversal = "transfer_reversal"
+	LedgerKindTopUp            = "top_up"
+	LedgerKindTopUpReversal    = "top_up_reversal"
+	LedgerKindAccountPurge     = "account_purge"
+)
+
+// System accounts sit on the other side of the entries of users, so every transaction balances.
+// Unlike the accounts of users they may go negative.
+const (
+	LedgerSystemAdjustments = "adjustments"
+	LedgerSystemRewards     = "rewards"
+	// LedgerSystemPayments holds what providers collected from users, LedgerSystemPromotions
+	// what discounts gave away, and LedgerSystemPaymentLosses what refunds and chargebacks
+	// could not take back from balances already spent.
+	LedgerSystemPayments      = "payments"
+	LedgerSystemPromotions    = "promotions"
+	LedgerSystemPaymentLosses = "payment_losses"
+	// LedgerSystemForfeited holds what was left on the balances of purged accounts.
+	LedgerSystemForfeited = "forfeited"
+)
+
+// LedgerPosting moves Amount minor units of Currency into an account, or out of it when
+// negative. The account is the one of UserID, or the system account System.
+type LedgerPosting struct {
+	UserID   *uuid.UUID
+	System   string
+	Currency string
+	Amount   int64
+}
+
+// LedgerTransaction is an immutable set of entries that sum to zero in each currency.
+type LedgerTransaction struct {
+	ID             uuid.UUID     `json:"id" db:"id"`
+	IdempotencyKey string        `json:"-" db:"idempotency_key"`
+	RequestHash    string        `json:"-" db:"request_hash"`
+	Kind           string        `json:"kind" db:"kind"`
+	Description    string        `json:"description" db:"description"`
+	CreatedAt      time.Time     `json:"created_at" db:"created_at"`
+	Entries        []LedgerEntry `json:"entries" db:"-"`
+}
+
+type LedgerEntry struct {
+	ID            uuid.UUID `json:"id" db:"id"`
+	TransactionID uuid.UUID `json:"transaction_id" db:"transaction_id"`
+	AccountID     uuid.UUID `json:"-" db:"account_id"`
+	Amount        int64     `json:"amount" db:"amount"`
+	Currency      string    `json:"currency" db:"currency"`
+	BalanceAfter  int64     `json:"balance_after" db:"balance_after"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// Balance is what a user holds in one currency, in minor units.
+type Balance struct {
+	Currency string `json:"currency" db:"currency"`
+	Amount   int64  `json:"amount" db:"balance"`
+}
+
+// StatementEntry is an entry on an account of a user, as their transaction history shows it.
+type StatementEntry struct {
+	ID            uuid.UUID `json:"id" db:"id"`
+	TransactionID uuid.UUID `json:"transaction_id" db:"transaction_id"`
+	Kind          string    `json:"kind" db:"kind"`
+	Description   string    `json:"description" db:"description"`
+	Amount        int64     `json:"amount" db:"amount"`
+	Currency      string    `json:"currency" db:"currency"`
+	BalanceAfter  int64     `json:"balance_after" db:"balance_after"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
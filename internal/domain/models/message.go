@@ -0,0 +1,57 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Message is a direct message. A deleted message keeps its place in the history with an empty body.
+type Message struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	ConversationID uuid.UUID  `json:"conversation_id" db:"conversation_id"`
+	SenderID       uuid.UUID  `json:"sender_id" db:"sender_id"`
+	Body           string     `json:"body" db:"body"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	EditedAt       *time.Time `json:"edited_at,omitempty" db:"edited_at"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// ConversationMember is one side of a conversation.
+type ConversationMember struct {
+	ConversationID uuid.UUID  `db:"conversation_id"`
+	UserID         uuid.UUID  `db:"user_id"`
+	LastReadAt     *time.Time `db:"last_read_at"`
+	Muted          bool       `db:"muted"`
+}
+
+// ConversationEntry is a conversation seen from one side: the other user, the latest message
+// that is not deleted and the number of messages the user has not read yet.
+type ConversationEntry struct {
+	ConversationID      uuid.UUID  `db:"conversation_id"`
+	UserID              uuid.UUID  `db:"user_id"`
+	Username            string     `db:"username"`
+	DisplayName         *string    `db:"display_name"`
+	AvatarURL           *string    `db:"avatar_url"`
+	LastMessageAt       time.Time  `db:"last_message_at"`
+	Muted               bool       `db:"muted"`
+	UnreadCount         int        `db:"unread_count"`
+	PeerLastReadAt      *time.Time `db:"peer_last_read_at"`
+	LastMessageID       *uuid.UUID `db:"last_message_id"`
+	LastMessageSenderID *uuid.UUID `db:"last_message_sender_id"`
+	LastMessageBody     *string    `db:"last_message_body"`
+	LastMessageSentAt   *time.Time `db:"last_message_sent_at"`
+}
+
+// NewMessageData is the payload of EventMessageNew. Muted tells clients not to alert.
+type NewMessageData struct {
+	Message *Message `json:"message"`
+	Muted   bool     `json:"muted"`
+}
+
+// MessagesReadData is the payload of EventMessagesRead: UserID has read everything in the
+// conversation sent before ReadAt.
+type MessagesReadData struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	ReadAt         time.Time `json:"read_at"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Notification types. Users can turn each of them off.
+const (
+	NotificationFriendRequest    = "friend_request"
+	NotificationFriendAccepted   = "friend_accepted"
+	NotificationSecurityAlert    = "security_alert"
+	NotificationNewDeviceLogin   = "new_device_login"
+	NotificationReferralCredited = "referral_credited"
+	NotificationTransferSent     = "transfer_sent"
+	NotificationTransferReceived = "transfer_received"
+	NotificationTransferReversed = "transfer_reversed"
+	NotificationPaymentSucceeded = "payment_succeeded"
+	NotificationPaymentReversed  = "payment_reversed"
+)
+
+// NotificationTypes lists every notification type.
+var NotificationTypes = []string{
+	NotificationFriendRequest,
+	NotificationFriendAccepted,
+	NotificationSecurityAlert,
+	NotificationNewDeviceLogin,
+	NotificationReferralCredited,
+	NotificationTransferSent,
+	NotificationTransferReceived,
+	NotificationTransferReversed,
+	NotificationPaymentSucceeded,
+	NotificationPaymentReversed,
+}
+
+// Security alert reasons.
+const (
+	SecurityAlertEmailChanged    = "email_changed"
+	SecurityAlertPasswordChanged = "password_changed"
+	SecurityAlertSuspiciousLogin = "suspicious_login"
+)
+
+type SecurityAlertData struct {
+	Reason string `json:"reason"`
+}
+
+type Notification struct {
+	ID        uuid.UUID       `json:"id" db:"id"`
+	UserID    uuid.UUID       `json:"-" db:"user_id"`
+	Type      string          `json:"type" db:"type"`
+	Data      json.RawMessage `json:"data" db:"data"`
+	ReadAt    *time.Time      `json:"read_at,omitempty" db:"read_at"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
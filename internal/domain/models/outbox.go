@@ -0,0 +1,59 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Domain event types written to the outbox. Unlike real-time events they describe state
+// changes for other services and are delivered at least once.
+const (
+	DomainUserRegistered      = "user.registered"
+	DomainUserEmailChanged    = "user.email_changed"
+	DomainUserEmailVerified   = "user.email_verified"
+	DomainUserPasswordChanged = "user.password_changed"
+	DomainUserDeleted         = "user.deleted"
+	DomainUserRestored        = "user.restored"
+)
+
+// OutboxEvent is a domain event waiting in the outbox to be published.
+type OutboxEvent struct {
+	ID        uuid.UUID       `json:"id" db:"id"`
+	Type      string          `json:"type" db:"type"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	Attempts  int             `json:"attempts" db:"attempts"`
+}
+
+type UserRegisteredData struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+}
+
+type UserEmailChangedData struct {
+	UserID        string `json:"user_id"`
+	Email         string `json:"email"`
+	PreviousEmail string `json:"previous_email"`
+}
+
+type UserEmailVerifiedData struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+}
+
+type UserPasswordChangedData struct {
+	UserID string `json:"user_id"`
+}
+
+type UserDeletedData struct {
+	UserID     uuid.UUID `json:"user_id"`
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+// UserRestoredData follows a user.deleted event when the user logs in again before the purge.
+type UserRestoredData struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Payment statuses. A pending payment waits for the payer; once it succeeds its amount is on
+// the balance of the user until it is refunded or charged back.
+const (
+	PaymentPending     = "pending"
+	PaymentSucceeded   = "succeeded"
+	PaymentFailed      = "failed"
+	PaymentRefunded    = "refunded"
+	PaymentChargedBack = "charged_back"
+)
+
+// Payment tops up the balance of a user through a payment provider. The user pays Charge,
+// which is Amount less the Discount of the promo code they redeemed.
+type Payment struct {
+	ID                    uuid.UUID  `json:"id" db:"id"`
+	UserID                *uuid.UUID `json:"user_id" db:"user_id"`
+	Provider              string     `json:"provider" db:"provider"`
+	InvoiceID             *string    `json:"-" db:"invoice_id"`
+	CheckoutURL           *string    `json:"checkout_url,omitempty" db:"checkout_url"`
+	Amount                int64      `json:"amount" db:"amount"`
+	Discount              int64      `json:"discount" db:"discount"`
+	Charge                int64      `json:"charge" db:"charge"`
+	Currency              string     `json:"currency" db:"currency"`
+	Status                string     `json:"status" db:"status"`
+	IdempotencyKey        string     `json:"-" db:"idempotency_key"`
+	RedemptionID          *uuid.UUID `json:"-" db:"redemption_id"`
+	TransactionID         *uuid.UUID `json:"transaction_id,omitempty" db:"transaction_id"`
+	ReversalTransactionID *uuid.UUID `json:"reversal_transaction_id,omitempty" db:"reversal_transaction_id"`
+	CreatedAt             time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at" db:"updated_at"`
+	PaidAt                *time.Time `json:"paid_at,omitempty" db:"paid_at"`
+	ReversedAt            *time.Time `json:"reversed_at,omitempty" db:"reversed_at"`
+}
+
+// PaymentEvent is a status a provider reported for the invoice of a payment. Providers may
+// report an event more than once; only its first delivery is applied.
+type PaymentEvent struct {
+	Provider string
+	EventID  string
+	Status   string
+	Amount   int64
+	Currency string
+	Payload  []byte
+}
+
+// PaymentData is the data of payment notifications.
+type PaymentData struct {
+	PaymentID uuid.UUID `json:"payment_id"`
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+	Status    string    `json:"status"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Who may send friend requests to a user.
+const (
+	FriendRequestsEveryone         = "everyone"
+	FriendRequestsFriendsOfFriends = "friends_of_friends"
+	FriendRequestsNobody           = "nobody"
+)
+
+type PrivacySettings struct {
+	UserID               uuid.UUID `json:"-" db:"user_id"`
+	FriendRequestsFrom   string    `json:"friend_requests_from" db:"friend_requests_from"`
+	FriendListVisibility string    `json:"friend_list_visibility" db:"friend_list_visibility"`
+	HideOnlineStatus     bool      `json:"hide_online_status" db:"hide_online_status"`
+}
+
+// DefaultPrivacySettings are the settings of users who never changed them.
+func DefaultPrivacySettings(userId uuid.UUID) *PrivacySettings {
+	return &PrivacySettings{
+		UserID:               userId,
+		FriendRequestsFrom:   FriendRequestsEveryone,
+		FriendListVisibility: VisibilityFriends,
+	}
+}
+
+type BlockedUser struct {
+	UserID      uuid.UUID `json:"id" db:"user_id"`
+	Username    string    `json:"username" db:"username"`
+	DisplayName *string   `json:"display_name,omitempty" db:"display_name"`
+	AvatarURL   *string   `json:"avatar_url,omitempty" db:"avatar_url"`
+	BlockedAt   time.Time `json:"blocked_at" db:"blocked_at"`
+}
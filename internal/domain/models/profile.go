@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Visibility levels of profile fields.
+const (
+	VisibilityPublic  = "public"
+	VisibilityFriends = "friends"
+	VisibilityPrivate = "private"
+)
+
+type Profile struct {
+	UserID             uuid.UUID  `json:"user_id" db:"user_id"`
+	Username           string     `json:"username" db:"username"`
+	DisplayName        *string    `json:"display_name" db:"display_name"`
+	Bio                *string    `json:"bio" db:"bio"`
+	AvatarURL          *string    `json:"avatar_url" db:"avatar_url"`
+	Locale             *string    `json:"locale" db:"locale"`
+	Timezone           *string    `json:"timezone" db:"timezone"`
+	Birthday           *time.Time `json:"birthday" db:"birthday"`
+	BirthdayVisibility string     `json:"birthday_visibility" db:"birthday_visibility"`
+}
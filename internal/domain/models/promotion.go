@@ -0,0 +1,82 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Kinds of promo codes.
+const (
+	PromoPercent = "percent"
+	PromoFixed   = "fixed"
+)
+
+// PromoCode grants a discount to the users who redeem it. Value is a percentage for percent
+// codes and minor units of Currency for fixed ones.
+type PromoCode struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	Code           string     `json:"code" db:"code"`
+	Kind           string     `json:"kind" db:"kind"`
+	Value          int64      `json:"value" db:"value"`
+	Currency       string     `json:"currency" db:"currency"`
+	MinSpend       int64      `json:"min_spend" db:"min_spend"`
+	NewUsersOnly   bool       `json:"new_users_only" db:"new_users_only"`
+	StartsAt       *time.Time `json:"starts_at,omitempty" db:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at,omitempty" db:"ends_at"`
+	MaxRedemptions *int       `json:"max_redemptions,omitempty" db:"max_redemptions"`
+	PerUserLimit   int        `json:"per_user_limit" db:"per_user_limit"`
+	Redemptions    int        `json:"redemptions" db:"redemptions"`
+	Description    string     `json:"description" db:"description"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+}
+
+// Active reports whether the code can be redeemed at the time.
+func (p *PromoCode) Active(at time.Time) bool {
+	return p.DisabledAt == nil &&
+		(p.StartsAt == nil || !at.Before(*p.StartsAt)) &&
+		(p.EndsAt == nil || at.Before(*p.EndsAt))
+}
+
+// PromoStats reports how a promo code was used.
+type PromoStats struct {
+	Redeemed int `json:"redeemed" db:"redeemed"`
+	Used     int `json:"used" db:"used"`
+	Users    int `json:"users" db:"users"`
+}
+
+type PromoRedemption struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	PromoID    uuid.UUID  `json:"promo_id" db:"promo_id"`
+	UserID     *uuid.UUID `json:"user_id" db:"user_id"`
+	Username   *string    `json:"username" db:"username"`
+	RedeemedAt time.Time  `json:"redeemed_at" db:"redeemed_at"`
+	UsedAt     *time.Time `json:"used_at,omitempty" db:"used_at"`
+}
+
+// Discount is a promo code a user redeemed and no payment has used yet. It expires with
+// the code.
+type Discount struct {
+	RedemptionID uuid.UUID  `json:"-" db:"redemption_id"`
+	Code         string     `json:"code" db:"code"`
+	Kind         string     `json:"kind" db:"kind"`
+	Value        int64      `json:"value" db:"value"`
+	Currency     string     `json:"currency" db:"currency"`
+	MinSpend     int64      `json:"min_spend" db:"min_spend"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	RedeemedAt   time.Time  `json:"redeemed_at" db:"redeemed_at"`
+}
+
+// Apply returns how much the discount takes off a payment, or zero when the payment is in
+// another currency or below the minimum spend.
+func (d *Discount) Apply(amount int64, currency string) int64 {
+	if currency != d.Currency || amount < d.MinSpend || amount <= 0 {
+		return 0
+	}
+
+	if d.Kind == PromoPercent {
+		return amount * d.Value / 100
+	}
+
+	return min(d.Value, amount)
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Referral statuses. A pending referral is credited once the referee qualifies, or rejected.
+const (
+	ReferralPending  = "pending"
+	ReferralCredited = "credited"
+	ReferralRejected = "rejected"
+)
+
+// Reasons a referral is rejected for.
+const (
+	ReferralReasonSelfReferral = "self_referral"
+	ReferralReasonCapReached   = "cap_reached"
+)
+
+type Referral struct {
+	ID              uuid.UUID  `json:"id" db:"id"`
+	ReferrerID      uuid.UUID  `json:"-" db:"referrer_id"`
+	RefereeID       uuid.UUID  `json:"referee_id" db:"referee_id"`
+	RefereeUsername string     `json:"referee_username" db:"referee_username"`
+	Status          string     `json:"status" db:"status"`
+	Reason          *string    `json:"reason,omitempty" db:"reason"`
+	Reward          int64      `json:"reward" db:"reward"`
+	UserAgentFamily string     `json:"-" db:"user_agent_family"`
+	IPPrefix        string     `json:"-" db:"ip_prefix"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+}
+
+// Referrer is the owner of a referral code.
+type Referrer struct {
+	UserID uuid.UUID `db:"user_id"`
+	Email  string    `db:"email"`
+}
+
+// ReferralStats counts the referrals of a referrer by status.
+type ReferralStats struct {
+	Pending  int   `json:"pending" db:"pending"`
+	Credited int   `json:"credited" db:"credited"`
+	Rejected int   `json:"rejected" db:"rejected"`
+	Rewarded int64 `json:"rewarded" db:"rewarded"`
+}
+
+// ReferredBy is the referral a user registered through, as the referee sees it.
+type ReferredBy struct {
+	Username string `json:"username" db:"username"`
+	Status   string `json:"status" db:"status"`
+}
+
+// ReferralSummary is what a user sees of the referral program: their code, how their referrals
+// went and who referred them, if anyone.
+type ReferralSummary struct {
+	Code       string        `json:"code"`
+	Stats      ReferralStats `json:"stats"`
+	ReferredBy *ReferredBy   `json:"referred_by,omitempty"`
+}
+
+type ReferralCreditedData struct {
+	ReferralID      uuid.UUID `json:"referral_id"`
+	RefereeUsername string    `json:"referee_username"`
+	Reward          int64     `json:"reward"`
+	Currency        string    `json:"currency"`
+}
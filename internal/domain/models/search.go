@@ -0,0 +1,14 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// UserSearchResult is a user matching a search query. Score ranks prefix matches above fuzzy ones.
+type UserSearchResult struct {
+	UserID      uuid.UUID `json:"id" db:"user_id"`
+	Username    string    `json:"username" db:"username"`
+	DisplayName *string   `json:"display_name,omitempty" db:"display_name"`
+	AvatarURL   *string   `json:"avatar_url,omitempty" db:"avatar_url"`
+	Score       float32   `json:"-" db:"score"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Transfer statuses. Only operators can reverse a completed transfer.
+const (
+	TransferCompleted = "completed"
+	TransferReversed  = "reversed"
+)
+
+// Transfer moves funds from one user to a friend. Its parties are nil once they delete
+// their accounts.
+type Transfer struct {
+	ID                    uuid.UUID  `json:"id" db:"id"`
+	SenderID              *uuid.UUID `json:"sender_id" db:"sender_id"`
+	SenderUsername        *string    `json:"sender_username" db:"sender_username"`
+	RecipientID           *uuid.UUID `json:"recipient_id" db:"recipient_id"`
+	RecipientUsername     *string    `json:"recipient_username" db:"recipient_username"`
+	Amount                int64      `json:"amount" db:"amount"`
+	Currency              string     `json:"currency" db:"currency"`
+	Note                  string     `json:"note" db:"note"`
+	Status                string     `json:"status" db:"status"`
+	IdempotencyKey        string     `json:"-" db:"idempotency_key"`
+	TransactionID         uuid.UUID  `json:"transaction_id" db:"transaction_id"`
+	ReversalTransactionID *uuid.UUID `json:"reversal_transaction_id,omitempty" db:"reversal_transaction_id"`
+	CreatedAt             time.Time  `json:"created_at" db:"created_at"`
+	ReversedAt            *time.Time `json:"reversed_at,omitempty" db:"reversed_at"`
+}
+
+// TransferData is the data of transfer notifications. Username is the other party.
+type TransferData struct {
+	TransferID uuid.UUID `json:"transfer_id"`
+	Username   string    `json:"username"`
+	Amount     int64     `json:"amount"`
+	Currency   string    `json:"currency"`
+	Note       string    `json:"note,omitempty"`
+}
@@ -12,4 +12,21 @@ type User struct {
 	Password  []byte    `json:"password" db:"password"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	// DeletedAt and PurgeAfter are set while the account waits for its hard purge.
+	DeletedAt  *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	PurgeAfter *time.Time `json:"purge_after,omitempty" db:"purge_after"`
+}
+
+// MailRecipient is who an email to a user is addressed to and in which language.
+type MailRecipient struct {
+	UserID   uuid.UUID `db:"user_id"`
+	Username string    `db:"username"`
+	Email    string    `db:"email"`
+	Locale   *string   `db:"locale"`
+}
+
+// EmailVerification is a pending check that a user owns an address.
+type EmailVerification struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
 }
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// WebhookEventTypes lists the domain events partners can subscribe to.
+var WebhookEventTypes = []string{
+	DomainUserRegistered,
+	DomainUserEmailChanged,
+	DomainUserEmailVerified,
+	DomainUserDeleted,
+	DomainUserRestored,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookSubscription sends the events of EventTypes to URL. Secret is shown only when it is
+// created or rotated.
+type WebhookSubscription struct {
+	ID                  uuid.UUID  `json:"id" db:"id"`
+	URL                 string     `json:"url" db:"url"`
+	Secret              string     `json:"-" db:"secret"`
+	EventTypes          []string   `json:"event_types" db:"event_types"`
+	ConsecutiveFailures int        `json:"consecutive_failures" db:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	DisabledReason      *string    `json:"disabled_reason,omitempty" db:"disabled_reason"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// WebhookDelivery is one event sent to one subscription, retried until it succeeds or runs out
+// of attempts.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id" db:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id" db:"subscription_id"`
+	EventID        uuid.UUID       `json:"event_id" db:"event_id"`
+	EventType      string          `json:"event_type" db:"event_type"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	EventCreatedAt time.Time       `json:"event_created_at" db:"event_created_at"`
+	State          string          `json:"state" db:"state"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" db:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code,omitempty" db:"last_status_code"`
+	LastError      *string         `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty" db:"delivered_at"`
+}
+
+// WebhookAttempt is one request made for a delivery. StatusCode is missing when no response
+// arrived.
+type WebhookAttempt struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	DeliveryID  uuid.UUID `json:"-" db:"delivery_id"`
+	AttemptedAt time.Time `json:"attempted_at" db:"attempted_at"`
+	StatusCode  *int      `json:"status_code,omitempty" db:"status_code"`
+	Error       *string   `json:"error,omitempty" db:"error"`
+	DurationMs  int       `json:"duration_ms" db:"duration_ms"`
+}
+
+// PendingWebhook is a claimed delivery together with where and how to send it.
+type PendingWebhook struct {
+	ID             uuid.UUID       `db:"id"`
+	SubscriptionID uuid.UUID       `db:"subscription_id"`
+	EventID        uuid.UUID       `db:"event_id"`
+	EventType      string          `db:"event_type"`
+	Payload        json.RawMessage `db:"payload"`
+	EventCreatedAt time.Time       `db:"event_created_at"`
+	Attempts       int             `db:"attempts"`
+	URL            string          `db:"url"`
+	Secret         string          `db:"secret"`
+}
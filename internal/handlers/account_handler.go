@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"boton-back/internal/lib/cookies"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type AccountService interface {
+	DeleteAccount(ctx context.Context, userId uuid.UUID, password string) (time.Time, error)
+}
+
+type AccountHandler struct {
+	log            *slog.Logger
+	accountService *services.AccountService
+	cookies        *cookies.Manager
+}
+
+func NewAccountHandler(log *slog.Logger, accountService *services.AccountService, cookieManager *cookies.Manager) *AccountHandler {
+	return &AccountHandler{
+		log:            log,
+		accountService: accountService,
+		cookies:        cookieManager,
+	}
+}
+
+func (h *AccountHandler) DeleteAccount(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	purgeAfter, err := h.accountService.DeleteAccount(c.Request.Context(), userID, input.Password)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidCredentials) || errors.Is(err, services.ErrEmptyField) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.cookies.Clear(c.Writer)
+
+	c.JSON(http.StatusOK, gin.H{"message": "account deleted", "purge_after": purgeAfter})
+}
@@ -1,44 +1,69 @@
 package handlers
 
 import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cookies"
+	"boton-back/internal/lib/logger/sl"
 	"boton-back/internal/services"
 	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"log/slog"
+	"net/http"
 )
 
 type AuthService interface {
-	Register(ctx context.Context, login, email, password string) error
-	Login(ctx context.Context, input, password string) (string, string, error)
+	Register(ctx context.Context, login, email, password string, opts services.RegisterOptions) error
+	Login(ctx context.Context, input, password string, client models.LoginClient) (string, string, error)
+	ConfirmLogin(ctx context.Context, token string) (string, string, error)
+	Refresh(ctx context.Context, refreshToken string) (string, string, error)
+	Logout(ctx context.Context, refreshToken string)
 	UpdateUserEmail(ctx context.Context, userId, oldEmail, newEmail string) (string, error)
 	UpdateUserPassword(ctx context.Context, userId, oldPassword, newPassword string) (string, error)
 }
 
+// loginModeCookie asks Login to deliver the token pair as session cookies instead of the body.
+const loginModeCookie = "cookie"
+
 type AuthHandler struct {
 	log         *slog.Logger
 	authService *services.AuthService
+	cookies     *cookies.Manager
 }
 
-func NewAuthHandler(log *slog.Logger, authService *services.AuthService) *AuthHandler {
+func NewAuthHandler(log *slog.Logger, authService *services.AuthService, cookieManager *cookies.Manager) *AuthHandler {
 	return &AuthHandler{
 		log:         log,
 		authService: authService,
+		cookies:     cookieManager,
 	}
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
 	var input struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Username     string `json:"username"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		InviteCode   string `json:"invite_code"`
+		ReferralCode string `json:"referral_code"`
 	}
 	if err := c.BindJSON(&input); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	err := h.authService.Register(c.Request.Context(), input.Username, input.Email, input.Password)
+	opts := services.RegisterOptions{
+		InviteCode:   input.InviteCode,
+		ReferralCode: input.ReferralCode,
+		Client:       models.LoginClient{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()},
+	}
+
+	err := h.authService.Register(c.Request.Context(), input.Username, input.Email, input.Password, opts)
 	if err != nil {
+		if errors.Is(err, services.ErrRegistrationClosed) {
+			c.JSON(http.StatusForbidden, gin.H{"error": services.ErrRegistrationClosed.Error()})
+			return
+		}
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
@@ -50,21 +75,128 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	var input struct {
 		Input    string `json:"input"`
 		Password string `json:"password"`
+		Mode     string `json:"mode"`
 	}
 	if err := c.BindJSON(&input); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	accessToken, refreshToken, err := h.authService.Login(c.Request.Context(), input.Input, input.Password)
+	client := models.LoginClient{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+
+	accessToken, refreshToken, err := h.authService.Login(c.Request.Context(), input.Input, input.Password, client)
 	if err != nil {
+		if errors.Is(err, services.ErrLoginConfirmationRequired) {
+			c.JSON(http.StatusAccepted, gin.H{"status": "confirmation_required", "message": services.ErrLoginConfirmationRequired.Error()})
+			return
+		}
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
+	if input.Mode == loginModeCookie {
+		h.writeSession(c, accessToken, refreshToken)
+		return
+	}
+
+	c.JSON(200, gin.H{"accessToken": accessToken, "refresh_token": refreshToken})
+}
+
+// ConfirmLogin finishes a login from a new device with the token of the confirmation email and
+// delivers the token pair like Login.
+func (h *AuthHandler) ConfirmLogin(c *gin.Context) {
+	var input struct {
+		Token string `json:"token"`
+		Mode  string `json:"mode"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	accessToken, refreshToken, err := h.authService.ConfirmLogin(c.Request.Context(), input.Token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidLoginConfirmation) {
+			c.JSON(400, gin.H{"error": services.ErrInvalidLoginConfirmation.Error()})
+			return
+		}
+		h.log.Error("failed to confirm login", sl.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm login"})
+		return
+	}
+
+	if input.Mode == loginModeCookie {
+		h.writeSession(c, accessToken, refreshToken)
+		return
+	}
+
+	c.JSON(200, gin.H{"accessToken": accessToken, "refresh_token": refreshToken})
+}
+
+// Refresh exchanges a refresh token for a new pair. The token is taken from the request body
+// or, for the web client, from the refresh token cookie, in which case the new pair is
+// written back as cookies too.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	_ = c.ShouldBindJSON(&input)
+
+	fromCookie := false
+	if input.RefreshToken == "" {
+		if cookie, err := c.Cookie(cookies.RefreshTokenName); err == nil {
+			input.RefreshToken = cookie
+			fromCookie = true
+		}
+	}
+
+	accessToken, refreshToken, err := h.authService.Refresh(c.Request.Context(), input.RefreshToken)
+	if err != nil {
+		if fromCookie {
+			h.cookies.Clear(c.Writer)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if fromCookie {
+		h.writeSession(c, accessToken, refreshToken)
+		return
+	}
+
 	c.JSON(200, gin.H{"accessToken": accessToken, "refresh_token": refreshToken})
 }
 
+// Logout drops the session cookies of the web client and closes the real-time connections
+// of the session.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	_ = c.ShouldBindJSON(&input)
+
+	if input.RefreshToken == "" {
+		input.RefreshToken, _ = c.Cookie(cookies.RefreshTokenName)
+	}
+
+	h.authService.Logout(c.Request.Context(), input.RefreshToken)
+
+	h.cookies.Clear(c.Writer)
+
+	c.JSON(200, gin.H{"message": "logged out"})
+}
+
+func (h *AuthHandler) writeSession(c *gin.Context, accessToken, refreshToken string) {
+	csrfToken, err := h.cookies.SetSession(c.Writer, accessToken, refreshToken)
+	if err != nil {
+		h.log.Error("failed to set session cookies", sl.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create session"})
+		return
+	}
+
+	c.JSON(200, gin.H{"csrf_token": csrfToken})
+}
+
 func (h *AuthHandler) UpdateUserEmail(c *gin.Context) {
 	var input struct {
 		UserID   string `json:"user_id"`
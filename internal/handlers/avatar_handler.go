@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"boton-back/internal/lib/blob"
+	"boton-back/internal/lib/images"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// multipartOverhead is the room left for multipart headers on top of the file size limit.
+const multipartOverhead = 64 << 10
+
+type AvatarService interface {
+	Upload(ctx context.Context, userId uuid.UUID, data []byte) (map[string]string, error)
+	Stat(ctx context.Context, userId uuid.UUID, version string, size int) (string, error)
+	Open(ctx context.Context, userId uuid.UUID, version string, size int) (io.ReadCloser, error)
+	Delete(ctx context.Context, userId uuid.UUID) error
+}
+
+type AvatarHandler struct {
+	log           *slog.Logger
+	avatarService *services.AvatarService
+}
+
+func NewAvatarHandler(log *slog.Logger, avatarService *services.AvatarService) *AvatarHandler {
+	return &AvatarHandler{
+		log:           log,
+		avatarService: avatarService,
+	}
+}
+
+// Upload accepts the image in the "avatar" field of a multipart form.
+func (h *AvatarHandler) Upload(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	maxBytes := h.avatarService.MaxBytes()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+multipartOverhead)
+
+	file, _, err := c.Request.FormFile("avatar")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrAvatarTooLarge.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar file is required"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	urls, err := h.avatarService.Upload(c.Request.Context(), userID, data)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAvatarTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": services.ErrAvatarTooLarge.Error()})
+		case errors.Is(err, images.ErrUnsupportedFormat), errors.Is(err, images.ErrImageTooLarge):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"avatar": urls})
+}
+
+func (h *AvatarHandler) Delete(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.avatarService.Delete(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, repository.ErrAvatarNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "avatar deleted"})
+}
+
+// Serve returns an avatar rendition. Urls are versioned by content, so responses are immutable.
+func (h *AvatarHandler) Serve(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	size, err := strconv.Atoi(c.Param("size"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid size"})
+		return
+	}
+
+	version := c.Param("version")
+
+	contentType, err := h.avatarService.Stat(c.Request.Context(), userID, version, size)
+	if err != nil {
+		avatarError(c, err)
+		return
+	}
+
+	etag := `"` + version + "-" + strconv.Itoa(size) + `"`
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", etag)
+
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	r, err := h.avatarService.Open(c.Request.Context(), userID, version, size)
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.Writer.Header().Del("ETag")
+		avatarError(c, err)
+		return
+	}
+	defer r.Close()
+
+	c.DataFromReader(http.StatusOK, -1, contentType, r, map[string]string{"X-Content-Type-Options": "nosniff"})
+}
+
+func avatarError(c *gin.Context, err error) {
+	if errors.Is(err, repository.ErrAvatarNotFound) || errors.Is(err, services.ErrAvatarSizeNotExists) || errors.Is(err, blob.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"boton-back/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+)
+
+// currentUserID returns the id of the authenticated user set by the auth middleware.
+// It writes the error response and returns false if there is none.
+func currentUserID(c *gin.Context) (uuid.UUID, bool) {
+	userIDVal, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(userIDVal.(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+// pageParams reads the cursor and limit query parameters of a paginated list.
+// It writes the error response and returns false if the limit is invalid.
+func pageParams(c *gin.Context) (string, int, bool) {
+	limit := services.DefaultPageSize
+
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > services.MaxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(services.MaxPageSize)})
+			return "", 0, false
+		}
+		limit = n
+	}
+
+	return c.Query("cursor"), limit, true
+}
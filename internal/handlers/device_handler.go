@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type DeviceService interface {
+	ListDevices(ctx context.Context, userId uuid.UUID) ([]models.Device, error)
+	ForgetDevice(ctx context.Context, userId, deviceId uuid.UUID) error
+}
+
+type DeviceHandler struct {
+	log           *slog.Logger
+	deviceService *services.DeviceService
+}
+
+func NewDeviceHandler(log *slog.Logger, deviceService *services.DeviceService) *DeviceHandler {
+	return &DeviceHandler{
+		log:           log,
+		deviceService: deviceService,
+	}
+}
+
+func (h *DeviceHandler) List(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	devices, err := h.deviceService.ListDevices(c.Request.Context(), userID)
+	if err != nil {
+		deviceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// Forget removes a device of the user, so the next login from it is treated as new.
+func (h *DeviceHandler) Forget(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return
+	}
+
+	if err = h.deviceService.ForgetDevice(c.Request.Context(), userID, deviceID); err != nil {
+		deviceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "device removed"})
+}
+
+func deviceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repository.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrDeviceNotFound.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
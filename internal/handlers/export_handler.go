@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/blob"
+	"boton-back/internal/lib/signedurl"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+type ExportService interface {
+	RequestExport(ctx context.Context, userId uuid.UUID) (*models.DataExport, error)
+	GetExport(ctx context.Context, userId, exportId uuid.UUID) (*models.DataExport, string, error)
+	OpenDownload(ctx context.Context, exportId uuid.UUID, query url.Values) (io.ReadCloser, error)
+}
+
+type ExportHandler struct {
+	log           *slog.Logger
+	exportService *services.ExportService
+}
+
+func NewExportHandler(log *slog.Logger, exportService *services.ExportService) *ExportHandler {
+	return &ExportHandler{
+		log:           log,
+		exportService: exportService,
+	}
+}
+
+func (h *ExportHandler) RequestExport(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	export, err := h.exportService.RequestExport(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrExportInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"export": export})
+}
+
+func (h *ExportHandler) GetExport(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	exportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	export, downloadURL, err := h.exportService.GetExport(c.Request.Context(), userID, exportID)
+	if err != nil {
+		if errors.Is(err, repository.ErrExportNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"export": export}
+	if downloadURL != "" {
+		response["download_url"] = downloadURL
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Download serves the archive behind a signed link, so it needs no authentication.
+func (h *ExportHandler) Download(c *gin.Context) {
+	exportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	archive, err := h.exportService.OpenDownload(c.Request.Context(), exportID, c.Request.URL.Query())
+	if err != nil {
+		switch {
+		case errors.Is(err, signedurl.ErrInvalidSignature), errors.Is(err, signedurl.ErrExpired):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, repository.ErrExportNotFound), errors.Is(err, services.ErrExportNotReady), errors.Is(err, blob.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "export not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	defer archive.Close()
+
+	c.Header("Content-Disposition", `attachment; filename="export-`+exportID.String()+`.zip"`)
+	c.Header("Cache-Control", "no-store")
+	c.DataFromReader(http.StatusOK, -1, "application/zip", archive, nil)
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type FriendService interface {
+	SendRequest(ctx context.Context, userId uuid.UUID, username string) (*models.Friendship, error)
+	AcceptRequest(ctx context.Context, userId, requestId uuid.UUID) (*models.Friendship, error)
+	DeclineRequest(ctx context.Context, userId, requestId uuid.UUID) error
+	CancelRequest(ctx context.Context, userId, requestId uuid.UUID) error
+	Unfriend(ctx context.Context, userId, friendId uuid.UUID) error
+	ListFriends(ctx context.Context, userId uuid.UUID, after string, limit int) ([]dto.Friend, string, error)
+	ListUserFriends(ctx context.Context, viewerId uuid.UUID, username string, after string, limit int) ([]dto.Friend, string, error)
+	ListRequests(ctx context.Context, userId uuid.UUID, incoming bool, after string, limit int) ([]dto.FriendRequest, string, error)
+	ListMutualFriends(ctx context.Context, viewerId uuid.UUID, username string, after string, limit int) ([]dto.Friend, string, error)
+	Suggestions(ctx context.Context, userId uuid.UUID, limit int) ([]models.FriendSuggestion, error)
+}
+
+type FriendHandler struct {
+	log           *slog.Logger
+	friendService *services.FriendService
+}
+
+func NewFriendHandler(log *slog.Logger, friendService *services.FriendService) *FriendHandler {
+	return &FriendHandler{
+		log:           log,
+		friendService: friendService,
+	}
+}
+
+func (h *FriendHandler) ListFriends(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	friends, next, err := h.friendService.ListFriends(c.Request.Context(), userID, after, limit)
+	if err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"friends": friends, "next_cursor": next})
+}
+
+func (h *FriendHandler) ListUserFriends(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	friends, next, err := h.friendService.ListUserFriends(c.Request.Context(), userID, c.Param("username"), after, limit)
+	if err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"friends": friends, "next_cursor": next})
+}
+
+func (h *FriendHandler) ListMutualFriends(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	friends, next, err := h.friendService.ListMutualFriends(c.Request.Context(), userID, c.Param("username"), after, limit)
+	if err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"friends": friends, "next_cursor": next})
+}
+
+func (h *FriendHandler) Suggestions(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	limit := services.DefaultSuggestions
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > services.MaxSuggestions {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(services.MaxSuggestions)})
+			return
+		}
+		limit = n
+	}
+
+	suggestions, err := h.friendService.Suggestions(c.Request.Context(), userID, limit)
+	if err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+}
+
+func (h *FriendHandler) ListIncomingRequests(c *gin.Context) {
+	h.listRequests(c, true)
+}
+
+func (h *FriendHandler) ListOutgoingRequests(c *gin.Context) {
+	h.listRequests(c, false)
+}
+
+func (h *FriendHandler) SendRequest(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	friendship, err := h.friendService.SendRequest(c.Request.Context(), userID, input.Username)
+	if err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"friendship": friendship})
+}
+
+func (h *FriendHandler) AcceptRequest(c *gin.Context) {
+	userID, requestID, ok := requestParams(c)
+	if !ok {
+		return
+	}
+
+	friendship, err := h.friendService.AcceptRequest(c.Request.Context(), userID, requestID)
+	if err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"friendship": friendship})
+}
+
+func (h *FriendHandler) DeclineRequest(c *gin.Context) {
+	userID, requestID, ok := requestParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.friendService.DeclineRequest(c.Request.Context(), userID, requestID); err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "friend request declined"})
+}
+
+func (h *FriendHandler) CancelRequest(c *gin.Context) {
+	userID, requestID, ok := requestParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.friendService.CancelRequest(c.Request.Context(), userID, requestID); err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "friend request cancelled"})
+}
+
+func (h *FriendHandler) Unfriend(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	friendID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err = h.friendService.Unfriend(c.Request.Context(), userID, friendID); err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "friend removed"})
+}
+
+func (h *FriendHandler) listRequests(c *gin.Context, incoming bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	requests, next, err := h.friendService.ListRequests(c.Request.Context(), userID, incoming, after, limit)
+	if err != nil {
+		friendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"requests": requests, "next_cursor": next})
+}
+
+func requestParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	requestID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, requestID, true
+}
+
+func friendError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor), errors.Is(err, services.ErrCannotBefriendSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, repository.ErrFriendshipNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrFriendshipNotFound.Error()})
+	case errors.Is(err, services.ErrFriendRequestNotAllowed), errors.Is(err, services.ErrFriendListHidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAlreadyFriends), errors.Is(err, services.ErrFriendRequestSent):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type InviteService interface {
+	CreateInvite(ctx context.Context, owner *uuid.UUID, input dto.CreateInvite) (*models.Invite, error)
+	ListInvites(ctx context.Context, owner *uuid.UUID, after string, limit int) ([]models.Invite, string, error)
+	GetInvite(ctx context.Context, owner *uuid.UUID, inviteId uuid.UUID) (*models.Invite, []models.InviteRedemption, error)
+	RevokeInvite(ctx context.Context, owner *uuid.UUID, inviteId uuid.UUID) (*models.Invite, error)
+}
+
+// InviteHandler serves the invites of the current user and, through the Admin methods, every
+// invite to operators.
+type InviteHandler struct {
+	log           *slog.Logger
+	inviteService *services.InviteService
+}
+
+func NewInviteHandler(log *slog.Logger, inviteService *services.InviteService) *InviteHandler {
+	return &InviteHandler{
+		log:           log,
+		inviteService: inviteService,
+	}
+}
+
+func (h *InviteHandler) Create(c *gin.Context) {
+	if userID, ok := currentUserID(c); ok {
+		h.create(c, &userID)
+	}
+}
+
+func (h *InviteHandler) List(c *gin.Context) {
+	if userID, ok := currentUserID(c); ok {
+		h.list(c, &userID)
+	}
+}
+
+func (h *InviteHandler) Get(c *gin.Context) {
+	if userID, ok := currentUserID(c); ok {
+		h.get(c, &userID)
+	}
+}
+
+func (h *InviteHandler) Revoke(c *gin.Context) {
+	if userID, ok := currentUserID(c); ok {
+		h.revoke(c, &userID)
+	}
+}
+
+func (h *InviteHandler) AdminCreate(c *gin.Context) {
+	h.create(c, nil)
+}
+
+func (h *InviteHandler) AdminList(c *gin.Context) {
+	h.list(c, nil)
+}
+
+func (h *InviteHandler) AdminGet(c *gin.Context) {
+	h.get(c, nil)
+}
+
+func (h *InviteHandler) AdminRevoke(c *gin.Context) {
+	h.revoke(c, nil)
+}
+
+func (h *InviteHandler) create(c *gin.Context, owner *uuid.UUID) {
+	var input dto.CreateInvite
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invite, err := h.inviteService.CreateInvite(c.Request.Context(), owner, input)
+	if err != nil {
+		inviteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"invite": invite})
+}
+
+func (h *InviteHandler) list(c *gin.Context, owner *uuid.UUID) {
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	invites, next, err := h.inviteService.ListInvites(c.Request.Context(), owner, after, limit)
+	if err != nil {
+		inviteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": invites, "next_cursor": next})
+}
+
+// get returns an invite with the users who registered with it.
+func (h *InviteHandler) get(c *gin.Context, owner *uuid.UUID) {
+	inviteID, ok := inviteID(c)
+	if !ok {
+		return
+	}
+
+	invite, redemptions, err := h.inviteService.GetInvite(c.Request.Context(), owner, inviteID)
+	if err != nil {
+		inviteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invite": invite, "redemptions": redemptions})
+}
+
+func (h *InviteHandler) revoke(c *gin.Context, owner *uuid.UUID) {
+	inviteID, ok := inviteID(c)
+	if !ok {
+		return
+	}
+
+	invite, err := h.inviteService.RevokeInvite(c.Request.Context(), owner, inviteID)
+	if err != nil {
+		inviteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invite": invite})
+}
+
+func inviteID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+		return uuid.Nil, false
+	}
+
+	return id, true
+}
+
+func inviteError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor),
+		errors.Is(err, services.ErrInvalidInviteUses),
+		errors.Is(err, services.ErrInvalidInviteExpiry),
+		errors.Is(err, services.ErrUserInviteUses),
+		errors.Is(err, services.ErrInvalidEmail):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrInviteQuotaExceeded):
+		c.JSON(http.StatusForbidden, gin.H{"error": repository.ErrInviteQuotaExceeded.Error()})
+	case errors.Is(err, repository.ErrInviteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrInviteNotFound.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type LedgerService interface {
+	Balances(ctx context.Context, userId uuid.UUID) ([]models.Balance, error)
+	History(ctx context.Context, userId uuid.UUID, currency string, after string, limit int) ([]models.StatementEntry, string, error)
+	Adjust(ctx context.Context, userId uuid.UUID, input dto.LedgerAdjustment) (*models.LedgerTransaction, error)
+}
+
+type LedgerHandler struct {
+	log           *slog.Logger
+	ledgerService *services.LedgerService
+}
+
+func NewLedgerHandler(log *slog.Logger, ledgerService *services.LedgerService) *LedgerHandler {
+	return &LedgerHandler{
+		log:           log,
+		ledgerService: ledgerService,
+	}
+}
+
+func (h *LedgerHandler) Balance(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	balances, err := h.ledgerService.Balances(c.Request.Context(), userID)
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"balances": balances})
+}
+
+// History lists the transactions of the user, optionally filtered by ?currency=.
+func (h *LedgerHandler) History(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	entries, next, err := h.ledgerService.History(c.Request.Context(), userID, c.Query("currency"), after, limit)
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transactions": entries, "next_cursor": next})
+}
+
+// AdminAdjust corrects the balance of a user. Repeating a request with the same idempotency
+// key returns the original transaction.
+func (h *LedgerHandler) AdminAdjust(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var input dto.LedgerAdjustment
+	if err = c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transaction, err := h.ledgerService.Adjust(c.Request.Context(), userID, input)
+	if err != nil {
+		ledgerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transaction": transaction})
+}
+
+func ledgerError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor),
+		errors.Is(err, services.ErrInvalidIdempotencyKey),
+		errors.Is(err, services.ErrUnsupportedCurrency),
+		errors.Is(err, services.ErrInvalidAmount),
+		errors.Is(err, services.ErrDescriptionTooLong):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrUserNotFound.Error()})
+	case errors.Is(err, repository.ErrIdempotencyKeyReused):
+		c.JSON(http.StatusConflict, gin.H{"error": repository.ErrIdempotencyKeyReused.Error()})
+	case errors.Is(err, repository.ErrInsufficientFunds):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": repository.ErrInsufficientFunds.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
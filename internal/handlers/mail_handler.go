@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"boton-back/internal/lib/mailer"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+)
+
+// DevMailHandler shows the emails kept by a development mailer. It is only routed in the local
+// environment.
+type DevMailHandler struct {
+	log    *slog.Logger
+	outbox mailer.Lister
+}
+
+func NewDevMailHandler(log *slog.Logger, outbox mailer.Lister) *DevMailHandler {
+	return &DevMailHandler{
+		log:    log,
+		outbox: outbox,
+	}
+}
+
+// List returns the kept emails, newest first.
+func (h *DevMailHandler) List(c *gin.Context) {
+	sent, err := h.outbox.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"emails": sent})
+}
+
+func (h *DevMailHandler) Get(c *gin.Context) {
+	sent, ok := h.find(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"email": sent})
+}
+
+// Preview renders the HTML body of an email as a browser would show it.
+func (h *DevMailHandler) Preview(c *gin.Context) {
+	sent, ok := h.find(c)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(sent.Message.HTML))
+}
+
+func (h *DevMailHandler) find(c *gin.Context) (mailer.Sent, bool) {
+	sent, err := h.outbox.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return mailer.Sent{}, false
+	}
+
+	for _, s := range sent {
+		if s.ID == c.Param("id") {
+			return s, true
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "email not found"})
+	return mailer.Sent{}, false
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type MessageService interface {
+	StartConversation(ctx context.Context, userId uuid.UUID, username string) (uuid.UUID, error)
+	Send(ctx context.Context, userId, conversationId uuid.UUID, body string) (*models.Message, error)
+	ListConversations(ctx context.Context, userId uuid.UUID, after string, limit int) ([]dto.Conversation, string, error)
+	ListMessages(ctx context.Context, userId, conversationId uuid.UUID, after string, limit int) ([]models.Message, string, *time.Time, error)
+	MarkRead(ctx context.Context, userId, conversationId uuid.UUID) (time.Time, error)
+	Edit(ctx context.Context, userId, messageId uuid.UUID, body string) (*models.Message, error)
+	Delete(ctx context.Context, userId, messageId uuid.UUID) error
+	SetMuted(ctx context.Context, userId, conversationId uuid.UUID, muted bool) error
+}
+
+type MessageHandler struct {
+	log            *slog.Logger
+	messageService *services.MessageService
+}
+
+func NewMessageHandler(log *slog.Logger, messageService *services.MessageService) *MessageHandler {
+	return &MessageHandler{
+		log:            log,
+		messageService: messageService,
+	}
+}
+
+func (h *MessageHandler) ListConversations(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	conversations, next, err := h.messageService.ListConversations(c.Request.Context(), userID, after, limit)
+	if err != nil {
+		messageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversations": conversations, "next_cursor": next})
+}
+
+func (h *MessageHandler) StartConversation(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversationID, err := h.messageService.StartConversation(c.Request.Context(), userID, input.Username)
+	if err != nil {
+		messageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation_id": conversationID})
+}
+
+// ListMessages returns a page of the history, newest first. read_at is when the other side
+// last read the conversation: every message sent before it has been read.
+func (h *MessageHandler) ListMessages(c *gin.Context) {
+	userID, conversationID, ok := conversationParams(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	messages, next, readAt, err := h.messageService.ListMessages(c.Request.Context(), userID, conversationID, after, limit)
+	if err != nil {
+		messageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages, "read_at": readAt, "next_cursor": next})
+}
+
+func (h *MessageHandler) Send(c *gin.Context) {
+	userID, conversationID, ok := conversationParams(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Body string `json:"body"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.messageService.Send(c.Request.Context(), userID, conversationID, input.Body)
+	if err != nil {
+		messageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": message})
+}
+
+func (h *MessageHandler) MarkRead(c *gin.Context) {
+	userID, conversationID, ok := conversationParams(c)
+	if !ok {
+		return
+	}
+
+	readAt, err := h.messageService.MarkRead(c.Request.Context(), userID, conversationID)
+	if err != nil {
+		messageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"read_at": readAt})
+}
+
+func (h *MessageHandler) Mute(c *gin.Context) {
+	h.setMuted(c, true)
+}
+
+func (h *MessageHandler) Unmute(c *gin.Context) {
+	h.setMuted(c, false)
+}
+
+func (h *MessageHandler) Edit(c *gin.Context) {
+	userID, messageID, ok := messageParams(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Body string `json:"body"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.messageService.Edit(c.Request.Context(), userID, messageID, input.Body)
+	if err != nil {
+		messageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func (h *MessageHandler) Delete(c *gin.Context) {
+	userID, messageID, ok := messageParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.messageService.Delete(c.Request.Context(), userID, messageID); err != nil {
+		messageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "message deleted"})
+}
+
+func (h *MessageHandler) setMuted(c *gin.Context, muted bool) {
+	userID, conversationID, ok := conversationParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.messageService.SetMuted(c.Request.Context(), userID, conversationID, muted); err != nil {
+		messageError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"muted": muted})
+}
+
+func conversationParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	conversationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, conversationID, true
+}
+
+func messageParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	messageID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, messageID, true
+}
+
+func messageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor),
+		errors.Is(err, services.ErrCannotMessageSelf),
+		errors.Is(err, services.ErrEmptyMessage),
+		errors.Is(err, services.ErrMessageTooLong):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMessagingNotAllowed),
+		errors.Is(err, services.ErrNotMessageSender),
+		errors.Is(err, services.ErrMessageNotEditable):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, repository.ErrConversationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrConversationNotFound.Error()})
+	case errors.Is(err, repository.ErrMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrMessageNotFound.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type NotificationService interface {
+	List(ctx context.Context, userId uuid.UUID, unreadOnly bool, after string, limit int) ([]models.Notification, string, int, error)
+	MarkRead(ctx context.Context, userId, notificationId uuid.UUID) error
+	MarkAllRead(ctx context.Context, userId uuid.UUID) (int64, error)
+	GetPreferences(ctx context.Context, userId uuid.UUID) (map[string]bool, error)
+	UpdatePreferences(ctx context.Context, userId uuid.UUID, input map[string]bool) (map[string]bool, error)
+}
+
+type NotificationHandler struct {
+	log                 *slog.Logger
+	notificationService *services.NotificationService
+}
+
+func NewNotificationHandler(log *slog.Logger, notificationService *services.NotificationService) *NotificationHandler {
+	return &NotificationHandler{
+		log:                 log,
+		notificationService: notificationService,
+	}
+}
+
+// List returns a page of notifications. ?unread=true leaves out the read ones.
+func (h *NotificationHandler) List(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	unreadOnly := c.Query("unread") == "true"
+
+	notifications, next, unread, err := h.notificationService.List(c.Request.Context(), userID, unreadOnly, after, limit)
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread_count": unread, "next_cursor": next})
+}
+
+func (h *NotificationHandler) MarkRead(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	notificationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	if err = h.notificationService.MarkRead(c.Request.Context(), userID, notificationID); err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "notification marked read"})
+}
+
+func (h *NotificationHandler) MarkAllRead(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	marked, err := h.notificationService.MarkAllRead(c.Request.Context(), userID)
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
+}
+
+func (h *NotificationHandler) GetPreferences(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	preferences, err := h.notificationService.GetPreferences(c.Request.Context(), userID)
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": preferences})
+}
+
+// UpdatePreferences takes a map of notification type to whether it is enabled.
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input map[string]bool
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preferences, err := h.notificationService.UpdatePreferences(c.Request.Context(), userID, input)
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": preferences})
+}
+
+func notificationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor),
+		errors.Is(err, services.ErrUnknownNotificationType):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrNotificationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrNotificationNotFound.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/payments"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// maxWebhookBytes bounds the body of payment webhooks; providers send small JSON documents.
+const maxWebhookBytes = 1 << 20
+
+type PaymentService interface {
+	TopUp(ctx context.Context, userId uuid.UUID, input dto.CreateTopUp) (*models.Payment, error)
+	ListPayments(ctx context.Context, userId uuid.UUID, after string, limit int) ([]models.Payment, string, error)
+	GetPayment(ctx context.Context, userId *uuid.UUID, paymentId uuid.UUID) (*models.Payment, error)
+	HandleWebhook(ctx context.Context, provider string, header http.Header, body []byte) error
+	Refund(ctx context.Context, paymentId uuid.UUID) (*models.Payment, error)
+}
+
+type PaymentHandler struct {
+	log            *slog.Logger
+	paymentService *services.PaymentService
+}
+
+func NewPaymentHandler(log *slog.Logger, paymentService *services.PaymentService) *PaymentHandler {
+	return &PaymentHandler{
+		log:            log,
+		paymentService: paymentService,
+	}
+}
+
+// TopUp starts a payment and returns the checkout URL to redirect the user to. Repeating a
+// request with the same idempotency key returns the original payment.
+func (h *PaymentHandler) TopUp(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input dto.CreateTopUp
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment, err := h.paymentService.TopUp(c.Request.Context(), userID, input)
+	if err != nil {
+		paymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"payment": payment})
+}
+
+func (h *PaymentHandler) List(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	list, next, err := h.paymentService.ListPayments(c.Request.Context(), userID, after, limit)
+	if err != nil {
+		paymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payments": list, "next_cursor": next})
+}
+
+func (h *PaymentHandler) Get(c *gin.Context) {
+	if userID, ok := currentUserID(c); ok {
+		h.get(c, &userID)
+	}
+}
+
+func (h *PaymentHandler) AdminGet(c *gin.Context) {
+	h.get(c, nil)
+}
+
+// AdminRefund returns a payment to the payer and takes it back from the balance of the user.
+func (h *PaymentHandler) AdminRefund(c *gin.Context) {
+	paymentID, ok := paymentID(c)
+	if !ok {
+		return
+	}
+
+	payment, err := h.paymentService.Refund(c.Request.Context(), paymentID)
+	if err != nil {
+		paymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payment": payment})
+}
+
+// Webhook receives the events of the provider named in the path. Any 2xx response tells the
+// provider to stop redelivering the event.
+func (h *PaymentHandler) Webhook(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBytes))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err = h.paymentService.HandleWebhook(c.Request.Context(), c.Param("provider"), c.Request.Header, body); err != nil {
+		paymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+func (h *PaymentHandler) get(c *gin.Context, userID *uuid.UUID) {
+	paymentID, ok := paymentID(c)
+	if !ok {
+		return
+	}
+
+	payment, err := h.paymentService.GetPayment(c.Request.Context(), userID, paymentID)
+	if err != nil {
+		paymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payment": payment})
+}
+
+// DevPaymentHandler plays the checkout pages of the fake payment provider. It is only routed in
+// the local environment.
+type DevPaymentHandler struct {
+	log            *slog.Logger
+	provider       *payments.Fake
+	paymentService *services.PaymentService
+}
+
+func NewDevPaymentHandler(log *slog.Logger, provider *payments.Fake, paymentService *services.PaymentService) *DevPaymentHandler {
+	return &DevPaymentHandler{
+		log:            log,
+		provider:       provider,
+		paymentService: paymentService,
+	}
+}
+
+// Checkout shows an invoice, as the checkout page its URL points to.
+func (h *DevPaymentHandler) Checkout(c *gin.Context) {
+	invoice, err := h.provider.GetInvoice(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		paymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invoice": invoice})
+}
+
+// Simulate moves an invoice to the status in the body, such as paid or charged_back, and
+// delivers the signed webhook the provider would send for it.
+func (h *DevPaymentHandler) Simulate(c *gin.Context) {
+	var input struct {
+		Status string `json:"status"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	header, body, err := h.provider.Simulate(c.Param("id"), input.Status)
+	if err != nil {
+		paymentError(c, err)
+		return
+	}
+
+	if err = h.paymentService.HandleWebhook(c.Request.Context(), h.provider.Name(), header, body); err != nil {
+		paymentError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"event": json.RawMessage(body)})
+}
+
+func paymentID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return uuid.Nil, false
+	}
+
+	return id, true
+}
+
+func paymentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor),
+		errors.Is(err, services.ErrInvalidIdempotencyKey),
+		errors.Is(err, services.ErrInvalidTopUpAmount),
+		errors.Is(err, services.ErrUnsupportedCurrency),
+		errors.Is(err, payments.ErrInvalidEvent):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPaymentEventNotApplied):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": services.ErrPaymentEventNotApplied.Error()})
+	case errors.Is(err, payments.ErrInvalidSignature):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": payments.ErrInvalidSignature.Error()})
+	case errors.Is(err, services.ErrUnknownPaymentProvider):
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrUnknownPaymentProvider.Error()})
+	case errors.Is(err, repository.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrPaymentNotFound.Error()})
+	case errors.Is(err, payments.ErrInvoiceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": payments.ErrInvoiceNotFound.Error()})
+	case errors.Is(err, repository.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrUserNotFound.Error()})
+	case errors.Is(err, repository.ErrIdempotencyKeyReused):
+		c.JSON(http.StatusConflict, gin.H{"error": repository.ErrIdempotencyKeyReused.Error()})
+	case errors.Is(err, services.ErrPaymentEventEarly):
+		c.JSON(http.StatusConflict, gin.H{"error": services.ErrPaymentEventEarly.Error()})
+	case errors.Is(err, services.ErrPaymentNotRefundable):
+		c.JSON(http.StatusConflict, gin.H{"error": services.ErrPaymentNotRefundable.Error()})
+	case errors.Is(err, services.ErrPaymentProviderFailed):
+		c.JSON(http.StatusBadGateway, gin.H{"error": services.ErrPaymentProviderFailed.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
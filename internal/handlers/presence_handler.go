@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type PresenceService interface {
+	Heartbeat(ctx context.Context, userId uuid.UUID) error
+	GetPresence(ctx context.Context, viewerId uuid.UUID, userIds []uuid.UUID) ([]models.Presence, error)
+}
+
+type PresenceHandler struct {
+	log             *slog.Logger
+	presenceService *services.PresenceService
+}
+
+func NewPresenceHandler(log *slog.Logger, presenceService *services.PresenceService) *PresenceHandler {
+	return &PresenceHandler{
+		log:             log,
+		presenceService: presenceService,
+	}
+}
+
+func (h *PresenceHandler) Heartbeat(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.presenceService.Heartbeat(c.Request.Context(), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetPresence returns the presence of the comma-separated user ids in the ids query parameter.
+func (h *PresenceHandler) GetPresence(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var ids []uuid.UUID
+	for _, raw := range strings.Split(c.Query("ids"), ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID " + raw})
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	presence, err := h.presenceService.GetPresence(c.Request.Context(), userID, ids)
+	if err != nil {
+		if errors.Is(err, services.ErrNoPresenceIDs) || errors.Is(err, services.ErrPresenceBatchTooLarge) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presence": presence})
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type PrivacyService interface {
+	Block(ctx context.Context, userId uuid.UUID, username string) (uuid.UUID, error)
+	Unblock(ctx context.Context, userId, blockedId uuid.UUID) error
+	ListBlocked(ctx context.Context, userId uuid.UUID, after string, limit int) ([]models.BlockedUser, string, error)
+	GetSettings(ctx context.Context, userId uuid.UUID) (*models.PrivacySettings, error)
+	UpdateSettings(ctx context.Context, userId uuid.UUID, input dto.UpdatePrivacySettings) (*models.PrivacySettings, error)
+}
+
+type PrivacyHandler struct {
+	log            *slog.Logger
+	privacyService *services.PrivacyService
+}
+
+func NewPrivacyHandler(log *slog.Logger, privacyService *services.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{
+		log:            log,
+		privacyService: privacyService,
+	}
+}
+
+func (h *PrivacyHandler) Block(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Username string `json:"username"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	blockedID, err := h.privacyService.Block(c.Request.Context(), userID, input.Username)
+	if err != nil {
+		privacyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"user_id": blockedID})
+}
+
+func (h *PrivacyHandler) Unblock(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	blockedID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err = h.privacyService.Unblock(c.Request.Context(), userID, blockedID); err != nil {
+		privacyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "user unblocked"})
+}
+
+func (h *PrivacyHandler) ListBlocked(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	blocked, next, err := h.privacyService.ListBlocked(c.Request.Context(), userID, after, limit)
+	if err != nil {
+		privacyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blocked": blocked, "next_cursor": next})
+}
+
+func (h *PrivacyHandler) GetSettings(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	settings, err := h.privacyService.GetSettings(c.Request.Context(), userID)
+	if err != nil {
+		privacyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"privacy": settings})
+}
+
+func (h *PrivacyHandler) UpdateSettings(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input dto.UpdatePrivacySettings
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.privacyService.UpdateSettings(c.Request.Context(), userID, input)
+	if err != nil {
+		privacyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"privacy": settings})
+}
+
+func privacyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor),
+		errors.Is(err, services.ErrCannotBlockSelf),
+		errors.Is(err, services.ErrInvalidFriendRequestsFrom),
+		errors.Is(err, services.ErrInvalidVisibility):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, repository.ErrBlockNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrBlockNotFound.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type PromotionService interface {
+	CreatePromoCode(ctx context.Context, input dto.CreatePromoCode) (*models.PromoCode, error)
+	ListPromoCodes(ctx context.Context, after string, limit int) ([]models.PromoCode, string, error)
+	GetPromoCode(ctx context.Context, promoId uuid.UUID) (*models.PromoCode, *models.PromoStats, error)
+	ListRedemptions(ctx context.Context, promoId uuid.UUID, after string, limit int) ([]models.PromoRedemption, string, error)
+	DisablePromoCode(ctx context.Context, promoId uuid.UUID) (*models.PromoCode, error)
+	Redeem(ctx context.Context, userId uuid.UUID, code string) (*models.Discount, error)
+}
+
+type PromotionHandler struct {
+	log              *slog.Logger
+	promotionService *services.PromotionService
+}
+
+func NewPromotionHandler(log *slog.Logger, promotionService *services.PromotionService) *PromotionHandler {
+	return &PromotionHandler{
+		log:              log,
+		promotionService: promotionService,
+	}
+}
+
+// Redeem applies a promo code to the account of the user.
+func (h *PromotionHandler) Redeem(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input dto.RedeemPromoCode
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	discount, err := h.promotionService.Redeem(c.Request.Context(), userID, input.Code)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"discount": discount})
+}
+
+func (h *PromotionHandler) AdminCreate(c *gin.Context) {
+	var input dto.CreatePromoCode
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promo, err := h.promotionService.CreatePromoCode(c.Request.Context(), input)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"promo_code": promo})
+}
+
+func (h *PromotionHandler) AdminList(c *gin.Context) {
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	promos, next, err := h.promotionService.ListPromoCodes(c.Request.Context(), after, limit)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promo_codes": promos, "next_cursor": next})
+}
+
+// AdminGet returns a promo code with how many times it was redeemed and used.
+func (h *PromotionHandler) AdminGet(c *gin.Context) {
+	promoID, ok := promoID(c)
+	if !ok {
+		return
+	}
+
+	promo, stats, err := h.promotionService.GetPromoCode(c.Request.Context(), promoID)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promo_code": promo, "stats": stats})
+}
+
+func (h *PromotionHandler) AdminRedemptions(c *gin.Context) {
+	promoID, ok := promoID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	redemptions, next, err := h.promotionService.ListRedemptions(c.Request.Context(), promoID, after, limit)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"redemptions": redemptions, "next_cursor": next})
+}
+
+func (h *PromotionHandler) AdminDisable(c *gin.Context) {
+	promoID, ok := promoID(c)
+	if !ok {
+		return
+	}
+
+	promo, err := h.promotionService.DisablePromoCode(c.Request.Context(), promoID)
+	if err != nil {
+		promotionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"promo_code": promo})
+}
+
+func promoID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return uuid.Nil, false
+	}
+
+	return id, true
+}
+
+func promotionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor),
+		errors.Is(err, services.ErrMalformedPromoCode),
+		errors.Is(err, services.ErrInvalidPromoKind),
+		errors.Is(err, services.ErrInvalidPromoValue),
+		errors.Is(err, services.ErrInvalidPromoMinSpend),
+		errors.Is(err, services.ErrInvalidPromoWindow),
+		errors.Is(err, services.ErrInvalidPromoLimit),
+		errors.Is(err, services.ErrUnsupportedCurrency),
+		errors.Is(err, services.ErrDescriptionTooLong):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPromoCode):
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrInvalidPromoCode.Error()})
+	case errors.Is(err, repository.ErrPromoCodeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrPromoCodeNotFound.Error()})
+	case errors.Is(err, repository.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrUserNotFound.Error()})
+	case errors.Is(err, repository.ErrPromoNotEligible):
+		c.JSON(http.StatusForbidden, gin.H{"error": repository.ErrPromoNotEligible.Error()})
+	case errors.Is(err, repository.ErrPromoCodeTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": repository.ErrPromoCodeTaken.Error()})
+	case errors.Is(err, repository.ErrPromoUserLimitReached):
+		c.JSON(http.StatusConflict, gin.H{"error": repository.ErrPromoUserLimitReached.Error()})
+	case errors.Is(err, repository.ErrDiscountAlreadyActive):
+		c.JSON(http.StatusConflict, gin.H{"error": repository.ErrDiscountAlreadyActive.Error()})
+	case errors.Is(err, services.ErrPromoCodeNotAvailable):
+		c.JSON(http.StatusGone, gin.H{"error": services.ErrPromoCodeNotAvailable.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/realtime"
+	"context"
+	"encoding/json"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// closeSessionRevoked is the WebSocket close code sent when the session of the connection ends.
+const closeSessionRevoked = 4001
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsReadLimit    = 512
+)
+
+// EventLog keeps the latest events of each user so a client can resume a stream.
+type EventLog interface {
+	EventsSince(ctx context.Context, userId uuid.UUID, lastEventID string) ([]models.Event, error)
+}
+
+type RealtimeHandler struct {
+	log          *slog.Logger
+	hub          *realtime.Hub
+	eventLog     EventLog
+	upgrader     websocket.Upgrader
+	pingInterval time.Duration
+}
+
+func NewRealtimeHandler(log *slog.Logger, hub *realtime.Hub, eventLog EventLog, allowedOrigins []string, pingInterval time.Duration) *RealtimeHandler {
+	return &RealtimeHandler{
+		log:      log,
+		hub:      hub,
+		eventLog: eventLog,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				if origin == "" || slices.Contains(allowedOrigins, origin) {
+					return true
+				}
+				u, err := url.Parse(origin)
+				return err == nil && u.Host == r.Host
+			},
+		},
+		pingInterval: pingInterval,
+	}
+}
+
+// WebSocket streams the events of the authenticated user until the client goes away, the
+// session is revoked or the client falls too far behind.
+func (h *RealtimeHandler) WebSocket(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	sessionID := c.GetString("session_id")
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already answered the request
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	log := h.log.With(slog.String("user_id", userID.String()))
+
+	sub := h.hub.Subscribe(userID)
+	defer h.hub.Unsubscribe(sub)
+
+	readDone := make(chan struct{})
+	go h.readLoop(conn, readDone)
+
+	ping := time.NewTicker(h.pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-readDone:
+			return
+		case <-sub.Done():
+			if sub.Dropped() {
+				closeWebSocket(conn, websocket.CloseTryAgainLater, "too slow")
+				return
+			}
+			closeWebSocket(conn, websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-ping.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case event := <-sub.Events():
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err = conn.WriteJSON(event); err != nil {
+				log.Debug("failed to write event", sl.Err(err))
+				return
+			}
+			if endsSession(event, sessionID) {
+				closeWebSocket(conn, closeSessionRevoked, "session revoked")
+				return
+			}
+		}
+	}
+}
+
+// Events streams the events of the authenticated user as Server-Sent Events for clients that
+// cannot use WebSockets. A client reconnecting with Last-Event-ID first receives the logged
+// events it missed. The stream ends when the access token expires or the session is revoked.
+func (h *RealtimeHandler) Events(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	sessionID := c.GetString("session_id")
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	// subscribe before reading the log so nothing published in between is lost
+	sub := h.hub.Subscribe(userID)
+	defer h.hub.Unsubscribe(sub)
+
+	missed, err := h.eventLog.EventsSince(c.Request.Context(), userID, lastEventID)
+	if err != nil {
+		h.log.Error("failed to read event log", slog.String("user_id", userID.String()), sl.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read events"})
+		return
+	}
+
+	// the stream outlives any write timeout of the server
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, event := range missed {
+		writeSSE(c, event)
+		lastEventID = event.ID
+		if endsSession(event, sessionID) {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(h.pingInterval)
+	defer keepalive.Stop()
+
+	expiresAt, _ := c.Get("token_expires_at")
+	expiry := time.NewTimer(time.Until(expiresAt.(time.Time)))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-sub.Done():
+			return
+		case <-expiry.C:
+			writeSSE(c, models.Event{Type: models.EventSessionExpired, CreatedAt: time.Now()})
+			c.Writer.Flush()
+			return
+		case <-keepalive.C:
+			if _, err = c.Writer.WriteString(": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case event := <-sub.Events():
+			if !realtime.EventIDAfter(event.ID, lastEventID) {
+				continue
+			}
+			writeSSE(c, event)
+			c.Writer.Flush()
+			lastEventID = event.ID
+			if endsSession(event, sessionID) {
+				return
+			}
+		}
+	}
+}
+
+// readLoop consumes client frames so pongs and close frames are processed, and reports a dead
+// connection when no pong arrives within two ping intervals.
+func (h *RealtimeHandler) readLoop(conn *websocket.Conn, done chan<- struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(wsReadLimit)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * h.pingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * h.pingInterval))
+	})
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// endsSession reports whether event revokes the session the connection was opened with.
+func endsSession(event models.Event, sessionID string) bool {
+	if event.Type != models.EventSessionRevoked {
+		return false
+	}
+
+	var data models.SessionRevokedData
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return false
+	}
+
+	return data.SessionID == "" || data.SessionID == sessionID
+}
+
+func writeSSE(c *gin.Context, event models.Event) {
+	c.Render(-1, sse.Event{
+		Id:    event.ID,
+		Event: event.Type,
+		Data:  event,
+	})
+}
+
+func closeWebSocket(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type ReferralService interface {
+	Summary(ctx context.Context, userId uuid.UUID) (*models.ReferralSummary, error)
+	ListReferrals(ctx context.Context, userId uuid.UUID, after string, limit int) ([]models.Referral, string, error)
+}
+
+type ReferralHandler struct {
+	log             *slog.Logger
+	referralService *services.ReferralService
+}
+
+func NewReferralHandler(log *slog.Logger, referralService *services.ReferralService) *ReferralHandler {
+	return &ReferralHandler{
+		log:             log,
+		referralService: referralService,
+	}
+}
+
+// Summary returns the referral code of the user, the stats of their referrals and who referred
+// them, if anyone.
+func (h *ReferralHandler) Summary(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	summary, err := h.referralService.Summary(c.Request.Context(), userID)
+	if err != nil {
+		referralError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"referrals": summary})
+}
+
+func (h *ReferralHandler) List(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	referrals, next, err := h.referralService.ListReferrals(c.Request.Context(), userID, after, limit)
+	if err != nil {
+		referralError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"referees": referrals, "next_cursor": next})
+}
+
+func referralError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
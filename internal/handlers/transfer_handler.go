@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type TransferService interface {
+	Send(ctx context.Context, userId uuid.UUID, input dto.CreateTransfer) (*models.Transfer, error)
+	ListTransfers(ctx context.Context, userId uuid.UUID, after string, limit int) ([]models.Transfer, string, error)
+	GetTransfer(ctx context.Context, userId *uuid.UUID, transferId uuid.UUID) (*models.Transfer, error)
+	ReverseTransfer(ctx context.Context, transferId uuid.UUID) (*models.Transfer, error)
+}
+
+type TransferHandler struct {
+	log             *slog.Logger
+	transferService *services.TransferService
+}
+
+func NewTransferHandler(log *slog.Logger, transferService *services.TransferService) *TransferHandler {
+	return &TransferHandler{
+		log:             log,
+		transferService: transferService,
+	}
+}
+
+// Send transfers funds to a friend. Repeating a request with the same idempotency key returns
+// the original transfer.
+func (h *TransferHandler) Send(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input dto.CreateTransfer
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transfer, err := h.transferService.Send(c.Request.Context(), userID, input)
+	if err != nil {
+		transferError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"transfer": transfer})
+}
+
+func (h *TransferHandler) List(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	transfers, next, err := h.transferService.ListTransfers(c.Request.Context(), userID, after, limit)
+	if err != nil {
+		transferError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transfers": transfers, "next_cursor": next})
+}
+
+func (h *TransferHandler) Get(c *gin.Context) {
+	if userID, ok := currentUserID(c); ok {
+		h.get(c, &userID)
+	}
+}
+
+func (h *TransferHandler) AdminGet(c *gin.Context) {
+	h.get(c, nil)
+}
+
+// AdminReverse returns the funds of a transfer to its sender.
+func (h *TransferHandler) AdminReverse(c *gin.Context) {
+	transferID, ok := transferID(c)
+	if !ok {
+		return
+	}
+
+	transfer, err := h.transferService.ReverseTransfer(c.Request.Context(), transferID)
+	if err != nil {
+		transferError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transfer": transfer})
+}
+
+func (h *TransferHandler) get(c *gin.Context, userID *uuid.UUID) {
+	transferID, ok := transferID(c)
+	if !ok {
+		return
+	}
+
+	transfer, err := h.transferService.GetTransfer(c.Request.Context(), userID, transferID)
+	if err != nil {
+		transferError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transfer": transfer})
+}
+
+func transferID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID"})
+		return uuid.Nil, false
+	}
+
+	return id, true
+}
+
+func transferError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor),
+		errors.Is(err, services.ErrInvalidIdempotencyKey),
+		errors.Is(err, services.ErrInvalidTransferAmount),
+		errors.Is(err, services.ErrUnsupportedCurrency),
+		errors.Is(err, services.ErrTransferNoteTooLong),
+		errors.Is(err, services.ErrCannotTransferToSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTransferNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrTransferNotAllowed.Error()})
+	case errors.Is(err, repository.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrUserNotFound.Error()})
+	case errors.Is(err, repository.ErrTransferNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrTransferNotFound.Error()})
+	case errors.Is(err, repository.ErrIdempotencyKeyReused):
+		c.JSON(http.StatusConflict, gin.H{"error": repository.ErrIdempotencyKeyReused.Error()})
+	case errors.Is(err, repository.ErrInsufficientFunds):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": repository.ErrInsufficientFunds.Error()})
+	case errors.Is(err, repository.ErrTransferLimitReached):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": repository.ErrTransferLimitReached.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
 	"boton-back/internal/services"
 	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"log/slog"
@@ -12,6 +16,20 @@ import (
 
 type UserService interface {
 	GetUser(ctx context.Context, userId uuid.UUID) (*dto.User, error)
+	GetProfile(ctx context.Context, userId uuid.UUID) (*dto.Profile, error)
+	UpdateProfile(ctx context.Context, userId uuid.UUID, input dto.UpdateProfile) (*dto.Profile, error)
+	GetPublicProfile(ctx context.Context, viewerId uuid.UUID, username string) (*dto.PublicProfile, error)
+	SearchUsers(ctx context.Context, viewerId uuid.UUID, query string, after string, limit int) ([]models.UserSearchResult, string, error)
+}
+
+var profileValidationErrors = []error{
+	services.ErrDisplayNameTooLong,
+	services.ErrBioTooLong,
+	services.ErrInvalidAvatarURL,
+	services.ErrInvalidLocale,
+	services.ErrInvalidTimezone,
+	services.ErrInvalidBirthday,
+	services.ErrInvalidVisibility,
 }
 
 type UserHandler struct {
@@ -27,22 +45,107 @@ func NewUserHandler(log *slog.Logger, userService *services.UserService) *UserHa
 }
 
 func (h *UserHandler) GetUser(c *gin.Context) {
-	userIDVal, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	userID, ok := currentUserID(c)
+	if !ok {
 		return
 	}
-	userID, err := uuid.Parse(userIDVal.(string))
+
+	user, err := h.userService.GetUser(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := h.userService.GetUser(c.Request.Context(), userID)
+	c.JSON(200, gin.H{"user": user})
+}
+
+func (h *UserHandler) GetProfile(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	profile, err := h.userService.GetProfile(c.Request.Context(), userID)
 	if err != nil {
+		h.profileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"profile": profile})
+}
+
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	var input dto.UpdateProfile
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.userService.UpdateProfile(c.Request.Context(), userID, input)
+	if err != nil {
+		h.profileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"profile": profile})
+}
+
+func (h *UserHandler) GetPublicProfile(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	profile, err := h.userService.GetPublicProfile(c.Request.Context(), userID, c.Param("username"))
+	if err != nil {
+		h.profileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"profile": profile})
+}
+
+func (h *UserHandler) SearchUsers(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	users, next, err := h.userService.SearchUsers(c.Request.Context(), userID, c.Query("q"), after, limit)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSearchQuery) || errors.Is(err, cursor.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(200, gin.H{"user": user})
+	c.JSON(http.StatusOK, gin.H{"users": users, "next_cursor": next})
+}
+
+func (h *UserHandler) profileError(c *gin.Context, err error) {
+	if errors.Is(err, repository.ErrProfileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	for _, validationErr := range profileValidationErrors {
+		if errors.Is(err, validationErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
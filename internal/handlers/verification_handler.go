@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type VerificationService interface {
+	Resend(ctx context.Context, userId uuid.UUID) error
+	Verify(ctx context.Context, token string) error
+}
+
+type VerificationHandler struct {
+	log                 *slog.Logger
+	verificationService *services.VerificationService
+}
+
+func NewVerificationHandler(log *slog.Logger, verificationService *services.VerificationService) *VerificationHandler {
+	return &VerificationHandler{
+		log:                 log,
+		verificationService: verificationService,
+	}
+}
+
+// Verify confirms the email of a user with the token from the verification link.
+func (h *VerificationHandler) Verify(c *gin.Context) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.verificationService.Verify(c.Request.Context(), input.Token); err != nil {
+		verificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+func (h *VerificationHandler) Resend(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.verificationService.Resend(c.Request.Context(), userID); err != nil {
+		verificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "verification email sent"})
+}
+
+func verificationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidVerificationToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidVerificationToken.Error()})
+	case errors.Is(err, services.ErrEmailAlreadyVerified):
+		c.JSON(http.StatusConflict, gin.H{"error": services.ErrEmailAlreadyVerified.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
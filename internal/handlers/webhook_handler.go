@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"boton-back/internal/services"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+type WebhookService interface {
+	CreateSubscription(ctx context.Context, input dto.CreateWebhook) (*models.WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	GetSubscription(ctx context.Context, subscriptionId uuid.UUID) (*models.WebhookSubscription, error)
+	UpdateSubscription(ctx context.Context, subscriptionId uuid.UUID, input dto.UpdateWebhook) (*models.WebhookSubscription, error)
+	RotateSecret(ctx context.Context, subscriptionId uuid.UUID) (*models.WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, subscriptionId uuid.UUID) error
+	ListDeliveries(ctx context.Context, subscriptionId uuid.UUID, after string, limit int) ([]models.WebhookDelivery, string, error)
+	GetDelivery(ctx context.Context, deliveryId uuid.UUID) (*models.WebhookDelivery, []models.WebhookAttempt, error)
+	Redeliver(ctx context.Context, deliveryId uuid.UUID) (*models.WebhookDelivery, error)
+}
+
+// WebhookHandler serves the admin API managing partner webhook subscriptions.
+type WebhookHandler struct {
+	log            *slog.Logger
+	webhookService *services.WebhookService
+}
+
+func NewWebhookHandler(log *slog.Logger, webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		log:            log,
+		webhookService: webhookService,
+	}
+}
+
+// Create subscribes a partner url. The response is the only one carrying the signing secret.
+func (h *WebhookHandler) Create(c *gin.Context) {
+	var input dto.CreateWebhook
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subscription, err := h.webhookService.CreateSubscription(c.Request.Context(), input)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"webhook": subscription, "secret": subscription.Secret})
+}
+
+func (h *WebhookHandler) List(c *gin.Context) {
+	subscriptions, err := h.webhookService.ListSubscriptions(c.Request.Context())
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": subscriptions})
+}
+
+func (h *WebhookHandler) Get(c *gin.Context) {
+	subscriptionID, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	subscription, err := h.webhookService.GetSubscription(c.Request.Context(), subscriptionID)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook": subscription})
+}
+
+func (h *WebhookHandler) Update(c *gin.Context) {
+	subscriptionID, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	var input dto.UpdateWebhook
+	if err := c.BindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subscription, err := h.webhookService.UpdateSubscription(c.Request.Context(), subscriptionID, input)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook": subscription})
+}
+
+// RotateSecret replaces the signing secret of a subscription and returns the new one.
+func (h *WebhookHandler) RotateSecret(c *gin.Context) {
+	subscriptionID, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	subscription, err := h.webhookService.RotateSecret(c.Request.Context(), subscriptionID)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook": subscription, "secret": subscription.Secret})
+}
+
+func (h *WebhookHandler) Delete(c *gin.Context) {
+	subscriptionID, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.webhookService.DeleteSubscription(c.Request.Context(), subscriptionID); err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "webhook deleted"})
+}
+
+// ListDeliveries returns a page of the delivery log of a subscription, newest first.
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	subscriptionID, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	after, limit, ok := pageParams(c)
+	if !ok {
+		return
+	}
+
+	deliveries, next, err := h.webhookService.ListDeliveries(c.Request.Context(), subscriptionID, after, limit)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries, "next_cursor": next})
+}
+
+// GetDelivery returns a delivery with the response of each attempt.
+func (h *WebhookHandler) GetDelivery(c *gin.Context) {
+	deliveryID, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	delivery, attempts, err := h.webhookService.GetDelivery(c.Request.Context(), deliveryID)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"delivery": delivery, "attempts": attempts})
+}
+
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	deliveryID, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.webhookService.Redeliver(c.Request.Context(), deliveryID)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"delivery": delivery})
+}
+
+func webhookID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return uuid.Nil, false
+	}
+
+	return id, true
+}
+
+func webhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrInvalidCursor),
+		errors.Is(err, services.ErrInvalidWebhookURL),
+		errors.Is(err, services.ErrNoWebhookEvents),
+		errors.Is(err, services.ErrUnknownWebhookEvent):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrWebhookDisabled):
+		c.JSON(http.StatusConflict, gin.H{"error": services.ErrWebhookDisabled.Error()})
+	case errors.Is(err, repository.ErrWebhookNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrWebhookNotFound.Error()})
+	case errors.Is(err, repository.ErrDeliveryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": repository.ErrDeliveryNotFound.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUnknownJobType = errors.New("unknown job type")
+	ErrDuplicateJob   = errors.New("a job with this unique key is already queued")
+)
+
+// Job is a unit of work queued in the store and run by the worker pool.
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	UniqueKey   string          `json:"unique_key,omitempty"`
+	MaxAttempts int             `json:"max_attempts"`
+	EnqueuedAt  time.Time       `json:"enqueued_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	// Attempts counts the runs started so far, the current one included. The store keeps it
+	// apart from the job so a run that crashed still counts.
+	Attempts int `json:"-"`
+}
+
+// EnqueueOptions tune a single job. The zero value runs the job as soon as possible with the
+// default number of attempts.
+type EnqueueOptions struct {
+	// Delay postpones the first run.
+	Delay time.Duration
+	// UniqueKey, when set, keeps a job from being queued while another one with the same key is
+	// queued or running.
+	UniqueKey string
+	// MaxAttempts overrides the default number of attempts of the queue.
+	MaxAttempts int
+}
+
+// Store persists the queue. Reserving a job leases it: a job whose worker does not complete,
+// retry or bury it before the lease ends is handed out again.
+type Store interface {
+	EnqueueJob(ctx context.Context, job Job, runAt time.Time, uniqueTTL time.Duration) (bool, error)
+	ReserveJobs(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Job, error)
+	RetryJob(ctx context.Context, job Job, runAt time.Time) error
+	CompleteJob(ctx context.Context, job Job) error
+	BuryJob(ctx context.Context, job Job) error
+	ClaimScheduleTick(ctx context.Context, name string, tick time.Time) (bool, error)
+}
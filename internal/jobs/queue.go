@@ -0,0 +1,342 @@
+package jobs
+
+import (
+	"boton-back/internal/lib/backoff"
+	"boton-back/internal/lib/logger/sl"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRetryBackoff caps the delay between attempts of a job.
+	maxRetryBackoff = time.Hour
+	// uniqueKeyTTL bounds how long a unique key outlives the due time of a job that never
+	// finished, such as one removed by hand.
+	uniqueKeyTTL = 24 * time.Hour
+)
+
+var (
+	// errMalformedPayload marks a job whose payload does not decode: retrying it cannot succeed.
+	errMalformedPayload = errors.New("malformed job payload")
+	errLeaseExpired     = errors.New("lease expired on the last attempt")
+)
+
+type handler func(ctx context.Context, payload json.RawMessage) error
+
+type scheduled struct {
+	name     string
+	schedule Schedule
+}
+
+// Queue runs jobs from the store with a pool of workers. Jobs are delivered at least once:
+// handlers must tolerate running again after a crash or a lease running out.
+type Queue struct {
+	log               *slog.Logger
+	store             Store
+	handlers          map[string]handler
+	schedules         []scheduled
+	concurrency       int
+	pollInterval      time.Duration
+	visibilityTimeout time.Duration
+	maxAttempts       int
+	retryBackoff      time.Duration
+
+	loops        sync.WaitGroup
+	running      sync.WaitGroup
+	stopFetching context.CancelFunc
+	abort        context.CancelFunc
+}
+
+// NewQueue returns a queue running up to concurrency jobs at a time. The store is polled every
+// pollInterval while idle. A job runs for at most visibilityTimeout before it is cancelled and
+// handed out again, and is retried after retryBackoff, doubling with every attempt, until it
+// made maxAttempts.
+func NewQueue(log *slog.Logger, store Store, concurrency int, pollInterval, visibilityTimeout time.Duration, maxAttempts int, retryBackoff time.Duration) *Queue {
+	return &Queue{
+		log:               log,
+		store:             store,
+		handlers:          make(map[string]handler),
+		concurrency:       concurrency,
+		pollInterval:      pollInterval,
+		visibilityTimeout: visibilityTimeout,
+		maxAttempts:       maxAttempts,
+		retryBackoff:      retryBackoff,
+	}
+}
+
+// Register sets fn to run the jobs of jobType, decoding their payload into T. It must be called
+// before Start.
+func Register[T any](q *Queue, jobType string, fn func(ctx context.Context, payload T) error) {
+	q.handlers[jobType] = func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return fmt.Errorf("%w: %w", errMalformedPayload, err)
+		}
+
+		return fn(ctx, payload)
+	}
+}
+
+// Schedule registers task as the job type name and enqueues it at every tick of schedule. Each
+// tick is enqueued once across all instances, and skipped while the previous run is pending. It
+// must be called before Start.
+func (q *Queue) Schedule(name string, schedule Schedule, task func(ctx context.Context) error) {
+	Register(q, name, func(ctx context.Context, _ struct{}) error {
+		return task(ctx)
+	})
+
+	q.schedules = append(q.schedules, scheduled{name: name, schedule: schedule})
+}
+
+// Enqueue queues a job of a registered type with payload encoded as JSON and returns its id. It
+// returns ErrDuplicateJob when opts.UniqueKey is taken.
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any, opts EnqueueOptions) (string, error) {
+	const op = "jobs.Enqueue"
+
+	if _, ok := q.handlers[jobType]; !ok {
+		return "", fmt.Errorf("%s: %w: %s", op, ErrUnknownJobType, jobType)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	job := Job{
+		ID:          uuid.NewString(),
+		Type:        jobType,
+		Payload:     data,
+		UniqueKey:   opts.UniqueKey,
+		MaxAttempts: opts.MaxAttempts,
+		EnqueuedAt:  time.Now(),
+	}
+	if job.MaxAttempts < 1 {
+		job.MaxAttempts = q.maxAttempts
+	}
+
+	enqueued, err := q.store.EnqueueJob(ctx, job, job.EnqueuedAt.Add(opts.Delay), opts.Delay+uniqueKeyTTL)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !enqueued {
+		return "", fmt.Errorf("%s: %w", op, ErrDuplicateJob)
+	}
+
+	return job.ID, nil
+}
+
+// Start launches the workers and the scheduler. It does not block.
+func (q *Queue) Start(ctx context.Context) {
+	// running jobs outlive ctx so that Stop can let them finish
+	var jobCtx context.Context
+	jobCtx, q.abort = context.WithCancel(context.WithoutCancel(ctx))
+	ctx, q.stopFetching = context.WithCancel(ctx)
+
+	q.loops.Add(1)
+	go q.fetch(ctx, jobCtx)
+
+	if len(q.schedules) > 0 {
+		q.loops.Add(1)
+		go q.schedule(ctx)
+	}
+
+	q.log.Info("job queue started", slog.Int("concurrency", q.concurrency), slog.Int("schedules", len(q.schedules)))
+}
+
+// Stop stops taking jobs and waits for the running ones to finish. Once ctx is done the jobs
+// still running are cancelled; they run again when their lease ends.
+func (q *Queue) Stop(ctx context.Context) {
+	if q.stopFetching == nil {
+		return
+	}
+
+	q.stopFetching()
+	q.loops.Wait()
+
+	drained := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		q.log.Info("job queue stopped")
+	case <-ctx.Done():
+		q.abort()
+		q.log.Warn("job queue stopped before running jobs finished")
+	}
+}
+
+// fetch reserves jobs whenever a worker is free and runs each in its own goroutine.
+func (q *Queue) fetch(ctx, jobCtx context.Context) {
+	defer q.loops.Done()
+
+	slots := make(chan struct{}, q.concurrency)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+
+		// only this loop takes slots, so the free ones stay free until taken below
+		free := 1 + cap(slots) - len(slots)
+
+		reserved, err := q.store.ReserveJobs(ctx, time.Now(), free, q.visibilityTimeout)
+		if err != nil && ctx.Err() == nil {
+			q.log.Error("failed to reserve jobs", sl.Err(err))
+		}
+
+		for i, job := range reserved {
+			if i > 0 {
+				slots <- struct{}{}
+			}
+
+			q.running.Add(1)
+			go func() {
+				defer func() { <-slots }()
+				defer q.running.Done()
+
+				q.run(jobCtx, job)
+			}()
+		}
+
+		if len(reserved) == 0 {
+			<-slots
+		}
+
+		if len(reserved) < free {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(q.pollInterval):
+			}
+		}
+	}
+}
+
+// run runs a reserved job and records its outcome.
+func (q *Queue) run(ctx context.Context, job Job) {
+	log := q.log.With(
+		slog.String("job_id", job.ID),
+		slog.String("job_type", job.Type),
+		slog.Int("attempt", job.Attempts),
+	)
+
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// the previous attempts crashed or ran out of their lease
+		err = errLeaseExpired
+	} else {
+		runCtx, cancel := context.WithTimeout(ctx, q.visibilityTimeout)
+		err = q.handle(runCtx, job)
+		cancel()
+	}
+
+	// the outcome is recorded even when the queue is aborted, to spare a needless rerun
+	ctx = context.WithoutCancel(ctx)
+
+	if err == nil {
+		if err = q.store.CompleteJob(ctx, job); err != nil {
+			log.Error("failed to complete job", sl.Err(err))
+		}
+		return
+	}
+
+	job.LastError = err.Error()
+
+	if errors.Is(err, errMalformedPayload) || job.Attempts >= job.MaxAttempts {
+		log.Error("job failed for good", sl.Err(err))
+		if err = q.store.BuryJob(ctx, job); err != nil {
+			log.Error("failed to bury job", sl.Err(err))
+		}
+		return
+	}
+
+	delay := backoff.Exponential(q.retryBackoff, job.Attempts, maxRetryBackoff)
+
+	log.Warn("job failed, retrying", sl.Err(err), slog.Duration("retry_in", delay))
+
+	if err = q.store.RetryJob(ctx, job, time.Now().Add(delay)); err != nil {
+		log.Error("failed to retry job", sl.Err(err))
+	}
+}
+
+func (q *Queue) handle(ctx context.Context, job Job) (err error) {
+	h, ok := q.handlers[job.Type]
+	if !ok {
+		// a newer instance may know the type, so the job is left to be retried
+		return fmt.Errorf("%w: %s", ErrUnknownJobType, job.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	return h(ctx, job.Payload)
+}
+
+// schedule enqueues the jobs of the schedules as they come due.
+func (q *Queue) schedule(ctx context.Context) {
+	defer q.loops.Done()
+
+	now := time.Now()
+	next := make([]time.Time, len(q.schedules))
+	for i, s := range q.schedules {
+		next[i] = s.schedule.Next(now)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+
+		for i, s := range q.schedules {
+			if next[i].IsZero() || now.Before(next[i]) {
+				continue
+			}
+
+			tick := next[i]
+			next[i] = s.schedule.Next(now)
+
+			q.enqueueTick(ctx, s.name, tick)
+		}
+	}
+}
+
+func (q *Queue) enqueueTick(ctx context.Context, name string, tick time.Time) {
+	log := q.log.With(slog.String("schedule", name))
+
+	claimed, err := q.store.ClaimScheduleTick(ctx, name, tick)
+	if err != nil {
+		log.Error("failed to claim schedule tick", sl.Err(err))
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	_, err = q.Enqueue(ctx, name, struct{}{}, EnqueueOptions{UniqueKey: "schedule:" + name})
+	switch {
+	case errors.Is(err, ErrDuplicateJob):
+		log.Warn("previous run still pending, skipping tick")
+	case err != nil:
+		log.Error("failed to enqueue scheduled job", sl.Err(err))
+	}
+}
@@ -0,0 +1,14 @@
+package backoff
+
+import "time"
+
+// Exponential returns the delay before the next attempt after attempts failed ones: base,
+// doubled with every further attempt and capped at ceiling.
+func Exponential(base time.Duration, attempts int, ceiling time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < ceiling; i++ {
+		delay *= 2
+	}
+
+	return min(delay, ceiling)
+}
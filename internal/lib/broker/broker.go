@@ -0,0 +1,20 @@
+package broker
+
+import (
+	"context"
+	"time"
+)
+
+// Message is a domain event handed to a broker. Delivery is at least once: consumers
+// deduplicate by ID.
+type Message struct {
+	ID        string
+	Type      string
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+// Broker publishes domain events to the services consuming them.
+type Broker interface {
+	Publish(ctx context.Context, msg Message) error
+}
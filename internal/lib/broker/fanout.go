@@ -0,0 +1,26 @@
+package broker
+
+import (
+	"context"
+	"errors"
+)
+
+// Fanout publishes every message to each of its brokers in turn. A message is reported as
+// published only when all of them accepted it, so a retry may reach some brokers twice.
+type Fanout []Broker
+
+func NewFanout(brokers ...Broker) Fanout {
+	return brokers
+}
+
+func (f Fanout) Publish(ctx context.Context, msg Message) error {
+	var errs []error
+
+	for _, b := range f {
+		if err := b.Publish(ctx, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,47 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps published messages in memory. It stands in for a real broker in tests and
+// local development.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+	err      error
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (b *Memory) Publish(_ context.Context, msg Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return b.err
+	}
+
+	b.messages = append(b.messages, msg)
+
+	return nil
+}
+
+// Messages returns the messages published so far, oldest first.
+func (b *Memory) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Message(nil), b.messages...)
+}
+
+// FailWith makes every following Publish return err until it is called again with nil.
+func (b *Memory) FailWith(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.err = err
+}
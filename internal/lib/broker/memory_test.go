@@ -0,0 +1,42 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemory()
+
+	first := Message{ID: "1", Type: "user.registered", Payload: []byte(`{}`), CreatedAt: time.Now()}
+	second := Message{ID: "2", Type: "user.deleted", Payload: []byte(`{}`), CreatedAt: time.Now()}
+
+	if err := b.Publish(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	errDown := errors.New("broker down")
+	b.FailWith(errDown)
+	if err := b.Publish(ctx, second); !errors.Is(err, errDown) {
+		t.Fatalf("Publish error = %v, want %v", err, errDown)
+	}
+
+	b.FailWith(nil)
+	if err := b.Publish(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := b.Messages()
+	if len(messages) != 2 || messages[0].ID != "1" || messages[1].ID != "2" {
+		t.Fatalf("Messages = %v, want the two published messages in order", messages)
+	}
+
+	// the returned slice is a copy
+	messages[0].ID = "changed"
+	if b.Messages()[0].ID != "1" {
+		t.Fatal("Messages exposes the internal slice")
+	}
+}
@@ -0,0 +1,96 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points at the last item of a page ordered by (time, id) for keyset pagination.
+type Cursor struct {
+	Time time.Time
+	ID   uuid.UUID
+}
+
+// Encode returns the opaque cursor of an item.
+func Encode(t time.Time, id uuid.UUID) string {
+	raw := strconv.FormatInt(t.UnixNano(), 10) + "_" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Decode parses a cursor returned by Encode. An empty string means the first page and yields nil.
+func Decode(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	ts, idStr, ok := strings.Cut(string(raw), "_")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// in UTC like the TIMESTAMP columns it is compared against; pgx drops the zone of parameters
+	return &Cursor{Time: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// ScoreCursor points at the last item of a page ordered by (score, id), such as ranked search results.
+type ScoreCursor struct {
+	Score float32
+	ID    uuid.UUID
+}
+
+// EncodeScore returns the opaque cursor of a ranked item. The score round-trips exactly so the
+// database comparison against the same real value stays stable.
+func EncodeScore(score float32, id uuid.UUID) string {
+	raw := strconv.FormatFloat(float64(score), 'g', -1, 32) + "_" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeScore parses a cursor returned by EncodeScore. An empty string means the first page and yields nil.
+func DecodeScore(s string) (*ScoreCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	scoreStr, idStr, ok := strings.Cut(string(raw), "_")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	score, err := strconv.ParseFloat(scoreStr, 32)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &ScoreCursor{Score: float32(score), ID: id}, nil
+}
@@ -0,0 +1,63 @@
+package cursor
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := uuid.New()
+	zone := time.FixedZone("UTC+3", 3*60*60)
+
+	tests := []struct {
+		name string
+		time time.Time
+	}{
+		{name: "utc", time: time.Date(2024, 11, 20, 10, 30, 0, 123456000, time.UTC)},
+		{name: "other zone", time: time.Date(2024, 11, 20, 13, 30, 0, 123456000, zone)},
+		{name: "local", time: time.Date(2024, 11, 20, 10, 30, 0, 123456000, time.Local)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Decode(Encode(tt.time, id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.Time.Equal(tt.time) || c.ID != id {
+				t.Fatalf("Decode = (%v, %v), want (%v, %v)", c.Time, c.ID, tt.time, id)
+			}
+			if c.Time.Location() != time.UTC {
+				t.Fatalf("Decode time is in %v, want UTC", c.Time.Location())
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	if c, err := Decode(""); c != nil || err != nil {
+		t.Fatalf("Decode(\"\") = (%v, %v), want first page", c, err)
+	}
+
+	for _, s := range []string{"%%%", "bm9zZXBhcmF0b3I", "eF9hYmM", "MTIzX25vdC1hLXV1aWQ"} {
+		if _, err := Decode(s); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Decode(%q) error = %v, want ErrInvalidCursor", s, err)
+		}
+	}
+}
+
+func TestScoreCursorRoundTrip(t *testing.T) {
+	id := uuid.New()
+
+	for _, score := range []float32{0, 0.1, 0.0607927, 1} {
+		c, err := DecodeScore(EncodeScore(score, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Score != score || c.ID != id {
+			t.Fatalf("DecodeScore = (%v, %v), want (%v, %v)", c.Score, c.ID, score, id)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package geoip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Location is where an IP address is registered.
+type Location struct {
+	Country   string  `json:"country"`
+	Region    string  `json:"region"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type network struct {
+	prefix   netip.Prefix
+	location Location
+}
+
+// DB maps networks to locations. It is loaded from a local file, so lookups never leave the
+// process.
+type DB struct {
+	networks []network
+}
+
+// Open loads a CSV file of non-overlapping networks with lines of the form
+//
+//	network,country,region,latitude,longitude
+//
+// such as "203.0.113.0/24,RU,Moscow,55.7558,37.6173". Lines starting with # are skipped.
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 5
+
+	db := &DB{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := parseNetwork(record)
+		if err != nil {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		db.networks = append(db.networks, n)
+	}
+
+	sort.Slice(db.networks, func(i, j int) bool {
+		return db.networks[i].prefix.Addr().Less(db.networks[j].prefix.Addr())
+	})
+
+	return db, nil
+}
+
+func parseNetwork(record []string) (network, error) {
+	prefix, err := netip.ParsePrefix(record[0])
+	if err != nil {
+		return network{}, err
+	}
+
+	lat, err := strconv.ParseFloat(record[3], 64)
+	if err != nil {
+		return network{}, fmt.Errorf("invalid latitude: %w", err)
+	}
+
+	lon, err := strconv.ParseFloat(record[4], 64)
+	if err != nil {
+		return network{}, fmt.Errorf("invalid longitude: %w", err)
+	}
+
+	return network{
+		prefix:   prefix.Masked(),
+		location: Location{Country: record[1], Region: record[2], Latitude: lat, Longitude: lon},
+	}, nil
+}
+
+// Lookup returns the location of the network containing ip.
+func (db *DB) Lookup(ip netip.Addr) (Location, bool) {
+	ip = ip.Unmap()
+
+	// the last network starting at or before ip is the only one that may contain it
+	i := sort.Search(len(db.networks), func(i int) bool {
+		return ip.Less(db.networks[i].prefix.Addr())
+	})
+	if i == 0 || !db.networks[i-1].prefix.Contains(ip) {
+		return Location{}, false
+	}
+
+	return db.networks[i-1].location, true
+}
+
+const earthRadiusKm = 6371
+
+// Distance returns the great-circle distance between two locations in kilometers.
+func Distance(a, b Location) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,106 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	xdraw "golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrImageTooLarge     = errors.New("image dimensions are too large")
+)
+
+// maxPixels guards against decompression bombs: small files that decode into huge bitmaps.
+const maxPixels = 40_000_000
+
+const jpegQuality = 85
+
+// Thumbnail is a square re-encoded rendition of an image.
+type Thumbnail struct {
+	Size        int
+	Data        []byte
+	ContentType string
+}
+
+// SniffContentType detects the image type from the content rather than trusting the client.
+func SniffContentType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return contentType, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// SquareThumbnails decodes the image, crops its centered square and re-encodes it in every size.
+// Re-encoding drops all metadata of the original, EXIF included. Images with transparency are
+// encoded as PNG, all others as JPEG.
+func SquareThumbnails(data []byte, sizes []int) ([]Thumbnail, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	square := centerSquare(src.Bounds())
+	transparent := hasAlpha(src)
+
+	thumbs := make([]Thumbnail, 0, len(sizes))
+	for _, size := range sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		if !transparent {
+			draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		}
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, square, xdraw.Over, nil)
+
+		var buf bytes.Buffer
+		contentType := "image/jpeg"
+		if transparent {
+			contentType = "image/png"
+			err = png.Encode(&buf, dst)
+		} else {
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		thumbs = append(thumbs, Thumbnail{Size: size, Data: buf.Bytes(), ContentType: contentType})
+	}
+
+	return thumbs, nil
+}
+
+func centerSquare(b image.Rectangle) image.Rectangle {
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+
+	return image.Rect(x, y, x+side, y+side)
+}
+
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	return true
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"math"
 	"time"
 )
 
@@ -21,8 +22,16 @@ func NewGenerator(secret string, accessTTL time.Duration, refreshTTL time.Durati
 	}
 }
 
+// GeneratePair starts a new session for the user and returns its first token pair.
 func (g *Generator) GeneratePair(id uuid.UUID) (accessToken string, refreshToken string, err error) {
-	now := time.Now().Unix()
+	return g.GenerateSessionPair(id, uuid.NewString())
+}
+
+// GenerateSessionPair returns a token pair that continues the session sessionID, e.g. on refresh.
+func (g *Generator) GenerateSessionPair(id uuid.UUID, sessionID string) (accessToken string, refreshToken string, err error) {
+	// iat carries milliseconds, so a session revoked a moment before the pair is issued
+	// doesn't take the new pair with it
+	now := float64(time.Now().UnixMilli()) / 1000
 
 	jtiAccess := uuid.NewString()
 	jtiRefresh := uuid.NewString()
@@ -32,6 +41,7 @@ func (g *Generator) GeneratePair(id uuid.UUID) (accessToken string, refreshToken
 		"iat": now,
 		"exp": time.Now().Add(g.accessTTL).Unix(),
 		"jti": jtiAccess,
+		"sid": sessionID,
 		"typ": "access",
 	}
 
@@ -40,6 +50,7 @@ func (g *Generator) GeneratePair(id uuid.UUID) (accessToken string, refreshToken
 		"iat": now,
 		"exp": time.Now().Add(g.refreshTTL).Unix(),
 		"jti": jtiRefresh,
+		"sid": sessionID,
 		"typ": "refresh",
 	}
 
@@ -58,7 +69,45 @@ func (g *Generator) GeneratePair(id uuid.UUID) (accessToken string, refreshToken
 	return accessToken, refreshToken, nil
 }
 
+// Claims are the parts of a validated token the rest of the app cares about.
+type Claims struct {
+	// ID is the jti of the token, unique to every token issued.
+	ID     string
+	UserID string
+	// SessionID is shared by every token pair issued from one login. It is empty for tokens
+	// issued before sessions were tracked.
+	SessionID string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// ParseToken validates an access token and returns the user id it was issued for.
 func (g *Generator) ParseToken(tokenString string) (string, error) {
+	claims, err := g.ParseAccessClaims(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+// ParseRefreshToken validates a refresh token and returns the user id it was issued for.
+func (g *Generator) ParseRefreshToken(tokenString string) (string, error) {
+	claims, err := g.ParseRefreshClaims(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+
+func (g *Generator) ParseAccessClaims(tokenString string) (*Claims, error) {
+	return g.parse(tokenString, "access")
+}
+
+func (g *Generator) ParseRefreshClaims(tokenString string) (*Claims, error) {
+	return g.parse(tokenString, "refresh")
+}
+
+func (g *Generator) parse(tokenString, typ string) (*Claims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
@@ -67,18 +116,35 @@ func (g *Generator) ParseToken(tokenString string) (string, error) {
 	})
 
 	if err != nil || !token.Valid {
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", errors.New("invalid token claims")
+		return nil, errors.New("invalid token claims")
+	}
+
+	if t, _ := claims["typ"].(string); t != typ {
+		return nil, errors.New("invalid token type")
+	}
+
+	id, ok := claims["sub"].(string)
+	if !ok {
+		return nil, errors.New("invalid user_id in token")
 	}
 
-	id, ok := claims["sub"]
+	iat, ok := claims["iat"].(float64)
 	if !ok {
-		return "", errors.New("invalid user_id in token")
+		return nil, errors.New("invalid iat in token")
 	}
 
-	return id.(string), nil
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return nil, errors.New("invalid exp in token")
+	}
+
+	jti, _ := claims["jti"].(string)
+	sid, _ := claims["sid"].(string)
+
+	return &Claims{ID: jti, UserID: id, SessionID: sid, IssuedAt: time.UnixMilli(int64(math.Round(iat * 1000))), ExpiresAt: exp.Time}, nil
 }
@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// File writes every message to a JSON file in a directory instead of sending it.
+type File struct {
+	dir string
+}
+
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &File{dir: dir}, nil
+}
+
+func (f *File) Send(_ context.Context, msg Message) error {
+	sent := Sent{ID: uuid.NewString(), SentAt: time.Now(), Message: msg}
+
+	data, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the timestamp prefix keeps the files in sending order
+	name := fmt.Sprintf("%d-%s.json", sent.SentAt.UnixNano(), sent.ID)
+
+	return os.WriteFile(filepath.Join(f.dir, name), data, 0o644)
+}
+
+// List returns the written messages, newest first.
+func (f *File) List() ([]Sent, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Reverse(entries)
+
+	var sent []Sent
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(f.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var s Sent
+		if err = json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		sent = append(sent, s)
+	}
+
+	return sent, nil
+}
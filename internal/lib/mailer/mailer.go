@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"context"
+	"time"
+)
+
+// Message is a rendered email with a plain text and an HTML alternative.
+type Message struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Sent is a message kept by a development mailer instead of being sent.
+type Sent struct {
+	ID      string    `json:"id"`
+	SentAt  time.Time `json:"sent_at"`
+	Message Message   `json:"message"`
+}
+
+// Lister is implemented by the development mailers to show what would have been sent.
+type Lister interface {
+	List() ([]Sent, error)
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Memory keeps the latest sent messages in memory. It stands in for a real mailer in tests and
+// local development.
+type Memory struct {
+	mu    sync.Mutex
+	sent  []Sent
+	limit int
+}
+
+// NewMemory returns a mailer keeping the latest limit messages.
+func NewMemory(limit int) *Memory {
+	return &Memory{limit: limit}
+}
+
+func (m *Memory) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, Sent{ID: uuid.NewString(), SentAt: time.Now(), Message: msg})
+	if len(m.sent) > m.limit {
+		m.sent = slices.Delete(m.sent, 0, len(m.sent)-m.limit)
+	}
+
+	return nil
+}
+
+// List returns the kept messages, newest first.
+func (m *Memory) List() ([]Sent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := slices.Clone(m.sent)
+	slices.Reverse(sent)
+
+	return sent, nil
+}
@@ -0,0 +1,171 @@
+package payments
+
+import (
+	"boton-back/internal/lib/webhooksig"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FakeName is the name of the fake provider.
+const FakeName = "fake"
+
+// Fake keeps invoices in memory and signs its webhooks like a real provider would. It stands
+// in for a real provider in local development, where Simulate plays the part of the payer.
+type Fake struct {
+	mu          sync.Mutex
+	secret      string
+	checkoutURL string
+	tolerance   time.Duration
+	invoices    map[string]*Invoice
+	references  map[string]string
+}
+
+// NewFake returns a provider with checkout pages under checkoutURL whose webhooks are signed
+// with secret and accepted within tolerance.
+func NewFake(secret, checkoutURL string, tolerance time.Duration) *Fake {
+	return &Fake{
+		secret:      secret,
+		checkoutURL: checkoutURL,
+		tolerance:   tolerance,
+		invoices:    make(map[string]*Invoice),
+		references:  make(map[string]string),
+	}
+}
+
+func (f *Fake) Name() string {
+	return FakeName
+}
+
+func (f *Fake) CreateInvoice(_ context.Context, req InvoiceRequest) (*Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.references[req.Reference]; ok {
+		invoice := *f.invoices[id]
+		return &invoice, nil
+	}
+
+	id, err := newID("inv_")
+	if err != nil {
+		return nil, err
+	}
+
+	invoice := &Invoice{
+		ID:        id,
+		Reference: req.Reference,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		Status:    StatusPending,
+		URL:       f.checkoutURL + "/" + id,
+	}
+	f.invoices[id] = invoice
+	f.references[req.Reference] = id
+
+	created := *invoice
+	return &created, nil
+}
+
+func (f *Fake) GetInvoice(_ context.Context, invoiceID string) (*Invoice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	invoice, ok := f.invoices[invoiceID]
+	if !ok {
+		return nil, ErrInvoiceNotFound
+	}
+
+	found := *invoice
+	return &found, nil
+}
+
+func (f *Fake) Refund(_ context.Context, invoiceID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	invoice, ok := f.invoices[invoiceID]
+	if !ok {
+		return ErrInvoiceNotFound
+	}
+
+	if invoice.Status != StatusPaid {
+		return ErrNotRefundable
+	}
+	invoice.Status = StatusRefunded
+
+	return nil
+}
+
+func (f *Fake) ParseWebhook(header http.Header, body []byte) (*Event, error) {
+	err := webhooksig.Verify(f.secret, header.Get(webhooksig.HeaderTimestamp), header.Get(webhooksig.HeaderSignature), body, f.tolerance)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	var event Event
+	if err = json.Unmarshal(body, &event); err != nil || event.ID == "" || event.InvoiceID == "" {
+		return nil, ErrInvalidEvent
+	}
+
+	return &event, nil
+}
+
+// Simulate moves an invoice to status, as if the payer paid, the payment failed or was
+// refunded or charged back, and returns the signed webhook request the provider would send.
+func (f *Fake) Simulate(invoiceID, status string) (http.Header, []byte, error) {
+	switch status {
+	case StatusPaid, StatusFailed, StatusRefunded, StatusChargedBack:
+	default:
+		return nil, nil, ErrInvalidEvent
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	invoice, ok := f.invoices[invoiceID]
+	if !ok {
+		return nil, nil, ErrInvoiceNotFound
+	}
+	invoice.Status = status
+
+	id, err := newID("evt_")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := json.Marshal(Event{
+		ID:        id,
+		InvoiceID: invoice.ID,
+		Status:    status,
+		Amount:    invoice.Amount,
+		Currency:  invoice.Currency,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timestamp := time.Now().Unix()
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	header.Set(webhooksig.HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	header.Set(webhooksig.HeaderSignature, webhooksig.Sign(f.secret, timestamp, body))
+
+	return header, body, nil
+}
+
+func newID(prefix string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return prefix + hex.EncodeToString(b), nil
+}
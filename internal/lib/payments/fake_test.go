@@ -0,0 +1,145 @@
+package payments
+
+import (
+	"boton-back/internal/lib/webhooksig"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFakeParseWebhook(t *testing.T) {
+	fake := NewFake("whsec_test", "http://localhost/pay", 5*time.Minute)
+
+	invoice, err := fake.CreateInvoice(context.Background(), InvoiceRequest{Reference: "payment-1", Amount: 750, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, body, err := fake.Simulate(invoice.ID, StatusPaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(secret string, at time.Time, body []byte) http.Header {
+		h := make(http.Header)
+		h.Set(webhooksig.HeaderTimestamp, strconv.FormatInt(at.Unix(), 10))
+		h.Set(webhooksig.HeaderSignature, webhooksig.Sign(secret, at.Unix(), body))
+		return h
+	}
+
+	tampered := append([]byte{}, body...)
+	tampered[len(tampered)-2] ^= 1
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    []byte
+		wantErr error
+	}{
+		{name: "simulated", header: header, body: body},
+		{name: "resigned", header: signed("whsec_test", time.Now(), body), body: body},
+		{name: "tampered body", header: header, body: tampered, wantErr: ErrInvalidSignature},
+		{name: "other secret", header: signed("whsec_other", time.Now(), body), body: body, wantErr: ErrInvalidSignature},
+		{name: "stale", header: signed("whsec_test", time.Now().Add(-time.Hour), body), body: body, wantErr: ErrInvalidSignature},
+		{name: "from the future", header: signed("whsec_test", time.Now().Add(time.Hour), body), body: body, wantErr: ErrInvalidSignature},
+		{name: "unsigned", header: http.Header{}, body: body, wantErr: ErrInvalidSignature},
+		{name: "not an event", header: signed("whsec_test", time.Now(), []byte(`{}`)), body: []byte(`{}`), wantErr: ErrInvalidEvent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := fake.ParseWebhook(tt.header, tt.body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseWebhook() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if event.InvoiceID != invoice.ID || event.Status != StatusPaid || event.Amount != 750 || event.Currency != "USD" {
+				t.Errorf("ParseWebhook() = %+v, want a paid event of %s for 750 USD", event, invoice.ID)
+			}
+		})
+	}
+}
+
+func TestFakeSimulate(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []string
+		want      string
+		wantErr   error
+		refundErr error
+	}{
+		{name: "paid", statuses: []string{StatusPaid}, want: StatusPaid},
+		{name: "failed", statuses: []string{StatusFailed}, want: StatusFailed, refundErr: ErrNotRefundable},
+		{name: "charged back", statuses: []string{StatusPaid, StatusChargedBack}, want: StatusChargedBack, refundErr: ErrNotRefundable},
+		{name: "pending", statuses: []string{StatusPending}, want: StatusPending, wantErr: ErrInvalidEvent, refundErr: ErrNotRefundable},
+		{name: "unknown status", statuses: []string{"settled"}, want: StatusPending, wantErr: ErrInvalidEvent, refundErr: ErrNotRefundable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := NewFake("whsec_test", "http://localhost/pay", time.Minute)
+
+			invoice, err := fake.CreateInvoice(ctx, InvoiceRequest{Reference: "payment-1", Amount: 100, Currency: "USD"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, status := range tt.statuses {
+				_, _, err = fake.Simulate(invoice.ID, status)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Simulate() error = %v, want %v", err, tt.wantErr)
+			}
+
+			got, err := fake.GetInvoice(ctx, invoice.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.want {
+				t.Errorf("status = %s, want %s", got.Status, tt.want)
+			}
+
+			if err = fake.Refund(ctx, invoice.ID); !errors.Is(err, tt.refundErr) {
+				t.Errorf("Refund() error = %v, want %v", err, tt.refundErr)
+			}
+		})
+	}
+}
+
+func TestFakeCreateInvoiceOncePerReference(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake("whsec_test", "http://localhost/pay", time.Minute)
+
+	first, err := fake.CreateInvoice(ctx, InvoiceRequest{Reference: "payment-1", Amount: 100, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := fake.CreateInvoice(ctx, InvoiceRequest{Reference: "payment-1", Amount: 100, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := fake.CreateInvoice(ctx, InvoiceRequest{Reference: "payment-2", Amount: 100, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.ID != first.ID {
+		t.Errorf("invoice for the same reference = %s, want %s", again.ID, first.ID)
+	}
+	if other.ID == first.ID {
+		t.Errorf("invoice for another reference reused %s", first.ID)
+	}
+
+	if _, _, err = fake.Simulate("inv_missing", StatusPaid); !errors.Is(err, ErrInvoiceNotFound) {
+		t.Errorf("Simulate() of a missing invoice error = %v, want %v", err, ErrInvoiceNotFound)
+	}
+}
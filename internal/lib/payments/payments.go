@@ -0,0 +1,67 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Invoice statuses, as providers report them.
+const (
+	StatusPending     = "pending"
+	StatusPaid        = "paid"
+	StatusFailed      = "failed"
+	StatusRefunded    = "refunded"
+	StatusChargedBack = "charged_back"
+)
+
+var (
+	ErrInvoiceNotFound  = errors.New("invoice not found")
+	ErrNotRefundable    = errors.New("invoice is not paid")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidEvent     = errors.New("invalid webhook event")
+)
+
+// InvoiceRequest asks a provider to collect Amount minor units of Currency. Reference is our
+// id of the payment; a provider returns the same invoice when asked again for it.
+type InvoiceRequest struct {
+	Reference   string
+	Amount      int64
+	Currency    string
+	Description string
+	ReturnURL   string
+}
+
+// Invoice is a payment at a provider. URL is the checkout page the payer is redirected to.
+type Invoice struct {
+	ID        string `json:"id"`
+	Reference string `json:"reference"`
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+	Status    string `json:"status"`
+	URL       string `json:"url"`
+}
+
+// Event is a change of the status of an invoice that a provider reports with a webhook. ID
+// identifies the event at the provider, which may deliver it more than once.
+type Event struct {
+	ID        string    `json:"id"`
+	InvoiceID string    `json:"invoice_id"`
+	Status    string    `json:"status"`
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Provider collects payments.
+type Provider interface {
+	// Name identifies the provider in webhook URLs and in stored payments.
+	Name() string
+	CreateInvoice(ctx context.Context, req InvoiceRequest) (*Invoice, error)
+	GetInvoice(ctx context.Context, invoiceID string) (*Invoice, error)
+	// Refund returns a paid invoice to the payer in full.
+	Refund(ctx context.Context, invoiceID string) error
+	// ParseWebhook verifies the signature of a webhook request and returns its event.
+	ParseWebhook(header http.Header, body []byte) (*Event, error)
+}
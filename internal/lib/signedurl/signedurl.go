@@ -0,0 +1,62 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrExpired          = errors.New("link expired")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Signer issues and checks short-lived links to a resource path.
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret string) *Signer {
+	return &Signer{secret: []byte(secret)}
+}
+
+// Sign returns path with expires and signature query parameters appended.
+func (s *Signer) Sign(path string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("signature", s.signature(path, exp))
+
+	return path + "?" + q.Encode()
+}
+
+// Verify checks the expires and signature query parameters of a link to path.
+func (s *Signer) Verify(path string, query url.Values) error {
+	exp := query.Get("expires")
+
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(query.Get("signature")), []byte(s.signature(path, exp))) {
+		return ErrInvalidSignature
+	}
+
+	if time.Now().Unix() > expUnix {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+func (s *Signer) signature(path, exp string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(path + "\n" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,50 @@
+package useragent
+
+import "strings"
+
+// browsers are matched in order: most browsers also claim to be the ones listed after them.
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"okhttp/", "Android app"},
+	{"CFNetwork/", "iOS app"},
+	{"curl/", "curl"},
+}
+
+var systems = []struct{ token, name string }{
+	{"Windows", "Windows"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Darwin", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// Family describes the browser and operating system of a user agent, such as "Firefox on
+// Linux". Versions are left out, so that updates do not make a device look new.
+func Family(userAgent string) string {
+	browser := "Unknown browser"
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			return browser + " on " + s.name
+		}
+	}
+
+	return browser
+}
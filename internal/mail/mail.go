@@ -0,0 +1,193 @@
+package mail
+
+import (
+	"boton-back/internal/lib/mailer"
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Email templates. Each one defines a subject, a text body and an HTML body in every locale.
+const (
+	TemplateVerification    = "verification"
+	TemplatePasswordReset   = "password_reset"
+	TemplateNewLogin        = "new_login"
+	TemplateEmailChanged    = "email_changed"
+	TemplateEmailChangedNew = "email_changed_new"
+	TemplatePasswordChanged = "password_changed"
+	TemplateLoginConfirm    = "login_confirmation"
+)
+
+// DefaultLocale is used for users whose locale has no translation.
+const DefaultLocale = "en"
+
+var (
+	Locales   = []string{"en", "ru"}
+	Templates = []string{
+		TemplateVerification,
+		TemplatePasswordReset,
+		TemplateNewLogin,
+		TemplateEmailChanged,
+		TemplateEmailChangedNew,
+		TemplatePasswordChanged,
+		TemplateLoginConfirm,
+	}
+)
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+//go:embed templates
+var templatesFS embed.FS
+
+// VerificationData is the data of TemplateVerification.
+type VerificationData struct {
+	Link      string
+	ExpiresIn time.Duration
+}
+
+// PasswordResetData is the data of TemplatePasswordReset.
+type PasswordResetData struct {
+	Link      string
+	ExpiresIn time.Duration
+}
+
+// NewLoginData is the data of TemplateNewLogin. Location is empty when the address is not in
+// the IP database; Suspicious is set when the login is too far from the previous one to travel.
+type NewLoginData struct {
+	Device     string
+	IP         string
+	Location   string
+	Time       time.Time
+	Suspicious bool
+}
+
+// LoginConfirmationData is the data of TemplateLoginConfirm.
+type LoginConfirmationData struct {
+	Link      string
+	Device    string
+	IP        string
+	Location  string
+	ExpiresIn time.Duration
+}
+
+// EmailChangedData is the data of TemplateEmailChanged and TemplateEmailChangedNew.
+type EmailChangedData struct {
+	OldEmail string
+	NewEmail string
+}
+
+// view is what the templates are executed with: the greeting uses Username, the body Data.
+type view struct {
+	Username string
+	Data     any
+}
+
+// pluralRules pick the form of a noun following n out of one, few and many, by language.
+var pluralRules = map[string]func(n int, one, few, many string) string{
+	"en": func(n int, one, _, many string) string {
+		if n == 1 {
+			return one
+		}
+		return many
+	},
+	// one for 1, 21, 31…, few for 2-4, 22-24… and many otherwise
+	"ru": func(n int, one, few, many string) string {
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return one
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return few
+		default:
+			return many
+		}
+	},
+}
+
+type localized struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Renderer renders the email templates embedded in the binary.
+type Renderer struct {
+	templates map[string]localized
+}
+
+// NewRenderer parses every template in every locale, so that a broken one fails at startup.
+func NewRenderer() (*Renderer, error) {
+	r := &Renderer{templates: make(map[string]localized)}
+
+	for _, locale := range Locales {
+		funcs := map[string]any{
+			"hours":   func(d time.Duration) int { return int(d.Hours()) },
+			"minutes": func(d time.Duration) int { return int(d.Minutes()) },
+			"plural":  pluralRules[locale],
+		}
+
+		for _, name := range Templates {
+			files := []string{"templates/" + locale + "/common.tmpl", "templates/" + locale + "/" + name + ".tmpl"}
+
+			text, err := texttemplate.New(name).Funcs(funcs).ParseFS(templatesFS, files...)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", locale, name, err)
+			}
+
+			html, err := htmltemplate.New(name).Funcs(funcs).ParseFS(templatesFS, append([]string{"templates/layout.html"}, files...)...)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", locale, name, err)
+			}
+
+			r.templates[locale+"/"+name] = localized{text: text, html: html}
+		}
+	}
+
+	return r, nil
+}
+
+// Render renders the named template for username in the language of locale, such as "ru" or
+// "en-US", falling back to DefaultLocale. The recipient and sender are left to the caller.
+func (r *Renderer) Render(locale, name, username string, data any) (mailer.Message, error) {
+	t, ok := r.templates[Language(locale)+"/"+name]
+	if !ok {
+		return mailer.Message{}, fmt.Errorf("%w: %s", ErrUnknownTemplate, name)
+	}
+
+	var subject, text, html bytes.Buffer
+
+	v := view{Username: username, Data: data}
+
+	if err := t.text.ExecuteTemplate(&subject, "subject", v); err != nil {
+		return mailer.Message{}, err
+	}
+
+	if err := t.text.ExecuteTemplate(&text, "text", v); err != nil {
+		return mailer.Message{}, err
+	}
+
+	if err := t.html.ExecuteTemplate(&html, "layout", v); err != nil {
+		return mailer.Message{}, err
+	}
+
+	return mailer.Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// Language returns the supported language of locale, or DefaultLocale.
+func Language(locale string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	for _, l := range Locales {
+		if l == lang {
+			return l
+		}
+	}
+
+	return DefaultLocale
+}
@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const adminKeyHeader = "X-Admin-Key"
+
+// AdminMiddleware guards the operator endpoints with a static API key. With no key configured
+// every request is rejected.
+type AdminMiddleware struct {
+	apiKey string
+}
+
+func NewAdminMiddleware(apiKey string) *AdminMiddleware {
+	return &AdminMiddleware{
+		apiKey: apiKey,
+	}
+}
+
+func (m *AdminMiddleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(adminKeyHeader)
+		if m.apiKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(m.apiKey)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin key"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -1,43 +1,79 @@
 package middlewares
 
 import (
+	"boton-back/internal/lib/cookies"
 	"boton-back/internal/lib/jwt"
+	"context"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strings"
+	"time"
 )
 
+type SessionChecker interface {
+	IsSessionRevoked(ctx context.Context, userID string, issuedAt time.Time) (bool, error)
+}
+
 type AuthMiddleware struct {
-	jwtGen *jwt.Generator
+	jwtGen   *jwt.Generator
+	sessions SessionChecker
 }
 
-func NewAuthMiddleware(jwtGen *jwt.Generator) *AuthMiddleware {
+func NewAuthMiddleware(jwtGen *jwt.Generator, sessions SessionChecker) *AuthMiddleware {
 	return &AuthMiddleware{
-		jwtGen: jwtGen,
+		jwtGen:   jwtGen,
+		sessions: sessions,
 	}
 }
 
 func (m *AuthMiddleware) Handle() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		tokenString, ok := accessToken(c)
+		if !ok {
 			return
 		}
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header"})
+
+		claims, err := m.jwtGen.ParseAccessClaims(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
 		}
-		tokenString := parts[1]
 
-		id, err := m.jwtGen.ParseToken(tokenString)
+		revoked, err := m.sessions.IsSessionRevoked(c.Request.Context(), claims.UserID, claims.IssuedAt)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+			return
+		}
+		if revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session revoked"})
 			return
 		}
 
-		c.Set("user_id", id)
+		c.Set("user_id", claims.UserID)
+		c.Set("session_id", claims.SessionID)
+		c.Set("token_expires_at", claims.ExpiresAt)
 		c.Next()
 	}
 }
+
+// accessToken extracts the access token from the Authorization header or, for the web client,
+// from the access token cookie. It aborts the request and returns false when there is none.
+func accessToken(c *gin.Context) (string, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header"})
+			return "", false
+		}
+		return parts[1], true
+	}
+
+	cookie, err := c.Cookie(cookies.AccessTokenName)
+	if err != nil || cookie == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		return "", false
+	}
+
+	return cookie, true
+}
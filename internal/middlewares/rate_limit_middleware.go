@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"boton-back/internal/lib/logger/sl"
+	"context"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error)
+}
+
+// RateLimitMiddleware allows each user, or each client IP for anonymous requests, at most
+// limit requests per window to the routes it guards.
+type RateLimitMiddleware struct {
+	log     *slog.Logger
+	limiter RateLimiter
+	name    string
+	limit   int
+	window  time.Duration
+}
+
+func NewRateLimitMiddleware(log *slog.Logger, limiter RateLimiter, name string, limit int, window time.Duration) *RateLimitMiddleware {
+	return &RateLimitMiddleware{
+		log:     log,
+		limiter: limiter,
+		name:    name,
+		limit:   limit,
+		window:  window,
+	}
+}
+
+func (m *RateLimitMiddleware) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := m.name + ":ip:" + c.ClientIP()
+		if userID, ok := c.Get("user_id"); ok {
+			key = m.name + ":user:" + userID.(string)
+		}
+
+		allowed, retryAfter, err := m.limiter.Allow(c.Request.Context(), key, m.limit, m.window)
+		if err != nil {
+			// an unavailable limiter must not take the routes it guards down with it
+			m.log.Warn("rate limiter unavailable", slog.String("limiter", m.name), sl.Err(err))
+			c.Next()
+			return
+		}
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,151 @@
+package realtime
+
+import (
+	"boton-back/internal/domain/models"
+	"context"
+	"github.com/google/uuid"
+	"log/slog"
+	"sync"
+)
+
+// EventSource feeds the hub with the events of all users, e.g. from a pub/sub broker shared
+// by every instance of the service.
+type EventSource interface {
+	ListenEvents(ctx context.Context, deliver func(userId uuid.UUID, event models.Event)) error
+}
+
+// Subscription receives the events of one user for one client connection.
+type Subscription struct {
+	UserID uuid.UUID
+	events chan models.Event
+	done   chan struct{}
+	once   sync.Once
+	// dropped is set when the subscription was closed because the client fell behind.
+	dropped bool
+}
+
+// Events returns the channel of events. It is never closed, so readers also wait on Done.
+func (s *Subscription) Events() <-chan models.Event {
+	return s.events
+}
+
+// Done is closed once the subscription is closed by the hub.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped reports whether the hub closed the subscription because its buffer was full.
+// It is only meaningful after Done is closed.
+func (s *Subscription) Dropped() bool {
+	return s.dropped
+}
+
+func (s *Subscription) close(dropped bool) {
+	s.once.Do(func() {
+		s.dropped = dropped
+		close(s.done)
+	})
+}
+
+// Hub delivers events to the subscriptions of the users connected to this instance.
+type Hub struct {
+	log        *slog.Logger
+	bufferSize int
+
+	mu   sync.RWMutex
+	subs map[uuid.UUID]map[*Subscription]struct{}
+}
+
+// NewHub returns a hub buffering up to bufferSize events per subscription. A subscription
+// whose buffer is full is closed rather than allowed to slow the delivery to everyone else.
+func NewHub(log *slog.Logger, bufferSize int) *Hub {
+	return &Hub{
+		log:        log,
+		bufferSize: bufferSize,
+		subs:       make(map[uuid.UUID]map[*Subscription]struct{}),
+	}
+}
+
+func (h *Hub) Subscribe(userId uuid.UUID) *Subscription {
+	sub := &Subscription{
+		UserID: userId,
+		events: make(chan models.Event, h.bufferSize),
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs[userId] == nil {
+		h.subs[userId] = make(map[*Subscription]struct{})
+	}
+	h.subs[userId][sub] = struct{}{}
+
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(sub)
+	sub.close(false)
+}
+
+// Deliver hands the event to every subscription of the user on this instance without blocking.
+func (h *Hub) Deliver(userId uuid.UUID, event models.Event) {
+	h.mu.RLock()
+	var slow []*Subscription
+	for sub := range h.subs[userId] {
+		select {
+		case sub.events <- event:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, sub := range slow {
+		h.log.Warn("dropping slow subscriber", slog.String("user_id", userId.String()))
+		h.remove(sub)
+		sub.close(true)
+	}
+}
+
+// Run feeds the hub from source until ctx is done, then closes every subscription.
+func (h *Hub) Run(ctx context.Context, source EventSource) error {
+	err := source.ListenEvents(ctx, h.Deliver)
+
+	if ctx.Err() != nil {
+		h.closeAll()
+	}
+
+	return err
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, subs := range h.subs {
+		for sub := range subs {
+			sub.close(false)
+		}
+	}
+	h.subs = make(map[uuid.UUID]map[*Subscription]struct{})
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	subs := h.subs[sub.UserID]
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subs, sub.UserID)
+	}
+}
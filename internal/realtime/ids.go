@@ -0,0 +1,42 @@
+package realtime
+
+import (
+	"strconv"
+	"strings"
+)
+
+// EventIDAfter reports whether the logged event id a comes after b. Ids have the
+// "<milliseconds>-<sequence>" form of stream entries. Ids that cannot be ordered count as
+// after, so an event is never skipped for looking like a duplicate.
+func EventIDAfter(a, b string) bool {
+	aMs, aSeq, ok := parseEventID(a)
+	if !ok {
+		return true
+	}
+
+	bMs, bSeq, ok := parseEventID(b)
+	if !ok {
+		return true
+	}
+
+	return aMs > bMs || (aMs == bMs && aSeq > bSeq)
+}
+
+func parseEventID(id string) (uint64, uint64, bool) {
+	msStr, seqStr, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	ms, err := strconv.ParseUint(msStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return ms, seq, true
+}
@@ -0,0 +1,201 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// SoftDeleteUser marks the user as deleted and schedules the hard purge. DomainUserDeleted is
+// recorded in the same transaction.
+func (s *Storage) SoftDeleteUser(ctx context.Context, userId uuid.UUID, purgeAfter time.Time) error {
+	const op = "storage.Postgres.SoftDeleteUser"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, err := squirrel.Update("users").
+		Set("deleted_at", time.Now()).
+		Set("purge_after", purgeAfter).
+		Where(squirrel.Eq{"id": userId, "deleted_at": nil}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+	}
+
+	err = insertOutboxEvent(ctx, tx, models.DomainUserDeleted, models.UserDeletedData{UserID: userId, PurgeAfter: purgeAfter})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RestoreUser brings a soft-deleted user back. If the username was released and taken by
+// someone else in the meantime, the restored account gets fallbackUsername instead.
+// It returns the username the account ends up with. DomainUserRestored is recorded in the same
+// transaction.
+func (s *Storage) RestoreUser(ctx context.Context, userId uuid.UUID, fallbackUsername string) (string, error) {
+	const op = "storage.Postgres.RestoreUser"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var username string
+	err = tx.QueryRow(ctx,
+		`SELECT username FROM users WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`, userId,
+	).Scan(&username)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+		}
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var taken bool
+	err = tx.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1 AND deleted_at IS NULL)`, username,
+	).Scan(&taken)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if taken {
+		username = fallbackUsername
+	}
+
+	sql, args, err := squirrel.Update("users").
+		Set("username", username).
+		Set("deleted_at", nil).
+		Set("purge_after", nil).
+		Where(squirrel.Eq{"id": userId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = insertOutboxEvent(ctx, tx, models.DomainUserRestored, models.UserRestoredData{UserID: userId, Username: username})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return username, nil
+}
+
+// UsersDueForPurge returns up to limit deleted users whose grace period ended before t.
+func (s *Storage) UsersDueForPurge(ctx context.Context, t time.Time, limit int) ([]uuid.UUID, error) {
+	const op = "storage.Postgres.UsersDueForPurge"
+
+	sql, args, err := squirrel.Select("id").
+		From("users").
+		Where(squirrel.NotEq{"deleted_at": nil}).
+		Where(squirrel.LtOrEq{"purge_after": t}).
+		OrderBy("purge_after").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+// PurgeUser hard-deletes a soft-deleted user. The rows that only matter to the user reference
+// users(id) with ON DELETE CASCADE and go with the user row. Ledger accounts, transfers,
+// payments and promo redemptions are kept on purpose, with ON DELETE SET NULL, so the books
+// still balance; what is left on the balances of the user is swept into the forfeited system
+// account first, so no funds are left without an owner.
+func (s *Storage) PurgeUser(ctx context.Context, userId uuid.UUID) error {
+	const op = "storage.Postgres.PurgeUser"
+
+	err := s.inSerializableTx(ctx, func(tx pgx.Tx) error {
+		// a user who restored their account in the meantime is not purged
+		var id uuid.UUID
+		err := tx.QueryRow(ctx, "SELECT id FROM users WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE", userId).Scan(&id)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		rows, err := tx.Query(ctx, "SELECT currency, balance FROM ledger_accounts WHERE user_id = $1 AND balance > 0 ORDER BY currency",
+			userId)
+		if err != nil {
+			return err
+		}
+
+		balances, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Balance])
+		if err != nil {
+			return err
+		}
+
+		for _, balance := range balances {
+			_, _, err = postLedger(ctx, tx, &models.LedgerTransaction{
+				IdempotencyKey: "account-purge:" + userId.String() + ":" + balance.Currency,
+				Kind:           models.LedgerKindAccountPurge,
+				Description:    "Account purged",
+			}, []models.LedgerPosting{
+				{UserID: &userId, Currency: balance.Currency, Amount: -balance.Amount},
+				{System: models.LedgerSystemForfeited, Currency: balance.Currency, Amount: balance.Amount},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, "DELETE FROM users WHERE id = $1", userId)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func (s *Storage) GetAvatar(ctx context.Context, userId uuid.UUID) (*models.Avatar, error) {
+	const op = "storage.Postgres.GetAvatar"
+
+	sql, args, err := squirrel.Select("user_id", "version", "content_type", "updated_at").
+		From("user_avatars").
+		Where(squirrel.Eq{"user_id": userId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var avatar models.Avatar
+	err = s.db.QueryRow(ctx, sql, args...).Scan(&avatar.UserID, &avatar.Version, &avatar.ContentType, &avatar.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrAvatarNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &avatar, nil
+}
+
+// SaveAvatar stores the avatar and points the profile avatar url at it in one transaction.
+func (s *Storage) SaveAvatar(ctx context.Context, avatar *models.Avatar, avatarURL string) error {
+	const op = "storage.Postgres.SaveAvatar"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, err := squirrel.Insert("user_avatars").
+		Columns("user_id", "version", "content_type").
+		Values(avatar.UserID, avatar.Version, avatar.ContentType).
+		Suffix("ON CONFLICT (user_id) DO UPDATE SET version = EXCLUDED.version, content_type = EXCLUDED.content_type").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sql, args, err = squirrel.Insert("user_profiles").
+		Columns("user_id", "avatar_url").
+		Values(avatar.UserID, avatarURL).
+		Suffix("ON CONFLICT (user_id) DO UPDATE SET avatar_url = EXCLUDED.avatar_url").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteAvatar removes the avatar and clears the profile avatar url if it still points at it.
+func (s *Storage) DeleteAvatar(ctx context.Context, userId uuid.UUID, avatarURL string) error {
+	const op = "storage.Postgres.DeleteAvatar"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err = tx.Exec(ctx, `DELETE FROM user_avatars WHERE user_id = $1`, userId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE user_profiles SET avatar_url = NULL WHERE user_id = $1 AND avatar_url = $2`, userId, avatarURL)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ExportAvatar returns the avatar metadata of the user for the data export.
+func (s *Storage) ExportAvatar(ctx context.Context, userId uuid.UUID) (any, error) {
+	avatar, err := s.GetAvatar(ctx, userId)
+	if err != nil {
+		if errors.Is(err, repository.ErrAvatarNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return avatar, nil
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var deviceColumns = []string{
+	"id", "user_id", "user_agent_family", "ip_prefix", "last_ip", "country", "region", "latitude", "longitude",
+	"first_seen_at", "last_seen_at",
+}
+
+// ListDevices returns the devices of the user, most recently seen first.
+func (s *Storage) ListDevices(ctx context.Context, userId uuid.UUID) ([]models.Device, error) {
+	const op = "storage.Postgres.ListDevices"
+
+	sql, args, err := squirrel.Select(deviceColumns...).
+		From("user_devices").
+		Where(squirrel.Eq{"user_id": userId}).
+		OrderBy("last_seen_at DESC").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	devices, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Device])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return devices, nil
+}
+
+// SaveDevice records a login from the device. A device seen before keeps its ID and first
+// login time and gets the address, location and time of this one.
+func (s *Storage) SaveDevice(ctx context.Context, device *models.Device) error {
+	const op = "storage.Postgres.SaveDevice"
+
+	sql, args, err := squirrel.Insert("user_devices").
+		Columns("user_id", "user_agent_family", "ip_prefix", "last_ip", "country", "region", "latitude", "longitude").
+		Values(device.UserID, device.UserAgentFamily, device.IPPrefix, device.LastIP, device.Country, device.Region,
+			device.Latitude, device.Longitude).
+		Suffix(`ON CONFLICT (user_id, user_agent_family, ip_prefix) DO UPDATE SET
+			last_ip = EXCLUDED.last_ip,
+			country = EXCLUDED.country,
+			region = EXCLUDED.region,
+			latitude = EXCLUDED.latitude,
+			longitude = EXCLUDED.longitude,
+			last_seen_at = NOW()
+			RETURNING id, first_seen_at, last_seen_at`).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.db.QueryRow(ctx, sql, args...).Scan(&device.ID, &device.FirstSeenAt, &device.LastSeenAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteDevice forgets a device, so the next login from it raises a new device alert again.
+func (s *Storage) DeleteDevice(ctx context.Context, userId, deviceId uuid.UUID) error {
+	const op = "storage.Postgres.DeleteDevice"
+
+	sql, args, err := squirrel.Delete("user_devices").
+		Where(squirrel.Eq{"id": deviceId, "user_id": userId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, repository.ErrDeviceNotFound)
+	}
+
+	return nil
+}
+
+// ExportDevices returns the devices of the user for the data export.
+func (s *Storage) ExportDevices(ctx context.Context, userId uuid.UUID) (any, error) {
+	return s.ListDevices(ctx, userId)
+}
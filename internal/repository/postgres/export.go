@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"time"
+)
+
+var dataExportColumns = []string{
+	"id", "user_id", "status", "blob_key", "error", "created_at", "started_at", "completed_at", "expires_at",
+}
+
+func (s *Storage) CreateDataExport(ctx context.Context, userId uuid.UUID) (*models.DataExport, error) {
+	const op = "storage.Postgres.CreateDataExport"
+
+	sql, args, err := squirrel.Insert("data_exports").
+		Columns("user_id", "status").
+		Values(userId, models.DataExportPending).
+		Suffix("RETURNING " + joinColumns(dataExportColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	export, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.DataExport])
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrExportInProgress)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return export, nil
+}
+
+func (s *Storage) GetDataExport(ctx context.Context, userId, exportId uuid.UUID) (*models.DataExport, error) {
+	const op = "storage.Postgres.GetDataExport"
+
+	export, err := s.selectDataExport(ctx, squirrel.Eq{"id": exportId, "user_id": userId})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return export, nil
+}
+
+// GetDataExportByID fetches an export regardless of its owner, for signed download links.
+func (s *Storage) GetDataExportByID(ctx context.Context, exportId uuid.UUID) (*models.DataExport, error) {
+	const op = "storage.Postgres.GetDataExportByID"
+
+	export, err := s.selectDataExport(ctx, squirrel.Eq{"id": exportId})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return export, nil
+}
+
+// ListDataExports returns the export history of the user, newest first.
+func (s *Storage) ListDataExports(ctx context.Context, userId uuid.UUID) ([]models.DataExport, error) {
+	const op = "storage.Postgres.ListDataExports"
+
+	sql, args, err := squirrel.Select(dataExportColumns...).
+		From("data_exports").
+		Where(squirrel.Eq{"user_id": userId}).
+		OrderBy("created_at DESC").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	exports, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.DataExport])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return exports, nil
+}
+
+// ClaimDataExport picks the oldest pending export, or one whose worker died more than
+// staleAfter ago, and marks it as processing.
+func (s *Storage) ClaimDataExport(ctx context.Context, staleAfter time.Duration) (*models.DataExport, error) {
+	const op = "storage.Postgres.ClaimDataExport"
+
+	sql := `
+		UPDATE data_exports SET status = $1, started_at = NOW()
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = $2 OR (status = $1 AND started_at < $3)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + joinColumns(dataExportColumns)
+
+	rows, err := s.db.Query(ctx, sql, models.DataExportProcessing, models.DataExportPending, time.Now().Add(-staleAfter))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	export, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.DataExport])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrExportNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return export, nil
+}
+
+func (s *Storage) CompleteDataExport(ctx context.Context, exportId uuid.UUID, blobKey string, expiresAt time.Time) error {
+	const op = "storage.Postgres.CompleteDataExport"
+
+	err := s.updateDataExport(ctx, exportId, map[string]interface{}{
+		"status":       models.DataExportReady,
+		"blob_key":     blobKey,
+		"completed_at": time.Now(),
+		"expires_at":   expiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) FailDataExport(ctx context.Context, exportId uuid.UUID, reason string) error {
+	const op = "storage.Postgres.FailDataExport"
+
+	err := s.updateDataExport(ctx, exportId, map[string]interface{}{
+		"status":       models.DataExportFailed,
+		"error":        reason,
+		"completed_at": time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ExpireDataExport forgets the archive of an export once it has been removed from the blob store.
+func (s *Storage) ExpireDataExport(ctx context.Context, exportId uuid.UUID) error {
+	const op = "storage.Postgres.ExpireDataExport"
+
+	err := s.updateDataExport(ctx, exportId, map[string]interface{}{
+		"status":   models.DataExportExpired,
+		"blob_key": nil,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ExpiredDataExports returns up to limit ready exports whose archive expired before t.
+func (s *Storage) ExpiredDataExports(ctx context.Context, t time.Time, limit int) ([]models.DataExport, error) {
+	const op = "storage.Postgres.ExpiredDataExports"
+
+	sql, args, err := squirrel.Select(dataExportColumns...).
+		From("data_exports").
+		Where(squirrel.Eq{"status": models.DataExportReady}).
+		Where(squirrel.Lt{"expires_at": t}).
+		OrderBy("expires_at").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	exports, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.DataExport])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return exports, nil
+}
+
+// ExportAccount returns the account row of the user without the password hash.
+func (s *Storage) ExportAccount(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportAccount"
+
+	var account struct {
+		ID         uuid.UUID  `json:"id"`
+		Username   string     `json:"username"`
+		Email      string     `json:"email"`
+		CreatedAt  time.Time  `json:"created_at"`
+		UpdatedAt  time.Time  `json:"updated_at"`
+		DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+		LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	}
+
+	sql, args, err := squirrel.Select("id", "username", "email", "created_at", "updated_at", "deleted_at", "last_seen_at").
+		From("users").
+		Where(squirrel.Eq{"id": userId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.db.QueryRow(ctx, sql, args...).
+		Scan(&account.ID, &account.Username, &account.Email, &account.CreatedAt, &account.UpdatedAt, &account.DeletedAt, &account.LastSeenAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return account, nil
+}
+
+// ExportDataExports returns the export history of the user.
+func (s *Storage) ExportDataExports(ctx context.Context, userId uuid.UUID) (any, error) {
+	return s.ListDataExports(ctx, userId)
+}
+
+func (s *Storage) selectDataExport(ctx context.Context, where squirrel.Eq) (*models.DataExport, error) {
+	sql, args, err := squirrel.Select(dataExportColumns...).
+		From("data_exports").
+		Where(where).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	export, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.DataExport])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrExportNotFound
+		}
+		return nil, err
+	}
+
+	return export, nil
+}
+
+func (s *Storage) updateDataExport(ctx context.Context, exportId uuid.UUID, values map[string]interface{}) error {
+	sql, args, err := squirrel.Update("data_exports").
+		SetMap(values).
+		Where(squirrel.Eq{"id": exportId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, sql, args...)
+	return err
+}
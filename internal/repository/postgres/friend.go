@@ -0,0 +1,439 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"time"
+)
+
+var friendshipColumns = []string{"id", "requester_id", "addressee_id", "state", "created_at", "responded_at"}
+
+// GetUserIDByUsername resolves the username of an active user.
+func (s *Storage) GetUserIDByUsername(ctx context.Context, username string) (uuid.UUID, error) {
+	const op = "storage.Postgres.GetUserIDByUsername"
+
+	sql, args, err := squirrel.Select("id").
+		From("users").
+		Where(squirrel.Eq{"username": username, "deleted_at": nil}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var id uuid.UUID
+	err = s.db.QueryRow(ctx, sql, args...).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+		}
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+func (s *Storage) CreateFriendRequest(ctx context.Context, requesterId, addresseeId uuid.UUID) (*models.Friendship, error) {
+	const op = "storage.Postgres.CreateFriendRequest"
+
+	sql, args, err := squirrel.Insert("friendships").
+		Columns("requester_id", "addressee_id", "state").
+		Values(requesterId, addresseeId, models.FriendshipPending).
+		Suffix("RETURNING " + joinColumns(friendshipColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	friendship, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Friendship])
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrFriendshipExists)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return friendship, nil
+}
+
+// GetFriendshipBetween returns the friendship or pending request between two users in either direction.
+func (s *Storage) GetFriendshipBetween(ctx context.Context, userId, otherId uuid.UUID) (*models.Friendship, error) {
+	const op = "storage.Postgres.GetFriendshipBetween"
+
+	friendship, err := s.selectFriendship(ctx, squirrel.Or{
+		squirrel.Eq{"requester_id": userId, "addressee_id": otherId},
+		squirrel.Eq{"requester_id": otherId, "addressee_id": userId},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return friendship, nil
+}
+
+func (s *Storage) AreFriends(ctx context.Context, userId, otherId uuid.UUID) (bool, error) {
+	const op = "storage.Postgres.AreFriends"
+
+	friendship, err := s.GetFriendshipBetween(ctx, userId, otherId)
+	if err != nil {
+		if errors.Is(err, repository.ErrFriendshipNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return friendship.State == models.FriendshipAccepted, nil
+}
+
+// AcceptFriendRequest accepts a pending request addressed to addresseeId.
+func (s *Storage) AcceptFriendRequest(ctx context.Context, requestId, addresseeId uuid.UUID) (*models.Friendship, error) {
+	const op = "storage.Postgres.AcceptFriendRequest"
+
+	sql, args, err := squirrel.Update("friendships").
+		Set("state", models.FriendshipAccepted).
+		Set("responded_at", time.Now()).
+		Where(squirrel.Eq{"id": requestId, "addressee_id": addresseeId, "state": models.FriendshipPending}).
+		Suffix("RETURNING " + joinColumns(friendshipColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	friendship, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Friendship])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrFriendshipNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return friendship, nil
+}
+
+// DeclineFriendRequest removes a pending request addressed to addresseeId.
+func (s *Storage) DeclineFriendRequest(ctx context.Context, requestId, addresseeId uuid.UUID) error {
+	const op = "storage.Postgres.DeclineFriendRequest"
+
+	err := s.deleteFriendship(ctx, squirrel.Eq{"id": requestId, "addressee_id": addresseeId, "state": models.FriendshipPending})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// CancelFriendRequest removes a pending request sent by requesterId.
+func (s *Storage) CancelFriendRequest(ctx context.Context, requestId, requesterId uuid.UUID) error {
+	const op = "storage.Postgres.CancelFriendRequest"
+
+	err := s.deleteFriendship(ctx, squirrel.Eq{"id": requestId, "requester_id": requesterId, "state": models.FriendshipPending})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteFriendship ends an accepted friendship between two users.
+func (s *Storage) DeleteFriendship(ctx context.Context, userId, friendId uuid.UUID) error {
+	const op = "storage.Postgres.DeleteFriendship"
+
+	err := s.deleteFriendship(ctx, squirrel.And{
+		squirrel.Eq{"state": models.FriendshipAccepted},
+		squirrel.Or{
+			squirrel.Eq{"requester_id": userId, "addressee_id": friendId},
+			squirrel.Eq{"requester_id": friendId, "addressee_id": userId},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListFriends returns a page of accepted friends, most recent friendships first.
+func (s *Storage) ListFriends(ctx context.Context, userId uuid.UUID, after *cursor.Cursor, limit int) ([]models.FriendshipEntry, error) {
+	const op = "storage.Postgres.ListFriends"
+
+	query := friendshipEntrySelect("f.responded_at", "CASE WHEN f.requester_id = ? THEN f.addressee_id ELSE f.requester_id END", userId).
+		Where(squirrel.Or{squirrel.Eq{"f.requester_id": userId}, squirrel.Eq{"f.addressee_id": userId}}).
+		Where(squirrel.Eq{"f.state": models.FriendshipAccepted})
+
+	entries, err := s.listFriendshipEntries(ctx, query, "f.responded_at", after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
+
+// ListFriendRequests returns a page of pending requests addressed to the user (incoming)
+// or sent by them, newest first.
+func (s *Storage) ListFriendRequests(ctx context.Context, userId uuid.UUID, incoming bool, after *cursor.Cursor, limit int) ([]models.FriendshipEntry, error) {
+	const op = "storage.Postgres.ListFriendRequests"
+
+	self, other := "f.requester_id", "f.addressee_id"
+	if incoming {
+		self, other = other, self
+	}
+
+	query := friendshipEntrySelect("f.created_at", other).
+		Where(squirrel.Eq{self: userId, "f.state": models.FriendshipPending})
+
+	entries, err := s.listFriendshipEntries(ctx, query, "f.created_at", after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
+
+// acceptedFriendIDs selects the ids of the accepted friends of the user given by the first
+// placeholder. Each branch is served by one of the partial covering indexes on friendships.
+const acceptedFriendIDs = `
+	SELECT addressee_id AS id FROM friendships WHERE requester_id = ? AND state = 'accepted'
+	UNION ALL
+	SELECT requester_id FROM friendships WHERE addressee_id = ? AND state = 'accepted'`
+
+// ListMutualFriends returns a page of the accepted friends of ownerId who are also friends of
+// viewerId, ordered like ListFriends of the owner.
+func (s *Storage) ListMutualFriends(ctx context.Context, viewerId, ownerId uuid.UUID, after *cursor.Cursor, limit int) ([]models.FriendshipEntry, error) {
+	const op = "storage.Postgres.ListMutualFriends"
+
+	query := friendshipEntrySelect("f.responded_at", "CASE WHEN f.requester_id = ? THEN f.addressee_id ELSE f.requester_id END", ownerId).
+		Where(squirrel.Or{squirrel.Eq{"f.requester_id": ownerId}, squirrel.Eq{"f.addressee_id": ownerId}}).
+		Where(squirrel.Eq{"f.state": models.FriendshipAccepted}).
+		Where("u.id IN ("+acceptedFriendIDs+")", viewerId, viewerId)
+
+	entries, err := s.listFriendshipEntries(ctx, query, "f.responded_at", after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
+
+// FriendSuggestions ranks the friends of the user's friends by the number of mutual friends.
+// Users already related to the user by a friendship or a pending request in either direction,
+// blocked in either direction, or accepting no friend requests are left out. Each suggestion
+// carries up to sampleSize usernames of the mutual friends.
+func (s *Storage) FriendSuggestions(ctx context.Context, userId uuid.UUID, sampleSize, limit int) ([]models.FriendSuggestion, error) {
+	const op = "storage.Postgres.FriendSuggestions"
+
+	sql, args, err := squirrel.Select(
+		"u.id AS user_id", "u.username", "p.display_name", "p.avatar_url", "COUNT(*) AS score",
+		fmt.Sprintf("(array_agg(m.username ORDER BY m.username))[1:%d] AS mutual_friends", sampleSize),
+	).
+		Prefix("WITH friends AS ("+acceptedFriendIDs+"),", userId, userId).
+		Prefix(`candidates AS (
+			SELECT f.addressee_id AS id, fr.id AS via FROM friends fr
+			JOIN friendships f ON f.requester_id = fr.id AND f.state = 'accepted'
+			UNION ALL
+			SELECT f.requester_id, fr.id FROM friends fr
+			JOIN friendships f ON f.addressee_id = fr.id AND f.state = 'accepted'
+		)`).
+		From("candidates c").
+		Join("users u ON u.id = c.id").
+		Join("users m ON m.id = c.via").
+		LeftJoin("user_profiles p ON p.user_id = u.id").
+		LeftJoin("user_privacy_settings ps ON ps.user_id = u.id").
+		Where(squirrel.Eq{"u.deleted_at": nil, "m.deleted_at": nil}).
+		Where("c.id <> ?", userId).
+		Where(`NOT EXISTS (
+			SELECT 1 FROM friendships x
+			WHERE LEAST(x.requester_id, x.addressee_id) = LEAST(c.id, ?::uuid)
+			  AND GREATEST(x.requester_id, x.addressee_id) = GREATEST(c.id, ?::uuid)
+		)`, userId, userId).
+		Where(`NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.blocker_id = ? AND b.blocked_id = c.id) OR (b.blocker_id = c.id AND b.blocked_id = ?)
+		)`, userId, userId).
+		Where(squirrel.Or{
+			squirrel.Eq{"ps.friend_requests_from": nil},
+			squirrel.NotEq{"ps.friend_requests_from": models.FriendRequestsNobody},
+		}).
+		GroupBy("u.id", "u.username", "p.display_name", "p.avatar_url").
+		OrderBy("score DESC", "u.id").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	suggestions, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.FriendSuggestion])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return suggestions, nil
+}
+
+// ListSuggestionAudience returns the ids of the users whose friend suggestions may include
+// userId: the accepted friends of the accepted friends of the user.
+func (s *Storage) ListSuggestionAudience(ctx context.Context, userId uuid.UUID) ([]uuid.UUID, error) {
+	const op = "storage.Postgres.ListSuggestionAudience"
+
+	sql, args, err := squirrel.Select("c.id").
+		Prefix("WITH friends AS ("+acceptedFriendIDs+"),", userId, userId).
+		Prefix(`candidates AS (
+			SELECT f.addressee_id AS id FROM friends fr
+			JOIN friendships f ON f.requester_id = fr.id AND f.state = 'accepted'
+			UNION
+			SELECT f.requester_id FROM friends fr
+			JOIN friendships f ON f.addressee_id = fr.id AND f.state = 'accepted'
+		)`).
+		From("candidates c").
+		Where("c.id <> ?", userId).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+// ExportFriendships returns all friendships and requests of the user for the data export.
+func (s *Storage) ExportFriendships(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportFriendships"
+
+	sql, args, err := squirrel.Select(friendshipColumns...).
+		From("friendships").
+		Where(squirrel.Or{squirrel.Eq{"requester_id": userId}, squirrel.Eq{"addressee_id": userId}}).
+		OrderBy("created_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	friendships, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Friendship])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return friendships, nil
+}
+
+// friendshipEntrySelect joins the other side of a friendship, given by the otherUser expression.
+func friendshipEntrySelect(since, otherUser string, args ...interface{}) squirrel.SelectBuilder {
+	return squirrel.Select(
+		"f.id AS friendship_id", "u.id AS user_id", "u.username", "p.display_name", "p.avatar_url", since+" AS since",
+	).
+		From("friendships f").
+		Join("users u ON u.id = "+otherUser, args...).
+		LeftJoin("user_profiles p ON p.user_id = u.id").
+		Where(squirrel.Eq{"u.deleted_at": nil}).
+		PlaceholderFormat(squirrel.Dollar)
+}
+
+func (s *Storage) listFriendshipEntries(ctx context.Context, query squirrel.SelectBuilder, orderBy string, after *cursor.Cursor, limit int) ([]models.FriendshipEntry, error) {
+	if after != nil {
+		query = query.Where("("+orderBy+", f.id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy(orderBy+" DESC", "f.id DESC").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[models.FriendshipEntry])
+}
+
+func (s *Storage) selectFriendship(ctx context.Context, where squirrel.Sqlizer) (*models.Friendship, error) {
+	sql, args, err := squirrel.Select(friendshipColumns...).
+		From("friendships").
+		Where(where).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	friendship, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Friendship])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrFriendshipNotFound
+		}
+		return nil, err
+	}
+
+	return friendship, nil
+}
+
+func (s *Storage) deleteFriendship(ctx context.Context, where squirrel.Sqlizer) error {
+	sql, args, err := squirrel.Delete("friendships").
+		Where(where).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return repository.ErrFriendshipNotFound
+	}
+
+	return nil
+}
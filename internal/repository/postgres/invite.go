@@ -0,0 +1,236 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var inviteColumns = []string{
+	"id", "code", "created_by", "email", "max_uses", "uses", "expires_at", "revoked_at", "created_at",
+}
+
+// CreateInvite saves a new invite. An invite created by a user counts against their quota while
+// it can still be used: it is not created if they already hold quota live invites.
+func (s *Storage) CreateInvite(ctx context.Context, invite *models.Invite, quota int) (*models.Invite, error) {
+	const op = "storage.Postgres.CreateInvite"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if invite.CreatedBy != nil {
+		// the user row serializes concurrent invites of the same user
+		if _, err = tx.Exec(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", *invite.CreatedBy); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		countSQL, countArgs, err := squirrel.Select("COUNT(*)").
+			From("invites").
+			Where(squirrel.Eq{"created_by": *invite.CreatedBy, "revoked_at": nil}).
+			Where("uses < max_uses").
+			Where(squirrel.Or{squirrel.Eq{"expires_at": nil}, squirrel.Gt{"expires_at": time.Now()}}).
+			PlaceholderFormat(squirrel.Dollar).
+			ToSql()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		var count int
+		if err = tx.QueryRow(ctx, countSQL, countArgs...).Scan(&count); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if count >= quota {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrInviteQuotaExceeded)
+		}
+	}
+
+	sql, args, err := squirrel.Insert("invites").
+		Columns("code", "created_by", "email", "max_uses", "expires_at").
+		Values(invite.Code, invite.CreatedBy, invite.Email, invite.MaxUses, invite.ExpiresAt).
+		Suffix("RETURNING " + joinColumns(inviteColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	created, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Invite])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return created, nil
+}
+
+func (s *Storage) GetInvite(ctx context.Context, inviteId uuid.UUID) (*models.Invite, error) {
+	const op = "storage.Postgres.GetInvite"
+
+	sql, args, err := squirrel.Select(inviteColumns...).
+		From("invites").
+		Where(squirrel.Eq{"id": inviteId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	invite, err := s.collectInvite(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invite, nil
+}
+
+// ListInvites returns a page of invites, newest first. A non-nil createdBy only lists the
+// invites created by that user.
+func (s *Storage) ListInvites(ctx context.Context, createdBy *uuid.UUID, after *cursor.Cursor, limit int) ([]models.Invite, error) {
+	const op = "storage.Postgres.ListInvites"
+
+	query := squirrel.Select(inviteColumns...).
+		From("invites")
+
+	if createdBy != nil {
+		query = query.Where(squirrel.Eq{"created_by": *createdBy})
+	}
+
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("created_at DESC", "id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	invites, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Invite])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invites, nil
+}
+
+// ListInviteRedemptions returns the users who registered with the invite, in order.
+func (s *Storage) ListInviteRedemptions(ctx context.Context, inviteId uuid.UUID) ([]models.InviteRedemption, error) {
+	const op = "storage.Postgres.ListInviteRedemptions"
+
+	sql, args, err := squirrel.Select("r.user_id", "u.username", "r.redeemed_at").
+		From("invite_redemptions r").
+		Join("users u ON u.id = r.user_id").
+		Where(squirrel.Eq{"r.invite_id": inviteId}).
+		OrderBy("r.redeemed_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	redemptions, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.InviteRedemption])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return redemptions, nil
+}
+
+// RevokeInvite stops an invite from being used. Revoking it again keeps the first revocation
+// time.
+func (s *Storage) RevokeInvite(ctx context.Context, inviteId uuid.UUID) (*models.Invite, error) {
+	const op = "storage.Postgres.RevokeInvite"
+
+	sql, args, err := squirrel.Update("invites").
+		Set("revoked_at", squirrel.Expr("COALESCE(revoked_at, ?)", time.Now())).
+		Where(squirrel.Eq{"id": inviteId}).
+		Suffix("RETURNING " + joinColumns(inviteColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	invite, err := s.collectInvite(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invite, nil
+}
+
+func (s *Storage) collectInvite(ctx context.Context, sql string, args []interface{}) (*models.Invite, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	invite, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Invite])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrInviteNotFound
+		}
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+// useInvite takes a use of the invite with the code for email. An invite that is revoked,
+// expired, used up or bound to another address is reported as not found.
+func useInvite(ctx context.Context, tx pgx.Tx, code, email string) (uuid.UUID, error) {
+	sql, args, err := squirrel.Update("invites").
+		Set("uses", squirrel.Expr("uses + 1")).
+		Where(squirrel.Eq{"code": code, "revoked_at": nil}).
+		Where("uses < max_uses").
+		Where(squirrel.Or{squirrel.Eq{"expires_at": nil}, squirrel.Gt{"expires_at": time.Now()}}).
+		Where(squirrel.Or{squirrel.Eq{"email": nil}, squirrel.Eq{"email": email}}).
+		Suffix("RETURNING id").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	var inviteId uuid.UUID
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&inviteId); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, repository.ErrInviteNotFound
+		}
+		return uuid.Nil, err
+	}
+
+	return inviteId, nil
+}
@@ -0,0 +1,321 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var statementColumns = []string{
+	"e.id", "e.transaction_id", "t.kind", "t.description", "e.amount", "e.currency", "e.balance_after", "e.created_at",
+}
+
+// PostLedgerTransaction writes the transaction with an entry per posting and moves the balances
+// of their accounts. It reports false when a transaction with the same idempotency key was
+// already posted: that one is returned instead, provided it was posted for the same postings.
+func (s *Storage) PostLedgerTransaction(ctx context.Context, transaction *models.LedgerTransaction, postings []models.LedgerPosting) (*models.LedgerTransaction, bool, error) {
+	const op = "storage.Postgres.PostLedgerTransaction"
+
+	var (
+		posted  *models.LedgerTransaction
+		created bool
+	)
+
+	err := s.inSerializableTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		posted, created, err = postLedger(ctx, tx, transaction, postings)
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return posted, created, nil
+}
+
+// GetBalances returns the balances of the accounts of the user.
+func (s *Storage) GetBalances(ctx context.Context, userId uuid.UUID) ([]models.Balance, error) {
+	const op = "storage.Postgres.GetBalances"
+
+	sql, args, err := squirrel.Select("currency", "balance").
+		From("ledger_accounts").
+		Where(squirrel.Eq{"user_id": userId}).
+		OrderBy("currency").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	balances, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Balance])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return balances, nil
+}
+
+// ListStatement returns a page of the entries on the accounts of the user, newest first. An
+// empty currency lists every account.
+func (s *Storage) ListStatement(ctx context.Context, userId uuid.UUID, currency string, after *cursor.Cursor, limit int) ([]models.StatementEntry, error) {
+	const op = "storage.Postgres.ListStatement"
+
+	query := statementQuery(userId)
+
+	if currency != "" {
+		query = query.Where(squirrel.Eq{"e.currency": currency})
+	}
+
+	if after != nil {
+		query = query.Where("(e.created_at, e.id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("e.created_at DESC", "e.id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.StatementEntry])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
+
+func (s *Storage) ExportLedger(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportLedger"
+
+	sql, args, err := statementQuery(userId).
+		OrderBy("e.created_at", "e.id").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.StatementEntry])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
+
+func statementQuery(userId uuid.UUID) squirrel.SelectBuilder {
+	return squirrel.Select(statementColumns...).
+		From("ledger_entries e").
+		Join("ledger_accounts a ON a.id = e.account_id").
+		Join("ledger_transactions t ON t.id = e.transaction_id").
+		Where(squirrel.Eq{"a.user_id": userId})
+}
+
+// postLedger posts a transaction within tx, so other writes can commit together with their
+// ledger entries. The accounts are locked in a fixed order, so concurrent transactions over
+// the same accounts queue up instead of deadlocking.
+func postLedger(ctx context.Context, tx pgx.Tx, transaction *models.LedgerTransaction, postings []models.LedgerPosting) (*models.LedgerTransaction, bool, error) {
+	postings = append([]models.LedgerPosting(nil), postings...)
+	sort.Slice(postings, func(i, j int) bool {
+		return postingKey(postings[i]) < postingKey(postings[j])
+	})
+
+	requestHash := ledgerRequestHash(transaction.Kind, postings)
+
+	existing, err := getLedgerTransaction(ctx, tx, transaction.IdempotencyKey)
+	if err == nil {
+		if existing.RequestHash != requestHash {
+			return nil, false, repository.ErrIdempotencyKeyReused
+		}
+		return existing, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+
+	balances := make(map[uuid.UUID]int64, len(postings))
+	accounts := make([]uuid.UUID, len(postings))
+	userAccounts := make(map[uuid.UUID]bool, len(postings))
+
+	for i, posting := range postings {
+		var accountId uuid.UUID
+		var balance int64
+
+		if accountId, balance, err = lockLedgerAccount(ctx, tx, posting); err != nil {
+			return nil, false, err
+		}
+
+		if _, ok := balances[accountId]; !ok {
+			balances[accountId] = balance
+		}
+		accounts[i] = accountId
+		userAccounts[accountId] = posting.UserID != nil
+	}
+
+	posted := &models.LedgerTransaction{
+		IdempotencyKey: transaction.IdempotencyKey,
+		RequestHash:    requestHash,
+		Kind:           transaction.Kind,
+		Description:    transaction.Description,
+	}
+
+	err = tx.QueryRow(ctx, `INSERT INTO ledger_transactions (idempotency_key, request_hash, kind, description)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		posted.IdempotencyKey, posted.RequestHash, posted.Kind, posted.Description,
+	).Scan(&posted.ID, &posted.CreatedAt)
+	if err != nil {
+		return nil, false, err
+	}
+
+	insert := squirrel.Insert("ledger_entries").
+		Columns("transaction_id", "account_id", "amount", "currency", "balance_after", "created_at").
+		Suffix("RETURNING id")
+
+	for i, posting := range postings {
+		balances[accounts[i]] += posting.Amount
+
+		entry := models.LedgerEntry{
+			TransactionID: posted.ID,
+			AccountID:     accounts[i],
+			Amount:        posting.Amount,
+			Currency:      posting.Currency,
+			BalanceAfter:  balances[accounts[i]],
+			CreatedAt:     posted.CreatedAt,
+		}
+
+		sql, args, err := insert.
+			Values(entry.TransactionID, entry.AccountID, entry.Amount, entry.Currency, entry.BalanceAfter, entry.CreatedAt).
+			PlaceholderFormat(squirrel.Dollar).
+			ToSql()
+		if err != nil {
+			return nil, false, err
+		}
+
+		if err = tx.QueryRow(ctx, sql, args...).Scan(&entry.ID); err != nil {
+			return nil, false, err
+		}
+
+		posted.Entries = append(posted.Entries, entry)
+	}
+
+	for accountId, balance := range balances {
+		if userAccounts[accountId] && balance < 0 {
+			return nil, false, repository.ErrInsufficientFunds
+		}
+
+		if _, err = tx.Exec(ctx, "UPDATE ledger_accounts SET balance = $1 WHERE id = $2", balance, accountId); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return posted, true, nil
+}
+
+// lockLedgerAccount locks the account a posting goes to, creating it on first use, and returns
+// it with its balance.
+func lockLedgerAccount(ctx context.Context, tx pgx.Tx, posting models.LedgerPosting) (uuid.UUID, int64, error) {
+	var (
+		accountId uuid.UUID
+		balance   int64
+	)
+
+	var err error
+	if posting.UserID != nil {
+		err = tx.QueryRow(ctx, `INSERT INTO ledger_accounts (user_id, currency) VALUES ($1, $2)
+			ON CONFLICT (user_id, currency) WHERE user_id IS NOT NULL
+			DO UPDATE SET balance = ledger_accounts.balance RETURNING id, balance`,
+			*posting.UserID, posting.Currency,
+		).Scan(&accountId, &balance)
+	} else {
+		err = tx.QueryRow(ctx, `INSERT INTO ledger_accounts (system_code, currency) VALUES ($1, $2)
+			ON CONFLICT (system_code, currency) WHERE system_code IS NOT NULL
+			DO UPDATE SET balance = ledger_accounts.balance RETURNING id, balance`,
+			posting.System, posting.Currency,
+		).Scan(&accountId, &balance)
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return uuid.Nil, 0, repository.ErrUserNotFound
+		}
+		return uuid.Nil, 0, err
+	}
+
+	return accountId, balance, nil
+}
+
+func getLedgerTransaction(ctx context.Context, tx pgx.Tx, idempotencyKey string) (*models.LedgerTransaction, error) {
+	rows, err := tx.Query(ctx, `SELECT id, idempotency_key, request_hash, kind, description, created_at
+		FROM ledger_transactions WHERE idempotency_key = $1`, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.LedgerTransaction])
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(ctx, `SELECT id, transaction_id, account_id, amount, currency, balance_after, created_at
+		FROM ledger_entries WHERE transaction_id = $1 ORDER BY created_at, id`, transaction.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction.Entries, err = pgx.CollectRows(rows, pgx.RowToStructByName[models.LedgerEntry])
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
+func postingKey(posting models.LedgerPosting) string {
+	if posting.UserID != nil {
+		return "user:" + posting.UserID.String() + ":" + posting.Currency
+	}
+	return "system:" + posting.System + ":" + posting.Currency
+}
+
+// ledgerRequestHash identifies what a transaction does, to tell a retry of it from another
+// transaction reusing its idempotency key. The postings must be sorted.
+func ledgerRequestHash(kind string, postings []models.LedgerPosting) string {
+	var b strings.Builder
+	b.WriteString(kind)
+	for _, posting := range postings {
+		b.WriteString("|" + postingKey(posting) + ":" + strconv.FormatInt(posting.Amount, 10))
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
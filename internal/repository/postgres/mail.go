@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// GetMailRecipient returns the address and locale of an active user.
+func (s *Storage) GetMailRecipient(ctx context.Context, userId uuid.UUID) (*models.MailRecipient, error) {
+	const op = "storage.Postgres.GetMailRecipient"
+
+	sql, args, err := squirrel.Select("u.id AS user_id", "u.username", "u.email", "p.locale").
+		From("users u").
+		LeftJoin("user_profiles p ON p.user_id = u.id").
+		Where(squirrel.Eq{"u.id": userId, "u.deleted_at": nil}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	recipient, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.MailRecipient])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return recipient, nil
+}
@@ -0,0 +1,404 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var messageColumns = []string{"id", "conversation_id", "sender_id", "body", "created_at", "edited_at", "deleted_at"}
+
+// GetOrCreateConversation returns the conversation between two users, creating it on first use.
+func (s *Storage) GetOrCreateConversation(ctx context.Context, userId, otherId uuid.UUID) (uuid.UUID, error) {
+	const op = "storage.Postgres.GetOrCreateConversation"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// the no-op update makes RETURNING yield the id of an existing conversation too
+	sql, args, err := squirrel.Insert("conversations").
+		Columns("user_low", "user_high").
+		Values(
+			squirrel.Expr("LEAST(?::uuid, ?::uuid)", userId, otherId),
+			squirrel.Expr("GREATEST(?::uuid, ?::uuid)", userId, otherId),
+		).
+		Suffix("ON CONFLICT (user_low, user_high) DO UPDATE SET user_low = EXCLUDED.user_low RETURNING id").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var conversationId uuid.UUID
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&conversationId); err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sql, args, err = squirrel.Insert("conversation_members").
+		Columns("conversation_id", "user_id").
+		Values(conversationId, userId).
+		Values(conversationId, otherId).
+		Suffix("ON CONFLICT DO NOTHING").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return conversationId, nil
+}
+
+// GetConversationPeer returns the other side of a conversation of the user. A conversation the
+// user is not part of is reported as not found.
+func (s *Storage) GetConversationPeer(ctx context.Context, conversationId, userId uuid.UUID) (*models.ConversationMember, error) {
+	const op = "storage.Postgres.GetConversationPeer"
+
+	sql, args, err := squirrel.Select("peer.conversation_id", "peer.user_id", "peer.last_read_at", "peer.muted").
+		From("conversation_members self").
+		Join("conversation_members peer ON peer.conversation_id = self.conversation_id AND peer.user_id <> self.user_id").
+		Where(squirrel.Eq{"self.conversation_id": conversationId, "self.user_id": userId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	peer, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.ConversationMember])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrConversationNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return peer, nil
+}
+
+// CreateMessage stores a message and moves the conversation to the top of both inboxes. The
+// sender has read everything up to their own message.
+func (s *Storage) CreateMessage(ctx context.Context, conversationId, senderId uuid.UUID, body string) (*models.Message, error) {
+	const op = "storage.Postgres.CreateMessage"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, err := squirrel.Insert("messages").
+		Columns("conversation_id", "sender_id", "body").
+		Values(conversationId, senderId, body).
+		Suffix("RETURNING " + joinColumns(messageColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	message, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Message])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sql, args, err = squirrel.Update("conversation_members").
+		Set("last_message_at", message.CreatedAt).
+		Set("last_read_at", squirrel.Expr("CASE WHEN user_id = ? THEN ?::timestamp ELSE last_read_at END", senderId, message.CreatedAt)).
+		Where(squirrel.Eq{"conversation_id": conversationId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return message, nil
+}
+
+func (s *Storage) GetMessage(ctx context.Context, messageId uuid.UUID) (*models.Message, error) {
+	const op = "storage.Postgres.GetMessage"
+
+	sql, args, err := squirrel.Select(messageColumns...).
+		From("messages").
+		Where(squirrel.Eq{"id": messageId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	message, err := s.collectMessage(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return message, nil
+}
+
+// UpdateMessage replaces the body of a message of the sender that is not deleted.
+func (s *Storage) UpdateMessage(ctx context.Context, messageId, senderId uuid.UUID, body string) (*models.Message, error) {
+	const op = "storage.Postgres.UpdateMessage"
+
+	sql, args, err := squirrel.Update("messages").
+		Set("body", body).
+		Set("edited_at", time.Now()).
+		Where(squirrel.Eq{"id": messageId, "sender_id": senderId, "deleted_at": nil}).
+		Suffix("RETURNING " + joinColumns(messageColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	message, err := s.collectMessage(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return message, nil
+}
+
+// DeleteMessage clears the body of a message of the sender and marks it deleted.
+func (s *Storage) DeleteMessage(ctx context.Context, messageId, senderId uuid.UUID) (*models.Message, error) {
+	const op = "storage.Postgres.DeleteMessage"
+
+	sql, args, err := squirrel.Update("messages").
+		Set("body", "").
+		Set("deleted_at", time.Now()).
+		Where(squirrel.Eq{"id": messageId, "sender_id": senderId, "deleted_at": nil}).
+		Suffix("RETURNING " + joinColumns(messageColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	message, err := s.collectMessage(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return message, nil
+}
+
+// ListMessages returns a page of the history of a conversation, newest first.
+func (s *Storage) ListMessages(ctx context.Context, conversationId uuid.UUID, after *cursor.Cursor, limit int) ([]models.Message, error) {
+	const op = "storage.Postgres.ListMessages"
+
+	query := squirrel.Select(messageColumns...).
+		From("messages").
+		Where(squirrel.Eq{"conversation_id": conversationId})
+
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("created_at DESC", "id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	messages, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Message])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return messages, nil
+}
+
+// ListConversations returns a page of the conversations of the user, most recently active first.
+func (s *Storage) ListConversations(ctx context.Context, userId uuid.UUID, after *cursor.Cursor, limit int) ([]models.ConversationEntry, error) {
+	const op = "storage.Postgres.ListConversations"
+
+	query := squirrel.Select(
+		"self.conversation_id",
+		"u.id AS user_id",
+		"u.username",
+		"p.display_name",
+		"p.avatar_url",
+		"self.last_message_at",
+		"self.muted",
+		`(SELECT COUNT(*) FROM messages m
+			WHERE m.conversation_id = self.conversation_id
+			AND m.sender_id <> self.user_id
+			AND m.deleted_at IS NULL
+			AND m.created_at > COALESCE(self.last_read_at, '-infinity')) AS unread_count`,
+		"peer.last_read_at AS peer_last_read_at",
+		"last.id AS last_message_id",
+		"last.sender_id AS last_message_sender_id",
+		"last.body AS last_message_body",
+		"last.created_at AS last_message_sent_at",
+	).
+		From("conversation_members self").
+		Join("conversation_members peer ON peer.conversation_id = self.conversation_id AND peer.user_id <> self.user_id").
+		Join("users u ON u.id = peer.user_id").
+		LeftJoin("user_profiles p ON p.user_id = u.id").
+		JoinClause(`LEFT JOIN LATERAL (
+			SELECT id, sender_id, body, created_at FROM messages
+			WHERE conversation_id = self.conversation_id AND deleted_at IS NULL
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1
+		) last ON TRUE`).
+		Where(squirrel.Eq{"self.user_id": userId, "u.deleted_at": nil})
+
+	if after != nil {
+		query = query.Where("(self.last_message_at, self.conversation_id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("self.last_message_at DESC", "self.conversation_id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.ConversationEntry])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
+
+// MarkConversationRead records that the user has read the conversation up to now.
+func (s *Storage) MarkConversationRead(ctx context.Context, conversationId, userId uuid.UUID) (time.Time, error) {
+	const op = "storage.Postgres.MarkConversationRead"
+
+	sql, args, err := squirrel.Update("conversation_members").
+		Set("last_read_at", time.Now()).
+		Where(squirrel.Eq{"conversation_id": conversationId, "user_id": userId}).
+		Suffix("RETURNING last_read_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var readAt time.Time
+	if err = s.db.QueryRow(ctx, sql, args...).Scan(&readAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, fmt.Errorf("%s: %w", op, repository.ErrConversationNotFound)
+		}
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return readAt, nil
+}
+
+func (s *Storage) SetConversationMuted(ctx context.Context, conversationId, userId uuid.UUID, muted bool) error {
+	const op = "storage.Postgres.SetConversationMuted"
+
+	sql, args, err := squirrel.Update("conversation_members").
+		Set("muted", muted).
+		Where(squirrel.Eq{"conversation_id": conversationId, "user_id": userId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, repository.ErrConversationNotFound)
+	}
+
+	return nil
+}
+
+// ExportMessages returns the messages the user sent that are not deleted for the data export.
+func (s *Storage) ExportMessages(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportMessages"
+
+	sql, args, err := squirrel.Select(messageColumns...).
+		From("messages").
+		Where(squirrel.Eq{"sender_id": userId, "deleted_at": nil}).
+		OrderBy("created_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	messages, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Message])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return messages, nil
+}
+
+func (s *Storage) collectMessage(ctx context.Context, sql string, args []interface{}) (*models.Message, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Message])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
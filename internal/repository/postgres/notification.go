@@ -0,0 +1,265 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var notificationColumns = []string{"id", "user_id", "type", "data", "read_at", "created_at"}
+
+func (s *Storage) CreateNotification(ctx context.Context, userId uuid.UUID, notificationType string, data json.RawMessage) (*models.Notification, error) {
+	const op = "storage.Postgres.CreateNotification"
+
+	sql, args, err := squirrel.Insert("notifications").
+		Columns("user_id", "type", "data").
+		Values(userId, notificationType, data).
+		Suffix("RETURNING " + joinColumns(notificationColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	notification, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Notification])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return notification, nil
+}
+
+// ListNotifications returns a page of the user's notifications, newest first.
+func (s *Storage) ListNotifications(ctx context.Context, userId uuid.UUID, unreadOnly bool, after *cursor.Cursor, limit int) ([]models.Notification, error) {
+	const op = "storage.Postgres.ListNotifications"
+
+	query := squirrel.Select(notificationColumns...).
+		From("notifications").
+		Where(squirrel.Eq{"user_id": userId})
+
+	if unreadOnly {
+		query = query.Where(squirrel.Eq{"read_at": nil})
+	}
+
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("created_at DESC", "id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	notifications, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Notification])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return notifications, nil
+}
+
+func (s *Storage) CountUnreadNotifications(ctx context.Context, userId uuid.UUID) (int, error) {
+	const op = "storage.Postgres.CountUnreadNotifications"
+
+	sql, args, err := squirrel.Select("COUNT(*)").
+		From("notifications").
+		Where(squirrel.Eq{"user_id": userId, "read_at": nil}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var count int
+	if err = s.db.QueryRow(ctx, sql, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return count, nil
+}
+
+// MarkNotificationRead marks a notification of the user read. Marking it again is a no-op.
+func (s *Storage) MarkNotificationRead(ctx context.Context, userId, notificationId uuid.UUID) error {
+	const op = "storage.Postgres.MarkNotificationRead"
+
+	sql, args, err := squirrel.Update("notifications").
+		Set("read_at", squirrel.Expr("COALESCE(read_at, ?)", time.Now())).
+		Where(squirrel.Eq{"id": notificationId, "user_id": userId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, repository.ErrNotificationNotFound)
+	}
+
+	return nil
+}
+
+// MarkAllNotificationsRead marks every unread notification of the user read and returns how many there were.
+func (s *Storage) MarkAllNotificationsRead(ctx context.Context, userId uuid.UUID) (int64, error) {
+	const op = "storage.Postgres.MarkAllNotificationsRead"
+
+	sql, args, err := squirrel.Update("notifications").
+		Set("read_at", time.Now()).
+		Where(squirrel.Eq{"user_id": userId, "read_at": nil}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// DeleteNotificationsBefore prunes notifications created before t.
+func (s *Storage) DeleteNotificationsBefore(ctx context.Context, t time.Time) (int64, error) {
+	const op = "storage.Postgres.DeleteNotificationsBefore"
+
+	sql, args, err := squirrel.Delete("notifications").
+		Where(squirrel.Lt{"created_at": t}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// GetNotificationPreferences returns the types the user set a preference for. Missing types are enabled.
+func (s *Storage) GetNotificationPreferences(ctx context.Context, userId uuid.UUID) (map[string]bool, error) {
+	const op = "storage.Postgres.GetNotificationPreferences"
+
+	sql, args, err := squirrel.Select("type", "enabled").
+		From("notification_preferences").
+		Where(squirrel.Eq{"user_id": userId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	preferences := make(map[string]bool)
+	for rows.Next() {
+		var notificationType string
+		var enabled bool
+		if err = rows.Scan(&notificationType, &enabled); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		preferences[notificationType] = enabled
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return preferences, nil
+}
+
+func (s *Storage) SaveNotificationPreferences(ctx context.Context, userId uuid.UUID, preferences map[string]bool) error {
+	const op = "storage.Postgres.SaveNotificationPreferences"
+
+	if len(preferences) == 0 {
+		return nil
+	}
+
+	query := squirrel.Insert("notification_preferences").
+		Columns("user_id", "type", "enabled")
+	for notificationType, enabled := range preferences {
+		query = query.Values(userId, notificationType, enabled)
+	}
+
+	sql, args, err := query.
+		Suffix("ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = s.db.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ExportNotifications returns all notifications and notification preferences of the user for the data export.
+func (s *Storage) ExportNotifications(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportNotifications"
+
+	sql, args, err := squirrel.Select(notificationColumns...).
+		From("notifications").
+		Where(squirrel.Eq{"user_id": userId}).
+		OrderBy("created_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	notifications, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Notification])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	preferences, err := s.GetNotificationPreferences(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return map[string]any{
+		"notifications": notifications,
+		"preferences":   preferences,
+	}, nil
+}
@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var outboxColumns = []string{"id", "type", "payload", "created_at", "attempts"}
+
+// insertOutboxEvent writes a domain event in the transaction of the change it describes, so
+// the event exists if and only if the change is committed.
+func insertOutboxEvent(ctx context.Context, tx pgx.Tx, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	sql, args, err := squirrel.Insert("outbox_events").
+		Columns("type", "payload").
+		Values(eventType, payload).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, sql, args...)
+
+	return err
+}
+
+// ClaimOutboxEvents leases up to limit events that are due, oldest first, and counts the attempt.
+// A leased event is not claimed again before lease is over, so events of a relay that died are
+// picked up by the next one.
+func (s *Storage) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error) {
+	const op = "storage.Postgres.ClaimOutboxEvents"
+
+	due := squirrel.Select("id").
+		From("outbox_events").
+		Where(squirrel.LtOrEq{"next_attempt_at": time.Now()}).
+		OrderBy("created_at").
+		Limit(uint64(limit)).
+		Suffix("FOR UPDATE SKIP LOCKED")
+
+	sql, args, err := squirrel.Update("outbox_events").
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		Set("next_attempt_at", time.Now().Add(lease)).
+		Where(squirrel.Expr("id IN (?)", due)).
+		Suffix("RETURNING " + joinColumns(outboxColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	events, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.OutboxEvent])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// DeleteOutboxEvent removes a published event.
+func (s *Storage) DeleteOutboxEvent(ctx context.Context, eventId uuid.UUID) error {
+	const op = "storage.Postgres.DeleteOutboxEvent"
+
+	sql, args, err := squirrel.Delete("outbox_events").
+		Where(squirrel.Eq{"id": eventId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = s.db.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RetryOutboxEvent schedules another attempt to publish an event that failed.
+func (s *Storage) RetryOutboxEvent(ctx context.Context, eventId uuid.UUID, nextAttemptAt time.Time, lastError string) error {
+	const op = "storage.Postgres.RetryOutboxEvent"
+
+	sql, args, err := squirrel.Update("outbox_events").
+		Set("next_attempt_at", nextAttemptAt).
+		Set("last_error", lastError).
+		Where(squirrel.Eq{"id": eventId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = s.db.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeadLetterOutboxEvent moves an event that ran out of attempts to the dead letter table.
+func (s *Storage) DeadLetterOutboxEvent(ctx context.Context, eventId uuid.UUID, lastError string) error {
+	const op = "storage.Postgres.DeadLetterOutboxEvent"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, err := squirrel.Insert("outbox_dead_letters").
+		Columns("id", "type", "payload", "created_at", "attempts", "last_error").
+		Select(squirrel.Select("id", "type", "payload", "created_at", "attempts").
+			Column("?::text", lastError).
+			From("outbox_events").
+			Where(squirrel.Eq{"id": eventId})).
+		Suffix("ON CONFLICT (id) DO NOTHING").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sql, args, err = squirrel.Delete("outbox_events").
+		Where(squirrel.Eq{"id": eventId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,566 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var paymentColumns = []string{
+	"id", "user_id", "provider", "invoice_id", "checkout_url", "amount", "discount", "charge", "currency", "status",
+	"idempotency_key", "redemption_id", "transaction_id", "reversal_transaction_id", "created_at", "updated_at",
+	"paid_at", "reversed_at",
+}
+
+// CreatePayment records a pending top-up of the balance of a user. The active discount of the
+// user applies to it, unless another payment holds it or it would leave nothing to charge. It
+// reports false when the user already made a payment with the idempotency key, which is
+// returned instead.
+func (s *Storage) CreatePayment(ctx context.Context, payment *models.Payment) (*models.Payment, bool, error) {
+	const op = "storage.Postgres.CreatePayment"
+
+	var (
+		created *models.Payment
+		isNew   bool
+	)
+
+	err := s.inSerializableTx(ctx, func(tx pgx.Tx) error {
+		existing, err := getPayment(ctx, tx, squirrel.Eq{"user_id": payment.UserID, "idempotency_key": payment.IdempotencyKey})
+		if err == nil {
+			created, isNew = existing, false
+			return nil
+		}
+		if !errors.Is(err, repository.ErrPaymentNotFound) {
+			return err
+		}
+
+		// the user row serializes the payments of one user, so a discount goes to one of them
+		var userId uuid.UUID
+		err = tx.QueryRow(ctx, "SELECT id FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", *payment.UserID).
+			Scan(&userId)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return repository.ErrUserNotFound
+			}
+			return err
+		}
+
+		var (
+			discount     int64
+			redemptionId *uuid.UUID
+		)
+
+		active, err := activeDiscount(ctx, tx, userId, time.Now())
+		if err != nil && !errors.Is(err, repository.ErrDiscountNotFound) {
+			return err
+		}
+
+		if active != nil {
+			var held bool
+			err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM payments WHERE redemption_id = $1 AND status <> $2)",
+				active.RedemptionID, models.PaymentFailed).Scan(&held)
+			if err != nil {
+				return err
+			}
+
+			if off := active.Apply(payment.Amount, payment.Currency); !held && off > 0 && off < payment.Amount {
+				discount, redemptionId = off, &active.RedemptionID
+			}
+		}
+
+		sql, args, err := squirrel.Insert("payments").
+			Columns("user_id", "provider", "amount", "discount", "charge", "currency", "idempotency_key", "redemption_id").
+			Values(userId, payment.Provider, payment.Amount, discount, payment.Amount-discount, payment.Currency,
+				payment.IdempotencyKey, redemptionId).
+			Suffix("RETURNING " + joinColumns(paymentColumns)).
+			PlaceholderFormat(squirrel.Dollar).
+			ToSql()
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+
+		created, err = pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Payment])
+		isNew = true
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return created, isNew, nil
+}
+
+// SetPaymentInvoice attaches the invoice the provider created for a payment.
+func (s *Storage) SetPaymentInvoice(ctx context.Context, paymentId uuid.UUID, invoiceId, checkoutURL string) (*models.Payment, error) {
+	const op = "storage.Postgres.SetPaymentInvoice"
+
+	sql, args, err := squirrel.Update("payments").
+		Set("invoice_id", invoiceId).
+		Set("checkout_url", checkoutURL).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": paymentId}).
+		Suffix("RETURNING " + joinColumns(paymentColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	payment, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Payment])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrPaymentNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return payment, nil
+}
+
+func (s *Storage) GetPayment(ctx context.Context, paymentId uuid.UUID) (*models.Payment, error) {
+	const op = "storage.Postgres.GetPayment"
+
+	payment, err := getPayment(ctx, s.db, squirrel.Eq{"id": paymentId})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return payment, nil
+}
+
+// GetPaymentByInvoice returns the payment the provider created the invoice for.
+func (s *Storage) GetPaymentByInvoice(ctx context.Context, provider, invoiceId string) (*models.Payment, error) {
+	const op = "storage.Postgres.GetPaymentByInvoice"
+
+	payment, err := getPayment(ctx, s.db, squirrel.Eq{"provider": provider, "invoice_id": invoiceId})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return payment, nil
+}
+
+// ListPayments returns a page of the payments of the user, newest first.
+func (s *Storage) ListPayments(ctx context.Context, userId uuid.UUID, after *cursor.Cursor, limit int) ([]models.Payment, error) {
+	const op = "storage.Postgres.ListPayments"
+
+	query := squirrel.Select(paymentColumns...).
+		From("payments").
+		Where(squirrel.Eq{"user_id": userId})
+
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("created_at DESC", "id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	payments, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Payment])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return payments, nil
+}
+
+// ListPendingPayments returns up to limit payments still pending since before the given time,
+// oldest first.
+func (s *Storage) ListPendingPayments(ctx context.Context, before time.Time, limit int) ([]models.Payment, error) {
+	const op = "storage.Postgres.ListPendingPayments"
+
+	sql, args, err := squirrel.Select(paymentColumns...).
+		From("payments").
+		Where(squirrel.Eq{"status": models.PaymentPending}).
+		Where(squirrel.Lt{"created_at": before}).
+		OrderBy("created_at").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	payments, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Payment])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return payments, nil
+}
+
+// ApplyPaymentEvent moves a payment to the status of the event, posting to the ledger what
+// the move takes: a succeeded payment credits its amount to the user, and a refund or
+// chargeback takes it back. An event is applied only once and is recorded only once applied.
+// Moves that make no sense, such as failing a payment that succeeded, are ignored, while a
+// refund or chargeback of a payment that has not succeeded yet fails with
+// ErrPaymentEventEarly, so that it is delivered again. It reports whether the event changed
+// the payment.
+func (s *Storage) ApplyPaymentEvent(ctx context.Context, paymentId uuid.UUID, event models.PaymentEvent) (*models.Payment, bool, error) {
+	const op = "storage.Postgres.ApplyPaymentEvent"
+
+	var (
+		applied *models.Payment
+		changed bool
+	)
+
+	err := s.inSerializableTx(ctx, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx, "SELECT status FROM payments WHERE id = $1 FOR UPDATE", paymentId).Scan(&status)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return repository.ErrPaymentNotFound
+			}
+			return err
+		}
+
+		payment, err := getPayment(ctx, tx, squirrel.Eq{"id": paymentId})
+		if err != nil {
+			return err
+		}
+
+		applied, changed = payment, false
+
+		var seen bool
+		err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM payment_events WHERE provider = $1 AND event_id = $2)",
+			event.Provider, event.EventID).Scan(&seen)
+		if err != nil || seen {
+			return err
+		}
+
+		facts, err := loadPaymentFacts(ctx, tx, payment, event)
+		if err != nil {
+			return err
+		}
+
+		move, err := movePayment(payment, event, facts)
+		if err != nil || move == nil {
+			return err
+		}
+
+		if err = applyPaymentMove(ctx, tx, payment, move); err != nil {
+			return err
+		}
+
+		var payload any
+		if len(event.Payload) > 0 {
+			payload = event.Payload
+		}
+
+		_, err = tx.Exec(ctx, "INSERT INTO payment_events (provider, event_id, payment_id, status, payload) VALUES ($1, $2, $3, $4, $5)",
+			event.Provider, event.EventID, paymentId, event.Status, payload,
+		)
+		if err != nil {
+			return err
+		}
+
+		applied, err = getPayment(ctx, tx, squirrel.Eq{"id": paymentId})
+		changed = true
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return applied, changed, nil
+}
+
+// ExportPayments returns the payments of the user.
+func (s *Storage) ExportPayments(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportPayments"
+
+	sql, args, err := squirrel.Select(paymentColumns...).
+		From("payments").
+		Where(squirrel.Eq{"user_id": userId}).
+		OrderBy("created_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	payments, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Payment])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return payments, nil
+}
+
+var reversalDescriptions = map[string]string{
+	models.PaymentRefunded:    "Top-up refunded",
+	models.PaymentChargedBack: "Top-up charged back",
+}
+
+// paymentFacts is what deciding on an event takes to know besides the payment: whether the
+// discount the payment was charged with is still there for it to use, and the balance of its
+// user.
+type paymentFacts struct {
+	DiscountFree bool
+	Balance      int64
+}
+
+// paymentMove is what applying an event does to a payment: the status it moves to and the
+// ledger transaction it posts. A payment that succeeds credits Amount, which leaves out the
+// discount when the payment lost its redemption.
+type paymentMove struct {
+	Status       string
+	Amount       int64
+	Discount     int64
+	RedemptionID *uuid.UUID
+	Transaction  *models.LedgerTransaction
+	Postings     []models.LedgerPosting
+}
+
+// loadPaymentFacts reads the facts movePayment takes to decide on the event. A payment that
+// failed released its discount, which another payment may have taken since.
+func loadPaymentFacts(ctx context.Context, tx pgx.Tx, payment *models.Payment, event models.PaymentEvent) (paymentFacts, error) {
+	var facts paymentFacts
+
+	switch event.Status {
+	case models.PaymentSucceeded:
+		if payment.RedemptionID == nil {
+			return facts, nil
+		}
+
+		err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM promo_redemptions WHERE id = $1 AND used_at IS NULL)
+			AND NOT EXISTS (SELECT 1 FROM payments WHERE redemption_id = $1 AND id <> $2 AND status <> $3)`,
+			*payment.RedemptionID, payment.ID, models.PaymentFailed,
+		).Scan(&facts.DiscountFree)
+		if err != nil {
+			return facts, err
+		}
+	case models.PaymentRefunded, models.PaymentChargedBack:
+		if payment.UserID == nil {
+			return facts, nil
+		}
+
+		err := tx.QueryRow(ctx, "SELECT balance FROM ledger_accounts WHERE user_id = $1 AND currency = $2 FOR UPDATE",
+			*payment.UserID, payment.Currency).Scan(&facts.Balance)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return facts, err
+		}
+	}
+
+	return facts, nil
+}
+
+// movePayment decides what applying an event to a payment does. It returns no move for an
+// event that changes nothing, such as a success of a payment that already succeeded, and
+// ErrPaymentEventEarly for a refund or chargeback of a payment that has not succeeded yet. A
+// failed payment may still succeed, as providers report some payments late.
+func movePayment(payment *models.Payment, event models.PaymentEvent, facts paymentFacts) (*paymentMove, error) {
+	switch event.Status {
+	case models.PaymentSucceeded:
+		if payment.Status != models.PaymentPending && payment.Status != models.PaymentFailed {
+			return nil, nil
+		}
+
+		if event.Amount != payment.Charge || event.Currency != payment.Currency {
+			return nil, repository.ErrPaymentMismatch
+		}
+
+		// the funds were collected for a user who has deleted their account since
+		if payment.UserID == nil {
+			return nil, repository.ErrUserNotFound
+		}
+
+		credited := *payment
+		if credited.RedemptionID != nil && !facts.DiscountFree {
+			credited.Amount, credited.Discount, credited.RedemptionID = credited.Charge, 0, nil
+		}
+
+		return &paymentMove{
+			Status:       models.PaymentSucceeded,
+			Amount:       credited.Amount,
+			Discount:     credited.Discount,
+			RedemptionID: credited.RedemptionID,
+			Transaction: &models.LedgerTransaction{
+				IdempotencyKey: "payment:" + payment.ID.String(),
+				Kind:           models.LedgerKindTopUp,
+				Description:    "Top-up",
+			},
+			Postings: topUpPostings(&credited),
+		}, nil
+	case models.PaymentFailed:
+		if payment.Status != models.PaymentPending {
+			return nil, nil
+		}
+
+		return &paymentMove{Status: models.PaymentFailed}, nil
+	case models.PaymentRefunded, models.PaymentChargedBack:
+		if payment.Status == models.PaymentRefunded || payment.Status == models.PaymentChargedBack {
+			return nil, nil
+		}
+
+		if event.Amount != payment.Charge || event.Currency != payment.Currency {
+			return nil, repository.ErrPaymentMismatch
+		}
+
+		if payment.Status != models.PaymentSucceeded {
+			return nil, repository.ErrPaymentEventEarly
+		}
+
+		return &paymentMove{
+			Status: event.Status,
+			Transaction: &models.LedgerTransaction{
+				IdempotencyKey: "payment-reversal:" + payment.ID.String(),
+				Kind:           models.LedgerKindTopUpReversal,
+				Description:    reversalDescriptions[event.Status],
+			},
+			Postings: reversalPostings(payment, facts.Balance),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// applyPaymentMove saves a move of a payment. A payment that succeeds uses up the discount it
+// kept.
+func applyPaymentMove(ctx context.Context, tx pgx.Tx, payment *models.Payment, move *paymentMove) error {
+	now := time.Now()
+
+	query := squirrel.Update("payments").
+		Set("status", move.Status).
+		Set("updated_at", now).
+		Where(squirrel.Eq{"id": payment.ID})
+
+	if move.Transaction != nil {
+		posted, _, err := postLedger(ctx, tx, move.Transaction, move.Postings)
+		if err != nil {
+			return err
+		}
+
+		if move.Status == models.PaymentSucceeded {
+			query = query.
+				Set("amount", move.Amount).
+				Set("discount", move.Discount).
+				Set("redemption_id", move.RedemptionID).
+				Set("transaction_id", posted.ID).
+				Set("paid_at", now)
+		} else {
+			query = query.
+				Set("reversal_transaction_id", posted.ID).
+				Set("reversed_at", now)
+		}
+	}
+
+	if move.Status == models.PaymentSucceeded && move.RedemptionID != nil {
+		if _, err := tx.Exec(ctx, "UPDATE promo_redemptions SET used_at = $1 WHERE id = $2", now, *move.RedemptionID); err != nil {
+			return err
+		}
+	}
+
+	sql, args, err := query.PlaceholderFormat(squirrel.Dollar).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// topUpPostings credits the amount of a payment to its user, taking the charge from the
+// payments account and the discount from the promotions account.
+func topUpPostings(payment *models.Payment) []models.LedgerPosting {
+	postings := []models.LedgerPosting{
+		{System: models.LedgerSystemPayments, Currency: payment.Currency, Amount: -payment.Charge},
+		{UserID: payment.UserID, Currency: payment.Currency, Amount: payment.Amount},
+	}
+	if payment.Discount > 0 {
+		postings = append(postings, models.LedgerPosting{
+			System: models.LedgerSystemPromotions, Currency: payment.Currency, Amount: -payment.Discount,
+		})
+	}
+
+	return postings
+}
+
+// reversalPostings undoes the top-up of a payment whose user holds balance. The charge goes
+// back to the payer and the discount to the promotions account whether or not the user still
+// has the funds; what they already spent is written off as a loss.
+func reversalPostings(payment *models.Payment, balance int64) []models.LedgerPosting {
+	taken := max(min(balance, payment.Amount), 0)
+
+	postings := []models.LedgerPosting{
+		{System: models.LedgerSystemPayments, Currency: payment.Currency, Amount: payment.Charge},
+	}
+	if taken > 0 {
+		postings = append(postings, models.LedgerPosting{UserID: payment.UserID, Currency: payment.Currency, Amount: -taken})
+	}
+	if payment.Discount > 0 {
+		postings = append(postings, models.LedgerPosting{
+			System: models.LedgerSystemPromotions, Currency: payment.Currency, Amount: payment.Discount,
+		})
+	}
+	if lost := payment.Amount - taken; lost > 0 {
+		postings = append(postings, models.LedgerPosting{
+			System: models.LedgerSystemPaymentLosses, Currency: payment.Currency, Amount: -lost,
+		})
+	}
+
+	return postings
+}
+
+func getPayment(ctx context.Context, q querier, where squirrel.Eq) (*models.Payment, error) {
+	sql, args, err := squirrel.Select(paymentColumns...).
+		From("payments").
+		Where(where).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Payment])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrPaymentNotFound
+		}
+		return nil, err
+	}
+
+	return payment, nil
+}
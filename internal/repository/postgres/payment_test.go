@@ -0,0 +1,296 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestMovePayment(t *testing.T) {
+	userId, redemptionId := uuid.New(), uuid.New()
+
+	payment := func(status string) *models.Payment {
+		return &models.Payment{
+			ID: uuid.New(), UserID: &userId, Amount: 1000, Discount: 250, Charge: 750, Currency: "USD",
+			Status: status, RedemptionID: &redemptionId,
+		}
+	}
+	event := func(status string) models.PaymentEvent {
+		return models.PaymentEvent{Provider: "fake", EventID: "evt_1", Status: status, Amount: 750, Currency: "USD"}
+	}
+	free := paymentFacts{DiscountFree: true, Balance: 1000}
+
+	tests := []struct {
+		name     string
+		payment  *models.Payment
+		event    models.PaymentEvent
+		facts    paymentFacts
+		want     string
+		credited int64
+		wantErr  error
+	}{
+		{name: "pending succeeds", payment: payment(models.PaymentPending), event: event(models.PaymentSucceeded), facts: free, want: models.PaymentSucceeded, credited: 1000},
+		{name: "pending fails", payment: payment(models.PaymentPending), event: event(models.PaymentFailed), facts: free, want: models.PaymentFailed},
+		{name: "failed succeeds late", payment: payment(models.PaymentFailed), event: event(models.PaymentSucceeded), facts: free, want: models.PaymentSucceeded, credited: 1000},
+		{name: "failed succeeds late without its discount", payment: payment(models.PaymentFailed), event: event(models.PaymentSucceeded), want: models.PaymentSucceeded, credited: 750},
+		{name: "succeeded is refunded", payment: payment(models.PaymentSucceeded), event: event(models.PaymentRefunded), facts: free, want: models.PaymentRefunded},
+		{name: "succeeded is charged back", payment: payment(models.PaymentSucceeded), event: event(models.PaymentChargedBack), facts: free, want: models.PaymentChargedBack},
+		{name: "succeeded again", payment: payment(models.PaymentSucceeded), event: event(models.PaymentSucceeded), facts: free},
+		{name: "succeeded fails", payment: payment(models.PaymentSucceeded), event: event(models.PaymentFailed), facts: free},
+		{name: "failed again", payment: payment(models.PaymentFailed), event: event(models.PaymentFailed), facts: free},
+		{name: "refunded succeeds", payment: payment(models.PaymentRefunded), event: event(models.PaymentSucceeded), facts: free},
+		{name: "refunded is charged back", payment: payment(models.PaymentRefunded), event: event(models.PaymentChargedBack), facts: free},
+		{name: "charged back is refunded", payment: payment(models.PaymentChargedBack), event: event(models.PaymentRefunded), facts: free},
+		{name: "pending is refunded", payment: payment(models.PaymentPending), event: event(models.PaymentRefunded), facts: free, wantErr: repository.ErrPaymentEventEarly},
+		{name: "pending is charged back", payment: payment(models.PaymentPending), event: event(models.PaymentChargedBack), facts: free, wantErr: repository.ErrPaymentEventEarly},
+		{name: "failed is refunded", payment: payment(models.PaymentFailed), event: event(models.PaymentRefunded), facts: free, wantErr: repository.ErrPaymentEventEarly},
+		{
+			name:    "success of another amount",
+			payment: payment(models.PaymentPending),
+			event:   models.PaymentEvent{Status: models.PaymentSucceeded, Amount: 1000, Currency: "USD"},
+			wantErr: repository.ErrPaymentMismatch,
+		},
+		{
+			name:    "refund of another currency",
+			payment: payment(models.PaymentSucceeded),
+			event:   models.PaymentEvent{Status: models.PaymentRefunded, Amount: 750, Currency: "EUR"},
+			wantErr: repository.ErrPaymentMismatch,
+		},
+		{
+			name:    "refund of another amount",
+			payment: payment(models.PaymentSucceeded),
+			event:   models.PaymentEvent{Status: models.PaymentRefunded, Amount: 100, Currency: "USD"},
+			wantErr: repository.ErrPaymentMismatch,
+		},
+		{
+			name:    "success for a deleted user",
+			payment: &models.Payment{Amount: 750, Charge: 750, Currency: "USD", Status: models.PaymentPending},
+			event:   event(models.PaymentSucceeded),
+			wantErr: repository.ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			move, err := movePayment(tt.payment, tt.event, tt.facts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("movePayment() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.want == "" {
+				if move != nil {
+					t.Fatalf("movePayment() = %+v, want no move", move)
+				}
+				return
+			}
+
+			if move.Status != tt.want {
+				t.Fatalf("status = %s, want %s", move.Status, tt.want)
+			}
+
+			accounts := sumPostings(t, move.Postings)
+			if got := accounts["user"]; tt.credited > 0 && got != tt.credited {
+				t.Errorf("credited = %d, want %d", got, tt.credited)
+			}
+			if move.Status == models.PaymentSucceeded && move.Amount != tt.credited {
+				t.Errorf("amount = %d, want %d", move.Amount, tt.credited)
+			}
+			if move.Status == models.PaymentSucceeded && (move.RedemptionID != nil) != (tt.credited == 1000) {
+				t.Errorf("redemption kept = %v, want %v", move.RedemptionID != nil, tt.credited == 1000)
+			}
+		})
+	}
+}
+
+func TestReversalPostings(t *testing.T) {
+	userId := uuid.New()
+
+	tests := []struct {
+		name     string
+		discount int64
+		balance  int64
+		taken    int64
+		lost     int64
+	}{
+		{name: "balance covers the amount", balance: 5000, taken: 1000},
+		{name: "balance covers part of the amount", balance: 400, taken: 400, lost: 600},
+		{name: "nothing left", lost: 1000},
+		{name: "negative balance", balance: -200, lost: 1000},
+		{name: "discounted", discount: 250, balance: 1000, taken: 1000},
+		{name: "discounted, partly spent", discount: 250, balance: 100, taken: 100, lost: 900},
+	}
+
+	for _, tt := range tests {
+		for _, status := range []string{models.PaymentRefunded, models.PaymentChargedBack} {
+			t.Run(tt.name+"/"+status, func(t *testing.T) {
+				payment := &models.Payment{
+					UserID: &userId, Amount: 1000, Discount: tt.discount, Charge: 1000 - tt.discount, Currency: "USD",
+				}
+
+				accounts := sumPostings(t, reversalPostings(payment, tt.balance))
+
+				if got := -accounts["user"]; got != tt.taken {
+					t.Errorf("taken from user = %d, want %d", got, tt.taken)
+				}
+				if got := -accounts[models.LedgerSystemPaymentLosses]; got != tt.lost {
+					t.Errorf("lost = %d, want %d", got, tt.lost)
+				}
+				if got := accounts[models.LedgerSystemPayments]; got != payment.Charge {
+					t.Errorf("returned to payer = %d, want %d", got, payment.Charge)
+				}
+				if got := accounts[models.LedgerSystemPromotions]; got != tt.discount {
+					t.Errorf("returned to promotions = %d, want %d", got, tt.discount)
+				}
+			})
+		}
+	}
+}
+
+func TestMovePaymentEvents(t *testing.T) {
+	event := func(id, status string) models.PaymentEvent {
+		return models.PaymentEvent{Provider: "fake", EventID: id, Status: status, Amount: 1000, Currency: "USD"}
+	}
+
+	tests := []struct {
+		name    string
+		events  []models.PaymentEvent
+		status  string
+		balance int64
+		applied int
+	}{
+		{
+			name: "redelivered success credits once",
+			events: []models.PaymentEvent{
+				event("evt_1", models.PaymentSucceeded),
+				event("evt_1", models.PaymentSucceeded),
+			},
+			status:  models.PaymentSucceeded,
+			balance: 1000,
+			applied: 1,
+		},
+		{
+			name: "success from the webhook and the reconciler credits once",
+			events: []models.PaymentEvent{
+				event("evt_1", models.PaymentSucceeded),
+				event("reconcile:inv_1:paid", models.PaymentSucceeded),
+			},
+			status:  models.PaymentSucceeded,
+			balance: 1000,
+			applied: 1,
+		},
+		{
+			name: "late success after failure",
+			events: []models.PaymentEvent{
+				event("evt_1", models.PaymentFailed),
+				event("evt_2", models.PaymentSucceeded),
+			},
+			status:  models.PaymentSucceeded,
+			balance: 1000,
+			applied: 2,
+		},
+		{
+			name: "refund before success waits for the success",
+			events: []models.PaymentEvent{
+				event("evt_2", models.PaymentRefunded),
+				event("evt_1", models.PaymentSucceeded),
+				event("evt_2", models.PaymentRefunded),
+				event("evt_2", models.PaymentRefunded),
+			},
+			status:  models.PaymentRefunded,
+			applied: 2,
+		},
+		{
+			name: "chargeback before a late success waits for the success",
+			events: []models.PaymentEvent{
+				event("evt_1", models.PaymentFailed),
+				event("evt_3", models.PaymentChargedBack),
+				event("evt_2", models.PaymentSucceeded),
+				event("evt_3", models.PaymentChargedBack),
+			},
+			status:  models.PaymentChargedBack,
+			applied: 3,
+		},
+		{
+			name: "success after a chargeback is ignored",
+			events: []models.PaymentEvent{
+				event("evt_1", models.PaymentSucceeded),
+				event("evt_2", models.PaymentChargedBack),
+				event("evt_3", models.PaymentSucceeded),
+			},
+			status:  models.PaymentChargedBack,
+			applied: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId := uuid.New()
+			payment := &models.Payment{
+				ID: uuid.New(), UserID: &userId, Amount: 1000, Charge: 1000, Currency: "USD", Status: models.PaymentPending,
+			}
+
+			// the events and balances ApplyPaymentEvent keeps in the database
+			recorded := map[string]bool{}
+			accounts := map[string]int64{}
+
+			applied := 0
+			for _, e := range tt.events {
+				if recorded[e.EventID] {
+					continue
+				}
+
+				move, err := movePayment(payment, e, paymentFacts{Balance: accounts["user"]})
+				if errors.Is(err, repository.ErrPaymentEventEarly) {
+					continue
+				}
+				if err != nil {
+					t.Fatalf("movePayment(%s) error = %v", e.EventID, err)
+				}
+				if move == nil {
+					continue
+				}
+
+				for account, amount := range sumPostings(t, move.Postings) {
+					accounts[account] += amount
+				}
+				payment.Status = move.Status
+				recorded[e.EventID] = true
+				applied++
+			}
+
+			if payment.Status != tt.status {
+				t.Errorf("status = %s, want %s", payment.Status, tt.status)
+			}
+			if applied != tt.applied {
+				t.Errorf("applied %d events, want %d", applied, tt.applied)
+			}
+			if got := accounts["user"]; got != tt.balance {
+				t.Errorf("user balance = %d, want %d", got, tt.balance)
+			}
+		})
+	}
+}
+
+// sumPostings returns what postings move per account, failing the test unless they balance.
+func sumPostings(t *testing.T, postings []models.LedgerPosting) map[string]int64 {
+	t.Helper()
+
+	accounts := map[string]int64{}
+	var total int64
+	for _, p := range postings {
+		account := p.System
+		if p.UserID != nil {
+			account = "user"
+		}
+		accounts[account] += p.Amount
+		total += p.Amount
+	}
+
+	if total != 0 {
+		t.Fatalf("postings sum to %d, want 0: %+v", total, postings)
+	}
+
+	return accounts
+}
@@ -10,8 +10,9 @@ import (
 	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/lib/pq"
+	"strings"
 	"time"
 )
 
@@ -30,48 +31,114 @@ func NewPostgres(ctx context.Context, conn string) (*Storage, error) {
 	return &Storage{db: db}, nil
 }
 
-func (s *Storage) SaveUser(ctx context.Context, username, email string, passHash []byte) error {
+// SaveUser creates a user and returns its ID, recording DomainUserRegistered in the same
+// transaction. A non-empty inviteCode is redeemed along with it and the user is not created if
+// the invite cannot be used.
+func (s *Storage) SaveUser(ctx context.Context, username, email string, passHash []byte, inviteCode string) (uuid.UUID, error) {
 	const op = "storage.Postgres.SaveUser"
 
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var inviteId uuid.UUID
+	if inviteCode != "" {
+		if inviteId, err = useInvite(ctx, tx, inviteCode, email); err != nil {
+			return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
 	sql, args, err := squirrel.Insert("users").
 		Columns("username", "email", "password", "created_at").
 		Values(username, email, passHash, time.Now()).
+		Suffix("RETURNING id").
 		PlaceholderFormat(squirrel.Dollar).
 		ToSql()
 	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = s.db.Exec(ctx, sql, args...)
+	var userId uuid.UUID
+	err = tx.QueryRow(ctx, sql, args...).Scan(&userId)
 	if err != nil {
-		var pgErr *pq.Error
-		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
-			return fmt.Errorf("%s: %w", op, repository.ErrUserAlreadyExists)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return uuid.Nil, fmt.Errorf("%s: %w", op, repository.ErrUserAlreadyExists)
 		}
 
-		return fmt.Errorf("%s: %w", op, err)
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return nil
+	if inviteCode != "" {
+		sql, args, err = squirrel.Insert("invite_redemptions").
+			Columns("user_id", "invite_id").
+			Values(userId, inviteId).
+			PlaceholderFormat(squirrel.Dollar).
+			ToSql()
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if _, err = tx.Exec(ctx, sql, args...); err != nil {
+			return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	err = insertOutboxEvent(ctx, tx, models.DomainUserRegistered, models.UserRegisteredData{
+		UserID:   userId,
+		Username: username,
+		Email:    email,
+	})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return userId, nil
 }
 
 // GetUser fetches a user by login or email
 func (s *Storage) LoginUser(ctx context.Context, inputType, input string) (*models.User, error) {
 	const op = "storage.Postgres.GetUser"
 
-	var pgUUID uuid.UUID
 	var user models.User
 
-	sql, args, err := squirrel.Select("id", "username", "email", "password").
+	// a released username can belong to an active and a deleted account at once, prefer the active one
+	sql, args, err := squirrel.Select("id", "username", "email", "password", "deleted_at", "purge_after").
 		From("users").
 		Where(squirrel.Eq{inputType: input}).
+		OrderBy("deleted_at IS NOT NULL").
+		Limit(1).
 		PlaceholderFormat(squirrel.Dollar).
 		ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	err = s.db.QueryRow(ctx, sql, args...).Scan(&pgUUID, &user.Username, &user.Email, &user.Password)
+	err = s.db.QueryRow(ctx, sql, args...).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.DeletedAt, &user.PurgeAfter)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &user, nil
+}
+
+// GetLoginUser returns the user a login was held for, deleted or not.
+func (s *Storage) GetLoginUser(ctx context.Context, userId uuid.UUID) (*models.User, error) {
+	const op = "storage.Postgres.GetLoginUser"
+
+	var user models.User
+
+	err := s.db.QueryRow(ctx, "SELECT id, username, email, deleted_at, purge_after FROM users WHERE id = $1", userId).
+		Scan(&user.ID, &user.Username, &user.Email, &user.DeletedAt, &user.PurgeAfter)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
@@ -106,6 +173,31 @@ func (s *Storage) CheckUsernameIsAvailable(ctx context.Context, input string) (b
 	return false, nil
 }
 
+// CheckActiveUsernameIsAvailable is like CheckUsernameIsAvailable but ignores deleted accounts.
+func (s *Storage) CheckActiveUsernameIsAvailable(ctx context.Context, input string) (bool, error) {
+	const op = "storage.CheckActiveUsernameIsAvailable"
+
+	sql, args, err := squirrel.Select("id").
+		From("users").
+		Where(squirrel.Eq{"username": input, "deleted_at": nil}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var id uuid.UUID
+	err = s.db.QueryRow(ctx, sql, args...).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return true, nil
+		}
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return false, nil
+}
+
 func (s *Storage) CheckEmailIsAvailable(ctx context.Context, email string) (bool, error) {
 	const op = "storage.CheckEmailIsAvailable"
 
@@ -154,11 +246,37 @@ func (s *Storage) CheckUserByEmail(ctx context.Context, userId, email string) er
 	return nil
 }
 
+// UpdateEmail changes the email of a user and records DomainUserEmailChanged in the same transaction.
 func (s *Storage) UpdateEmail(ctx context.Context, userId, email string) error {
 	const op = "storage.Postgres.UpdateEmail"
 
-	sql, args, err := squirrel.Update("users").
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, err := squirrel.Select("email").
+		From("users").
+		Where(squirrel.Eq{"id": userId}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var previousEmail string
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&previousEmail); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sql, args, err = squirrel.Update("users").
 		SetMap(squirrel.Eq{"email": email}).
+		SetMap(squirrel.Eq{"email_verified_at": nil}).
 		SetMap(squirrel.Eq{"updated_at": time.Now()}).
 		Where(squirrel.Eq{"id": userId}).
 		PlaceholderFormat(squirrel.Dollar).
@@ -167,8 +285,15 @@ func (s *Storage) UpdateEmail(ctx context.Context, userId, email string) error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = s.db.Exec(ctx, sql, args...)
-	if err != nil {
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = insertOutboxEvent(ctx, tx, models.DomainUserEmailChanged, models.UserEmailChangedData{UserID: userId, Email: email, PreviousEmail: previousEmail}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -199,9 +324,16 @@ func (s *Storage) CheckUserByPassword(ctx context.Context, userId, password stri
 	return userPassword, nil
 }
 
+// UpdatePassword changes the password of a user and records DomainUserPasswordChanged in the same transaction.
 func (s *Storage) UpdatePassword(ctx context.Context, userId, password string) error {
 	const op = "storage.Postgres.UpdatePassword"
 
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
 	sql, args, err := squirrel.Update("users").
 		SetMap(squirrel.Eq{"password": password}).
 		SetMap(squirrel.Eq{"updated_at": time.Now()}).
@@ -212,8 +344,15 @@ func (s *Storage) UpdatePassword(ctx context.Context, userId, password string) e
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = s.db.Exec(ctx, sql, args...)
-	if err != nil {
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = insertOutboxEvent(ctx, tx, models.DomainUserPasswordChanged, models.UserPasswordChangedData{UserID: userId}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
@@ -225,9 +364,9 @@ func (s *Storage) GetUser(ctx context.Context, userId uuid.UUID) (*dto.User, err
 
 	var user dto.User
 
-	sql, args, err := squirrel.Select("id", "username", "email", "balance", "discount").
+	sql, args, err := squirrel.Select("id", "username", "email").
 		From("users").
-		Where(squirrel.Eq{"id": userId}).
+		Where(squirrel.Eq{"id": userId, "deleted_at": nil}).
 		PlaceholderFormat(squirrel.Dollar).
 		ToSql()
 	if err != nil {
@@ -249,3 +388,65 @@ func (s *Storage) Close() error {
 	s.db.Close()
 	return nil
 }
+
+// Postgres error codes.
+const (
+	uniqueViolation      = "23505"
+	foreignKeyViolation  = "23503"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// maxSerializableAttempts bounds how often inSerializableTx retries a transaction that lost a
+// conflict with a concurrent one.
+const maxSerializableAttempts = 5
+
+// inSerializableTx runs fn in a serializable transaction and commits it, retrying the whole
+// transaction while it fails on a conflict with a concurrent one.
+func (s *Storage) inSerializableTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := s.serializable(ctx, fn)
+		if err == nil || attempt == maxSerializableAttempts || !retryable(err) {
+			return err
+		}
+	}
+}
+
+func (s *Storage) serializable(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// retryable reports whether a transaction failed only because a concurrent one won, including
+// one that made the same idempotent write first.
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	switch pgErr.Code {
+	case serializationFailure, deadlockDetected:
+		return true
+	case uniqueViolation:
+		return pgErr.ConstraintName == "ledger_transactions_idempotency_key_key" ||
+			pgErr.ConstraintName == "idx_transfers_idempotency_key" ||
+			pgErr.ConstraintName == "idx_payments_idempotency_key" ||
+			pgErr.ConstraintName == "payment_events_provider_event_id_key"
+	default:
+		return false
+	}
+}
+
+func joinColumns(columns []string) string {
+	return strings.Join(columns, ", ")
+}
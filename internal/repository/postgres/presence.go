@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// UpdateLastSeen persists last seen times in one statement. A time never moves backwards.
+func (s *Storage) UpdateLastSeen(ctx context.Context, lastSeen map[uuid.UUID]time.Time) error {
+	const op = "storage.Postgres.UpdateLastSeen"
+
+	if len(lastSeen) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(lastSeen))
+	times := make([]time.Time, 0, len(lastSeen))
+	for id, t := range lastSeen {
+		ids = append(ids, id)
+		times = append(times, t)
+	}
+
+	sql := `
+		UPDATE users u
+		SET last_seen_at = v.seen_at
+		FROM unnest($1::uuid[], $2::timestamp[]) AS v(id, seen_at)
+		WHERE u.id = v.id
+		  AND (u.last_seen_at IS NULL OR u.last_seen_at < v.seen_at)`
+
+	if _, err := s.db.Exec(ctx, sql, ids, times); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// VisiblePresence returns the persisted last seen time of each of userIds whose presence viewerId
+// may see: the viewer themself and accepted friends who do not hide their online status.
+func (s *Storage) VisiblePresence(ctx context.Context, viewerId uuid.UUID, userIds []uuid.UUID) (map[uuid.UUID]*time.Time, error) {
+	const op = "storage.Postgres.VisiblePresence"
+
+	sql := `
+		SELECT u.id, u.last_seen_at
+		FROM users u
+		LEFT JOIN user_privacy_settings ps ON ps.user_id = u.id
+		WHERE u.id = ANY($2::uuid[])
+		  AND u.deleted_at IS NULL
+		  AND (
+			u.id = $1
+			OR (
+				NOT COALESCE(ps.hide_online_status, FALSE)
+				AND EXISTS (
+					SELECT 1 FROM friendships f
+					WHERE f.state = 'accepted'
+					  AND LEAST(f.requester_id, f.addressee_id) = LEAST(u.id, $1)
+					  AND GREATEST(f.requester_id, f.addressee_id) = GREATEST(u.id, $1)
+				)
+			)
+		  )`
+
+	rows, err := s.db.Query(ctx, sql, viewerId, userIds)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	visible := make(map[uuid.UUID]*time.Time, len(userIds))
+	for rows.Next() {
+		var id uuid.UUID
+		var lastSeen *time.Time
+		if err = rows.Scan(&id, &lastSeen); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		visible[id] = lastSeen
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return visible, nil
+}
@@ -0,0 +1,273 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// BlockUser blocks a user and dissolves any friendship or pending request between the two
+// in the same transaction.
+func (s *Storage) BlockUser(ctx context.Context, blockerId, blockedId uuid.UUID) error {
+	const op = "storage.Postgres.BlockUser"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, err := squirrel.Insert("user_blocks").
+		Columns("blocker_id", "blocked_id").
+		Values(blockerId, blockedId).
+		Suffix("ON CONFLICT DO NOTHING").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sql, args, err = squirrel.Delete("friendships").
+		Where(squirrel.Or{
+			squirrel.Eq{"requester_id": blockerId, "addressee_id": blockedId},
+			squirrel.Eq{"requester_id": blockedId, "addressee_id": blockerId},
+		}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) UnblockUser(ctx context.Context, blockerId, blockedId uuid.UUID) error {
+	const op = "storage.Postgres.UnblockUser"
+
+	sql, args, err := squirrel.Delete("user_blocks").
+		Where(squirrel.Eq{"blocker_id": blockerId, "blocked_id": blockedId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, repository.ErrBlockNotFound)
+	}
+
+	return nil
+}
+
+// IsBlocked reports whether blockerId blocked blockedId.
+func (s *Storage) IsBlocked(ctx context.Context, blockerId, blockedId uuid.UUID) (bool, error) {
+	const op = "storage.Postgres.IsBlocked"
+
+	blocked, err := s.exists(ctx, squirrel.Select("1").
+		From("user_blocks").
+		Where(squirrel.Eq{"blocker_id": blockerId, "blocked_id": blockedId}))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return blocked, nil
+}
+
+// IsBlockedEither reports whether either of the two users blocked the other.
+func (s *Storage) IsBlockedEither(ctx context.Context, userId, otherId uuid.UUID) (bool, error) {
+	const op = "storage.Postgres.IsBlockedEither"
+
+	blocked, err := s.exists(ctx, squirrel.Select("1").
+		From("user_blocks").
+		Where(squirrel.Or{
+			squirrel.Eq{"blocker_id": userId, "blocked_id": otherId},
+			squirrel.Eq{"blocker_id": otherId, "blocked_id": userId},
+		}))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return blocked, nil
+}
+
+// ListBlockedUsers returns a page of users blocked by blockerId, most recently blocked first.
+func (s *Storage) ListBlockedUsers(ctx context.Context, blockerId uuid.UUID, after *cursor.Cursor, limit int) ([]models.BlockedUser, error) {
+	const op = "storage.Postgres.ListBlockedUsers"
+
+	query := squirrel.Select("u.id AS user_id", "u.username", "p.display_name", "p.avatar_url", "b.created_at AS blocked_at").
+		From("user_blocks b").
+		Join("users u ON u.id = b.blocked_id").
+		LeftJoin("user_profiles p ON p.user_id = u.id").
+		Where(squirrel.Eq{"b.blocker_id": blockerId, "u.deleted_at": nil})
+
+	if after != nil {
+		query = query.Where("(b.created_at, b.blocked_id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("b.created_at DESC", "b.blocked_id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	blocked, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.BlockedUser])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return blocked, nil
+}
+
+// GetPrivacySettings returns the privacy settings of the user, or the defaults if they never changed them.
+func (s *Storage) GetPrivacySettings(ctx context.Context, userId uuid.UUID) (*models.PrivacySettings, error) {
+	const op = "storage.Postgres.GetPrivacySettings"
+
+	sql, args, err := squirrel.Select("user_id", "friend_requests_from", "friend_list_visibility", "hide_online_status").
+		From("user_privacy_settings").
+		Where(squirrel.Eq{"user_id": userId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	settings, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.PrivacySettings])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.DefaultPrivacySettings(userId), nil
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return settings, nil
+}
+
+func (s *Storage) SavePrivacySettings(ctx context.Context, settings *models.PrivacySettings) error {
+	const op = "storage.Postgres.SavePrivacySettings"
+
+	sql, args, err := squirrel.Insert("user_privacy_settings").
+		Columns("user_id", "friend_requests_from", "friend_list_visibility", "hide_online_status").
+		Values(settings.UserID, settings.FriendRequestsFrom, settings.FriendListVisibility, settings.HideOnlineStatus).
+		Suffix(`ON CONFLICT (user_id) DO UPDATE SET
+			friend_requests_from = EXCLUDED.friend_requests_from,
+			friend_list_visibility = EXCLUDED.friend_list_visibility,
+			hide_online_status = EXCLUDED.hide_online_status`).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = s.db.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// HaveMutualFriend reports whether the two users share at least one accepted friend.
+func (s *Storage) HaveMutualFriend(ctx context.Context, userId, otherId uuid.UUID) (bool, error) {
+	const op = "storage.Postgres.HaveMutualFriend"
+
+	sql := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM friendships a
+			JOIN friendships b
+			  ON b.state = 'accepted'
+			 AND (b.requester_id = $2 OR b.addressee_id = $2)
+			 AND (CASE WHEN a.requester_id = $1 THEN a.addressee_id ELSE a.requester_id END)
+			   = (CASE WHEN b.requester_id = $2 THEN b.addressee_id ELSE b.requester_id END)
+			WHERE a.state = 'accepted'
+			  AND (a.requester_id = $1 OR a.addressee_id = $1)
+		)`
+
+	var mutual bool
+	if err := s.db.QueryRow(ctx, sql, userId, otherId).Scan(&mutual); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return mutual, nil
+}
+
+// ExportBlocks returns the users blocked by the user for the data export.
+func (s *Storage) ExportBlocks(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportBlocks"
+
+	sql, args, err := squirrel.Select("blocked_id", "created_at").
+		From("user_blocks").
+		Where(squirrel.Eq{"blocker_id": userId}).
+		OrderBy("created_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	blocks, err := pgx.CollectRows(rows, pgx.RowToMap)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return blocks, nil
+}
+
+// ExportPrivacySettings returns the privacy settings of the user for the data export.
+func (s *Storage) ExportPrivacySettings(ctx context.Context, userId uuid.UUID) (any, error) {
+	return s.GetPrivacySettings(ctx, userId)
+}
+
+func (s *Storage) exists(ctx context.Context, query squirrel.SelectBuilder) (bool, error) {
+	sql, args, err := query.Prefix("SELECT EXISTS (").Suffix(")").PlaceholderFormat(squirrel.Dollar).ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	if err = s.db.QueryRow(ctx, sql, args...).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// profileSelect selects the profile of active users; users who never edited their
+// profile get the column defaults.
+func profileSelect() squirrel.SelectBuilder {
+	return squirrel.Select(
+		"u.id AS user_id", "u.username", "p.display_name", "p.bio", "p.avatar_url", "p.locale", "p.timezone",
+		"p.birthday", "COALESCE(p.birthday_visibility, 'private') AS birthday_visibility",
+	).
+		From("users u").
+		LeftJoin("user_profiles p ON p.user_id = u.id").
+		Where(squirrel.Eq{"u.deleted_at": nil}).
+		PlaceholderFormat(squirrel.Dollar)
+}
+
+func (s *Storage) GetProfile(ctx context.Context, userId uuid.UUID) (*models.Profile, error) {
+	const op = "storage.Postgres.GetProfile"
+
+	profile, err := s.selectProfile(ctx, profileSelect().Where(squirrel.Eq{"u.id": userId}))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return profile, nil
+}
+
+func (s *Storage) GetProfileByUsername(ctx context.Context, username string) (*models.Profile, error) {
+	const op = "storage.Postgres.GetProfileByUsername"
+
+	profile, err := s.selectProfile(ctx, profileSelect().Where(squirrel.Eq{"u.username": username}))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return profile, nil
+}
+
+func (s *Storage) SaveProfile(ctx context.Context, profile *models.Profile) error {
+	const op = "storage.Postgres.SaveProfile"
+
+	sql, args, err := squirrel.Insert("user_profiles").
+		Columns("user_id", "display_name", "bio", "avatar_url", "locale", "timezone", "birthday", "birthday_visibility").
+		Values(profile.UserID, profile.DisplayName, profile.Bio, profile.AvatarURL, profile.Locale, profile.Timezone,
+			profile.Birthday, profile.BirthdayVisibility).
+		Suffix(`ON CONFLICT (user_id) DO UPDATE SET
+			display_name = EXCLUDED.display_name,
+			bio = EXCLUDED.bio,
+			avatar_url = EXCLUDED.avatar_url,
+			locale = EXCLUDED.locale,
+			timezone = EXCLUDED.timezone,
+			birthday = EXCLUDED.birthday,
+			birthday_visibility = EXCLUDED.birthday_visibility`).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ExportProfile returns the profile of the user for the data export.
+func (s *Storage) ExportProfile(ctx context.Context, userId uuid.UUID) (any, error) {
+	return s.GetProfile(ctx, userId)
+}
+
+func (s *Storage) selectProfile(ctx context.Context, query squirrel.SelectBuilder) (*models.Profile, error) {
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Profile])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrProfileNotFound
+		}
+		return nil, err
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,394 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"time"
+)
+
+var promoCodeColumns = []string{
+	"id", "code", "kind", "value", "currency", "min_spend", "new_users_only", "starts_at", "ends_at",
+	"max_redemptions", "per_user_limit", "redemptions", "description", "created_at", "disabled_at",
+}
+
+var discountColumns = []string{
+	"r.id AS redemption_id", "p.code", "p.kind", "p.value", "p.currency", "p.min_spend", "p.ends_at AS expires_at",
+	"r.redeemed_at",
+}
+
+func (s *Storage) CreatePromoCode(ctx context.Context, promo *models.PromoCode) (*models.PromoCode, error) {
+	const op = "storage.Postgres.CreatePromoCode"
+
+	sql, args, err := squirrel.Insert("promo_codes").
+		Columns("code", "kind", "value", "currency", "min_spend", "new_users_only", "starts_at", "ends_at",
+			"max_redemptions", "per_user_limit", "description").
+		Values(promo.Code, promo.Kind, promo.Value, promo.Currency, promo.MinSpend, promo.NewUsersOnly, promo.StartsAt,
+			promo.EndsAt, promo.MaxRedemptions, promo.PerUserLimit, promo.Description).
+		Suffix("RETURNING " + joinColumns(promoCodeColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	created, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.PromoCode])
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrPromoCodeTaken)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return created, nil
+}
+
+func (s *Storage) GetPromoCode(ctx context.Context, promoId uuid.UUID) (*models.PromoCode, error) {
+	const op = "storage.Postgres.GetPromoCode"
+
+	promo, err := s.getPromoCode(ctx, squirrel.Eq{"id": promoId})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return promo, nil
+}
+
+func (s *Storage) GetPromoCodeByCode(ctx context.Context, code string) (*models.PromoCode, error) {
+	const op = "storage.Postgres.GetPromoCodeByCode"
+
+	promo, err := s.getPromoCode(ctx, squirrel.Eq{"code": code})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return promo, nil
+}
+
+// ListPromoCodes returns a page of promo codes, newest first.
+func (s *Storage) ListPromoCodes(ctx context.Context, after *cursor.Cursor, limit int) ([]models.PromoCode, error) {
+	const op = "storage.Postgres.ListPromoCodes"
+
+	query := squirrel.Select(promoCodeColumns...).
+		From("promo_codes")
+
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("created_at DESC", "id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	promos, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.PromoCode])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return promos, nil
+}
+
+func (s *Storage) GetPromoStats(ctx context.Context, promoId uuid.UUID) (*models.PromoStats, error) {
+	const op = "storage.Postgres.GetPromoStats"
+
+	sql, args, err := squirrel.Select(
+		"COUNT(*) AS redeemed",
+		"COUNT(used_at) AS used",
+		"COUNT(DISTINCT user_id) AS users",
+	).
+		From("promo_redemptions").
+		Where(squirrel.Eq{"promo_id": promoId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stats, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.PromoStats])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return stats, nil
+}
+
+// ListPromoRedemptions returns a page of the redemptions of the promo code, newest first.
+func (s *Storage) ListPromoRedemptions(ctx context.Context, promoId uuid.UUID, after *cursor.Cursor, limit int) ([]models.PromoRedemption, error) {
+	const op = "storage.Postgres.ListPromoRedemptions"
+
+	query := squirrel.Select("r.id", "r.promo_id", "r.user_id", "u.username", "r.redeemed_at", "r.used_at").
+		From("promo_redemptions r").
+		LeftJoin("users u ON u.id = r.user_id").
+		Where(squirrel.Eq{"r.promo_id": promoId})
+
+	if after != nil {
+		query = query.Where("(r.redeemed_at, r.id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("r.redeemed_at DESC", "r.id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	redemptions, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.PromoRedemption])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return redemptions, nil
+}
+
+// DisablePromoCode stops the code from being redeemed and voids the discounts not used yet.
+// Disabling it again keeps the first time.
+func (s *Storage) DisablePromoCode(ctx context.Context, promoId uuid.UUID) (*models.PromoCode, error) {
+	const op = "storage.Postgres.DisablePromoCode"
+
+	sql, args, err := squirrel.Update("promo_codes").
+		Set("disabled_at", squirrel.Expr("COALESCE(disabled_at, ?)", time.Now())).
+		Where(squirrel.Eq{"id": promoId}).
+		Suffix("RETURNING " + joinColumns(promoCodeColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	promo, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.PromoCode])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrPromoCodeNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return promo, nil
+}
+
+// RedeemPromoCode gives the user the discount of the promo code. It fails when the user
+// already holds a discount, has redeemed the code as often as it allows, registered before
+// registeredAfter if that is set, or when the code is inactive or used up.
+func (s *Storage) RedeemPromoCode(ctx context.Context, promoId, userId uuid.UUID, registeredAfter *time.Time) (*models.Discount, error) {
+	const op = "storage.Postgres.RedeemPromoCode"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// the user row serializes the redemptions of one user, so the per-user rules hold
+	var registeredAt time.Time
+	err = tx.QueryRow(ctx, "SELECT created_at FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", userId).
+		Scan(&registeredAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if registeredAfter != nil && registeredAt.Before(*registeredAfter) {
+		return nil, fmt.Errorf("%s: %w", op, repository.ErrPromoNotEligible)
+	}
+
+	now := time.Now()
+
+	if _, err = activeDiscount(ctx, tx, userId, now); err == nil {
+		return nil, fmt.Errorf("%s: %w", op, repository.ErrDiscountAlreadyActive)
+	} else if !errors.Is(err, repository.ErrDiscountNotFound) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var perUserLimit, redeemed int
+	err = tx.QueryRow(ctx, `SELECT per_user_limit,
+			(SELECT COUNT(*) FROM promo_redemptions WHERE promo_id = $1 AND user_id = $2)
+		FROM promo_codes WHERE id = $1`, promoId, userId,
+	).Scan(&perUserLimit, &redeemed)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrPromoCodeNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if redeemed >= perUserLimit {
+		return nil, fmt.Errorf("%s: %w", op, repository.ErrPromoUserLimitReached)
+	}
+
+	sql, args, err := squirrel.Update("promo_codes").
+		Set("redemptions", squirrel.Expr("redemptions + 1")).
+		Where(squirrel.Eq{"id": promoId, "disabled_at": nil}).
+		Where(squirrel.Or{squirrel.Eq{"max_redemptions": nil}, squirrel.Expr("redemptions < max_redemptions")}).
+		Where(squirrel.Or{squirrel.Eq{"starts_at": nil}, squirrel.LtOrEq{"starts_at": now}}).
+		Where(squirrel.Or{squirrel.Eq{"ends_at": nil}, squirrel.Gt{"ends_at": now}}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, fmt.Errorf("%s: %w", op, repository.ErrPromoCodeExhausted)
+	}
+
+	if _, err = tx.Exec(ctx, "INSERT INTO promo_redemptions (promo_id, user_id, redeemed_at) VALUES ($1, $2, $3)",
+		promoId, userId, now); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	discount, err := activeDiscount(ctx, tx, userId, now)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return discount, nil
+}
+
+// GetActiveDiscount returns the discount the user holds, if it has not expired.
+func (s *Storage) GetActiveDiscount(ctx context.Context, userId uuid.UUID) (*models.Discount, error) {
+	const op = "storage.Postgres.GetActiveDiscount"
+
+	discount, err := activeDiscount(ctx, s.db, userId, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return discount, nil
+}
+
+// ExportPromoRedemptions returns the promo codes the user redeemed.
+func (s *Storage) ExportPromoRedemptions(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportPromoRedemptions"
+
+	sql, args, err := squirrel.Select("p.code", "p.kind", "p.value", "p.currency", "r.redeemed_at", "r.used_at").
+		From("promo_redemptions r").
+		Join("promo_codes p ON p.id = r.promo_id").
+		Where(squirrel.Eq{"r.user_id": userId}).
+		OrderBy("r.redeemed_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	redemptions, err := pgx.CollectRows(rows, pgx.RowToMap)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return redemptions, nil
+}
+
+func (s *Storage) getPromoCode(ctx context.Context, where squirrel.Eq) (*models.PromoCode, error) {
+	sql, args, err := squirrel.Select(promoCodeColumns...).
+		From("promo_codes").
+		Where(where).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	promo, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.PromoCode])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrPromoCodeNotFound
+		}
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+// activeDiscount returns the unused redemption of the user whose code is still enabled and
+// has not ended at the time.
+func activeDiscount(ctx context.Context, q querier, userId uuid.UUID, at time.Time) (*models.Discount, error) {
+	sql, args, err := squirrel.Select(discountColumns...).
+		From("promo_redemptions r").
+		Join("promo_codes p ON p.id = r.promo_id").
+		Where(squirrel.Eq{"r.user_id": userId, "r.used_at": nil, "p.disabled_at": nil}).
+		Where(squirrel.Or{squirrel.Eq{"p.ends_at": nil}, squirrel.Gt{"p.ends_at": at}}).
+		OrderBy("r.redeemed_at DESC").
+		Limit(1).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	discount, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Discount])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrDiscountNotFound
+		}
+		return nil, err
+	}
+
+	return discount, nil
+}
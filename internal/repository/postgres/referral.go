@@ -0,0 +1,329 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var referralColumns = []string{
+	"r.id", "r.referrer_id", "r.referee_id", "u.username AS referee_username", "r.status", "r.reason", "r.reward",
+	"r.user_agent_family", "r.ip_prefix", "r.created_at", "r.resolved_at",
+}
+
+// EnsureReferralCode gives the user the code unless they already have one, and returns their
+// code.
+func (s *Storage) EnsureReferralCode(ctx context.Context, userId uuid.UUID, code string) (string, error) {
+	const op = "storage.Postgres.EnsureReferralCode"
+
+	sql, args, err := squirrel.Insert("referral_codes").
+		Columns("user_id", "code").
+		Values(userId, code).
+		Suffix("ON CONFLICT (user_id) DO UPDATE SET code = referral_codes.code RETURNING code").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.db.QueryRow(ctx, sql, args...).Scan(&code); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+// GetReferrer returns the active user owning a referral code.
+func (s *Storage) GetReferrer(ctx context.Context, code string) (*models.Referrer, error) {
+	const op = "storage.Postgres.GetReferrer"
+
+	sql, args, err := squirrel.Select("u.id AS user_id", "u.email").
+		From("referral_codes c").
+		Join("users u ON u.id = c.user_id").
+		Where(squirrel.Eq{"c.code": code, "u.deleted_at": nil}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	referrer, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Referrer])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrReferralNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return referrer, nil
+}
+
+func (s *Storage) CreateReferral(ctx context.Context, referral *models.Referral) error {
+	const op = "storage.Postgres.CreateReferral"
+
+	sql, args, err := squirrel.Insert("referrals").
+		Columns("referrer_id", "referee_id", "status", "reason", "user_agent_family", "ip_prefix", "resolved_at").
+		Values(referral.ReferrerID, referral.RefereeID, referral.Status, referral.Reason, referral.UserAgentFamily,
+			referral.IPPrefix, referral.ResolvedAt).
+		Suffix("RETURNING id, created_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.db.QueryRow(ctx, sql, args...).Scan(&referral.ID, &referral.CreatedAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListReferrals returns a page of the referrals of a referrer, newest first.
+func (s *Storage) ListReferrals(ctx context.Context, referrerId uuid.UUID, after *cursor.Cursor, limit int) ([]models.Referral, error) {
+	const op = "storage.Postgres.ListReferrals"
+
+	query := squirrel.Select(referralColumns...).
+		From("referrals r").
+		Join("users u ON u.id = r.referee_id").
+		Where(squirrel.Eq{"r.referrer_id": referrerId})
+
+	if after != nil {
+		query = query.Where("(r.created_at, r.id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("r.created_at DESC", "r.id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	referrals, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Referral])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return referrals, nil
+}
+
+func (s *Storage) GetReferralStats(ctx context.Context, referrerId uuid.UUID) (*models.ReferralStats, error) {
+	const op = "storage.Postgres.GetReferralStats"
+
+	sql, args, err := squirrel.Select(
+		"COUNT(*) FILTER (WHERE status = 'pending') AS pending",
+		"COUNT(*) FILTER (WHERE status = 'credited') AS credited",
+		"COUNT(*) FILTER (WHERE status = 'rejected') AS rejected",
+		"COALESCE(SUM(reward) FILTER (WHERE status = 'credited'), 0) AS rewarded",
+	).
+		From("referrals").
+		Where(squirrel.Eq{"referrer_id": referrerId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stats, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.ReferralStats])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return stats, nil
+}
+
+// GetReferredBy returns the referral the user registered through.
+func (s *Storage) GetReferredBy(ctx context.Context, refereeId uuid.UUID) (*models.ReferredBy, error) {
+	const op = "storage.Postgres.GetReferredBy"
+
+	sql, args, err := squirrel.Select("u.username", "r.status").
+		From("referrals r").
+		Join("users u ON u.id = r.referrer_id").
+		Where(squirrel.Eq{"r.referee_id": refereeId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	referredBy, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.ReferredBy])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrReferralNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return referredBy, nil
+}
+
+// QualifyingReferrals returns pending referrals, oldest first, whose referee verified their
+// email, registered before registeredBefore and has at least minFriends friends.
+func (s *Storage) QualifyingReferrals(ctx context.Context, registeredBefore time.Time, minFriends, limit int) ([]models.Referral, error) {
+	const op = "storage.Postgres.QualifyingReferrals"
+
+	sql, args, err := squirrel.Select(referralColumns...).
+		From("referrals r").
+		Join("users u ON u.id = r.referee_id").
+		Where(squirrel.Eq{"r.status": models.ReferralPending, "u.deleted_at": nil}).
+		Where(squirrel.NotEq{"u.email_verified_at": nil}).
+		Where(squirrel.LtOrEq{"u.created_at": registeredBefore}).
+		Where(`(SELECT COUNT(*) FROM friendships f
+			WHERE f.state = 'accepted' AND (f.requester_id = u.id OR f.addressee_id = u.id)) >= ?`, minFriends).
+		OrderBy("r.created_at").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	referrals, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Referral])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return referrals, nil
+}
+
+// CreditReferral credits a pending referral, paying the reward in currency to the referrer from
+// the rewards account, unless the referrer already has maxPerReferrer credited referrals, in
+// which case it is rejected. Zero maxPerReferrer means no cap. It returns the status the
+// referral ends up with.
+func (s *Storage) CreditReferral(ctx context.Context, referralId uuid.UUID, reward int64, currency string, maxPerReferrer int) (string, error) {
+	const op = "storage.Postgres.CreditReferral"
+
+	var status string
+
+	err := s.inSerializableTx(ctx, func(tx pgx.Tx) error {
+		var referrerId uuid.UUID
+		err := tx.QueryRow(ctx, "SELECT referrer_id FROM referrals WHERE id = $1 AND status = 'pending' FOR UPDATE", referralId).
+			Scan(&referrerId)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return repository.ErrReferralNotFound
+			}
+			return err
+		}
+
+		status = models.ReferralCredited
+		var reason *string
+		paid := reward
+
+		if maxPerReferrer > 0 {
+			// the referrer row serializes the credits of one referrer, so the cap holds
+			if _, err = tx.Exec(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", referrerId); err != nil {
+				return err
+			}
+
+			var credited int
+			err = tx.QueryRow(ctx, "SELECT COUNT(*) FROM referrals WHERE referrer_id = $1 AND status = 'credited'", referrerId).
+				Scan(&credited)
+			if err != nil {
+				return err
+			}
+
+			if credited >= maxPerReferrer {
+				capReached := models.ReferralReasonCapReached
+				status, reason, paid = models.ReferralRejected, &capReached, 0
+			}
+		}
+
+		sql, args, err := squirrel.Update("referrals").
+			Set("status", status).
+			Set("reason", reason).
+			Set("reward", paid).
+			Set("resolved_at", time.Now()).
+			Where(squirrel.Eq{"id": referralId}).
+			PlaceholderFormat(squirrel.Dollar).
+			ToSql()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(ctx, sql, args...); err != nil {
+			return err
+		}
+
+		if paid == 0 {
+			return nil
+		}
+
+		_, _, err = postLedger(ctx, tx, &models.LedgerTransaction{
+			IdempotencyKey: "referral:" + referralId.String(),
+			Kind:           models.LedgerKindReferralReward,
+			Description:    "Referral reward",
+		}, []models.LedgerPosting{
+			{UserID: &referrerId, Currency: currency, Amount: paid},
+			{System: models.LedgerSystemRewards, Currency: currency, Amount: -paid},
+		})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return status, nil
+}
+
+// ExportReferrals returns the referrals the user made and the one they registered through.
+func (s *Storage) ExportReferrals(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportReferrals"
+
+	sql, args, err := squirrel.Select(referralColumns...).
+		From("referrals r").
+		Join("users u ON u.id = r.referee_id").
+		Where(squirrel.Or{squirrel.Eq{"r.referrer_id": userId}, squirrel.Eq{"r.referee_id": userId}}).
+		OrderBy("r.created_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	referrals, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Referral])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return referrals, nil
+}
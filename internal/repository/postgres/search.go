@@ -0,0 +1,72 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"strings"
+)
+
+// searchScore ranks a user against the query: the best trigram similarity of the username or
+// display name, plus one for a prefix match so those always come first.
+const searchScore = `(GREATEST(similarity(u.username, ?), similarity(COALESCE(p.display_name, ''), ?))
+	+ CASE WHEN u.username ILIKE ? OR p.display_name ILIKE ? THEN 1 ELSE 0 END)::real`
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchUsers returns a page of active users whose username or display name starts with or
+// resembles query, best matches first. Users blocked by or blocking viewerId are left out.
+func (s *Storage) SearchUsers(ctx context.Context, viewerId uuid.UUID, query string, after *cursor.ScoreCursor, limit int) ([]models.UserSearchResult, error) {
+	const op = "storage.Postgres.SearchUsers"
+
+	prefix := likeEscaper.Replace(query) + "%"
+	scoreArgs := []interface{}{query, query, prefix, prefix}
+
+	inner := squirrel.Select("u.id AS user_id", "u.username", "p.display_name", "p.avatar_url").
+		Column(squirrel.Alias(squirrel.Expr(searchScore, scoreArgs...), "score")).
+		From("users u").
+		LeftJoin("user_profiles p ON p.user_id = u.id").
+		Where(squirrel.Eq{"u.deleted_at": nil}).
+		Where(squirrel.Or{
+			squirrel.Expr("u.username % ?", query),
+			squirrel.Expr("p.display_name % ?", query),
+			squirrel.Expr("u.username ILIKE ?", prefix),
+			squirrel.Expr("p.display_name ILIKE ?", prefix),
+		}).
+		Where(`NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.blocker_id = ? AND b.blocked_id = u.id) OR (b.blocker_id = u.id AND b.blocked_id = ?)
+		)`, viewerId, viewerId)
+
+	outer := squirrel.Select("user_id", "username", "display_name", "avatar_url", "score").
+		FromSelect(inner, "matches")
+
+	if after != nil {
+		outer = outer.Where("(score, user_id) < (?::real, ?)", after.Score, after.ID)
+	}
+
+	sql, args, err := outer.
+		OrderBy("score DESC", "user_id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	results, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.UserSearchResult])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return results, nil
+}
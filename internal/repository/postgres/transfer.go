@@ -0,0 +1,320 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var transferColumns = []string{
+	"t.id", "t.sender_id", "s.username AS sender_username", "t.recipient_id", "r.username AS recipient_username",
+	"t.amount", "t.currency", "t.note", "t.status", "t.idempotency_key", "t.transaction_id",
+	"t.reversal_transaction_id", "t.created_at", "t.reversed_at",
+}
+
+// CreateTransfer moves the funds of a transfer from the sender to the recipient and records it.
+// The parties must be friends who have not blocked each other. The sender may not send more
+// than dailyLimit in the currency of the transfer within window; zero dailyLimit means no
+// limit. It reports false when the sender already made a transfer with the idempotency key,
+// which is returned instead.
+func (s *Storage) CreateTransfer(ctx context.Context, transfer *models.Transfer, dailyLimit int64, window time.Duration) (*models.Transfer, bool, error) {
+	const op = "storage.Postgres.CreateTransfer"
+
+	var (
+		created *models.Transfer
+		isNew   bool
+	)
+
+	err := s.inSerializableTx(ctx, func(tx pgx.Tx) error {
+		existing, err := getTransfer(ctx, tx, squirrel.Eq{"t.sender_id": transfer.SenderID, "t.idempotency_key": transfer.IdempotencyKey})
+		if err == nil {
+			created, isNew = existing, false
+			return nil
+		}
+		if !errors.Is(err, repository.ErrTransferNotFound) {
+			return err
+		}
+
+		// the sender row serializes the transfers of one sender, so the daily limit holds
+		var senderId uuid.UUID
+		err = tx.QueryRow(ctx, "SELECT id FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", *transfer.SenderID).
+			Scan(&senderId)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return repository.ErrUserNotFound
+			}
+			return err
+		}
+
+		// read in the transaction, so a block or an unfriending committed meanwhile aborts it
+		var allowed bool
+		err = tx.QueryRow(ctx, `SELECT EXISTS (
+				SELECT 1 FROM friendships
+				WHERE LEAST(requester_id, addressee_id) = LEAST($1::uuid, $2::uuid)
+				  AND GREATEST(requester_id, addressee_id) = GREATEST($1::uuid, $2::uuid)
+				  AND state = $3
+			) AND NOT EXISTS (
+				SELECT 1 FROM user_blocks
+				WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+			)`, senderId, *transfer.RecipientID, models.FriendshipAccepted,
+		).Scan(&allowed)
+		if err != nil {
+			return err
+		}
+
+		if !allowed {
+			return repository.ErrTransferNotAllowed
+		}
+
+		if dailyLimit > 0 {
+			// the window is taken from the database clock, like created_at
+			var sent int64
+			err = tx.QueryRow(ctx, `SELECT COALESCE(SUM(amount), 0) FROM transfers
+				WHERE sender_id = $1 AND currency = $2 AND status = 'completed'
+				  AND created_at >= NOW() - $3::float8 * INTERVAL '1 second'`,
+				senderId, transfer.Currency, window.Seconds(),
+			).Scan(&sent)
+			if err != nil {
+				return err
+			}
+
+			if sent+transfer.Amount > dailyLimit {
+				return repository.ErrTransferLimitReached
+			}
+		}
+
+		transferId := uuid.New()
+
+		posted, _, err := postLedger(ctx, tx, &models.LedgerTransaction{
+			IdempotencyKey: "transfer:" + transferId.String(),
+			Kind:           models.LedgerKindTransfer,
+			Description:    transfer.Note,
+		}, []models.LedgerPosting{
+			{UserID: transfer.SenderID, Currency: transfer.Currency, Amount: -transfer.Amount},
+			{UserID: transfer.RecipientID, Currency: transfer.Currency, Amount: transfer.Amount},
+		})
+		if err != nil {
+			return err
+		}
+
+		sql, args, err := squirrel.Insert("transfers").
+			Columns("id", "sender_id", "recipient_id", "amount", "currency", "note", "idempotency_key", "transaction_id").
+			Values(transferId, transfer.SenderID, transfer.RecipientID, transfer.Amount, transfer.Currency, transfer.Note,
+				transfer.IdempotencyKey, posted.ID).
+			PlaceholderFormat(squirrel.Dollar).
+			ToSql()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(ctx, sql, args...); err != nil {
+			return err
+		}
+
+		created, err = getTransfer(ctx, tx, squirrel.Eq{"t.id": transferId})
+		isNew = true
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return created, isNew, nil
+}
+
+// GetTransferByKey returns the transfer the sender made with the idempotency key.
+func (s *Storage) GetTransferByKey(ctx context.Context, senderId uuid.UUID, idempotencyKey string) (*models.Transfer, error) {
+	const op = "storage.Postgres.GetTransferByKey"
+
+	transfer, err := getTransfer(ctx, s.db, squirrel.Eq{"t.sender_id": senderId, "t.idempotency_key": idempotencyKey})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return transfer, nil
+}
+
+func (s *Storage) GetTransfer(ctx context.Context, transferId uuid.UUID) (*models.Transfer, error) {
+	const op = "storage.Postgres.GetTransfer"
+
+	transfer, err := getTransfer(ctx, s.db, squirrel.Eq{"t.id": transferId})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return transfer, nil
+}
+
+// ListTransfers returns a page of the transfers the user sent or received, newest first.
+func (s *Storage) ListTransfers(ctx context.Context, userId uuid.UUID, after *cursor.Cursor, limit int) ([]models.Transfer, error) {
+	const op = "storage.Postgres.ListTransfers"
+
+	query := transferQuery().
+		Where(squirrel.Or{squirrel.Eq{"t.sender_id": userId}, squirrel.Eq{"t.recipient_id": userId}})
+
+	if after != nil {
+		query = query.Where("(t.created_at, t.id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("t.created_at DESC", "t.id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	transfers, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Transfer])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return transfers, nil
+}
+
+// ReverseTransfer moves the funds of a completed transfer back to the sender with compensating
+// ledger entries. It reports false when the transfer was already reversed, leaving it as is.
+func (s *Storage) ReverseTransfer(ctx context.Context, transferId uuid.UUID) (*models.Transfer, bool, error) {
+	const op = "storage.Postgres.ReverseTransfer"
+
+	var (
+		reversed *models.Transfer
+		isNew    bool
+	)
+
+	err := s.inSerializableTx(ctx, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx, "SELECT status FROM transfers WHERE id = $1 FOR UPDATE", transferId).Scan(&status)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return repository.ErrTransferNotFound
+			}
+			return err
+		}
+
+		transfer, err := getTransfer(ctx, tx, squirrel.Eq{"t.id": transferId})
+		if err != nil {
+			return err
+		}
+
+		if status == models.TransferReversed {
+			reversed, isNew = transfer, false
+			return nil
+		}
+
+		// the parties stay on the ledger after deleting their accounts, but their funds cannot
+		// be moved anymore
+		if transfer.SenderID == nil || transfer.RecipientID == nil {
+			return repository.ErrUserNotFound
+		}
+
+		posted, _, err := postLedger(ctx, tx, &models.LedgerTransaction{
+			IdempotencyKey: "transfer-reversal:" + transferId.String(),
+			Kind:           models.LedgerKindTransferReversal,
+			Description:    transfer.Note,
+		}, []models.LedgerPosting{
+			{UserID: transfer.RecipientID, Currency: transfer.Currency, Amount: -transfer.Amount},
+			{UserID: transfer.SenderID, Currency: transfer.Currency, Amount: transfer.Amount},
+		})
+		if err != nil {
+			return err
+		}
+
+		sql, args, err := squirrel.Update("transfers").
+			Set("status", models.TransferReversed).
+			Set("reversal_transaction_id", posted.ID).
+			Set("reversed_at", time.Now()).
+			Where(squirrel.Eq{"id": transferId}).
+			PlaceholderFormat(squirrel.Dollar).
+			ToSql()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(ctx, sql, args...); err != nil {
+			return err
+		}
+
+		reversed, err = getTransfer(ctx, tx, squirrel.Eq{"t.id": transferId})
+		isNew = true
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return reversed, isNew, nil
+}
+
+// ExportTransfers returns the transfers the user sent or received.
+func (s *Storage) ExportTransfers(ctx context.Context, userId uuid.UUID) (any, error) {
+	const op = "storage.Postgres.ExportTransfers"
+
+	sql, args, err := transferQuery().
+		Where(squirrel.Or{squirrel.Eq{"t.sender_id": userId}, squirrel.Eq{"t.recipient_id": userId}}).
+		OrderBy("t.created_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	transfers, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Transfer])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return transfers, nil
+}
+
+// querier runs a query on the pool or within a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func getTransfer(ctx context.Context, q querier, where squirrel.Eq) (*models.Transfer, error) {
+	sql, args, err := transferQuery().Where(where).PlaceholderFormat(squirrel.Dollar).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.Transfer])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrTransferNotFound
+		}
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+func transferQuery() squirrel.SelectBuilder {
+	return squirrel.Select(transferColumns...).
+		From("transfers t").
+		LeftJoin("users s ON s.id = t.sender_id").
+		LeftJoin("users r ON r.id = t.recipient_id")
+}
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// IsEmailVerified reports whether the current email of an active user is verified.
+func (s *Storage) IsEmailVerified(ctx context.Context, userId uuid.UUID) (bool, error) {
+	const op = "storage.Postgres.IsEmailVerified"
+
+	sql, args, err := squirrel.Select("email_verified_at IS NOT NULL").
+		From("users").
+		Where(squirrel.Eq{"id": userId, "deleted_at": nil}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var verified bool
+	if err = s.db.QueryRow(ctx, sql, args...).Scan(&verified); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+		}
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return verified, nil
+}
+
+// MarkEmailVerified verifies the email of the user if it still is email and is not verified yet.
+// Otherwise it returns repository.ErrVerificationNotFound.
+func (s *Storage) MarkEmailVerified(ctx context.Context, userId uuid.UUID, email string) error {
+	const op = "storage.Postgres.MarkEmailVerified"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	sql, args, err := squirrel.Update("users").
+		Set("email_verified_at", time.Now()).
+		Where(squirrel.Eq{"id": userId, "email": email, "email_verified_at": nil, "deleted_at": nil}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, repository.ErrVerificationNotFound)
+	}
+
+	if err = insertOutboxEvent(ctx, tx, models.DomainUserEmailVerified, models.UserEmailVerifiedData{UserID: userId, Email: email}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,509 @@
+package postgres
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var (
+	webhookColumns = []string{
+		"id", "url", "secret", "event_types", "consecutive_failures", "disabled_at", "disabled_reason", "created_at", "updated_at",
+	}
+	deliveryColumns = []string{
+		"id", "subscription_id", "event_id", "event_type", "payload", "event_created_at", "state", "attempts",
+		"next_attempt_at", "last_status_code", "last_error", "created_at", "delivered_at",
+	}
+)
+
+func (s *Storage) CreateWebhookSubscription(ctx context.Context, url, secret string, eventTypes []string) (*models.WebhookSubscription, error) {
+	const op = "storage.Postgres.CreateWebhookSubscription"
+
+	sql, args, err := squirrel.Insert("webhook_subscriptions").
+		Columns("url", "secret", "event_types").
+		Values(url, secret, eventTypes).
+		Suffix("RETURNING " + joinColumns(webhookColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	subscription, err := s.collectWebhook(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+func (s *Storage) GetWebhookSubscription(ctx context.Context, subscriptionId uuid.UUID) (*models.WebhookSubscription, error) {
+	const op = "storage.Postgres.GetWebhookSubscription"
+
+	sql, args, err := squirrel.Select(webhookColumns...).
+		From("webhook_subscriptions").
+		Where(squirrel.Eq{"id": subscriptionId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	subscription, err := s.collectWebhook(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+func (s *Storage) ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	const op = "storage.Postgres.ListWebhookSubscriptions"
+
+	sql, args, err := squirrel.Select(webhookColumns...).
+		From("webhook_subscriptions").
+		OrderBy("created_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	subscriptions, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.WebhookSubscription])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscriptions, nil
+}
+
+// SaveWebhookSubscription writes the editable fields of a subscription back.
+func (s *Storage) SaveWebhookSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	const op = "storage.Postgres.SaveWebhookSubscription"
+
+	sql, args, err := squirrel.Update("webhook_subscriptions").
+		Set("url", subscription.URL).
+		Set("event_types", subscription.EventTypes).
+		Set("consecutive_failures", subscription.ConsecutiveFailures).
+		Set("disabled_at", subscription.DisabledAt).
+		Set("disabled_reason", subscription.DisabledReason).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": subscription.ID}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, repository.ErrWebhookNotFound)
+	}
+
+	return nil
+}
+
+// RotateWebhookSecret replaces the signing secret of a subscription. Deliveries claimed from
+// then on are signed with the new one.
+func (s *Storage) RotateWebhookSecret(ctx context.Context, subscriptionId uuid.UUID, secret string) (*models.WebhookSubscription, error) {
+	const op = "storage.Postgres.RotateWebhookSecret"
+
+	sql, args, err := squirrel.Update("webhook_subscriptions").
+		Set("secret", secret).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": subscriptionId}).
+		Suffix("RETURNING " + joinColumns(webhookColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	subscription, err := s.collectWebhook(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+func (s *Storage) DeleteWebhookSubscription(ctx context.Context, subscriptionId uuid.UUID) error {
+	const op = "storage.Postgres.DeleteWebhookSubscription"
+
+	sql, args, err := squirrel.Delete("webhook_subscriptions").
+		Where(squirrel.Eq{"id": subscriptionId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", op, repository.ErrWebhookNotFound)
+	}
+
+	return nil
+}
+
+// EnqueueWebhookDeliveries creates a delivery of the event for every enabled subscription to its
+// type. An event enqueued again is ignored. It returns the number of new deliveries.
+func (s *Storage) EnqueueWebhookDeliveries(ctx context.Context, eventId uuid.UUID, eventType string, payload json.RawMessage, createdAt time.Time) (int64, error) {
+	const op = "storage.Postgres.EnqueueWebhookDeliveries"
+
+	subscriptions := squirrel.Select("id").
+		Column("?::uuid", eventId).
+		Column("?::varchar", eventType).
+		Column("?::jsonb", payload).
+		Column("?::timestamp", createdAt).
+		From("webhook_subscriptions").
+		Where(squirrel.Eq{"disabled_at": nil}).
+		Where("?::text = ANY(event_types)", eventType)
+
+	sql, args, err := squirrel.Insert("webhook_deliveries").
+		Columns("subscription_id", "event_id", "event_type", "payload", "event_created_at").
+		Select(subscriptions).
+		Suffix("ON CONFLICT (subscription_id, event_id) DO NOTHING").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// ClaimWebhookDeliveries leases up to limit due deliveries of enabled subscriptions and counts
+// the attempt, like ClaimOutboxEvents.
+func (s *Storage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.PendingWebhook, error) {
+	const op = "storage.Postgres.ClaimWebhookDeliveries"
+
+	due := squirrel.Select("d.id").
+		From("webhook_deliveries d").
+		Join("webhook_subscriptions ws ON ws.id = d.subscription_id").
+		Where(squirrel.Eq{"d.state": models.WebhookDeliveryPending, "ws.disabled_at": nil}).
+		Where(squirrel.LtOrEq{"d.next_attempt_at": time.Now()}).
+		OrderBy("d.next_attempt_at").
+		Limit(uint64(limit)).
+		Suffix("FOR UPDATE OF d SKIP LOCKED")
+
+	sql, args, err := squirrel.Update("webhook_deliveries d").
+		Set("attempts", squirrel.Expr("d.attempts + 1")).
+		Set("next_attempt_at", time.Now().Add(lease)).
+		From("webhook_subscriptions ws").
+		Where("ws.id = d.subscription_id").
+		Where(squirrel.Expr("d.id IN (?)", due)).
+		Suffix("RETURNING d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.event_created_at, d.attempts, ws.url, ws.secret").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.PendingWebhook])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+// CompleteWebhookDelivery logs a successful attempt, marks the delivery delivered and resets the
+// failure count of the subscription.
+func (s *Storage) CompleteWebhookDelivery(ctx context.Context, delivery models.PendingWebhook, attempt models.WebhookAttempt) error {
+	const op = "storage.Postgres.CompleteWebhookDelivery"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err = insertWebhookAttempt(ctx, tx, attempt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sql, args, err := squirrel.Update("webhook_deliveries").
+		Set("state", models.WebhookDeliveryDelivered).
+		Set("last_status_code", attempt.StatusCode).
+		Set("last_error", nil).
+		Set("delivered_at", attempt.AttemptedAt).
+		Where(squirrel.Eq{"id": delivery.ID}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	sql, args, err = squirrel.Update("webhook_subscriptions").
+		Set("consecutive_failures", 0).
+		Where(squirrel.Eq{"id": delivery.SubscriptionID}).
+		Where(squirrel.Gt{"consecutive_failures": 0}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// FailWebhookDelivery logs a failed attempt and schedules the next one at nextAttemptAt, or marks
+// the delivery failed when it is nil. The subscription is disabled once disableAfter attempts in
+// a row have failed; the result reports whether this attempt disabled it.
+func (s *Storage) FailWebhookDelivery(ctx context.Context, delivery models.PendingWebhook, attempt models.WebhookAttempt, nextAttemptAt *time.Time, disableAfter int) (bool, error) {
+	const op = "storage.Postgres.FailWebhookDelivery"
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err = insertWebhookAttempt(ctx, tx, attempt); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	update := squirrel.Update("webhook_deliveries").
+		Set("last_status_code", attempt.StatusCode).
+		Set("last_error", attempt.Error)
+	if nextAttemptAt != nil {
+		update = update.Set("next_attempt_at", *nextAttemptAt)
+	} else {
+		update = update.Set("state", models.WebhookDeliveryFailed)
+	}
+
+	sql, args, err := update.
+		Where(squirrel.Eq{"id": delivery.ID}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sql, args, err = squirrel.Update("webhook_subscriptions").
+		Set("consecutive_failures", squirrel.Expr("consecutive_failures + 1")).
+		Set("disabled_at", squirrel.Expr("CASE WHEN disabled_at IS NULL AND consecutive_failures + 1 >= ? THEN NOW() ELSE disabled_at END", disableAfter)).
+		Set("disabled_reason", squirrel.Expr("CASE WHEN disabled_at IS NULL AND consecutive_failures + 1 >= ? THEN ?::text ELSE disabled_reason END",
+			disableAfter, fmt.Sprintf("disabled after %d failed deliveries in a row", disableAfter))).
+		Where(squirrel.Eq{"id": delivery.SubscriptionID}).
+		Suffix("RETURNING disabled_at IS NOT NULL AND consecutive_failures = ?", disableAfter).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var disabled bool
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&disabled); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return disabled, nil
+}
+
+// ListWebhookDeliveries returns a page of the deliveries of a subscription, newest first.
+func (s *Storage) ListWebhookDeliveries(ctx context.Context, subscriptionId uuid.UUID, after *cursor.Cursor, limit int) ([]models.WebhookDelivery, error) {
+	const op = "storage.Postgres.ListWebhookDeliveries"
+
+	query := squirrel.Select(deliveryColumns...).
+		From("webhook_deliveries").
+		Where(squirrel.Eq{"subscription_id": subscriptionId})
+
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.Time, after.ID)
+	}
+
+	sql, args, err := query.
+		OrderBy("created_at DESC", "id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.WebhookDelivery])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *Storage) GetWebhookDelivery(ctx context.Context, deliveryId uuid.UUID) (*models.WebhookDelivery, error) {
+	const op = "storage.Postgres.GetWebhookDelivery"
+
+	sql, args, err := squirrel.Select(deliveryColumns...).
+		From("webhook_deliveries").
+		Where(squirrel.Eq{"id": deliveryId}).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	delivery, err := s.collectDelivery(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return delivery, nil
+}
+
+// ListWebhookAttempts returns every attempt made for a delivery, oldest first.
+func (s *Storage) ListWebhookAttempts(ctx context.Context, deliveryId uuid.UUID) ([]models.WebhookAttempt, error) {
+	const op = "storage.Postgres.ListWebhookAttempts"
+
+	sql, args, err := squirrel.Select("id", "delivery_id", "attempted_at", "status_code", "error", "duration_ms").
+		From("webhook_delivery_attempts").
+		Where(squirrel.Eq{"delivery_id": deliveryId}).
+		OrderBy("attempted_at").
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	attempts, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.WebhookAttempt])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return attempts, nil
+}
+
+// RedeliverWebhook puts a delivery back in the queue with a fresh set of attempts.
+func (s *Storage) RedeliverWebhook(ctx context.Context, deliveryId uuid.UUID) (*models.WebhookDelivery, error) {
+	const op = "storage.Postgres.RedeliverWebhook"
+
+	sql, args, err := squirrel.Update("webhook_deliveries").
+		Set("state", models.WebhookDeliveryPending).
+		Set("attempts", 0).
+		Set("next_attempt_at", time.Now()).
+		Set("delivered_at", nil).
+		Where(squirrel.Eq{"id": deliveryId}).
+		Suffix("RETURNING " + joinColumns(deliveryColumns)).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	delivery, err := s.collectDelivery(ctx, sql, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return delivery, nil
+}
+
+func insertWebhookAttempt(ctx context.Context, tx pgx.Tx, attempt models.WebhookAttempt) error {
+	sql, args, err := squirrel.Insert("webhook_delivery_attempts").
+		Columns("delivery_id", "attempted_at", "status_code", "error", "duration_ms").
+		Values(attempt.DeliveryID, attempt.AttemptedAt, attempt.StatusCode, attempt.Error, attempt.DurationMs).
+		PlaceholderFormat(squirrel.Dollar).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, sql, args...)
+
+	return err
+}
+
+func (s *Storage) collectWebhook(ctx context.Context, sql string, args []interface{}) (*models.WebhookSubscription, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.WebhookSubscription])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrWebhookNotFound
+		}
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
+func (s *Storage) collectDelivery(ctx context.Context, sql string, args []interface{}) (*models.WebhookDelivery, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[models.WebhookDelivery])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, repository.ErrDeliveryNotFound
+		}
+		return nil, err
+	}
+
+	return delivery, nil
+}
@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"boton-back/internal/lib/broker"
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+)
+
+// StreamBroker publishes domain events to a Redis stream. Consumers read it with consumer
+// groups and deduplicate by the id field.
+type StreamBroker struct {
+	db     *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewStreamBroker returns a broker appending to stream on the connection of s. The stream is
+// trimmed to about maxLen entries, 0 keeps every entry.
+func NewStreamBroker(s *Storage, stream string, maxLen int64) *StreamBroker {
+	return &StreamBroker{
+		db:     s.db,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+func (b *StreamBroker) Publish(ctx context.Context, msg broker.Message) error {
+	const op = "storage.Redis.StreamBroker.Publish"
+
+	err := b.db.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.stream,
+		MaxLen: b.maxLen,
+		Approx: b.maxLen > 0,
+		Values: map[string]interface{}{
+			"id":         msg.ID,
+			"type":       msg.Type,
+			"payload":    string(msg.Payload),
+			"created_at": msg.CreatedAt.UnixMilli(),
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"boton-back/internal/domain/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventsChannelPrefix = "events:user:"
+	eventLogPrefix      = "events:log:"
+)
+
+// streamIDRegex matches the ids of stream entries, which are the ids of logged events.
+var streamIDRegex = regexp.MustCompile(`^\d+-\d+$`)
+
+// SetEventLogLimits bounds the per-user event log kept for resuming streams: at most size
+// events, expiring retention after the latest one.
+func (s *Storage) SetEventLogLimits(size int64, retention time.Duration) {
+	s.eventLogSize = size
+	s.eventLogRetention = retention
+}
+
+// PublishEvent appends the event to the user's event log, which assigns its id, and fans it
+// out to every instance with a connection of the user.
+func (s *Storage) PublishEvent(ctx context.Context, userId uuid.UUID, event models.Event) error {
+	const op = "storage.Redis.PublishEvent"
+
+	if s.eventLogSize > 0 {
+		logKey := eventLogPrefix + userId.String()
+
+		pipe := s.db.TxPipeline()
+		id := pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: logKey,
+			MaxLen: s.eventLogSize,
+			Approx: true,
+			Values: map[string]interface{}{"type": event.Type, "data": string(event.Data), "created_at": event.CreatedAt.UnixMilli()},
+		})
+		pipe.Expire(ctx, logKey, s.eventLogRetention)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		event.ID = id.Val()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.db.Publish(ctx, eventsChannelPrefix+userId.String(), payload).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// EventsSince returns the logged events of the user published after the event lastEventID,
+// oldest first. Events already trimmed from the log are silently missing.
+func (s *Storage) EventsSince(ctx context.Context, userId uuid.UUID, lastEventID string) ([]models.Event, error) {
+	const op = "storage.Redis.EventsSince"
+
+	if !streamIDRegex.MatchString(lastEventID) {
+		return nil, nil
+	}
+
+	entries, err := s.db.XRangeN(ctx, eventLogPrefix+userId.String(), "("+lastEventID, "+", s.eventLogSize).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	events := make([]models.Event, 0, len(entries))
+	for _, entry := range entries {
+		event := models.Event{ID: entry.ID}
+		event.Type, _ = entry.Values["type"].(string)
+		if data, _ := entry.Values["data"].(string); data != "" {
+			event.Data = json.RawMessage(data)
+		}
+		if ms, _ := entry.Values["created_at"].(string); ms != "" {
+			if unixMilli, err := strconv.ParseInt(ms, 10, 64); err == nil {
+				event.CreatedAt = time.UnixMilli(unixMilli)
+			}
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// ListenEvents subscribes to the events of all users and calls deliver for each one until ctx
+// is done. Events published while no instance listens are only kept in the event log.
+func (s *Storage) ListenEvents(ctx context.Context, deliver func(userId uuid.UUID, event models.Event)) error {
+	const op = "storage.Redis.ListenEvents"
+
+	pubsub := s.db.PSubscribe(ctx, eventsChannelPrefix+"*")
+	defer func() { _ = pubsub.Close() }()
+
+	// wait for the subscription so a broken connection is reported instead of silently ignored
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return fmt.Errorf("%s: subscription closed", op)
+			}
+
+			userId, err := uuid.Parse(strings.TrimPrefix(msg.Channel, eventsChannelPrefix))
+			if err != nil {
+				continue
+			}
+
+			var event models.Event
+			if err = json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+
+			deliver(userId, event)
+		}
+	}
+}
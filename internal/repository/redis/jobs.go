@@ -0,0 +1,198 @@
+package redis
+
+import (
+	"boton-back/internal/jobs"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const (
+	// jobsQueueKey is a sorted set of job ids scored by when they are due. A reserved job is
+	// rescheduled to the end of its lease, so it runs again if its worker never reports back.
+	jobsQueueKey       = "jobs:queue"
+	jobsDataKey        = "jobs:data"
+	jobsAttemptsKey    = "jobs:attempts"
+	jobsDeadKey        = "jobs:dead"
+	jobsUniquePrefix   = "jobs:unique:"
+	jobsSchedulePrefix = "jobs:schedule:"
+
+	// deadJobsLimit is the number of latest failed jobs kept for inspection.
+	deadJobsLimit = 1000
+)
+
+// enqueueJobScript stores and queues a job unless its unique key, when it has one, is taken.
+var enqueueJobScript = redis.NewScript(`
+if #KEYS == 3 and not redis.call('SET', KEYS[3], ARGV[1], 'NX', 'PX', ARGV[4]) then
+	return 0
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// reserveJobsScript leases up to ARGV[2] due jobs until ARGV[3] and counts the attempt. It
+// returns the data and attempt count of each job in turn.
+var reserveJobsScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+local reserved = {}
+for _, id in ipairs(ids) do
+	local data = redis.call('HGET', KEYS[2], id)
+	if data then
+		redis.call('ZADD', KEYS[1], ARGV[3], id)
+		table.insert(reserved, data)
+		table.insert(reserved, redis.call('HINCRBY', KEYS[3], id, 1))
+	else
+		redis.call('ZREM', KEYS[1], id)
+	end
+end
+return reserved
+`)
+
+// removeJobScript drops a finished job, frees its unique key if the job still holds it and,
+// given ARGV[2], files it under the dead jobs.
+var removeJobScript = redis.NewScript(`
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+if ARGV[2] ~= '' then
+	redis.call('LPUSH', KEYS[4], ARGV[2])
+	redis.call('LTRIM', KEYS[4], 0, ARGV[3] - 1)
+end
+if #KEYS == 5 and redis.call('GET', KEYS[5]) == ARGV[1] then
+	redis.call('DEL', KEYS[5])
+end
+return 1
+`)
+
+// claimScheduleTickScript records the latest tick of a schedule, refusing ticks already claimed.
+var claimScheduleTickScript = redis.NewScript(`
+local last = tonumber(redis.call('GET', KEYS[1]) or '0')
+if last >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+
+// EnqueueJob queues job to run at runAt. It returns false, queueing nothing, when the unique key
+// of the job is held by another job. The key is released when that job finishes or after
+// uniqueTTL.
+func (s *Storage) EnqueueJob(ctx context.Context, job jobs.Job, runAt time.Time, uniqueTTL time.Duration) (bool, error) {
+	const op = "storage.Redis.EnqueueJob"
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keys := []string{jobsQueueKey, jobsDataKey}
+	if job.UniqueKey != "" {
+		keys = append(keys, jobsUniquePrefix+job.UniqueKey)
+	}
+
+	enqueued, err := enqueueJobScript.Run(ctx, s.db, keys, job.ID, data, runAt.UnixMilli(), uniqueTTL.Milliseconds()).Bool()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return enqueued, nil
+}
+
+// ReserveJobs leases up to limit jobs due at now for lease and returns them with their attempt
+// counts, this one included.
+func (s *Storage) ReserveJobs(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]jobs.Job, error) {
+	const op = "storage.Redis.ReserveJobs"
+
+	values, err := reserveJobsScript.Run(ctx, s.db, []string{jobsQueueKey, jobsDataKey, jobsAttemptsKey}, now.UnixMilli(), limit, now.Add(lease).UnixMilli()).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	reserved := make([]jobs.Job, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		data, _ := values[i].(string)
+		attempts, _ := values[i+1].(int64)
+
+		var job jobs.Job
+		if err = json.Unmarshal([]byte(data), &job); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		job.Attempts = int(attempts)
+
+		reserved = append(reserved, job)
+	}
+
+	return reserved, nil
+}
+
+// RetryJob saves the last error of job and reschedules it to runAt. A job removed meanwhile
+// stays removed.
+func (s *Storage) RetryJob(ctx context.Context, job jobs.Job, runAt time.Time) error {
+	const op = "storage.Redis.RetryJob"
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	pipe := s.db.TxPipeline()
+	pipe.HSet(ctx, jobsDataKey, job.ID, data)
+	pipe.ZAddXX(ctx, jobsQueueKey, redis.Z{Score: float64(runAt.UnixMilli()), Member: job.ID})
+	if _, err = pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// CompleteJob removes a job that succeeded.
+func (s *Storage) CompleteJob(ctx context.Context, job jobs.Job) error {
+	const op = "storage.Redis.CompleteJob"
+
+	if err := s.removeJob(ctx, job, ""); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// BuryJob removes a job that will not be retried and keeps it in the dead jobs list.
+func (s *Storage) BuryJob(ctx context.Context, job jobs.Job) error {
+	const op = "storage.Redis.BuryJob"
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.removeJob(ctx, job, string(data)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ClaimScheduleTick reports whether this instance is the first to claim the tick of the named
+// schedule, so that a tick is enqueued once however many instances run the scheduler.
+func (s *Storage) ClaimScheduleTick(ctx context.Context, name string, tick time.Time) (bool, error) {
+	const op = "storage.Redis.ClaimScheduleTick"
+
+	claimed, err := claimScheduleTickScript.Run(ctx, s.db, []string{jobsSchedulePrefix + name}, tick.UnixMilli()).Bool()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return claimed, nil
+}
+
+func (s *Storage) removeJob(ctx context.Context, job jobs.Job, dead string) error {
+	keys := []string{jobsQueueKey, jobsDataKey, jobsAttemptsKey, jobsDeadKey}
+	if job.UniqueKey != "" {
+		keys = append(keys, jobsUniquePrefix+job.UniqueKey)
+	}
+
+	return removeJobScript.Run(ctx, s.db, keys, job.ID, dead, deadJobsLimit).Err()
+}
@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// SavePendingLogin keeps a login from a new device until it is confirmed or ttl passes.
+func (s *Storage) SavePendingLogin(ctx context.Context, token string, pending models.PendingLogin, ttl time.Duration) error {
+	const op = "storage.Redis.SavePendingLogin"
+
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.db.Set(ctx, pendingLoginKey(token), data, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TakePendingLogin returns the pending login of the token and removes it, so a token confirms
+// a login once.
+func (s *Storage) TakePendingLogin(ctx context.Context, token string) (*models.PendingLogin, error) {
+	const op = "storage.Redis.TakePendingLogin"
+
+	data, err := s.db.GetDel(ctx, pendingLoginKey(token)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrPendingLoginNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var pending models.PendingLogin
+	if err = json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &pending, nil
+}
+
+func pendingLoginKey(token string) string {
+	return "login:confirm:" + token
+}
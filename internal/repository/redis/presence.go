@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// lastSeenKey is a sorted set of users with heartbeats not yet persisted, scored by the time of
+// their latest heartbeat.
+const lastSeenKey = "presence:last_seen"
+
+// RecordHeartbeat stores the time of the user's latest heartbeat. The entry expires after ttl,
+// after which the user is offline.
+func (s *Storage) RecordHeartbeat(ctx context.Context, userId uuid.UUID, at time.Time, ttl time.Duration) error {
+	const op = "storage.Redis.RecordHeartbeat"
+
+	pipe := s.db.TxPipeline()
+	pipe.Set(ctx, presenceKey(userId), at.Unix(), ttl)
+	pipe.ZAdd(ctx, lastSeenKey, redis.Z{Score: float64(at.Unix()), Member: userId.String()})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// GetHeartbeats returns the latest heartbeat of each of the users that have a live one.
+func (s *Storage) GetHeartbeats(ctx context.Context, userIds []uuid.UUID) (map[uuid.UUID]time.Time, error) {
+	const op = "storage.Redis.GetHeartbeats"
+
+	heartbeats := make(map[uuid.UUID]time.Time, len(userIds))
+	if len(userIds) == 0 {
+		return heartbeats, nil
+	}
+
+	keys := make([]string, 0, len(userIds))
+	for _, id := range userIds {
+		keys = append(keys, presenceKey(id))
+	}
+
+	values, err := s.db.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		unix, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			continue
+		}
+		heartbeats[userIds[i]] = time.Unix(unix, 0)
+	}
+
+	return heartbeats, nil
+}
+
+// DrainLastSeen atomically takes every heartbeat recorded up to before out of the set of
+// heartbeats waiting to be persisted.
+func (s *Storage) DrainLastSeen(ctx context.Context, before time.Time) (map[uuid.UUID]time.Time, error) {
+	const op = "storage.Redis.DrainLastSeen"
+
+	until := strconv.FormatInt(before.Unix(), 10)
+
+	pipe := s.db.TxPipeline()
+	taken := pipe.ZRangeByScoreWithScores(ctx, lastSeenKey, &redis.ZRangeBy{Min: "-inf", Max: until})
+	pipe.ZRemRangeByScore(ctx, lastSeenKey, "-inf", until)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	lastSeen := make(map[uuid.UUID]time.Time, len(taken.Val()))
+	for _, z := range taken.Val() {
+		id, err := uuid.Parse(z.Member.(string))
+		if err != nil {
+			continue
+		}
+		lastSeen[id] = time.Unix(int64(z.Score), 0)
+	}
+
+	return lastSeen, nil
+}
+
+func presenceKey(userId uuid.UUID) string {
+	return "presence:" + userId.String()
+}
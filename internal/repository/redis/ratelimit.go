@@ -0,0 +1,29 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Allow counts a hit against key in a fixed window and reports whether it is within limit.
+// When it is not, it also returns how long until the window resets.
+func (s *Storage) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	const op = "storage.Redis.Allow"
+
+	key = "ratelimit:" + key
+
+	pipe := s.db.TxPipeline()
+	hits := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if hits.Val() > int64(limit) {
+		return false, ttl.Val(), nil
+	}
+
+	return true, 0, nil
+}
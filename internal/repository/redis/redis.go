@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
@@ -12,6 +13,9 @@ import (
 type Storage struct {
 	db         *redis.Client
 	refreshTTL time.Duration
+
+	eventLogSize      int64
+	eventLogRetention time.Duration
 }
 
 // InitRedis инициализирует клиент Redis.
@@ -73,6 +77,62 @@ func RevokeRefreshToken(redisClient *redis.Client, refreshToken string) error {
 	return redisClient.Del(ctx, refreshToken).Err()
 }
 
+// RevokeUserSessions invalidates every token issued to the user up to now. The marker lives as
+// long as a refresh token can, after which no token it guards against can still be valid.
+func (s *Storage) RevokeUserSessions(ctx context.Context, userID string) error {
+	const op = "storage.Redis.RevokeUserSessions"
+
+	err := s.db.Set(ctx, revokedKey(userID), time.Now().UnixMilli(), s.refreshTTL).Err()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// IsSessionRevoked reports whether a token issued at issuedAt was revoked by RevokeUserSessions.
+// Both sides are in milliseconds, so a token issued right after a revocation stays valid.
+func (s *Storage) IsSessionRevoked(ctx context.Context, userID string, issuedAt time.Time) (bool, error) {
+	const op = "storage.Redis.IsSessionRevoked"
+
+	revokedAt, err := s.db.Get(ctx, revokedKey(userID)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return issuedAt.UnixMilli() < revokedAt, nil
+}
+
+func revokedKey(userID string) string {
+	return "sessions:revoked:" + userID
+}
+
+// UseRefreshToken spends the refresh token with the jti tokenID and reports whether it was
+// still unspent. Checking and marking is a single SET NX, so of two requests racing with the
+// same token only one gets a new pair. The mark outlives the token itself.
+func (s *Storage) UseRefreshToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	const op = "storage.Redis.UseRefreshToken"
+
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false, nil
+	}
+
+	ok, err := s.db.SetNX(ctx, usedRefreshKey(tokenID), 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ok, nil
+}
+
+func usedRefreshKey(tokenID string) string {
+	return "sessions:refresh:used:" + tokenID
+}
+
 func (s *Storage) CloseConnection() error {
 	err := s.db.Close()
 	if err != nil {
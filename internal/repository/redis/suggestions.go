@@ -0,0 +1,67 @@
+package redis
+
+import (
+	"boton-back/internal/domain/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// GetFriendSuggestions returns the cached suggestions of the user. ok is false on a cache miss.
+func (s *Storage) GetFriendSuggestions(ctx context.Context, userId uuid.UUID) ([]models.FriendSuggestion, bool, error) {
+	const op = "storage.Redis.GetFriendSuggestions"
+
+	data, err := s.db.Get(ctx, suggestionsKey(userId)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var suggestions []models.FriendSuggestion
+	if err = json.Unmarshal(data, &suggestions); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return suggestions, true, nil
+}
+
+func (s *Storage) SetFriendSuggestions(ctx context.Context, userId uuid.UUID, suggestions []models.FriendSuggestion, ttl time.Duration) error {
+	const op = "storage.Redis.SetFriendSuggestions"
+
+	data, err := json.Marshal(suggestions)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.db.Set(ctx, suggestionsKey(userId), data, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// InvalidateFriendSuggestions drops the cached suggestions of the given users.
+func (s *Storage) InvalidateFriendSuggestions(ctx context.Context, userIds ...uuid.UUID) error {
+	const op = "storage.Redis.InvalidateFriendSuggestions"
+
+	keys := make([]string, 0, len(userIds))
+	for _, id := range userIds {
+		keys = append(keys, suggestionsKey(id))
+	}
+
+	if err := s.db.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func suggestionsKey(userId uuid.UUID) string {
+	return "friends:suggestions:" + userId.String()
+}
@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// SaveEmailVerification keeps a verification of an address until it is used or ttl passes.
+func (s *Storage) SaveEmailVerification(ctx context.Context, token string, verification models.EmailVerification, ttl time.Duration) error {
+	const op = "storage.Redis.SaveEmailVerification"
+
+	data, err := json.Marshal(verification)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.db.Set(ctx, emailVerificationKey(token), data, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// TakeEmailVerification returns the verification of the token and removes it.
+func (s *Storage) TakeEmailVerification(ctx context.Context, token string) (*models.EmailVerification, error) {
+	const op = "storage.Redis.TakeEmailVerification"
+
+	data, err := s.db.GetDel(ctx, emailVerificationKey(token)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("%s: %w", op, repository.ErrVerificationNotFound)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var verification models.EmailVerification
+	if err = json.Unmarshal(data, &verification); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &verification, nil
+}
+
+func emailVerificationKey(token string) string {
+	return "email:verify:" + token
+}
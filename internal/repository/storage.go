@@ -5,10 +5,43 @@ import (
 )
 
 var (
-	ErrUserNotFound      = errors.New("user not found")
-	ErrUserAlreadyExists = errors.New("user already exists")
-	ErrNoActiveSession   = errors.New("user already logged out")
-	ErrEmailAlreadyTaken = errors.New("email already taken")
-	ErrWrongEmail        = errors.New("wrong email")
-	ErrWrongPassword     = errors.New("wrong password")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrUserAlreadyExists     = errors.New("user already exists")
+	ErrNoActiveSession       = errors.New("user already logged out")
+	ErrEmailAlreadyTaken     = errors.New("email already taken")
+	ErrWrongEmail            = errors.New("wrong email")
+	ErrWrongPassword         = errors.New("wrong password")
+	ErrExportNotFound        = errors.New("export not found")
+	ErrExportInProgress      = errors.New("export already in progress")
+	ErrProfileNotFound       = errors.New("profile not found")
+	ErrAvatarNotFound        = errors.New("avatar not found")
+	ErrFriendshipExists      = errors.New("friendship or request already exists")
+	ErrFriendshipNotFound    = errors.New("friendship not found")
+	ErrBlockNotFound         = errors.New("user is not blocked")
+	ErrNotificationNotFound  = errors.New("notification not found")
+	ErrConversationNotFound  = errors.New("conversation not found")
+	ErrMessageNotFound       = errors.New("message not found")
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrDeliveryNotFound      = errors.New("webhook delivery not found")
+	ErrDeviceNotFound        = errors.New("device not found")
+	ErrPendingLoginNotFound  = errors.New("login confirmation not found or expired")
+	ErrInviteNotFound        = errors.New("invite not found")
+	ErrInviteQuotaExceeded   = errors.New("invite quota exceeded")
+	ErrVerificationNotFound  = errors.New("email verification not found or expired")
+	ErrReferralNotFound      = errors.New("referral not found")
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was already used for another request")
+	ErrTransferNotFound      = errors.New("transfer not found")
+	ErrTransferLimitReached  = errors.New("daily transfer limit reached")
+	ErrTransferNotAllowed    = errors.New("transfer not allowed between these users")
+	ErrPromoCodeNotFound     = errors.New("promo code not found")
+	ErrPromoCodeTaken        = errors.New("promo code already exists")
+	ErrPromoCodeExhausted    = errors.New("promo code is no longer available")
+	ErrPromoUserLimitReached = errors.New("promo code already redeemed")
+	ErrPromoNotEligible      = errors.New("promo code is for new users only")
+	ErrDiscountAlreadyActive = errors.New("another discount is already active")
+	ErrDiscountNotFound      = errors.New("no active discount")
+	ErrPaymentNotFound       = errors.New("payment not found")
+	ErrPaymentMismatch       = errors.New("payment event does not match the payment")
+	ErrPaymentEventEarly     = errors.New("payment event came before the payment succeeded")
 )
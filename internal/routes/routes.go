@@ -2,13 +2,49 @@ package routes
 
 import (
 	"boton-back/internal/handlers"
+	"boton-back/internal/lib/cookies"
 	"boton-back/internal/middlewares"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"time"
 )
 
-func InitRoutes(authHandler *handlers.AuthHandler, userHandler *handlers.UserHandler, authMiddleware *middlewares.AuthMiddleware) *gin.Engine {
+type Handlers struct {
+	Auth         *handlers.AuthHandler
+	User         *handlers.UserHandler
+	Account      *handlers.AccountHandler
+	Export       *handlers.ExportHandler
+	Avatar       *handlers.AvatarHandler
+	Friend       *handlers.FriendHandler
+	Privacy      *handlers.PrivacyHandler
+	Presence     *handlers.PresenceHandler
+	Realtime     *handlers.RealtimeHandler
+	Notification *handlers.NotificationHandler
+	Message      *handlers.MessageHandler
+	Webhook      *handlers.WebhookHandler
+	Device       *handlers.DeviceHandler
+	Invite       *handlers.InviteHandler
+	Verification *handlers.VerificationHandler
+	Referral     *handlers.ReferralHandler
+	Ledger       *handlers.LedgerHandler
+	Transfer     *handlers.TransferHandler
+	Promotion    *handlers.PromotionHandler
+	// Payment is only set with a payment provider configured
+	Payment *handlers.PaymentHandler
+	// DevMail is only set in the local environment
+	DevMail *handlers.DevMailHandler
+	// DevPayment is only set in the local environment with the fake payment provider
+	DevPayment *handlers.DevPaymentHandler
+}
+
+type Middlewares struct {
+	Auth            *middlewares.AuthMiddleware
+	CSRF            *middlewares.CSRFMiddleware
+	SearchRateLimit *middlewares.RateLimitMiddleware
+	Admin           *middlewares.AdminMiddleware
+}
+
+func InitRoutes(h Handlers, m Middlewares) *gin.Engine {
 	r := gin.Default()
 
 	_ = r.SetTrustedProxies(nil)
@@ -16,13 +52,14 @@ func InitRoutes(authHandler *handlers.AuthHandler, userHandler *handlers.UserHan
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:8080"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", cookies.CSRFHeaderName},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	api := r.Group("/api")
+	api.Use(m.CSRF.Handle())
 	{
 		api.GET("/ping", func(c *gin.Context) {
 			c.JSON(200, gin.H{
@@ -32,16 +69,204 @@ func InitRoutes(authHandler *handlers.AuthHandler, userHandler *handlers.UserHan
 
 		auth := api.Group("/auth")
 		{
-			auth.POST("/register", authHandler.Register)
-			auth.POST("/sign-in", authHandler.Login)
-			//auth.POST("/refresh", accountHandler.RefreshToken)
-			auth.PATCH("/email", authHandler.UpdateUserEmail)
-			auth.PATCH("/password", authHandler.UpdateUserPassword)
+			auth.POST("/register", h.Auth.Register)
+			auth.POST("/sign-in", h.Auth.Login)
+			auth.POST("/confirm-login", h.Auth.ConfirmLogin)
+			auth.POST("/verify-email", h.Verification.Verify)
+			auth.POST("/refresh", h.Auth.Refresh)
+			auth.POST("/logout", h.Auth.Logout)
+			auth.PATCH("/email", h.Auth.UpdateUserEmail)
+			auth.PATCH("/password", h.Auth.UpdateUserPassword)
+		}
+
+		// signed links carry their own authorization
+		api.GET("/exports/:id/download", h.Export.Download)
+		api.GET("/avatars/:user_id/:version/:size", h.Avatar.Serve)
+		// providers sign their webhooks instead
+		if h.Payment != nil {
+			api.POST("/payments/webhooks/:provider", h.Payment.Webhook)
+		}
+
+		api.Use(m.Auth.Handle())
+		{
+			api.GET("/ws", h.Realtime.WebSocket)
+			api.GET("/events", h.Realtime.Events)
+
+			api.GET("/me", h.User.GetUser)
+			api.DELETE("/me", h.Account.DeleteAccount)
+			api.POST("/me/export", h.Export.RequestExport)
+			api.GET("/me/export/:id", h.Export.GetExport)
+			api.GET("/me/profile", h.User.GetProfile)
+			api.PATCH("/me/profile", h.User.UpdateProfile)
+			api.PUT("/me/avatar", h.Avatar.Upload)
+			api.DELETE("/me/avatar", h.Avatar.Delete)
+			api.GET("/me/privacy", h.Privacy.GetSettings)
+			api.PATCH("/me/privacy", h.Privacy.UpdateSettings)
+			api.GET("/me/devices", h.Device.List)
+			api.DELETE("/me/devices/:id", h.Device.Forget)
+			api.POST("/me/email/verification", h.Verification.Resend)
+			api.GET("/me/balance", h.Ledger.Balance)
+			api.GET("/me/transactions", h.Ledger.History)
+
+			referrals := api.Group("/referrals")
+			{
+				referrals.GET("", h.Referral.Summary)
+				referrals.GET("/referees", h.Referral.List)
+			}
+
+			transfers := api.Group("/transfers")
+			{
+				transfers.GET("", h.Transfer.List)
+				transfers.POST("", h.Transfer.Send)
+				transfers.GET("/:id", h.Transfer.Get)
+			}
+
+			api.POST("/promotions/redeem", h.Promotion.Redeem)
+
+			if h.Payment != nil {
+				payments := api.Group("/payments")
+				{
+					payments.GET("", h.Payment.List)
+					payments.POST("", h.Payment.TopUp)
+					payments.GET("/:id", h.Payment.Get)
+				}
+			}
+
+			users := api.Group("/users")
+			{
+				users.GET("/search", m.SearchRateLimit.Handle(), h.User.SearchUsers)
+				users.GET("/:username", h.User.GetPublicProfile)
+				users.GET("/:username/friends", h.Friend.ListUserFriends)
+				users.GET("/:username/mutual-friends", h.Friend.ListMutualFriends)
+			}
+
+			presence := api.Group("/presence")
+			{
+				presence.GET("", h.Presence.GetPresence)
+				presence.POST("/heartbeat", h.Presence.Heartbeat)
+			}
+
+			blocks := api.Group("/blocks")
+			{
+				blocks.GET("", h.Privacy.ListBlocked)
+				blocks.POST("", h.Privacy.Block)
+				blocks.DELETE("/:user_id", h.Privacy.Unblock)
+			}
+
+			notifications := api.Group("/notifications")
+			{
+				notifications.GET("", h.Notification.List)
+				notifications.POST("/:id/read", h.Notification.MarkRead)
+				notifications.POST("/read-all", h.Notification.MarkAllRead)
+				notifications.GET("/preferences", h.Notification.GetPreferences)
+				notifications.PATCH("/preferences", h.Notification.UpdatePreferences)
+			}
+
+			conversations := api.Group("/conversations")
+			{
+				conversations.GET("", h.Message.ListConversations)
+				conversations.POST("", h.Message.StartConversation)
+				conversations.GET("/:id/messages", h.Message.ListMessages)
+				conversations.POST("/:id/messages", h.Message.Send)
+				conversations.POST("/:id/read", h.Message.MarkRead)
+				conversations.PUT("/:id/mute", h.Message.Mute)
+				conversations.DELETE("/:id/mute", h.Message.Unmute)
+			}
+
+			messages := api.Group("/messages")
+			{
+				messages.PATCH("/:id", h.Message.Edit)
+				messages.DELETE("/:id", h.Message.Delete)
+			}
+
+			invites := api.Group("/invites")
+			{
+				invites.GET("", h.Invite.List)
+				invites.POST("", h.Invite.Create)
+				invites.GET("/:id", h.Invite.Get)
+				invites.DELETE("/:id", h.Invite.Revoke)
+			}
+
+			friends := api.Group("/friends")
+			{
+				friends.GET("", h.Friend.ListFriends)
+				friends.GET("/suggestions", h.Friend.Suggestions)
+				friends.DELETE("/:user_id", h.Friend.Unfriend)
+				friends.GET("/requests/incoming", h.Friend.ListIncomingRequests)
+				friends.GET("/requests/outgoing", h.Friend.ListOutgoingRequests)
+				friends.POST("/requests", h.Friend.SendRequest)
+				friends.POST("/requests/:id/accept", h.Friend.AcceptRequest)
+				friends.POST("/requests/:id/decline", h.Friend.DeclineRequest)
+				friends.DELETE("/requests/:id", h.Friend.CancelRequest)
+			}
+		}
+	}
+
+	// operator endpoints, authorized by the admin API key rather than a user session
+	admin := r.Group("/admin")
+	admin.Use(m.Admin.Handle())
+	{
+		webhooks := admin.Group("/webhooks")
+		{
+			webhooks.GET("", h.Webhook.List)
+			webhooks.POST("", h.Webhook.Create)
+			webhooks.GET("/:id", h.Webhook.Get)
+			webhooks.PATCH("/:id", h.Webhook.Update)
+			webhooks.POST("/:id/secret", h.Webhook.RotateSecret)
+			webhooks.DELETE("/:id", h.Webhook.Delete)
+			webhooks.GET("/:id/deliveries", h.Webhook.ListDeliveries)
+			webhooks.GET("/deliveries/:id", h.Webhook.GetDelivery)
+			webhooks.POST("/deliveries/:id/redeliver", h.Webhook.Redeliver)
+		}
+
+		invites := admin.Group("/invites")
+		{
+			invites.GET("", h.Invite.AdminList)
+			invites.POST("", h.Invite.AdminCreate)
+			invites.GET("/:id", h.Invite.AdminGet)
+			invites.DELETE("/:id", h.Invite.AdminRevoke)
+		}
+
+		admin.POST("/users/:id/adjustments", h.Ledger.AdminAdjust)
+
+		transfers := admin.Group("/transfers")
+		{
+			transfers.GET("/:id", h.Transfer.AdminGet)
+			transfers.POST("/:id/reverse", h.Transfer.AdminReverse)
+		}
+
+		promotions := admin.Group("/promotions")
+		{
+			promotions.GET("", h.Promotion.AdminList)
+			promotions.POST("", h.Promotion.AdminCreate)
+			promotions.GET("/:id", h.Promotion.AdminGet)
+			promotions.GET("/:id/redemptions", h.Promotion.AdminRedemptions)
+			promotions.DELETE("/:id", h.Promotion.AdminDisable)
+		}
+
+		if h.Payment != nil {
+			payments := admin.Group("/payments")
+			{
+				payments.GET("/:id", h.Payment.AdminGet)
+				payments.POST("/:id/refund", h.Payment.AdminRefund)
+			}
 		}
+	}
+
+	if h.DevMail != nil {
+		devMail := r.Group("/dev/mail")
+		{
+			devMail.GET("", h.DevMail.List)
+			devMail.GET("/:id", h.DevMail.Get)
+			devMail.GET("/:id/html", h.DevMail.Preview)
+		}
+	}
 
-		api.Use(authMiddleware.Handle())
+	if h.DevPayment != nil {
+		devPayments := r.Group("/dev/payments")
 		{
-			api.GET("/me", userHandler.GetUser)
+			devPayments.GET("/:id", h.DevPayment.Checkout)
+			devPayments.POST("/:id/events", h.DevPayment.Simulate)
 		}
 	}
 
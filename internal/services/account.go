@@ -0,0 +1,137 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"log/slog"
+	"time"
+)
+
+// purgeBatchSize bounds how many accounts a single purge run removes.
+const purgeBatchSize = 100
+
+type AccountService struct {
+	log               *slog.Logger
+	accountRepository AccountRepository
+	sessions          SessionRevoker
+	events            EventPublisher
+	purgers           []UserDataPurger
+	gracePeriod       time.Duration
+}
+
+type AccountRepository interface {
+	CheckUserByPassword(ctx context.Context, userId, password string) (string, error)
+	SoftDeleteUser(ctx context.Context, userId uuid.UUID, purgeAfter time.Time) error
+	UsersDueForPurge(ctx context.Context, t time.Time, limit int) ([]uuid.UUID, error)
+	PurgeUser(ctx context.Context, userId uuid.UUID) error
+}
+
+type SessionRevoker interface {
+	RevokeUserSessions(ctx context.Context, userID string) error
+}
+
+// UserDataPurger removes user data kept outside postgres, e.g. files in a blob store,
+// before the account itself is purged.
+type UserDataPurger interface {
+	PurgeUserData(ctx context.Context, userId uuid.UUID) error
+}
+
+// NewAccountService returns a new instance of the Account service
+func NewAccountService(log *slog.Logger, accountRepository AccountRepository, sessions SessionRevoker, events EventPublisher, gracePeriod time.Duration, purgers ...UserDataPurger) *AccountService {
+	return &AccountService{
+		log:               log,
+		accountRepository: accountRepository,
+		sessions:          sessions,
+		events:            events,
+		purgers:           purgers,
+		gracePeriod:       gracePeriod,
+	}
+}
+
+// DeleteAccount re-authenticates the user with their password, soft-deletes the account and
+// revokes all of its sessions. It returns the moment after which the account is purged for good;
+// logging in before that restores it.
+func (s *AccountService) DeleteAccount(ctx context.Context, userId uuid.UUID, password string) (time.Time, error) {
+	const op = "account.DeleteAccount"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	if password == "" {
+		return time.Time{}, fmt.Errorf("%s: %w", op, ErrEmptyField)
+	}
+
+	passHash, err := s.accountRepository.CheckUserByPassword(ctx, userId.String(), password)
+	if err != nil {
+		if errors.Is(err, repository.ErrWrongPassword) {
+			return time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+		log.Error("failed to get user", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(passHash), []byte(password)); err != nil {
+		log.Info("invalid credentials")
+		return time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	purgeAfter := time.Now().Add(s.gracePeriod)
+
+	if err = s.accountRepository.SoftDeleteUser(ctx, userId, purgeAfter); err != nil {
+		log.Error("failed to delete user", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.sessions.RevokeUserSessions(ctx, userId.String()); err != nil {
+		log.Error("failed to revoke sessions", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	publishEvent(ctx, log, s.events, userId, models.EventSessionRevoked, models.SessionRevokedData{})
+
+	log.Info("account deleted", slog.Time("purge_after", purgeAfter))
+
+	return purgeAfter, nil
+}
+
+// PurgeDeletedAccounts hard-deletes accounts whose grace period is over.
+func (s *AccountService) PurgeDeletedAccounts(ctx context.Context) error {
+	const op = "account.PurgeDeletedAccounts"
+
+	log := s.log.With(slog.String("op", op))
+
+	ids, err := s.accountRepository.UsersDueForPurge(ctx, time.Now(), purgeBatchSize)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, id := range ids {
+		// an account that keeps failing must not hold up the ones due after it
+		if err = s.purge(ctx, id); err != nil {
+			log.Error("failed to purge account", slog.String("user_id", id.String()), sl.Err(err))
+			continue
+		}
+
+		log.Info("account purged", slog.String("user_id", id.String()))
+	}
+
+	return nil
+}
+
+func (s *AccountService) purge(ctx context.Context, userId uuid.UUID) error {
+	for _, purger := range s.purgers {
+		if err := purger.PurgeUserData(ctx, userId); err != nil {
+			return err
+		}
+	}
+
+	return s.accountRepository.PurgeUser(ctx, userId)
+}
@@ -2,7 +2,8 @@ package services
 
 import (
 	"boton-back/internal/domain/models"
-	_ "boton-back/internal/lib/jwt"
+	"boton-back/internal/lib/jwt"
+	"boton-back/internal/lib/logger/sl"
 	"boton-back/internal/repository"
 	"context"
 	"errors"
@@ -11,42 +12,93 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"log/slog"
 	"regexp"
+	"strings"
 	"time"
 )
 
 var (
-	ErrEmptyField       = errors.New("all fields must be filled")
-	ErrInvalidEmail     = errors.New("email is invalid")
-	ErrLoginTooShort    = errors.New("login must be at least 3 characters")
-	ErrPasswordTooShort = errors.New("password must be at least 8 characters")
+	ErrEmptyField         = errors.New("all fields must be filled")
+	ErrInvalidEmail       = errors.New("email is invalid")
+	ErrLoginTooShort      = errors.New("login must be at least 3 characters")
+	ErrPasswordTooShort   = errors.New("password must be at least 8 characters")
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrInviteRequired     = errors.New("an invite code is required to register")
+	ErrInvalidInvite      = errors.New("invite code is invalid, expired or used up")
+)
+
+// Username policies for deleted accounts: a reserved username can never be registered again,
+// a released one is free as soon as the account is deleted.
+const (
+	UsernamePolicyReserve = "reserve"
+	UsernamePolicyRelease = "release"
+)
+
+// Registration modes: anyone can register, only people with an invite can, or nobody can.
+const (
+	RegistrationModeOpen   = "open"
+	RegistrationModeInvite = "invite"
+	RegistrationModeClosed = "closed"
 )
 
 type AuthService struct {
-	log            *slog.Logger
-	redisDB        RedisClient
-	authRepository AuthRepository
-	tokenTTL       time.Duration
-	jwtGenerator   JwtGenerator
+	log              *slog.Logger
+	redisDB          RedisClient
+	authRepository   AuthRepository
+	tokenTTL         time.Duration
+	jwtGenerator     JwtGenerator
+	events           EventPublisher
+	notifier         Notifier
+	devices          LoginGuard
+	referrals        Referrals
+	usernamePolicy   string
+	registrationMode string
 }
 
 type JwtGenerator interface {
 	GeneratePair(id uuid.UUID) (accessToken string, refreshToken string, err error)
+	GenerateSessionPair(id uuid.UUID, sessionID string) (accessToken string, refreshToken string, err error)
 	ParseToken(tokenString string) (string, error)
+	ParseRefreshClaims(tokenString string) (*jwt.Claims, error)
 }
 
 type AuthRepository interface {
-	SaveUser(ctx context.Context, login, email string, password []byte) error
+	SaveUser(ctx context.Context, login, email string, password []byte, inviteCode string) (uuid.UUID, error)
 	LoginUser(ctx context.Context, inputType, input string) (*models.User, error)
+	GetLoginUser(ctx context.Context, userId uuid.UUID) (*models.User, error)
 	CheckUsernameIsAvailable(ctx context.Context, login string) (bool, error)
+	CheckActiveUsernameIsAvailable(ctx context.Context, login string) (bool, error)
 	CheckEmailIsAvailable(ctx context.Context, email string) (bool, error)
 	CheckUserByEmail(ctx context.Context, userId, email string) error
 	CheckUserByPassword(ctx context.Context, userId, password string) (string, error)
 	UpdateEmail(ctx context.Context, userId, email string) error
 	UpdatePassword(ctx context.Context, userId, password string) error
+	RestoreUser(ctx context.Context, userId uuid.UUID, fallbackUsername string) (string, error)
+}
+
+// LoginGuard checks where logins come from.
+type LoginGuard interface {
+	CheckLogin(ctx context.Context, userId uuid.UUID, client models.LoginClient) error
+	ConfirmLogin(ctx context.Context, token string) (uuid.UUID, error)
+}
+
+// Referrals records who referred new users.
+type Referrals interface {
+	ResolveCode(ctx context.Context, code string) (*models.Referrer, error)
+	Refer(ctx context.Context, referrer *models.Referrer, refereeId uuid.UUID, email string, client models.LoginClient) error
+}
+
+// RegisterOptions are the optional parts of a registration.
+type RegisterOptions struct {
+	InviteCode   string
+	ReferralCode string
+	// Client is where the registration comes from, to detect self-referrals.
+	Client models.LoginClient
 }
 
 type RedisClient interface {
 	StoreRefreshToken(userID string) (string, error)
+	IsSessionRevoked(ctx context.Context, userID string, issuedAt time.Time) (bool, error)
+	UseRefreshToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error)
 	CloseConnection() error
 }
 
@@ -58,20 +110,40 @@ var (
 	ErrUsernameAlreadyTaken = errors.New("this username already taken")
 )
 
-func NewAuthService(log *slog.Logger, jwtGenerator JwtGenerator, authRepository AuthRepository, redisDB RedisClient) *AuthService {
+func NewAuthService(log *slog.Logger, jwtGenerator JwtGenerator, authRepository AuthRepository, redisDB RedisClient, events EventPublisher, notifier Notifier, devices LoginGuard, referrals Referrals, usernamePolicy, registrationMode string) *AuthService {
 	return &AuthService{
-		log:            log,
-		jwtGenerator:   jwtGenerator,
-		redisDB:        redisDB,
-		authRepository: authRepository,
+		log:              log,
+		jwtGenerator:     jwtGenerator,
+		redisDB:          redisDB,
+		authRepository:   authRepository,
+		events:           events,
+		notifier:         notifier,
+		devices:          devices,
+		referrals:        referrals,
+		usernamePolicy:   usernamePolicy,
+		registrationMode: registrationMode,
 	}
 }
 
-func (s *AuthService) Register(ctx context.Context, login, email, password string) error {
+// Register creates an account. While registration is invite-only, opts must carry a usable
+// invite; in open mode an invite is optional and only recorded. A referral code credits its
+// owner once the new user qualifies.
+func (s *AuthService) Register(ctx context.Context, login, email, password string, opts RegisterOptions) error {
 	const op = "auth.Register"
 
 	log := s.log.With(slog.String("op", op), slog.String("email", email))
 
+	inviteCode := normalizeCode(opts.InviteCode)
+
+	switch s.registrationMode {
+	case RegistrationModeClosed:
+		return fmt.Errorf("%s: %w", op, ErrRegistrationClosed)
+	case RegistrationModeInvite:
+		if inviteCode == "" {
+			return fmt.Errorf("%s: %w", op, ErrInviteRequired)
+		}
+	}
+
 	if err := checkRegister(login, email, password); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -80,9 +152,14 @@ func (s *AuthService) Register(ctx context.Context, login, email, password strin
 		return err
 	}
 
-	usernameAvailable, err := s.authRepository.CheckUsernameIsAvailable(ctx, login)
+	checkUsername := s.authRepository.CheckUsernameIsAvailable
+	if s.usernamePolicy == UsernamePolicyRelease {
+		checkUsername = s.authRepository.CheckActiveUsernameIsAvailable
+	}
+
+	usernameAvailable, err := checkUsername(ctx, login)
 	if err != nil {
-		log.Error("failed to check username availability", err)
+		log.Error("failed to check username availability", sl.Err(err))
 		return fmt.Errorf("%s: failed to check username: %w", op, err)
 	}
 
@@ -96,7 +173,7 @@ func (s *AuthService) Register(ctx context.Context, login, email, password strin
 
 	emailAvailable, err := s.authRepository.CheckEmailIsAvailable(ctx, email)
 	if err != nil {
-		log.Error("failed to check email availability", err)
+		log.Error("failed to check email availability", sl.Err(err))
 		return fmt.Errorf("%s: failed to check email: %w", op, err)
 	}
 
@@ -104,30 +181,56 @@ func (s *AuthService) Register(ctx context.Context, login, email, password strin
 		return fmt.Errorf("%s: %w", op, ErrEmailAlreadyTaken)
 	}
 
+	var referrer *models.Referrer
+	if opts.ReferralCode != "" {
+		if referrer, err = s.referrals.ResolveCode(ctx, opts.ReferralCode); err != nil {
+			if !errors.Is(err, ErrInvalidReferralCode) {
+				log.Error("failed to resolve referral code", sl.Err(err))
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
 	log.Info("registering new user")
 
 	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Error("failed to hash password", err)
+		log.Error("failed to hash password", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
 	}
 
 	log.Info("password hash created")
 
-	if err := s.authRepository.SaveUser(ctx, login, email, passHash); err != nil {
+	userId, err := s.authRepository.SaveUser(ctx, login, email, passHash, inviteCode)
+	if err != nil {
+		if errors.Is(err, repository.ErrInviteNotFound) {
+			log.Info("invalid invite code")
+			return fmt.Errorf("%s: %w", op, ErrInvalidInvite)
+		}
 		if errors.Is(err, repository.ErrUserAlreadyExists) {
-			log.Warn("user already exists", err)
+			log.Warn("user already exists", sl.Err(err))
 			return fmt.Errorf("%s: %w", op, err)
 		}
-		log.Error("failed to save user", err)
+		log.Error("failed to save user", sl.Err(err))
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("user registered", "id")
+	log.Info("user registered")
+
+	// a referral that cannot be recorded must not fail the registration that already happened
+	if referrer != nil {
+		if err = s.referrals.Refer(ctx, referrer, userId, email, opts.Client); err != nil {
+			log.Warn("failed to record referral", sl.Err(err))
+		}
+	}
+
 	return nil
 }
 
-func (s *AuthService) Login(ctx context.Context, input, password string) (string, string, error) {
+// Login checks the credentials and the device of the client. A login from a new device may
+// need to be confirmed by email first, in which case it fails with
+// ErrLoginConfirmationRequired and ConfirmLogin finishes it.
+func (s *AuthService) Login(ctx context.Context, input, password string, client models.LoginClient) (string, string, error) {
 	const op = "auth.Login"
 
 	log := s.log.With(
@@ -146,30 +249,170 @@ func (s *AuthService) Login(ctx context.Context, input, password string) (string
 	user, err := s.authRepository.LoginUser(ctx, inputType, input)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
-			s.log.Warn("user not found", err)
+			log.Warn("user not found", sl.Err(err))
 
 			return "", "", fmt.Errorf("%s: %w", op, err)
 		}
 
-		s.log.Error("failed to get user", err)
+		log.Error("failed to get user", sl.Err(err))
 
 		return "", "", fmt.Errorf("%s: %w", op, err)
 	}
 
 	if err = bcrypt.CompareHashAndPassword(user.Password, []byte(password)); err != nil {
-		s.log.Info("invalid credentials", err)
+		log.Info("invalid credentials", sl.Err(err))
+
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
 
+	if isPurged(user) {
+		log.Info("login into purged account")
 		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
 	}
 
+	if err = s.devices.CheckLogin(ctx, user.ID, client); err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// a deleted account comes back only once the device of the login checked out
+	if user.DeletedAt != nil {
+		if err = s.restoreUser(ctx, log, user); err != nil {
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
 	accessToken, refreshToken, err := s.jwtGenerator.GeneratePair(user.ID)
 	if err != nil {
-		s.log.Error("failed to generate access token", err)
+		log.Error("failed to generate access token", sl.Err(err))
 		return "", "", fmt.Errorf("%s: %w", op, err)
 	}
 	return accessToken, refreshToken, nil
 }
 
+// ConfirmLogin finishes a login held for confirmation with the token emailed to the user.
+func (s *AuthService) ConfirmLogin(ctx context.Context, token string) (string, string, error) {
+	const op = "auth.ConfirmLogin"
+
+	log := s.log.With(slog.String("op", op))
+
+	userID, err := s.devices.ConfirmLogin(ctx, token)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := s.authRepository.GetLoginUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			log.Info("confirmed login into purged account")
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+		log.Error("failed to get user", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.DeletedAt != nil {
+		if err = s.restoreUser(ctx, log, user); err != nil {
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	accessToken, refreshToken, err := s.jwtGenerator.GeneratePair(userID)
+	if err != nil {
+		log.Error("failed to generate access token", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	return accessToken, refreshToken, nil
+}
+
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (string, string, error) {
+	const op = "auth.Refresh"
+
+	log := s.log.With(slog.String("op", op))
+
+	if refreshToken == "" {
+		return "", "", fmt.Errorf("%s: %w", op, ErrEmptyField)
+	}
+
+	if err := s.checkContext(ctx, op); err != nil {
+		return "", "", err
+	}
+
+	claims, err := s.jwtGenerator.ParseRefreshClaims(refreshToken)
+	if err != nil {
+		log.Info("invalid refresh token", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	revoked, err := s.redisDB.IsSessionRevoked(ctx, claims.UserID, claims.IssuedAt)
+	if err != nil {
+		log.Error("failed to check session", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if revoked || claims.ID == "" {
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	// every refresh token is good for one refresh, so a stolen copy dies once either side uses it
+	unused, err := s.redisDB.UseRefreshToken(ctx, claims.ID, claims.ExpiresAt)
+	if err != nil {
+		log.Error("failed to spend refresh token", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !unused {
+		log.Warn("refresh token reused", slog.String("user_id", claims.UserID))
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	sessionID := claims.SessionID
+	if sessionID == "" {
+		sessionID = uuid.NewString()
+	}
+
+	accessToken, newRefreshToken, err := s.jwtGenerator.GenerateSessionPair(userID, sessionID)
+	if err != nil {
+		log.Error("failed to generate token pair", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return accessToken, newRefreshToken, nil
+}
+
+// Logout spends refreshToken and ends its session on connected real-time clients. An invalid
+// or expired token has nothing left to end and is ignored.
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) {
+	const op = "auth.Logout"
+
+	claims, err := s.jwtGenerator.ParseRefreshClaims(refreshToken)
+	if err != nil {
+		return
+	}
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", claims.UserID),
+	)
+
+	if claims.ID != "" {
+		if _, err = s.redisDB.UseRefreshToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+			log.Warn("failed to spend refresh token", sl.Err(err))
+		}
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil || claims.SessionID == "" {
+		return
+	}
+
+	publishEvent(ctx, log, s.events, userID, models.EventSessionRevoked, models.SessionRevokedData{SessionID: claims.SessionID})
+}
+
 func (s *AuthService) UpdateUserEmail(ctx context.Context, userId, oldEmail, newEmail string) (string, error) {
 	const op = "auth.UpdateUserEmail"
 
@@ -191,23 +434,25 @@ func (s *AuthService) UpdateUserEmail(ctx context.Context, userId, oldEmail, new
 	err := s.authRepository.CheckUserByEmail(ctx, userId, oldEmail)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
-			s.log.Warn("user not found", err)
+			log.Warn("user not found", sl.Err(err))
 
 			return "", fmt.Errorf("%s: %w", op, err)
 		}
 
-		s.log.Error("failed to get user", err)
+		log.Error("failed to get user", sl.Err(err))
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
 	err = s.authRepository.UpdateEmail(ctx, userId, newEmail)
 	if err != nil {
-		s.log.Error("failed to update user email", err)
+		log.Error("failed to update user email", sl.Err(err))
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
+	s.securityAlert(ctx, log, userId, models.SecurityAlertEmailChanged)
+
 	return "email updated successfully", nil
 }
 
@@ -234,12 +479,12 @@ func (s *AuthService) UpdateUserPassword(ctx context.Context, userId, oldPasswor
 	password, err := s.authRepository.CheckUserByPassword(ctx, userId, oldPassword)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
-			s.log.Warn("user not found", err)
+			log.Warn("user not found", sl.Err(err))
 
 			return "", fmt.Errorf("%s: %w", op, err)
 		}
 
-		s.log.Error("failed to get user", err)
+		log.Error("failed to get user", sl.Err(err))
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
@@ -248,7 +493,7 @@ func (s *AuthService) UpdateUserPassword(ctx context.Context, userId, oldPasswor
 
 	err = bcrypt.CompareHashAndPassword([]byte(password), []byte(oldPassword))
 	if err != nil {
-		s.log.Info("invalid credentials", err)
+		log.Info("invalid credentials", sl.Err(err))
 
 		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
 	}
@@ -257,7 +502,7 @@ func (s *AuthService) UpdateUserPassword(ctx context.Context, userId, oldPasswor
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
-		s.log.Error("failed to hash password", err)
+		log.Error("failed to hash password", sl.Err(err))
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
@@ -266,14 +511,57 @@ func (s *AuthService) UpdateUserPassword(ctx context.Context, userId, oldPasswor
 
 	err = s.authRepository.UpdatePassword(ctx, userId, string(hashedPassword))
 	if err != nil {
-		s.log.Error("failed to update user password", err)
+		log.Error("failed to update user password", sl.Err(err))
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
+	s.securityAlert(ctx, log, userId, models.SecurityAlertPasswordChanged)
+
 	return "password updated successfully", nil
 }
 
+// securityAlert notifies the user that their credentials changed.
+func (s *AuthService) securityAlert(ctx context.Context, log *slog.Logger, userId, reason string) {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		log.Warn("failed to parse user id for security alert", sl.Err(err))
+		return
+	}
+
+	notify(ctx, log, s.notifier, id, models.NotificationSecurityAlert, models.SecurityAlertData{Reason: reason})
+}
+
+// restoreUser brings back a soft-deleted account on login, unless its grace period is over.
+func (s *AuthService) restoreUser(ctx context.Context, log *slog.Logger, user *models.User) error {
+	if isPurged(user) {
+		log.Info("login into purged account")
+		return ErrInvalidCredentials
+	}
+
+	fallbackUsername := "user_" + strings.ReplaceAll(user.ID.String(), "-", "")[:12]
+
+	username, err := s.authRepository.RestoreUser(ctx, user.ID, fallbackUsername)
+	if err != nil {
+		log.Error("failed to restore user", sl.Err(err))
+		return err
+	}
+
+	log.Info("account restored", slog.String("username", username))
+
+	user.Username = username
+	user.DeletedAt = nil
+	user.PurgeAfter = nil
+
+	return nil
+}
+
+// isPurged reports whether the grace period of a deleted account is over, so it can no longer
+// be restored.
+func isPurged(user *models.User) bool {
+	return user.DeletedAt != nil && user.PurgeAfter != nil && time.Now().After(*user.PurgeAfter)
+}
+
 func (s *AuthService) checkContext(ctx context.Context, op string) error {
 	if ctx.Err() != nil {
 		return fmt.Errorf("%s: context canceled: %w", op, ctx.Err())
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/blob"
+	"boton-back/internal/lib/images"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+)
+
+// AvatarSizes are the square renditions generated for every avatar, in pixels.
+var AvatarSizes = []int{64, 128, 256, 512}
+
+// profileAvatarSize is the rendition the profile avatar url points at.
+const profileAvatarSize = 256
+
+var (
+	ErrAvatarTooLarge      = errors.New("avatar file is too large")
+	ErrAvatarSizeNotExists = errors.New("avatar size does not exist")
+)
+
+type AvatarService struct {
+	log              *slog.Logger
+	avatarRepository AvatarRepository
+	blobs            blob.Store
+	maxBytes         int64
+}
+
+type AvatarRepository interface {
+	GetAvatar(ctx context.Context, userId uuid.UUID) (*models.Avatar, error)
+	SaveAvatar(ctx context.Context, avatar *models.Avatar, avatarURL string) error
+	DeleteAvatar(ctx context.Context, userId uuid.UUID, avatarURL string) error
+}
+
+// NewAvatarService returns a new instance of the Avatar service
+func NewAvatarService(log *slog.Logger, avatarRepository AvatarRepository, blobs blob.Store, maxBytes int64) *AvatarService {
+	return &AvatarService{
+		log:              log,
+		avatarRepository: avatarRepository,
+		blobs:            blobs,
+		maxBytes:         maxBytes,
+	}
+}
+
+// MaxBytes is the largest accepted upload.
+func (s *AvatarService) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+// Upload validates the uploaded image, stores all of its renditions and makes it the user's avatar.
+// It returns the urls of the renditions by size.
+func (s *AvatarService) Upload(ctx context.Context, userId uuid.UUID, data []byte) (map[string]string, error) {
+	const op = "avatar.Upload"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	if int64(len(data)) > s.maxBytes {
+		return nil, fmt.Errorf("%s: %w", op, ErrAvatarTooLarge)
+	}
+
+	if _, err := images.SniffContentType(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	thumbs, err := images.SquareThumbnails(data, AvatarSizes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sum := sha256.Sum256(data)
+	avatar := &models.Avatar{
+		UserID:      userId,
+		Version:     hex.EncodeToString(sum[:8]),
+		ContentType: thumbs[0].ContentType,
+	}
+
+	previous, err := s.avatarRepository.GetAvatar(ctx, userId)
+	if err != nil && !errors.Is(err, repository.ErrAvatarNotFound) {
+		log.Error("failed to get avatar", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, thumb := range thumbs {
+		err = s.blobs.Put(ctx, avatarKey(userId, avatar.Version, thumb.Size), bytes.NewReader(thumb.Data), thumb.ContentType)
+		if err != nil {
+			log.Error("failed to store avatar", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err = s.avatarRepository.SaveAvatar(ctx, avatar, AvatarURL(userId, avatar.Version, profileAvatarSize)); err != nil {
+		log.Error("failed to save avatar", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if previous != nil && previous.Version != avatar.Version {
+		s.deleteRenditions(ctx, log, userId, previous.Version)
+	}
+
+	log.Info("avatar uploaded", slog.String("version", avatar.Version))
+
+	return avatarURLs(userId, avatar.Version), nil
+}
+
+// Stat checks that version is the current avatar of the user and comes in size, and returns
+// the content type of its renditions.
+func (s *AvatarService) Stat(ctx context.Context, userId uuid.UUID, version string, size int) (string, error) {
+	const op = "avatar.Stat"
+
+	if !slices.Contains(AvatarSizes, size) {
+		return "", fmt.Errorf("%s: %w", op, ErrAvatarSizeNotExists)
+	}
+
+	avatar, err := s.avatarRepository.GetAvatar(ctx, userId)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if avatar.Version != version {
+		return "", fmt.Errorf("%s: %w", op, repository.ErrAvatarNotFound)
+	}
+
+	return avatar.ContentType, nil
+}
+
+// Open returns a rendition of an avatar checked with Stat.
+func (s *AvatarService) Open(ctx context.Context, userId uuid.UUID, version string, size int) (io.ReadCloser, error) {
+	const op = "avatar.Open"
+
+	r, err := s.blobs.Get(ctx, avatarKey(userId, version, size))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return r, nil
+}
+
+func (s *AvatarService) Delete(ctx context.Context, userId uuid.UUID) error {
+	const op = "avatar.Delete"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	avatar, err := s.avatarRepository.GetAvatar(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.avatarRepository.DeleteAvatar(ctx, userId, AvatarURL(userId, avatar.Version, profileAvatarSize)); err != nil {
+		log.Error("failed to delete avatar", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.deleteRenditions(ctx, log, userId, avatar.Version)
+
+	log.Info("avatar deleted")
+
+	return nil
+}
+
+// PurgeUserData removes the avatar files of a user whose account is being purged.
+func (s *AvatarService) PurgeUserData(ctx context.Context, userId uuid.UUID) error {
+	const op = "avatar.PurgeUserData"
+
+	avatar, err := s.avatarRepository.GetAvatar(ctx, userId)
+	if err != nil {
+		if errors.Is(err, repository.ErrAvatarNotFound) {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, size := range AvatarSizes {
+		if err = s.blobs.Delete(ctx, avatarKey(userId, avatar.Version, size)); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// AvatarURL is the url a rendition of an avatar is served at.
+func AvatarURL(userId uuid.UUID, version string, size int) string {
+	return "/api/avatars/" + userId.String() + "/" + version + "/" + strconv.Itoa(size)
+}
+
+func avatarURLs(userId uuid.UUID, version string) map[string]string {
+	urls := make(map[string]string, len(AvatarSizes))
+	for _, size := range AvatarSizes {
+		urls[strconv.Itoa(size)] = AvatarURL(userId, version, size)
+	}
+	return urls
+}
+
+// deleteRenditions removes the files of a replaced avatar. Failures only leave garbage behind,
+// so they are logged and not returned.
+func (s *AvatarService) deleteRenditions(ctx context.Context, log *slog.Logger, userId uuid.UUID, version string) {
+	for _, size := range AvatarSizes {
+		if err := s.blobs.Delete(ctx, avatarKey(userId, version, size)); err != nil {
+			log.Warn("failed to delete old avatar", sl.Err(err))
+		}
+	}
+}
+
+func avatarKey(userId uuid.UUID, version string, size int) string {
+	return "avatars/" + userId.String() + "/" + version + "/" + strconv.Itoa(size)
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/geoip"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/lib/useragent"
+	"boton-back/internal/mail"
+	"boton-back/internal/repository"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// minTravelDistance is how far apart two logins must be, in kilometers, to be checked for
+// impossible travel. Nearby networks often resolve to neighbouring regions.
+const minTravelDistance = 100
+
+var (
+	ErrLoginConfirmationRequired = errors.New("login from a new device must be confirmed by email")
+	ErrInvalidLoginConfirmation  = errors.New("login confirmation is invalid or expired")
+)
+
+type DeviceService struct {
+	log              *slog.Logger
+	deviceRepository DeviceRepository
+	pendingLogins    PendingLoginStore
+	geo              GeoLocator
+	notifier         Notifier
+	mailer           Mailer
+	policy           LoginPolicy
+}
+
+type DeviceRepository interface {
+	ListDevices(ctx context.Context, userId uuid.UUID) ([]models.Device, error)
+	SaveDevice(ctx context.Context, device *models.Device) error
+	DeleteDevice(ctx context.Context, userId, deviceId uuid.UUID) error
+}
+
+type PendingLoginStore interface {
+	SavePendingLogin(ctx context.Context, token string, pending models.PendingLogin, ttl time.Duration) error
+	TakePendingLogin(ctx context.Context, token string) (*models.PendingLogin, error)
+}
+
+// GeoLocator resolves IP addresses to locations.
+type GeoLocator interface {
+	Lookup(ip netip.Addr) (geoip.Location, bool)
+}
+
+// LoginPolicy decides what happens on a login from a new device.
+type LoginPolicy struct {
+	// MaxTravelSpeed is the speed in km/h above which moving between two logins is flagged as
+	// suspicious. Zero turns the check off.
+	MaxTravelSpeed float64
+	// ConfirmNewDevices holds logins from new devices until the user follows the link emailed
+	// to them. The link is ConfirmationURL with a token query parameter and expires after
+	// ConfirmationTTL.
+	ConfirmNewDevices bool
+	ConfirmationTTL   time.Duration
+	ConfirmationURL   string
+}
+
+// NewDeviceService returns a new instance of the Device service. geo may be nil, in which case
+// devices have no location and impossible travel is never detected.
+func NewDeviceService(log *slog.Logger, deviceRepository DeviceRepository, pendingLogins PendingLoginStore, geo GeoLocator, notifier Notifier, mailer Mailer, policy LoginPolicy) *DeviceService {
+	return &DeviceService{
+		log:              log,
+		deviceRepository: deviceRepository,
+		pendingLogins:    pendingLogins,
+		geo:              geo,
+		notifier:         notifier,
+		mailer:           mailer,
+		policy:           policy,
+	}
+}
+
+// CheckLogin records the device of a login with a correct password. The first device of a user
+// is remembered silently. A login from any other unknown device alerts the user, or, if the
+// policy asks for it, is held with ErrLoginConfirmationRequired until confirmed by email.
+func (s *DeviceService) CheckLogin(ctx context.Context, userId uuid.UUID, client models.LoginClient) error {
+	const op = "device.CheckLogin"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	device := s.fingerprint(userId, client)
+
+	devices, err := s.deviceRepository.ListDevices(ctx, userId)
+	if err != nil {
+		log.Error("failed to list devices", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(devices) == 0 || knownDevice(devices, device) {
+		if err = s.deviceRepository.SaveDevice(ctx, &device); err != nil {
+			log.Error("failed to save device", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return nil
+	}
+
+	suspicious := s.impossibleTravel(devices, device, time.Now())
+
+	if s.policy.ConfirmNewDevices {
+		if err = s.requestConfirmation(ctx, models.PendingLogin{UserID: userId, Device: device, Suspicious: suspicious}); err != nil {
+			log.Error("failed to request login confirmation", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("login from new device held for confirmation", slog.Bool("suspicious", suspicious))
+
+		return fmt.Errorf("%s: %w", op, ErrLoginConfirmationRequired)
+	}
+
+	if err = s.deviceRepository.SaveDevice(ctx, &device); err != nil {
+		log.Error("failed to save device", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("login from new device", slog.Bool("suspicious", suspicious))
+
+	s.alert(ctx, log, device, suspicious)
+
+	if err = s.mailer.SendToUser(ctx, userId, mail.TemplateNewLogin, mail.NewLoginData{
+		Device:     device.UserAgentFamily,
+		IP:         device.LastIP,
+		Location:   deviceLocation(device),
+		Time:       device.LastSeenAt,
+		Suspicious: suspicious,
+	}); err != nil {
+		log.Warn("failed to email new login", sl.Err(err))
+	}
+
+	return nil
+}
+
+// ConfirmLogin remembers the device of a login held by CheckLogin and returns its user. A token
+// confirms one login.
+func (s *DeviceService) ConfirmLogin(ctx context.Context, token string) (uuid.UUID, error) {
+	const op = "device.ConfirmLogin"
+
+	log := s.log.With(slog.String("op", op))
+
+	if token == "" {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, ErrInvalidLoginConfirmation)
+	}
+
+	pending, err := s.pendingLogins.TakePendingLogin(ctx, token)
+	if err != nil {
+		if errors.Is(err, repository.ErrPendingLoginNotFound) {
+			return uuid.Nil, fmt.Errorf("%s: %w", op, ErrInvalidLoginConfirmation)
+		}
+		log.Error("failed to get pending login", sl.Err(err))
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.String("user_id", pending.UserID.String()))
+
+	device := pending.Device
+	if err = s.deviceRepository.SaveDevice(ctx, &device); err != nil {
+		log.Error("failed to save device", sl.Err(err))
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("login from new device confirmed")
+
+	// the confirmation email already told the user about the device
+	s.alert(ctx, log, device, pending.Suspicious)
+
+	return pending.UserID, nil
+}
+
+func (s *DeviceService) ListDevices(ctx context.Context, userId uuid.UUID) ([]models.Device, error) {
+	const op = "device.ListDevices"
+
+	devices, err := s.deviceRepository.ListDevices(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return devices, nil
+}
+
+// ForgetDevice removes a device, so the next login from it is treated as new.
+func (s *DeviceService) ForgetDevice(ctx context.Context, userId, deviceId uuid.UUID) error {
+	const op = "device.ForgetDevice"
+
+	if err := s.deviceRepository.DeleteDevice(ctx, userId, deviceId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *DeviceService) requestConfirmation(ctx context.Context, pending models.PendingLogin) error {
+	token, err := newLinkToken()
+	if err != nil {
+		return err
+	}
+
+	if err = s.pendingLogins.SavePendingLogin(ctx, token, pending, s.policy.ConfirmationTTL); err != nil {
+		return err
+	}
+
+	link, err := tokenLink(s.policy.ConfirmationURL, token)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.SendToUser(ctx, pending.UserID, mail.TemplateLoginConfirm, mail.LoginConfirmationData{
+		Link:      link,
+		Device:    pending.Device.UserAgentFamily,
+		IP:        pending.Device.LastIP,
+		Location:  deviceLocation(pending.Device),
+		ExpiresIn: s.policy.ConfirmationTTL,
+	})
+}
+
+// alert notifies the user of a login from a new device and, if it is suspicious, raises a
+// security alert too.
+func (s *DeviceService) alert(ctx context.Context, log *slog.Logger, device models.Device, suspicious bool) {
+	notify(ctx, log, s.notifier, device.UserID, models.NotificationNewDeviceLogin, models.NewDeviceLoginData{
+		DeviceID:   device.ID,
+		Device:     device.UserAgentFamily,
+		IP:         device.LastIP,
+		Region:     deviceLocation(device),
+		Suspicious: suspicious,
+	})
+
+	if suspicious {
+		notify(ctx, log, s.notifier, device.UserID, models.NotificationSecurityAlert, models.SecurityAlertData{Reason: models.SecurityAlertSuspiciousLogin})
+	}
+}
+
+// fingerprint describes the device of a login and locates it if the IP database knows its
+// address.
+func (s *DeviceService) fingerprint(userId uuid.UUID, client models.LoginClient) models.Device {
+	family, prefix := clientFingerprint(client)
+
+	device := models.Device{
+		UserID:          userId,
+		UserAgentFamily: family,
+		IPPrefix:        prefix,
+		LastIP:          client.IP,
+		LastSeenAt:      time.Now(),
+	}
+
+	addr, err := netip.ParseAddr(client.IP)
+	if err != nil || s.geo == nil {
+		return device
+	}
+
+	if location, ok := s.geo.Lookup(addr.Unmap()); ok {
+		device.Country = &location.Country
+		device.Region = &location.Region
+		device.Latitude = &location.Latitude
+		device.Longitude = &location.Longitude
+	}
+
+	return device
+}
+
+// impossibleTravel reports whether getting from the last located login to this one would take
+// moving faster than the policy allows.
+func (s *DeviceService) impossibleTravel(devices []models.Device, device models.Device, now time.Time) bool {
+	if s.policy.MaxTravelSpeed <= 0 || device.Latitude == nil || device.Longitude == nil {
+		return false
+	}
+
+	// devices are ordered by the last login, so the first located one is where the user was last
+	for _, previous := range devices {
+		if previous.Latitude == nil || previous.Longitude == nil {
+			continue
+		}
+
+		distance := geoip.Distance(
+			geoip.Location{Latitude: *previous.Latitude, Longitude: *previous.Longitude},
+			geoip.Location{Latitude: *device.Latitude, Longitude: *device.Longitude},
+		)
+		if distance < minTravelDistance {
+			return false
+		}
+
+		hours := now.Sub(previous.LastSeenAt).Hours()
+		if hours <= 0 {
+			return true
+		}
+
+		return distance/hours > s.policy.MaxTravelSpeed
+	}
+
+	return false
+}
+
+// clientFingerprint returns the browser family and the network of a client, which together
+// identify a device. Addresses within a network change often, so the network is used instead.
+func clientFingerprint(client models.LoginClient) (string, string) {
+	family := useragent.Family(client.UserAgent)
+
+	addr, err := netip.ParseAddr(client.IP)
+	if err != nil {
+		return family, client.IP
+	}
+	addr = addr.Unmap()
+
+	bits := 24
+	if addr.Is6() {
+		bits = 48
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return family, client.IP
+	}
+
+	return family, prefix.String()
+}
+
+func knownDevice(devices []models.Device, device models.Device) bool {
+	for _, d := range devices {
+		if d.UserAgentFamily == device.UserAgentFamily && d.IPPrefix == device.IPPrefix {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceLocation describes where a device is, such as "Moscow, RU", or is empty if unknown.
+func deviceLocation(device models.Device) string {
+	switch {
+	case device.Region != nil && *device.Region != "" && device.Country != nil && *device.Country != "":
+		return *device.Region + ", " + *device.Country
+	case device.Country != nil:
+		return *device.Country
+	default:
+		return ""
+	}
+}
+
+// newLinkToken returns a random token for a link emailed to a user.
+func newLinkToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// tokenLink adds the token to the query of base.
+func tokenLink(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/logger/sl"
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+// EventPublisher delivers real-time events to the connected clients of a user.
+type EventPublisher interface {
+	PublishEvent(ctx context.Context, userId uuid.UUID, event models.Event) error
+}
+
+// publishEvent sends a real-time event to the user. Events are a best-effort convenience for
+// clients that would otherwise poll, so a failure is logged and not returned.
+func publishEvent(ctx context.Context, log *slog.Logger, events EventPublisher, userId uuid.UUID, eventType string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Error("failed to encode event", slog.String("type", eventType), sl.Err(err))
+		return
+	}
+
+	event := models.Event{
+		ID:        uuid.NewString(),
+		Type:      eventType,
+		Data:      payload,
+		CreatedAt: time.Now(),
+	}
+
+	if err = events.PublishEvent(ctx, userId, event); err != nil {
+		log.Warn("failed to publish event", slog.String("type", eventType), sl.Err(err))
+	}
+}
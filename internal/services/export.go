@@ -0,0 +1,293 @@
+package services
+
+import (
+	"archive/zip"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/blob"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+const (
+	// exportStaleAfter is how long an export may stay in processing before another worker retries it.
+	exportStaleAfter = 30 * time.Minute
+	exportBatchSize  = 100
+)
+
+var ErrExportNotReady = errors.New("export is not ready")
+
+// ExportSource returns the data of one archive section for the user. The result is written as JSON.
+type ExportSource func(ctx context.Context, userId uuid.UUID) (any, error)
+
+type exportSection struct {
+	name   string
+	source ExportSource
+}
+
+type ExportService struct {
+	log              *slog.Logger
+	exportRepository ExportRepository
+	blobs            blob.Store
+	signer           URLSigner
+	sections         []exportSection
+	retention        time.Duration
+	urlTTL           time.Duration
+}
+
+type ExportRepository interface {
+	CreateDataExport(ctx context.Context, userId uuid.UUID) (*models.DataExport, error)
+	GetDataExport(ctx context.Context, userId, exportId uuid.UUID) (*models.DataExport, error)
+	GetDataExportByID(ctx context.Context, exportId uuid.UUID) (*models.DataExport, error)
+	ListDataExports(ctx context.Context, userId uuid.UUID) ([]models.DataExport, error)
+	ClaimDataExport(ctx context.Context, staleAfter time.Duration) (*models.DataExport, error)
+	CompleteDataExport(ctx context.Context, exportId uuid.UUID, blobKey string, expiresAt time.Time) error
+	FailDataExport(ctx context.Context, exportId uuid.UUID, reason string) error
+	ExpireDataExport(ctx context.Context, exportId uuid.UUID) error
+	ExpiredDataExports(ctx context.Context, t time.Time, limit int) ([]models.DataExport, error)
+}
+
+type URLSigner interface {
+	Sign(path string, expires time.Time) string
+	Verify(path string, query url.Values) error
+}
+
+// NewExportService returns a new instance of the Export service
+func NewExportService(log *slog.Logger, exportRepository ExportRepository, blobs blob.Store, signer URLSigner, retention, urlTTL time.Duration) *ExportService {
+	return &ExportService{
+		log:              log,
+		exportRepository: exportRepository,
+		blobs:            blobs,
+		signer:           signer,
+		retention:        retention,
+		urlTTL:           urlTTL,
+	}
+}
+
+// RegisterSource adds a section to every archive. Each subsystem that owns user rows registers one.
+func (s *ExportService) RegisterSource(name string, source ExportSource) {
+	s.sections = append(s.sections, exportSection{name: name, source: source})
+}
+
+// RequestExport enqueues a new export for the user.
+func (s *ExportService) RequestExport(ctx context.Context, userId uuid.UUID) (*models.DataExport, error) {
+	const op = "export.RequestExport"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	export, err := s.exportRepository.CreateDataExport(ctx, userId)
+	if err != nil {
+		if !errors.Is(err, repository.ErrExportInProgress) {
+			log.Error("failed to create export", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("export requested", slog.String("export_id", export.ID.String()))
+
+	return export, nil
+}
+
+// GetExport returns the export together with a short-lived download URL once it is ready.
+func (s *ExportService) GetExport(ctx context.Context, userId, exportId uuid.UUID) (*models.DataExport, string, error) {
+	const op = "export.GetExport"
+
+	export, err := s.exportRepository.GetDataExport(ctx, userId, exportId)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if export.Status != models.DataExportReady {
+		return export, "", nil
+	}
+
+	expires := time.Now().Add(s.urlTTL)
+	if export.ExpiresAt != nil && export.ExpiresAt.Before(expires) {
+		expires = *export.ExpiresAt
+	}
+
+	return export, s.signer.Sign(DownloadPath(export.ID), expires), nil
+}
+
+// OpenDownload checks the signed link and opens the archive of the export.
+func (s *ExportService) OpenDownload(ctx context.Context, exportId uuid.UUID, query url.Values) (io.ReadCloser, error) {
+	const op = "export.OpenDownload"
+
+	if err := s.signer.Verify(DownloadPath(exportId), query); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	export, err := s.exportRepository.GetDataExportByID(ctx, exportId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if export.Status != models.DataExportReady || export.BlobKey == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrExportNotReady)
+	}
+
+	r, err := s.blobs.Get(ctx, *export.BlobKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return r, nil
+}
+
+// ProcessPendingExports builds archives for all queued exports.
+func (s *ExportService) ProcessPendingExports(ctx context.Context) error {
+	const op = "export.ProcessPendingExports"
+
+	for ctx.Err() == nil {
+		export, err := s.exportRepository.ClaimDataExport(ctx, exportStaleAfter)
+		if err != nil {
+			if errors.Is(err, repository.ErrExportNotFound) {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		s.process(ctx, export)
+	}
+
+	return nil
+}
+
+// CleanupExpiredExports removes archives past their retention from the blob store.
+func (s *ExportService) CleanupExpiredExports(ctx context.Context) error {
+	const op = "export.CleanupExpiredExports"
+
+	exports, err := s.exportRepository.ExpiredDataExports(ctx, time.Now(), exportBatchSize)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, export := range exports {
+		if err = s.expire(ctx, export); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// PurgeUserData removes the archives of a user whose account is being purged.
+func (s *ExportService) PurgeUserData(ctx context.Context, userId uuid.UUID) error {
+	const op = "export.PurgeUserData"
+
+	exports, err := s.exportRepository.ListDataExports(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, export := range exports {
+		if export.BlobKey == nil {
+			continue
+		}
+		if err = s.blobs.Delete(ctx, *export.BlobKey); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+// DownloadPath is the path the signed download links of an export point to.
+func DownloadPath(exportId uuid.UUID) string {
+	return "/api/exports/" + exportId.String() + "/download"
+}
+
+func (s *ExportService) process(ctx context.Context, export *models.DataExport) {
+	log := s.log.With(
+		slog.String("export_id", export.ID.String()),
+		slog.String("user_id", export.UserID.String()),
+	)
+
+	archive, err := s.buildArchive(ctx, export.UserID)
+	if err == nil {
+		key := "exports/" + export.UserID.String() + "/" + export.ID.String() + ".zip"
+
+		if err = s.blobs.Put(ctx, key, bytes.NewReader(archive), "application/zip"); err == nil {
+			err = s.exportRepository.CompleteDataExport(ctx, export.ID, key, time.Now().Add(s.retention))
+		}
+	}
+
+	if err != nil {
+		log.Error("failed to build export", sl.Err(err))
+
+		if err = s.exportRepository.FailDataExport(ctx, export.ID, "failed to build archive"); err != nil {
+			log.Error("failed to mark export as failed", sl.Err(err))
+		}
+		return
+	}
+
+	log.Info("export ready")
+}
+
+func (s *ExportService) buildArchive(ctx context.Context, userId uuid.UUID) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	manifest := map[string]interface{}{
+		"user_id":    userId,
+		"created_at": time.Now().UTC(),
+	}
+
+	names := make([]string, 0, len(s.sections))
+	for _, section := range s.sections {
+		data, err := section.source(ctx, userId)
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", section.name, err)
+		}
+
+		if err = writeJSON(zw, section.name+".json", data); err != nil {
+			return nil, err
+		}
+		names = append(names, section.name)
+	}
+
+	manifest["sections"] = names
+	if err := writeJSON(zw, "manifest.json", manifest); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *ExportService) expire(ctx context.Context, export models.DataExport) error {
+	if export.BlobKey != nil {
+		if err := s.blobs.Delete(ctx, *export.BlobKey); err != nil {
+			return err
+		}
+	}
+
+	return s.exportRepository.ExpireDataExport(ctx, export.ID)
+}
+
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
@@ -0,0 +1,456 @@
+package services
+
+import (
+	"boton-back/internal/converter"
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrCannotBefriendSelf = errors.New("you cannot send a friend request to yourself")
+	ErrAlreadyFriends     = errors.New("you are already friends")
+	ErrFriendRequestSent  = errors.New("friend request already sent")
+	// ErrFriendRequestNotAllowed deliberately does not tell a block apart from privacy settings.
+	ErrFriendRequestNotAllowed = errors.New("this user does not accept friend requests from you")
+	ErrFriendListHidden        = errors.New("this user's friend list is hidden")
+)
+
+const (
+	DefaultSuggestions = 10
+	MaxSuggestions     = 50
+	// mutualFriendsSample is the number of mutual friends named in each suggestion.
+	mutualFriendsSample = 3
+)
+
+type FriendService struct {
+	log              *slog.Logger
+	friendRepository FriendRepository
+	suggestionCache  SuggestionCache
+	suggestionsTTL   time.Duration
+	events           EventPublisher
+	notifier         Notifier
+}
+
+type FriendRepository interface {
+	GetUserIDByUsername(ctx context.Context, username string) (uuid.UUID, error)
+	CreateFriendRequest(ctx context.Context, requesterId, addresseeId uuid.UUID) (*models.Friendship, error)
+	GetFriendshipBetween(ctx context.Context, userId, otherId uuid.UUID) (*models.Friendship, error)
+	AcceptFriendRequest(ctx context.Context, requestId, addresseeId uuid.UUID) (*models.Friendship, error)
+	DeclineFriendRequest(ctx context.Context, requestId, addresseeId uuid.UUID) error
+	CancelFriendRequest(ctx context.Context, requestId, requesterId uuid.UUID) error
+	DeleteFriendship(ctx context.Context, userId, friendId uuid.UUID) error
+	ListFriends(ctx context.Context, userId uuid.UUID, after *cursor.Cursor, limit int) ([]models.FriendshipEntry, error)
+	ListFriendRequests(ctx context.Context, userId uuid.UUID, incoming bool, after *cursor.Cursor, limit int) ([]models.FriendshipEntry, error)
+	AreFriends(ctx context.Context, userId, otherId uuid.UUID) (bool, error)
+	HaveMutualFriend(ctx context.Context, userId, otherId uuid.UUID) (bool, error)
+	IsBlockedEither(ctx context.Context, userId, otherId uuid.UUID) (bool, error)
+	GetPrivacySettings(ctx context.Context, userId uuid.UUID) (*models.PrivacySettings, error)
+	ListMutualFriends(ctx context.Context, viewerId, ownerId uuid.UUID, after *cursor.Cursor, limit int) ([]models.FriendshipEntry, error)
+	FriendSuggestions(ctx context.Context, userId uuid.UUID, sampleSize, limit int) ([]models.FriendSuggestion, error)
+}
+
+type SuggestionCache interface {
+	GetFriendSuggestions(ctx context.Context, userId uuid.UUID) ([]models.FriendSuggestion, bool, error)
+	SetFriendSuggestions(ctx context.Context, userId uuid.UUID, suggestions []models.FriendSuggestion, ttl time.Duration) error
+	InvalidateFriendSuggestions(ctx context.Context, userIds ...uuid.UUID) error
+}
+
+// NewFriendService returns a new instance of the Friend service. Suggestions are cached for
+// suggestionsTTL; zero disables the cache.
+func NewFriendService(log *slog.Logger, friendRepository FriendRepository, suggestionCache SuggestionCache, suggestionsTTL time.Duration, events EventPublisher, notifier Notifier) *FriendService {
+	return &FriendService{
+		log:              log,
+		friendRepository: friendRepository,
+		suggestionCache:  suggestionCache,
+		suggestionsTTL:   suggestionsTTL,
+		events:           events,
+		notifier:         notifier,
+	}
+}
+
+// SendRequest sends a friend request to username. If that user has already asked the sender,
+// their request is accepted instead.
+func (s *FriendService) SendRequest(ctx context.Context, userId uuid.UUID, username string) (*models.Friendship, error) {
+	const op = "friend.SendRequest"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	addresseeId, err := s.friendRepository.GetUserIDByUsername(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if addresseeId == userId {
+		return nil, fmt.Errorf("%s: %w", op, ErrCannotBefriendSelf)
+	}
+
+	blocked, err := s.friendRepository.IsBlockedEither(ctx, userId, addresseeId)
+	if err != nil {
+		log.Error("failed to check blocks", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if blocked {
+		return nil, fmt.Errorf("%s: %w", op, ErrFriendRequestNotAllowed)
+	}
+
+	existing, err := s.friendRepository.GetFriendshipBetween(ctx, userId, addresseeId)
+	if err != nil && !errors.Is(err, repository.ErrFriendshipNotFound) {
+		log.Error("failed to get friendship", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if existing != nil {
+		switch {
+		case existing.State == models.FriendshipAccepted:
+			return nil, fmt.Errorf("%s: %w", op, ErrAlreadyFriends)
+		case existing.RequesterID == userId:
+			return nil, fmt.Errorf("%s: %w", op, ErrFriendRequestSent)
+		default:
+			return s.accept(ctx, log, existing.ID, userId)
+		}
+	}
+
+	allowed, err := s.canSendRequest(ctx, userId, addresseeId)
+	if err != nil {
+		log.Error("failed to check friend request permissions", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !allowed {
+		return nil, fmt.Errorf("%s: %w", op, ErrFriendRequestNotAllowed)
+	}
+
+	friendship, err := s.friendRepository.CreateFriendRequest(ctx, userId, addresseeId)
+	if err != nil {
+		if errors.Is(err, repository.ErrFriendshipExists) {
+			return nil, fmt.Errorf("%s: %w", op, ErrFriendRequestSent)
+		}
+		log.Error("failed to create friend request", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	forgetSuggestions(ctx, log, s.suggestionCache, userId, addresseeId)
+
+	publishEvent(ctx, log, s.events, addresseeId, models.EventFriendRequest, friendship)
+	notify(ctx, log, s.notifier, addresseeId, models.NotificationFriendRequest, friendship)
+
+	log.Info("friend request sent", slog.String("request_id", friendship.ID.String()))
+
+	return friendship, nil
+}
+
+func (s *FriendService) AcceptRequest(ctx context.Context, userId, requestId uuid.UUID) (*models.Friendship, error) {
+	const op = "friend.AcceptRequest"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	friendship, err := s.accept(ctx, log, requestId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return friendship, nil
+}
+
+func (s *FriendService) DeclineRequest(ctx context.Context, userId, requestId uuid.UUID) error {
+	const op = "friend.DeclineRequest"
+
+	if err := s.friendRepository.DeclineFriendRequest(ctx, requestId, userId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	forgetSuggestions(ctx, s.log.With(slog.String("op", op)), s.suggestionCache, userId)
+
+	s.log.Info("friend request declined", slog.String("op", op), slog.String("request_id", requestId.String()))
+
+	return nil
+}
+
+func (s *FriendService) CancelRequest(ctx context.Context, userId, requestId uuid.UUID) error {
+	const op = "friend.CancelRequest"
+
+	if err := s.friendRepository.CancelFriendRequest(ctx, requestId, userId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	forgetSuggestions(ctx, s.log.With(slog.String("op", op)), s.suggestionCache, userId)
+
+	s.log.Info("friend request cancelled", slog.String("op", op), slog.String("request_id", requestId.String()))
+
+	return nil
+}
+
+func (s *FriendService) Unfriend(ctx context.Context, userId, friendId uuid.UUID) error {
+	const op = "friend.Unfriend"
+
+	if err := s.friendRepository.DeleteFriendship(ctx, userId, friendId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	forgetSuggestions(ctx, s.log.With(slog.String("op", op)), s.suggestionCache, userId, friendId)
+
+	s.log.Info("friendship ended", slog.String("op", op), slog.String("user_id", userId.String()), slog.String("friend_id", friendId.String()))
+
+	return nil
+}
+
+// ListFriends returns a page of friends and the cursor of the next page, empty on the last one.
+func (s *FriendService) ListFriends(ctx context.Context, userId uuid.UUID, after string, limit int) ([]dto.Friend, string, error) {
+	const op = "friend.ListFriends"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, err := s.friendRepository.ListFriends(ctx, userId, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list friends", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, next := paginate(entries, limit, friendshipEntryKey)
+
+	friends := make([]dto.Friend, 0, len(entries))
+	for _, entry := range entries {
+		friends = append(friends, converter.ToFriendDTO(entry))
+	}
+
+	return friends, next, nil
+}
+
+// ListUserFriends returns a page of the friends of username, if their friend list visibility
+// admits the viewer.
+func (s *FriendService) ListUserFriends(ctx context.Context, viewerId uuid.UUID, username string, after string, limit int) ([]dto.Friend, string, error) {
+	const op = "friend.ListUserFriends"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", viewerId.String()),
+	)
+
+	ownerId, err := s.friendRepository.GetUserIDByUsername(ctx, username)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if ownerId != viewerId {
+		blocked, err := s.friendRepository.IsBlockedEither(ctx, viewerId, ownerId)
+		if err != nil {
+			log.Error("failed to check blocks", sl.Err(err))
+			return nil, "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		if blocked {
+			return nil, "", fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+		}
+
+		visible, err := s.friendListVisible(ctx, viewerId, ownerId)
+		if err != nil {
+			log.Error("failed to check friend list visibility", sl.Err(err))
+			return nil, "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		if !visible {
+			return nil, "", fmt.Errorf("%s: %w", op, ErrFriendListHidden)
+		}
+	}
+
+	return s.ListFriends(ctx, ownerId, after, limit)
+}
+
+// ListMutualFriends returns a page of the friends the viewer shares with username. They are
+// hidden only by a private friend list, since the viewer knows their own friends anyway.
+func (s *FriendService) ListMutualFriends(ctx context.Context, viewerId uuid.UUID, username string, after string, limit int) ([]dto.Friend, string, error) {
+	const op = "friend.ListMutualFriends"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", viewerId.String()),
+	)
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	ownerId, err := s.friendRepository.GetUserIDByUsername(ctx, username)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	blocked, err := s.friendRepository.IsBlockedEither(ctx, viewerId, ownerId)
+	if err != nil {
+		log.Error("failed to check blocks", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if blocked {
+		return nil, "", fmt.Errorf("%s: %w", op, repository.ErrUserNotFound)
+	}
+
+	settings, err := s.friendRepository.GetPrivacySettings(ctx, ownerId)
+	if err != nil {
+		log.Error("failed to get privacy settings", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if ownerId != viewerId && settings.FriendListVisibility == models.VisibilityPrivate {
+		return nil, "", fmt.Errorf("%s: %w", op, ErrFriendListHidden)
+	}
+
+	entries, err := s.friendRepository.ListMutualFriends(ctx, viewerId, ownerId, c, limit+1)
+	if err != nil {
+		log.Error("failed to list mutual friends", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, next := paginate(entries, limit, friendshipEntryKey)
+
+	friends := make([]dto.Friend, 0, len(entries))
+	for _, entry := range entries {
+		friends = append(friends, converter.ToFriendDTO(entry))
+	}
+
+	return friends, next, nil
+}
+
+// Suggestions returns up to limit friends of friends ranked by the number of mutual friends.
+// The top MaxSuggestions are cached and dropped whenever the user's friendships change.
+func (s *FriendService) Suggestions(ctx context.Context, userId uuid.UUID, limit int) ([]models.FriendSuggestion, error) {
+	const op = "friend.Suggestions"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	if s.suggestionsTTL > 0 {
+		suggestions, ok, err := s.suggestionCache.GetFriendSuggestions(ctx, userId)
+		if err != nil {
+			log.Warn("failed to read cached suggestions", sl.Err(err))
+		}
+		if ok {
+			return suggestions[:min(limit, len(suggestions))], nil
+		}
+	}
+
+	suggestions, err := s.friendRepository.FriendSuggestions(ctx, userId, mutualFriendsSample, MaxSuggestions)
+	if err != nil {
+		log.Error("failed to compute suggestions", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if s.suggestionsTTL > 0 {
+		if err = s.suggestionCache.SetFriendSuggestions(ctx, userId, suggestions, s.suggestionsTTL); err != nil {
+			log.Warn("failed to cache suggestions", sl.Err(err))
+		}
+	}
+
+	return suggestions[:min(limit, len(suggestions))], nil
+}
+
+// ListRequests returns a page of pending incoming or outgoing requests and the cursor of the next page.
+func (s *FriendService) ListRequests(ctx context.Context, userId uuid.UUID, incoming bool, after string, limit int) ([]dto.FriendRequest, string, error) {
+	const op = "friend.ListRequests"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, err := s.friendRepository.ListFriendRequests(ctx, userId, incoming, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list friend requests", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, next := paginate(entries, limit, friendshipEntryKey)
+
+	requests := make([]dto.FriendRequest, 0, len(entries))
+	for _, entry := range entries {
+		requests = append(requests, converter.ToFriendRequestDTO(entry))
+	}
+
+	return requests, next, nil
+}
+
+// canSendRequest applies the friend request privacy setting of the addressee.
+func (s *FriendService) canSendRequest(ctx context.Context, userId, addresseeId uuid.UUID) (bool, error) {
+	settings, err := s.friendRepository.GetPrivacySettings(ctx, addresseeId)
+	if err != nil {
+		return false, err
+	}
+
+	switch settings.FriendRequestsFrom {
+	case models.FriendRequestsNobody:
+		return false, nil
+	case models.FriendRequestsFriendsOfFriends:
+		return s.friendRepository.HaveMutualFriend(ctx, userId, addresseeId)
+	default:
+		return true, nil
+	}
+}
+
+func (s *FriendService) friendListVisible(ctx context.Context, viewerId, ownerId uuid.UUID) (bool, error) {
+	settings, err := s.friendRepository.GetPrivacySettings(ctx, ownerId)
+	if err != nil {
+		return false, err
+	}
+
+	switch settings.FriendListVisibility {
+	case models.VisibilityPublic:
+		return true, nil
+	case models.VisibilityFriends:
+		return s.friendRepository.AreFriends(ctx, viewerId, ownerId)
+	default:
+		return false, nil
+	}
+}
+
+func (s *FriendService) accept(ctx context.Context, log *slog.Logger, requestId, userId uuid.UUID) (*models.Friendship, error) {
+	friendship, err := s.friendRepository.AcceptFriendRequest(ctx, requestId, userId)
+	if err != nil {
+		if !errors.Is(err, repository.ErrFriendshipNotFound) {
+			log.Error("failed to accept friend request", sl.Err(err))
+		}
+		return nil, err
+	}
+
+	forgetSuggestions(ctx, log, s.suggestionCache, friendship.RequesterID, friendship.AddresseeID)
+
+	publishEvent(ctx, log, s.events, friendship.RequesterID, models.EventFriendAccepted, friendship)
+	notify(ctx, log, s.notifier, friendship.RequesterID, models.NotificationFriendAccepted, friendship)
+
+	log.Info("friend request accepted", slog.String("request_id", requestId.String()))
+
+	return friendship, nil
+}
+
+// forgetSuggestions drops the cached suggestions of users whose friendships changed. A failure
+// only delays the change until the cache expires, so it is logged and not returned.
+func forgetSuggestions(ctx context.Context, log *slog.Logger, cache SuggestionCache, userIds ...uuid.UUID) {
+	if err := cache.InvalidateFriendSuggestions(ctx, userIds...); err != nil {
+		log.Warn("failed to invalidate friend suggestions", sl.Err(err))
+	}
+}
+
+func friendshipEntryKey(entry models.FriendshipEntry) (time.Time, uuid.UUID) {
+	return entry.Since, entry.FriendshipID
+}
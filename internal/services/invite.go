@@ -0,0 +1,197 @@
+package services
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidInviteUses   = errors.New("max uses must be at least 1")
+	ErrInvalidInviteExpiry = errors.New("expiry must be in the future and within the invite lifetime")
+	ErrUserInviteUses      = errors.New("invites created by users can be used once")
+)
+
+type InviteService struct {
+	log              *slog.Logger
+	inviteRepository InviteRepository
+	userQuota        int
+	ttl              time.Duration
+}
+
+type InviteRepository interface {
+	CreateInvite(ctx context.Context, invite *models.Invite, quota int) (*models.Invite, error)
+	GetInvite(ctx context.Context, inviteId uuid.UUID) (*models.Invite, error)
+	ListInvites(ctx context.Context, createdBy *uuid.UUID, after *cursor.Cursor, limit int) ([]models.Invite, error)
+	ListInviteRedemptions(ctx context.Context, inviteId uuid.UUID) ([]models.InviteRedemption, error)
+	RevokeInvite(ctx context.Context, inviteId uuid.UUID) (*models.Invite, error)
+}
+
+// NewInviteService returns a new instance of the Invite service. Every user may create up to
+// userQuota single-use invites, valid for at most ttl; ttl is also the default lifetime of
+// invites created by admins.
+func NewInviteService(log *slog.Logger, inviteRepository InviteRepository, userQuota int, ttl time.Duration) *InviteService {
+	return &InviteService{
+		log:              log,
+		inviteRepository: inviteRepository,
+		userQuota:        userQuota,
+		ttl:              ttl,
+	}
+}
+
+// CreateInvite creates an invite on behalf of owner, or of an admin when owner is nil.
+func (s *InviteService) CreateInvite(ctx context.Context, owner *uuid.UUID, input dto.CreateInvite) (*models.Invite, error) {
+	const op = "invite.CreateInvite"
+
+	log := s.log.With(slog.String("op", op))
+
+	invite := &models.Invite{CreatedBy: owner, MaxUses: 1}
+
+	if input.MaxUses != nil {
+		if *input.MaxUses < 1 {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidInviteUses)
+		}
+		if owner != nil && *input.MaxUses != 1 {
+			return nil, fmt.Errorf("%s: %w", op, ErrUserInviteUses)
+		}
+		invite.MaxUses = *input.MaxUses
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+	if input.ExpiresAt != nil {
+		// admins may hand out invites for longer than users
+		if !input.ExpiresAt.After(now) || (owner != nil && input.ExpiresAt.After(expiresAt)) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidInviteExpiry)
+		}
+		expiresAt = *input.ExpiresAt
+	}
+	invite.ExpiresAt = &expiresAt
+
+	if input.Email != nil && *input.Email != "" {
+		email := strings.ToLower(strings.TrimSpace(*input.Email))
+		if !correctEmailChecker(email) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidEmail)
+		}
+		invite.Email = &email
+	}
+
+	code, err := newCode()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	invite.Code = code
+
+	created, err := s.inviteRepository.CreateInvite(ctx, invite, s.userQuota)
+	if err != nil {
+		if !errors.Is(err, repository.ErrInviteQuotaExceeded) {
+			log.Error("failed to create invite", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("invite created", slog.String("invite_id", created.ID.String()))
+
+	return created, nil
+}
+
+// ListInvites returns a page of the invites of owner, or of every invite when owner is nil,
+// newest first.
+func (s *InviteService) ListInvites(ctx context.Context, owner *uuid.UUID, after string, limit int) ([]models.Invite, string, error) {
+	const op = "invite.ListInvites"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	invites, err := s.inviteRepository.ListInvites(ctx, owner, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list invites", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	invites, next := paginate(invites, limit, func(i models.Invite) (time.Time, uuid.UUID) {
+		return i.CreatedAt, i.ID
+	})
+
+	return invites, next, nil
+}
+
+// GetInvite returns an invite and the users who registered with it. Users only see their own
+// invites.
+func (s *InviteService) GetInvite(ctx context.Context, owner *uuid.UUID, inviteId uuid.UUID) (*models.Invite, []models.InviteRedemption, error) {
+	const op = "invite.GetInvite"
+
+	invite, err := s.ownInvite(ctx, owner, inviteId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	redemptions, err := s.inviteRepository.ListInviteRedemptions(ctx, inviteId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return invite, redemptions, nil
+}
+
+// RevokeInvite stops an invite from being used. Users who already registered with it stay.
+func (s *InviteService) RevokeInvite(ctx context.Context, owner *uuid.UUID, inviteId uuid.UUID) (*models.Invite, error) {
+	const op = "invite.RevokeInvite"
+
+	if _, err := s.ownInvite(ctx, owner, inviteId); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	invite, err := s.inviteRepository.RevokeInvite(ctx, inviteId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.Info("invite revoked", slog.String("op", op), slog.String("invite_id", inviteId.String()))
+
+	return invite, nil
+}
+
+// ownInvite returns the invite if owner may see it: admins see every invite, users the ones
+// they created.
+func (s *InviteService) ownInvite(ctx context.Context, owner *uuid.UUID, inviteId uuid.UUID) (*models.Invite, error) {
+	invite, err := s.inviteRepository.GetInvite(ctx, inviteId)
+	if err != nil {
+		return nil, err
+	}
+
+	if owner != nil && (invite.CreatedBy == nil || *invite.CreatedBy != *owner) {
+		return nil, repository.ErrInviteNotFound
+	}
+
+	return invite, nil
+}
+
+// newCode returns a code for people to type in, such as an invite or referral code: uppercase
+// letters and digits only.
+func newCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// normalizeCode forgives codes typed in lowercase or pasted with spaces.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
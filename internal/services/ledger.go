@@ -0,0 +1,218 @@
+package services
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("idempotency key must be between 1 and 128 characters")
+	ErrUnsupportedCurrency   = errors.New("currency is not supported")
+	ErrInvalidAmount         = errors.New("amount must be a non-zero number of minor units")
+	ErrUnbalancedTransaction = errors.New("ledger transaction does not balance")
+	ErrDescriptionTooLong    = errors.New("description must be at most 255 characters")
+)
+
+const (
+	maxIdempotencyKeyLength = 128
+	maxDescriptionLength    = 255
+)
+
+// LedgerService keeps the funds of users in a double-entry ledger: every transaction moves
+// money between accounts and sums to zero, so funds are never created or lost on the way.
+type LedgerService struct {
+	log              *slog.Logger
+	ledgerRepository LedgerRepository
+	currencies       []string
+}
+
+type LedgerRepository interface {
+	PostLedgerTransaction(ctx context.Context, transaction *models.LedgerTransaction, postings []models.LedgerPosting) (*models.LedgerTransaction, bool, error)
+	GetBalances(ctx context.Context, userId uuid.UUID) ([]models.Balance, error)
+	ListStatement(ctx context.Context, userId uuid.UUID, currency string, after *cursor.Cursor, limit int) ([]models.StatementEntry, error)
+}
+
+// NewLedgerService returns a ledger over the currencies, the first of which is the default one.
+func NewLedgerService(log *slog.Logger, ledgerRepository LedgerRepository, currencies []string) *LedgerService {
+	return &LedgerService{
+		log:              log,
+		ledgerRepository: ledgerRepository,
+		currencies:       currencies,
+	}
+}
+
+// Post writes a transaction unless one with its idempotency key was already posted, in which
+// case that one is returned and created is false. Retrying a post is therefore always safe.
+func (s *LedgerService) Post(ctx context.Context, transaction *models.LedgerTransaction, postings []models.LedgerPosting) (*models.LedgerTransaction, bool, error) {
+	const op = "ledger.Post"
+
+	if err := s.validate(transaction, postings); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("kind", transaction.Kind),
+		slog.String("idempotency_key", transaction.IdempotencyKey),
+	)
+
+	posted, created, err := s.ledgerRepository.PostLedgerTransaction(ctx, transaction, postings)
+	if err != nil {
+		if !errors.Is(err, repository.ErrInsufficientFunds) && !errors.Is(err, repository.ErrIdempotencyKeyReused) &&
+			!errors.Is(err, repository.ErrUserNotFound) {
+			log.Error("failed to post transaction", sl.Err(err))
+		}
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if created {
+		log.Info("transaction posted", slog.String("transaction_id", posted.ID.String()))
+	}
+
+	return posted, created, nil
+}
+
+// Balances returns what the user holds in every supported currency, zero where they have
+// no account yet.
+func (s *LedgerService) Balances(ctx context.Context, userId uuid.UUID) ([]models.Balance, error) {
+	const op = "ledger.Balances"
+
+	stored, err := s.ledgerRepository.GetBalances(ctx, userId)
+	if err != nil {
+		s.log.Error("failed to get balances", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	amounts := make(map[string]int64, len(stored))
+	for _, balance := range stored {
+		amounts[balance.Currency] = balance.Amount
+	}
+
+	balances := make([]models.Balance, 0, len(s.currencies))
+	for _, currency := range s.currencies {
+		balances = append(balances, models.Balance{Currency: currency, Amount: amounts[currency]})
+	}
+
+	return balances, nil
+}
+
+// History returns a page of the entries on the accounts of the user, newest first, optionally
+// only those in one currency.
+func (s *LedgerService) History(ctx context.Context, userId uuid.UUID, currency string, after string, limit int) ([]models.StatementEntry, string, error) {
+	const op = "ledger.History"
+
+	if currency != "" {
+		currency = strings.ToUpper(currency)
+		if !s.Supports(currency) {
+			return nil, "", fmt.Errorf("%s: %w", op, ErrUnsupportedCurrency)
+		}
+	}
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, err := s.ledgerRepository.ListStatement(ctx, userId, currency, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list statement", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, next := paginate(entries, limit, func(e models.StatementEntry) (time.Time, uuid.UUID) {
+		return e.CreatedAt, e.ID
+	})
+
+	return entries, next, nil
+}
+
+// Adjust credits the user with a positive amount or debits them with a negative one against
+// the adjustments account. It is how operators correct balances by hand.
+func (s *LedgerService) Adjust(ctx context.Context, userId uuid.UUID, input dto.LedgerAdjustment) (*models.LedgerTransaction, error) {
+	const op = "ledger.Adjust"
+
+	currency := s.DefaultCurrency()
+	if input.Currency != "" {
+		currency = strings.ToUpper(input.Currency)
+	}
+
+	transaction := &models.LedgerTransaction{
+		IdempotencyKey: input.IdempotencyKey,
+		Kind:           models.LedgerKindAdjustment,
+		Description:    strings.TrimSpace(input.Description),
+	}
+
+	posted, _, err := s.Post(ctx, transaction, []models.LedgerPosting{
+		{UserID: &userId, Currency: currency, Amount: input.Amount},
+		{System: models.LedgerSystemAdjustments, Currency: currency, Amount: -input.Amount},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return posted, nil
+}
+
+func (s *LedgerService) validate(transaction *models.LedgerTransaction, postings []models.LedgerPosting) error {
+	if transaction.IdempotencyKey == "" || len(transaction.IdempotencyKey) > maxIdempotencyKeyLength {
+		return ErrInvalidIdempotencyKey
+	}
+
+	if utf8.RuneCountInString(transaction.Description) > maxDescriptionLength {
+		return ErrDescriptionTooLong
+	}
+
+	if len(postings) < 2 {
+		return ErrUnbalancedTransaction
+	}
+
+	sums := make(map[string]int64)
+	for _, posting := range postings {
+		if (posting.UserID == nil) == (posting.System == "") {
+			return ErrUnbalancedTransaction
+		}
+
+		if !s.Supports(posting.Currency) {
+			return ErrUnsupportedCurrency
+		}
+
+		if posting.Amount == 0 {
+			return ErrInvalidAmount
+		}
+
+		sums[posting.Currency] += posting.Amount
+	}
+
+	for _, sum := range sums {
+		if sum != 0 {
+			return ErrUnbalancedTransaction
+		}
+	}
+
+	return nil
+}
+
+// DefaultCurrency is the currency amounts are in when none is given.
+func (s *LedgerService) DefaultCurrency() string {
+	return s.currencies[0]
+}
+
+func (s *LedgerService) Supports(currency string) bool {
+	for _, supported := range s.currencies {
+		if supported == currency {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/jobs"
+	"boton-back/internal/lib/broker"
+	"boton-back/internal/lib/mailer"
+	"boton-back/internal/mail"
+	"boton-back/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+)
+
+// SendEmailJob is the job type delivering a rendered email.
+const SendEmailJob = "send-email"
+
+type MailService struct {
+	log            *slog.Logger
+	mailRepository MailRepository
+	renderer       MailRenderer
+	queue          JobQueue
+	mailer         mailer.Mailer
+	from           string
+}
+
+type MailRepository interface {
+	GetMailRecipient(ctx context.Context, userId uuid.UUID) (*models.MailRecipient, error)
+}
+
+type MailRenderer interface {
+	Render(locale, name, username string, data any) (mailer.Message, error)
+}
+
+type JobQueue interface {
+	Enqueue(ctx context.Context, jobType string, payload any, opts jobs.EnqueueOptions) (string, error)
+}
+
+// Mailer emails users on behalf of other services.
+type Mailer interface {
+	SendToUser(ctx context.Context, userId uuid.UUID, template string, data any) error
+}
+
+// NewMailService returns a new instance of the Mail service. Emails are rendered right away and
+// queued as SendEmailJob jobs, which Deliver hands to m with the from address.
+func NewMailService(log *slog.Logger, mailRepository MailRepository, renderer MailRenderer, queue JobQueue, m mailer.Mailer, from string) *MailService {
+	return &MailService{
+		log:            log,
+		mailRepository: mailRepository,
+		renderer:       renderer,
+		queue:          queue,
+		mailer:         m,
+		from:           from,
+	}
+}
+
+// SendToUser renders the template in the locale of the user and queues it to their address.
+func (s *MailService) SendToUser(ctx context.Context, userId uuid.UUID, template string, data any) error {
+	const op = "mail.SendToUser"
+
+	recipient, err := s.mailRepository.GetMailRecipient(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.send(ctx, recipient, recipient.Email, template, data, jobs.EnqueueOptions{}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Deliver sends a queued email. It is the handler of SendEmailJob.
+func (s *MailService) Deliver(ctx context.Context, msg mailer.Message) error {
+	const op = "mail.Deliver"
+
+	if msg.From == "" {
+		msg.From = s.from
+	}
+
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.Info("email sent", slog.String("op", op), slog.String("subject", msg.Subject))
+
+	return nil
+}
+
+// Publish emails users about the changes to their credentials described by domain events. It
+// makes the service a broker.Broker fed by the outbox relay.
+func (s *MailService) Publish(ctx context.Context, msg broker.Message) error {
+	const op = "mail.Publish"
+
+	var err error
+	switch msg.Type {
+	case models.DomainUserEmailChanged:
+		err = s.emailChanged(ctx, msg)
+	case models.DomainUserPasswordChanged:
+		err = s.passwordChanged(ctx, msg)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// emailChanged tells the previous address that it no longer belongs to the account and the new
+// one that it does.
+func (s *MailService) emailChanged(ctx context.Context, msg broker.Message) error {
+	var data models.UserEmailChangedData
+	if err := json.Unmarshal(msg.Payload, &data); err != nil {
+		return err
+	}
+
+	recipient, ok, err := s.eventRecipient(ctx, data.UserID)
+	if err != nil || !ok {
+		return err
+	}
+
+	changed := mail.EmailChangedData{OldEmail: data.PreviousEmail, NewEmail: data.Email}
+
+	if data.PreviousEmail != "" {
+		if err = s.send(ctx, recipient, data.PreviousEmail, mail.TemplateEmailChanged, changed, eventOptions(msg, mail.TemplateEmailChanged)); err != nil {
+			return err
+		}
+	}
+
+	return s.send(ctx, recipient, data.Email, mail.TemplateEmailChangedNew, changed, eventOptions(msg, mail.TemplateEmailChangedNew))
+}
+
+func (s *MailService) passwordChanged(ctx context.Context, msg broker.Message) error {
+	var data models.UserPasswordChangedData
+	if err := json.Unmarshal(msg.Payload, &data); err != nil {
+		return err
+	}
+
+	recipient, ok, err := s.eventRecipient(ctx, data.UserID)
+	if err != nil || !ok {
+		return err
+	}
+
+	return s.send(ctx, recipient, recipient.Email, mail.TemplatePasswordChanged, nil, eventOptions(msg, mail.TemplatePasswordChanged))
+}
+
+// eventRecipient looks up the user an event is about. It reports false for users deleted since.
+func (s *MailService) eventRecipient(ctx context.Context, userId string) (*models.MailRecipient, bool, error) {
+	id, err := uuid.Parse(userId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	recipient, err := s.mailRepository.GetMailRecipient(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	return recipient, true, nil
+}
+
+func (s *MailService) send(ctx context.Context, recipient *models.MailRecipient, to, template string, data any, opts jobs.EnqueueOptions) error {
+	locale := ""
+	if recipient.Locale != nil {
+		locale = *recipient.Locale
+	}
+
+	msg, err := s.renderer.Render(locale, template, recipient.Username, data)
+	if err != nil {
+		return err
+	}
+
+	msg.From = s.from
+	msg.To = to
+
+	if _, err = s.queue.Enqueue(ctx, SendEmailJob, msg, opts); err != nil {
+		if errors.Is(err, jobs.ErrDuplicateJob) {
+			s.log.Info("email already queued", slog.String("template", template), slog.String("user_id", recipient.UserID.String()))
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// eventOptions keeps an event relayed twice from queueing the same email twice while the first
+// one is pending.
+func eventOptions(msg broker.Message, template string) jobs.EnqueueOptions {
+	return jobs.EnqueueOptions{UniqueKey: "mail:" + msg.ID + ":" + template}
+}
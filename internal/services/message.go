@@ -0,0 +1,348 @@
+package services
+
+import (
+	"boton-back/internal/converter"
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrCannotMessageSelf = errors.New("you cannot message yourself")
+	// ErrMessagingNotAllowed deliberately does not tell a block apart from a missing friendship.
+	ErrMessagingNotAllowed = errors.New("you can only message your friends")
+	ErrEmptyMessage        = errors.New("message must not be empty")
+	ErrMessageTooLong      = errors.New("message is too long")
+	ErrNotMessageSender    = errors.New("you can only change your own messages")
+	ErrMessageNotEditable  = errors.New("message can no longer be changed")
+)
+
+type MessageService struct {
+	log               *slog.Logger
+	messageRepository MessageRepository
+	events            EventPublisher
+	editWindow        time.Duration
+	maxLength         int
+}
+
+type MessageRepository interface {
+	GetUserIDByUsername(ctx context.Context, username string) (uuid.UUID, error)
+	AreFriends(ctx context.Context, userId, otherId uuid.UUID) (bool, error)
+	IsBlockedEither(ctx context.Context, userId, otherId uuid.UUID) (bool, error)
+	GetOrCreateConversation(ctx context.Context, userId, otherId uuid.UUID) (uuid.UUID, error)
+	GetConversationPeer(ctx context.Context, conversationId, userId uuid.UUID) (*models.ConversationMember, error)
+	CreateMessage(ctx context.Context, conversationId, senderId uuid.UUID, body string) (*models.Message, error)
+	GetMessage(ctx context.Context, messageId uuid.UUID) (*models.Message, error)
+	UpdateMessage(ctx context.Context, messageId, senderId uuid.UUID, body string) (*models.Message, error)
+	DeleteMessage(ctx context.Context, messageId, senderId uuid.UUID) (*models.Message, error)
+	ListMessages(ctx context.Context, conversationId uuid.UUID, after *cursor.Cursor, limit int) ([]models.Message, error)
+	ListConversations(ctx context.Context, userId uuid.UUID, after *cursor.Cursor, limit int) ([]models.ConversationEntry, error)
+	MarkConversationRead(ctx context.Context, conversationId, userId uuid.UUID) (time.Time, error)
+	SetConversationMuted(ctx context.Context, conversationId, userId uuid.UUID, muted bool) error
+}
+
+// NewMessageService returns a new instance of the Message service. Messages can be edited or
+// deleted for editWindow after they are sent and hold at most maxLength characters.
+func NewMessageService(log *slog.Logger, messageRepository MessageRepository, events EventPublisher, editWindow time.Duration, maxLength int) *MessageService {
+	return &MessageService{
+		log:               log,
+		messageRepository: messageRepository,
+		events:            events,
+		editWindow:        editWindow,
+		maxLength:         maxLength,
+	}
+}
+
+// StartConversation returns the conversation with username, creating it if the two have never talked.
+func (s *MessageService) StartConversation(ctx context.Context, userId uuid.UUID, username string) (uuid.UUID, error) {
+	const op = "message.StartConversation"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	otherId, err := s.messageRepository.GetUserIDByUsername(ctx, username)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if otherId == userId {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, ErrCannotMessageSelf)
+	}
+
+	if err = s.checkCanMessage(ctx, userId, otherId); err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	conversationId, err := s.messageRepository.GetOrCreateConversation(ctx, userId, otherId)
+	if err != nil {
+		log.Error("failed to get conversation", sl.Err(err))
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return conversationId, nil
+}
+
+// Send sends a message to the other side of a conversation, as long as the two are still friends.
+func (s *MessageService) Send(ctx context.Context, userId, conversationId uuid.UUID, body string) (*models.Message, error) {
+	const op = "message.Send"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+		slog.String("conversation_id", conversationId.String()),
+	)
+
+	body, err := s.checkBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	peer, err := s.messageRepository.GetConversationPeer(ctx, conversationId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.checkCanMessage(ctx, userId, peer.UserID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	message, err := s.messageRepository.CreateMessage(ctx, conversationId, userId, body)
+	if err != nil {
+		log.Error("failed to create message", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	publishEvent(ctx, log, s.events, peer.UserID, models.EventMessageNew, models.NewMessageData{Message: message, Muted: peer.Muted})
+	publishEvent(ctx, log, s.events, userId, models.EventMessageNew, models.NewMessageData{Message: message})
+
+	return message, nil
+}
+
+// ListConversations returns a page of the user's conversations, most recently active first.
+func (s *MessageService) ListConversations(ctx context.Context, userId uuid.UUID, after string, limit int) ([]dto.Conversation, string, error) {
+	const op = "message.ListConversations"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, err := s.messageRepository.ListConversations(ctx, userId, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list conversations", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	entries, next := paginate(entries, limit, func(e models.ConversationEntry) (time.Time, uuid.UUID) {
+		return e.LastMessageAt, e.ConversationID
+	})
+
+	conversations := make([]dto.Conversation, 0, len(entries))
+	for _, entry := range entries {
+		conversations = append(conversations, converter.ToConversationDTO(entry))
+	}
+
+	return conversations, next, nil
+}
+
+// ListMessages returns a page of the history of a conversation, newest first, and when the
+// other side last read it.
+func (s *MessageService) ListMessages(ctx context.Context, userId, conversationId uuid.UUID, after string, limit int) ([]models.Message, string, *time.Time, error) {
+	const op = "message.ListMessages"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	peer, err := s.messageRepository.GetConversationPeer(ctx, conversationId, userId)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	messages, err := s.messageRepository.ListMessages(ctx, conversationId, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list messages", slog.String("op", op), sl.Err(err))
+		return nil, "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	messages, next := paginate(messages, limit, func(m models.Message) (time.Time, uuid.UUID) {
+		return m.CreatedAt, m.ID
+	})
+
+	return messages, next, peer.LastReadAt, nil
+}
+
+// MarkRead marks the conversation read and sends a read receipt to the other side.
+func (s *MessageService) MarkRead(ctx context.Context, userId, conversationId uuid.UUID) (time.Time, error) {
+	const op = "message.MarkRead"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+		slog.String("conversation_id", conversationId.String()),
+	)
+
+	peer, err := s.messageRepository.GetConversationPeer(ctx, conversationId, userId)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	readAt, err := s.messageRepository.MarkConversationRead(ctx, conversationId, userId)
+	if err != nil {
+		log.Error("failed to mark conversation read", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	publishEvent(ctx, log, s.events, peer.UserID, models.EventMessagesRead, models.MessagesReadData{
+		ConversationID: conversationId,
+		UserID:         userId,
+		ReadAt:         readAt,
+	})
+
+	return readAt, nil
+}
+
+// Edit replaces the body of one of the user's messages within the edit window.
+func (s *MessageService) Edit(ctx context.Context, userId, messageId uuid.UUID, body string) (*models.Message, error) {
+	const op = "message.Edit"
+
+	body, err := s.checkBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	message, err := s.change(ctx, op, userId, messageId, func() (*models.Message, error) {
+		return s.messageRepository.UpdateMessage(ctx, messageId, userId, body)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return message, nil
+}
+
+// Delete deletes one of the user's messages within the edit window. The message stays in the
+// history without its body.
+func (s *MessageService) Delete(ctx context.Context, userId, messageId uuid.UUID) error {
+	const op = "message.Delete"
+
+	_, err := s.change(ctx, op, userId, messageId, func() (*models.Message, error) {
+		return s.messageRepository.DeleteMessage(ctx, messageId, userId)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SetMuted mutes or unmutes a conversation for the user. Messages of a muted conversation are
+// still delivered, flagged so that clients do not alert.
+func (s *MessageService) SetMuted(ctx context.Context, userId, conversationId uuid.UUID, muted bool) error {
+	const op = "message.SetMuted"
+
+	if err := s.messageRepository.SetConversationMuted(ctx, conversationId, userId, muted); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// change applies an edit or deletion to a message of the user after checking the edit window
+// and tells both sides about the result.
+func (s *MessageService) change(ctx context.Context, op string, userId, messageId uuid.UUID, apply func() (*models.Message, error)) (*models.Message, error) {
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+		slog.String("message_id", messageId.String()),
+	)
+
+	message, err := s.messageRepository.GetMessage(ctx, messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := s.messageRepository.GetConversationPeer(ctx, message.ConversationID, userId)
+	if err != nil {
+		if errors.Is(err, repository.ErrConversationNotFound) {
+			return nil, repository.ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	if message.SenderID != userId {
+		return nil, ErrNotMessageSender
+	}
+
+	if message.DeletedAt != nil {
+		return nil, repository.ErrMessageNotFound
+	}
+
+	if time.Since(message.CreatedAt) > s.editWindow {
+		return nil, ErrMessageNotEditable
+	}
+
+	message, err = apply()
+	if err != nil {
+		if !errors.Is(err, repository.ErrMessageNotFound) {
+			log.Error("failed to change message", sl.Err(err))
+		}
+		return nil, err
+	}
+
+	publishEvent(ctx, log, s.events, peer.UserID, models.EventMessageUpdated, message)
+	publishEvent(ctx, log, s.events, userId, models.EventMessageUpdated, message)
+
+	return message, nil
+}
+
+// checkCanMessage reports ErrMessagingNotAllowed unless the two users are friends and neither
+// has blocked the other.
+func (s *MessageService) checkCanMessage(ctx context.Context, userId, otherId uuid.UUID) error {
+	blocked, err := s.messageRepository.IsBlockedEither(ctx, userId, otherId)
+	if err != nil {
+		return err
+	}
+
+	if blocked {
+		return ErrMessagingNotAllowed
+	}
+
+	friends, err := s.messageRepository.AreFriends(ctx, userId, otherId)
+	if err != nil {
+		return err
+	}
+
+	if !friends {
+		return ErrMessagingNotAllowed
+	}
+
+	return nil
+}
+
+func (s *MessageService) checkBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+
+	if body == "" {
+		return "", ErrEmptyMessage
+	}
+
+	if utf8.RuneCountInString(body) > s.maxLength {
+		return "", ErrMessageTooLong
+	}
+
+	return body, nil
+}
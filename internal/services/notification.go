@@ -0,0 +1,210 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+var ErrUnknownNotificationType = errors.New("unknown notification type")
+
+type NotificationService struct {
+	log                    *slog.Logger
+	notificationRepository NotificationRepository
+	events                 EventPublisher
+	retention              time.Duration
+}
+
+type NotificationRepository interface {
+	CreateNotification(ctx context.Context, userId uuid.UUID, notificationType string, data json.RawMessage) (*models.Notification, error)
+	ListNotifications(ctx context.Context, userId uuid.UUID, unreadOnly bool, after *cursor.Cursor, limit int) ([]models.Notification, error)
+	CountUnreadNotifications(ctx context.Context, userId uuid.UUID) (int, error)
+	MarkNotificationRead(ctx context.Context, userId, notificationId uuid.UUID) error
+	MarkAllNotificationsRead(ctx context.Context, userId uuid.UUID) (int64, error)
+	DeleteNotificationsBefore(ctx context.Context, t time.Time) (int64, error)
+	GetNotificationPreferences(ctx context.Context, userId uuid.UUID) (map[string]bool, error)
+	SaveNotificationPreferences(ctx context.Context, userId uuid.UUID, preferences map[string]bool) error
+}
+
+// Notifier creates notifications on behalf of other services.
+type Notifier interface {
+	Notify(ctx context.Context, userId uuid.UUID, notificationType string, data any) error
+}
+
+// NewNotificationService returns a new instance of the Notification service. Notifications older
+// than retention are pruned.
+func NewNotificationService(log *slog.Logger, notificationRepository NotificationRepository, events EventPublisher, retention time.Duration) *NotificationService {
+	return &NotificationService{
+		log:                    log,
+		notificationRepository: notificationRepository,
+		events:                 events,
+		retention:              retention,
+	}
+}
+
+// Notify stores a notification for the user and pushes it to their connected clients. Nothing
+// is stored if the user turned the type off.
+func (s *NotificationService) Notify(ctx context.Context, userId uuid.UUID, notificationType string, data any) error {
+	const op = "notification.Notify"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+		slog.String("type", notificationType),
+	)
+
+	if !slices.Contains(models.NotificationTypes, notificationType) {
+		return fmt.Errorf("%s: %w", op, ErrUnknownNotificationType)
+	}
+
+	preferences, err := s.notificationRepository.GetNotificationPreferences(ctx, userId)
+	if err != nil {
+		log.Error("failed to get notification preferences", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if enabled, ok := preferences[notificationType]; ok && !enabled {
+		return nil
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	notification, err := s.notificationRepository.CreateNotification(ctx, userId, notificationType, payload)
+	if err != nil {
+		log.Error("failed to create notification", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	publishEvent(ctx, log, s.events, userId, models.EventNotification, notification)
+
+	return nil
+}
+
+// List returns a page of the user's notifications, newest first, together with the number of
+// unread ones.
+func (s *NotificationService) List(ctx context.Context, userId uuid.UUID, unreadOnly bool, after string, limit int) ([]models.Notification, string, int, error) {
+	const op = "notification.List"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	notifications, err := s.notificationRepository.ListNotifications(ctx, userId, unreadOnly, c, limit+1)
+	if err != nil {
+		log.Error("failed to list notifications", sl.Err(err))
+		return nil, "", 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	unread, err := s.notificationRepository.CountUnreadNotifications(ctx, userId)
+	if err != nil {
+		log.Error("failed to count unread notifications", sl.Err(err))
+		return nil, "", 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	notifications, next := paginate(notifications, limit, func(n models.Notification) (time.Time, uuid.UUID) {
+		return n.CreatedAt, n.ID
+	})
+
+	return notifications, next, unread, nil
+}
+
+func (s *NotificationService) MarkRead(ctx context.Context, userId, notificationId uuid.UUID) error {
+	const op = "notification.MarkRead"
+
+	if err := s.notificationRepository.MarkNotificationRead(ctx, userId, notificationId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MarkAllRead marks every notification of the user read and returns how many were unread.
+func (s *NotificationService) MarkAllRead(ctx context.Context, userId uuid.UUID) (int64, error) {
+	const op = "notification.MarkAllRead"
+
+	marked, err := s.notificationRepository.MarkAllNotificationsRead(ctx, userId)
+	if err != nil {
+		s.log.Error("failed to mark notifications read", slog.String("op", op), sl.Err(err))
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return marked, nil
+}
+
+// GetPreferences returns whether each notification type is enabled for the user.
+func (s *NotificationService) GetPreferences(ctx context.Context, userId uuid.UUID) (map[string]bool, error) {
+	const op = "notification.GetPreferences"
+
+	saved, err := s.notificationRepository.GetNotificationPreferences(ctx, userId)
+	if err != nil {
+		s.log.Error("failed to get notification preferences", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	preferences := make(map[string]bool, len(models.NotificationTypes))
+	for _, notificationType := range models.NotificationTypes {
+		enabled, ok := saved[notificationType]
+		preferences[notificationType] = !ok || enabled
+	}
+
+	return preferences, nil
+}
+
+// UpdatePreferences turns the given notification types on or off. Types left out are untouched.
+func (s *NotificationService) UpdatePreferences(ctx context.Context, userId uuid.UUID, input map[string]bool) (map[string]bool, error) {
+	const op = "notification.UpdatePreferences"
+
+	for notificationType := range input {
+		if !slices.Contains(models.NotificationTypes, notificationType) {
+			return nil, fmt.Errorf("%s: %w: %s", op, ErrUnknownNotificationType, notificationType)
+		}
+	}
+
+	if err := s.notificationRepository.SaveNotificationPreferences(ctx, userId, input); err != nil {
+		s.log.Error("failed to save notification preferences", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return s.GetPreferences(ctx, userId)
+}
+
+// PruneOld deletes notifications older than the retention period.
+func (s *NotificationService) PruneOld(ctx context.Context) error {
+	const op = "notification.PruneOld"
+
+	pruned, err := s.notificationRepository.DeleteNotificationsBefore(ctx, time.Now().Add(-s.retention))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if pruned > 0 {
+		s.log.Info("old notifications pruned", slog.String("op", op), slog.Int64("count", pruned))
+	}
+
+	return nil
+}
+
+// notify creates a notification on behalf of another service. Like events, notifications never
+// fail the action that caused them.
+func notify(ctx context.Context, log *slog.Logger, notifier Notifier, userId uuid.UUID, notificationType string, data any) {
+	if err := notifier.Notify(ctx, userId, notificationType, data); err != nil {
+		log.Warn("failed to notify user", slog.String("type", notificationType), sl.Err(err))
+	}
+}
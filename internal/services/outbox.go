@@ -0,0 +1,105 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/backoff"
+	"boton-back/internal/lib/broker"
+	"boton-back/internal/lib/logger/sl"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+const (
+	// outboxLease is how long a claimed event is hidden from other relays while it is published.
+	outboxLease = time.Minute
+	// maxOutboxBackoff caps the delay between attempts to publish an event.
+	maxOutboxBackoff = time.Hour
+)
+
+// OutboxService relays domain events from the outbox to the broker. An event is deleted only
+// after the broker accepted it, so every event is delivered at least once; retried events may
+// arrive out of order.
+type OutboxService struct {
+	log              *slog.Logger
+	outboxRepository OutboxRepository
+	broker           broker.Broker
+	batchSize        int
+	maxAttempts      int
+	retryBackoff     time.Duration
+}
+
+type OutboxRepository interface {
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEvent, error)
+	DeleteOutboxEvent(ctx context.Context, eventId uuid.UUID) error
+	RetryOutboxEvent(ctx context.Context, eventId uuid.UUID, nextAttemptAt time.Time, lastError string) error
+	DeadLetterOutboxEvent(ctx context.Context, eventId uuid.UUID, lastError string) error
+}
+
+// NewOutboxService returns a new instance of the Outbox service. A failed event is retried after
+// retryBackoff, doubling with every attempt, and dead-lettered after maxAttempts.
+func NewOutboxService(log *slog.Logger, outboxRepository OutboxRepository, broker broker.Broker, batchSize, maxAttempts int, retryBackoff time.Duration) *OutboxService {
+	return &OutboxService{
+		log:              log,
+		outboxRepository: outboxRepository,
+		broker:           broker,
+		batchSize:        batchSize,
+		maxAttempts:      maxAttempts,
+		retryBackoff:     retryBackoff,
+	}
+}
+
+// Relay publishes the due events until the outbox has none left.
+func (s *OutboxService) Relay(ctx context.Context) error {
+	const op = "outbox.Relay"
+
+	for ctx.Err() == nil {
+		events, err := s.outboxRepository.ClaimOutboxEvents(ctx, s.batchSize, outboxLease)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		for _, event := range events {
+			if err = s.relay(ctx, event); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		if len(events) < s.batchSize {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// relay publishes one event. A publishing failure is recorded on the event; only a failure to
+// record the outcome is returned.
+func (s *OutboxService) relay(ctx context.Context, event models.OutboxEvent) error {
+	log := s.log.With(
+		slog.String("event_id", event.ID.String()),
+		slog.String("type", event.Type),
+		slog.Int("attempt", event.Attempts),
+	)
+
+	err := s.broker.Publish(ctx, broker.Message{
+		ID:        event.ID.String(),
+		Type:      event.Type,
+		Payload:   event.Payload,
+		CreatedAt: event.CreatedAt,
+	})
+	if err == nil {
+		return s.outboxRepository.DeleteOutboxEvent(ctx, event.ID)
+	}
+
+	if event.Attempts >= s.maxAttempts {
+		log.Error("outbox event dead-lettered", sl.Err(err))
+		return s.outboxRepository.DeadLetterOutboxEvent(ctx, event.ID, err.Error())
+	}
+
+	log.Warn("failed to publish outbox event", sl.Err(err))
+
+	return s.outboxRepository.RetryOutboxEvent(ctx, event.ID, time.Now().Add(backoff.Exponential(s.retryBackoff, event.Attempts, maxOutboxBackoff)), err.Error())
+}
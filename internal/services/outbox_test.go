@@ -0,0 +1,149 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/broker"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"sort"
+	"testing"
+	"time"
+)
+
+// memoryOutbox is an OutboxRepository keeping events in memory. Claims ignore leases: every
+// pending event is due.
+type memoryOutbox struct {
+	pending      map[uuid.UUID]*models.OutboxEvent
+	deadLettered map[uuid.UUID]string
+	retries      map[uuid.UUID]time.Time
+}
+
+func newMemoryOutbox(events ...models.OutboxEvent) *memoryOutbox {
+	o := &memoryOutbox{
+		pending:      map[uuid.UUID]*models.OutboxEvent{},
+		deadLettered: map[uuid.UUID]string{},
+		retries:      map[uuid.UUID]time.Time{},
+	}
+	for i := range events {
+		o.pending[events[i].ID] = &events[i]
+	}
+	return o
+}
+
+func (o *memoryOutbox) ClaimOutboxEvents(_ context.Context, limit int, _ time.Duration) ([]models.OutboxEvent, error) {
+	claimed := make([]models.OutboxEvent, 0, limit)
+	for _, event := range o.pending {
+		event.Attempts++
+		claimed = append(claimed, *event)
+	}
+	sort.Slice(claimed, func(i, j int) bool { return claimed[i].CreatedAt.Before(claimed[j].CreatedAt) })
+	if len(claimed) > limit {
+		for _, event := range claimed[limit:] {
+			o.pending[event.ID].Attempts--
+		}
+		claimed = claimed[:limit]
+	}
+	return claimed, nil
+}
+
+func (o *memoryOutbox) DeleteOutboxEvent(_ context.Context, eventId uuid.UUID) error {
+	delete(o.pending, eventId)
+	return nil
+}
+
+func (o *memoryOutbox) RetryOutboxEvent(_ context.Context, eventId uuid.UUID, nextAttemptAt time.Time, _ string) error {
+	o.retries[eventId] = nextAttemptAt
+	return nil
+}
+
+func (o *memoryOutbox) DeadLetterOutboxEvent(_ context.Context, eventId uuid.UUID, lastError string) error {
+	delete(o.pending, eventId)
+	o.deadLettered[eventId] = lastError
+	return nil
+}
+
+func newOutboxEvents(n int) []models.OutboxEvent {
+	start := time.Now()
+	events := make([]models.OutboxEvent, n)
+	for i := range events {
+		events[i] = models.OutboxEvent{
+			ID:        uuid.New(),
+			Type:      models.DomainUserRegistered,
+			Payload:   []byte(`{}`),
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+		}
+	}
+	return events
+}
+
+func TestOutboxServiceRelay(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	t.Run("publishes every event in batches", func(t *testing.T) {
+		events := newOutboxEvents(5)
+		repo := newMemoryOutbox(events...)
+		b := broker.NewMemory()
+
+		if err := NewOutboxService(log, repo, b, 2, 3, time.Second).Relay(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		messages := b.Messages()
+		if len(messages) != len(events) || len(repo.pending) != 0 {
+			t.Fatalf("published %d, pending %d; want %d and 0", len(messages), len(repo.pending), len(events))
+		}
+		for i, msg := range messages {
+			if msg.ID != events[i].ID.String() || msg.Type != events[i].Type || !msg.CreatedAt.Equal(events[i].CreatedAt) {
+				t.Fatalf("message %d = %+v, want event %+v", i, msg, events[i])
+			}
+		}
+	})
+
+	t.Run("keeps failed events for a retry", func(t *testing.T) {
+		events := newOutboxEvents(1)
+		repo := newMemoryOutbox(events...)
+		b := broker.NewMemory()
+		b.FailWith(errors.New("broker down"))
+
+		before := time.Now()
+		if err := NewOutboxService(log, repo, b, 10, 3, time.Minute).Relay(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		next, ok := repo.retries[events[0].ID]
+		if !ok || len(repo.pending) != 1 || len(repo.deadLettered) != 0 {
+			t.Fatalf("retries %v, pending %d, dead %d; want one retried event", repo.retries, len(repo.pending), len(repo.deadLettered))
+		}
+		if next.Before(before.Add(time.Minute)) {
+			t.Fatalf("retry at %v, want at least a minute after %v", next, before)
+		}
+
+		b.FailWith(nil)
+		if err := NewOutboxService(log, repo, b, 10, 3, time.Minute).Relay(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(b.Messages()) != 1 || len(repo.pending) != 0 {
+			t.Fatalf("published %d, pending %d after recovery; want 1 and 0", len(b.Messages()), len(repo.pending))
+		}
+	})
+
+	t.Run("dead-letters an event out of attempts", func(t *testing.T) {
+		events := newOutboxEvents(1)
+		events[0].Attempts = 2
+		repo := newMemoryOutbox(events...)
+		b := broker.NewMemory()
+		b.FailWith(errors.New("broker down"))
+
+		if err := NewOutboxService(log, repo, b, 10, 3, time.Minute).Relay(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if repo.deadLettered[events[0].ID] != "broker down" || len(repo.pending) != 0 {
+			t.Fatalf("dead %v, pending %d; want the event dead-lettered", repo.deadLettered, len(repo.pending))
+		}
+	})
+}
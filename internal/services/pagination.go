@@ -0,0 +1,24 @@
+package services
+
+import (
+	"boton-back/internal/lib/cursor"
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// paginate trims a page fetched with limit+1 rows and returns the cursor of the next page,
+// empty on the last one. key returns the (time, id) pair the rows are ordered by.
+func paginate[T any](items []T, limit int, key func(T) (time.Time, uuid.UUID)) ([]T, string) {
+	if len(items) <= limit {
+		return items, ""
+	}
+
+	items = items[:limit]
+
+	return items, cursor.Encode(key(items[len(items)-1]))
+}
@@ -0,0 +1,440 @@
+package services
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/lib/payments"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidTopUpAmount      = errors.New("amount is out of the allowed range")
+	ErrUnknownPaymentProvider  = errors.New("unknown payment provider")
+	ErrPaymentProviderFailed   = errors.New("payment provider is unavailable, try again later")
+	ErrPaymentNotRefundable    = errors.New("only succeeded payments can be refunded")
+	ErrPaymentEventNotApplied  = errors.New("payment event could not be applied")
+	ErrPaymentEventEarly       = errors.New("payment has not succeeded yet, deliver the event again later")
+	errPaymentProviderMismatch = errors.New("payment was made with another provider")
+)
+
+// paymentStatuses maps the invoice statuses of providers to the statuses of payments. Invoices
+// in other statuses have not settled yet.
+var paymentStatuses = map[string]string{
+	payments.StatusPaid:        models.PaymentSucceeded,
+	payments.StatusFailed:      models.PaymentFailed,
+	payments.StatusRefunded:    models.PaymentRefunded,
+	payments.StatusChargedBack: models.PaymentChargedBack,
+}
+
+// PaymentPolicy bounds the top-ups users may make. Payments still pending ReconcileAfter they
+// were made are checked with the provider, up to BatchSize at a time, in case their webhook
+// got lost.
+type PaymentPolicy struct {
+	MinAmount      int64
+	MaxAmount      int64
+	ReturnURL      string
+	ReconcileAfter time.Duration
+	BatchSize      int
+}
+
+type PaymentService struct {
+	log               *slog.Logger
+	paymentRepository PaymentRepository
+	provider          payments.Provider
+	currencies        Currencies
+	notifier          Notifier
+	policy            PaymentPolicy
+}
+
+type PaymentRepository interface {
+	CreatePayment(ctx context.Context, payment *models.Payment) (*models.Payment, bool, error)
+	SetPaymentInvoice(ctx context.Context, paymentId uuid.UUID, invoiceId, checkoutURL string) (*models.Payment, error)
+	GetPayment(ctx context.Context, paymentId uuid.UUID) (*models.Payment, error)
+	GetPaymentByInvoice(ctx context.Context, provider, invoiceId string) (*models.Payment, error)
+	ListPayments(ctx context.Context, userId uuid.UUID, after *cursor.Cursor, limit int) ([]models.Payment, error)
+	ListPendingPayments(ctx context.Context, before time.Time, limit int) ([]models.Payment, error)
+	ApplyPaymentEvent(ctx context.Context, paymentId uuid.UUID, event models.PaymentEvent) (*models.Payment, bool, error)
+}
+
+// NewPaymentService returns a service that tops up balances through provider.
+func NewPaymentService(log *slog.Logger, paymentRepository PaymentRepository, provider payments.Provider, currencies Currencies, notifier Notifier, policy PaymentPolicy) *PaymentService {
+	return &PaymentService{
+		log:               log,
+		paymentRepository: paymentRepository,
+		provider:          provider,
+		currencies:        currencies,
+		notifier:          notifier,
+		policy:            policy,
+	}
+}
+
+// TopUp starts a payment topping up the balance of the user and returns it with the checkout
+// page of the provider. The balance grows once the provider reports the payment succeeded.
+// Repeating a request with the same idempotency key returns the first payment; if the
+// provider failed the first time, it is asked again.
+func (s *PaymentService) TopUp(ctx context.Context, userId uuid.UUID, input dto.CreateTopUp) (*models.Payment, error) {
+	const op = "payment.TopUp"
+
+	log := s.log.With(slog.String("op", op), slog.String("user_id", userId.String()))
+
+	payment, err := s.newPayment(userId, input)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	created, isNew, err := s.paymentRepository.CreatePayment(ctx, payment)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			log.Error("failed to create payment", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !isNew && (created.Amount != payment.Amount || created.Currency != payment.Currency) {
+		return nil, fmt.Errorf("%s: %w", op, repository.ErrIdempotencyKeyReused)
+	}
+
+	if created.InvoiceID != nil || created.Status != models.PaymentPending {
+		return created, nil
+	}
+
+	if created.Provider != s.provider.Name() {
+		return nil, fmt.Errorf("%s: %w", op, errPaymentProviderMismatch)
+	}
+
+	log = log.With(slog.String("payment_id", created.ID.String()))
+
+	invoice, err := s.provider.CreateInvoice(ctx, payments.InvoiceRequest{
+		Reference:   created.ID.String(),
+		Amount:      created.Charge,
+		Currency:    created.Currency,
+		Description: "Balance top-up",
+		ReturnURL:   s.policy.ReturnURL,
+	})
+	if err != nil {
+		log.Error("failed to create invoice", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w: %w", op, ErrPaymentProviderFailed, err)
+	}
+
+	created, err = s.paymentRepository.SetPaymentInvoice(ctx, created.ID, invoice.ID, invoice.URL)
+	if err != nil {
+		log.Error("failed to save invoice", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("payment created", slog.String("invoice_id", invoice.ID))
+
+	return created, nil
+}
+
+// ListPayments returns a page of the payments of the user, newest first.
+func (s *PaymentService) ListPayments(ctx context.Context, userId uuid.UUID, after string, limit int) ([]models.Payment, string, error) {
+	const op = "payment.ListPayments"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	list, err := s.paymentRepository.ListPayments(ctx, userId, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list payments", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	list, next := paginate(list, limit, func(p models.Payment) (time.Time, uuid.UUID) {
+		return p.CreatedAt, p.ID
+	})
+
+	return list, next, nil
+}
+
+// GetPayment returns a payment of the user. A nil user is an operator, who can see every
+// payment.
+func (s *PaymentService) GetPayment(ctx context.Context, userId *uuid.UUID, paymentId uuid.UUID) (*models.Payment, error) {
+	const op = "payment.GetPayment"
+
+	payment, err := s.paymentRepository.GetPayment(ctx, paymentId)
+	if err != nil {
+		if !errors.Is(err, repository.ErrPaymentNotFound) {
+			s.log.Error("failed to get payment", slog.String("op", op), sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if userId != nil && (payment.UserID == nil || *payment.UserID != *userId) {
+		return nil, fmt.Errorf("%s: %w", op, repository.ErrPaymentNotFound)
+	}
+
+	return payment, nil
+}
+
+// HandleWebhook applies the event a provider sent in a webhook request, once its signature
+// checks out. Events delivered again and events about invoices that have not settled are
+// accepted without effect.
+func (s *PaymentService) HandleWebhook(ctx context.Context, provider string, header http.Header, body []byte) error {
+	const op = "payment.HandleWebhook"
+
+	log := s.log.With(slog.String("op", op), slog.String("provider", provider))
+
+	if provider != s.provider.Name() {
+		return fmt.Errorf("%s: %w", op, ErrUnknownPaymentProvider)
+	}
+
+	event, err := s.provider.ParseWebhook(header, body)
+	if err != nil {
+		log.Warn("rejected webhook", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.String("event_id", event.ID), slog.String("invoice_id", event.InvoiceID))
+
+	status, ok := paymentStatuses[event.Status]
+	if !ok {
+		log.Info("ignored webhook", slog.String("status", event.Status))
+		return nil
+	}
+
+	payment, err := s.paymentRepository.GetPaymentByInvoice(ctx, provider, event.InvoiceID)
+	if err != nil {
+		if errors.Is(err, repository.ErrPaymentNotFound) {
+			log.Warn("webhook for unknown invoice")
+		} else {
+			log.Error("failed to get payment", sl.Err(err))
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = s.apply(ctx, log, payment.ID, models.PaymentEvent{
+		Provider: provider,
+		EventID:  event.ID,
+		Status:   status,
+		Amount:   event.Amount,
+		Currency: event.Currency,
+		Payload:  body,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Refund returns a succeeded payment to the payer and takes its amount back from the balance
+// of the user. Refunding a payment again is a no-op.
+func (s *PaymentService) Refund(ctx context.Context, paymentId uuid.UUID) (*models.Payment, error) {
+	const op = "payment.Refund"
+
+	log := s.log.With(slog.String("op", op), slog.String("payment_id", paymentId.String()))
+
+	payment, err := s.paymentRepository.GetPayment(ctx, paymentId)
+	if err != nil {
+		if !errors.Is(err, repository.ErrPaymentNotFound) {
+			log.Error("failed to get payment", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch payment.Status {
+	case models.PaymentSucceeded:
+	case models.PaymentRefunded, models.PaymentChargedBack:
+		return payment, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", op, ErrPaymentNotRefundable)
+	}
+
+	if payment.Provider != s.provider.Name() {
+		return nil, fmt.Errorf("%s: %w", op, errPaymentProviderMismatch)
+	}
+
+	status := payments.StatusRefunded
+
+	if err = s.provider.Refund(ctx, *payment.InvoiceID); err != nil {
+		if !errors.Is(err, payments.ErrNotRefundable) {
+			log.Error("failed to refund invoice", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w: %w", op, ErrPaymentProviderFailed, err)
+		}
+
+		// the provider may have reversed the payment before us, with a webhook still on its way
+		invoice, err := s.provider.GetInvoice(ctx, *payment.InvoiceID)
+		if err != nil {
+			log.Error("failed to get invoice", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w: %w", op, ErrPaymentProviderFailed, err)
+		}
+
+		if invoice.Status != payments.StatusRefunded && invoice.Status != payments.StatusChargedBack {
+			return nil, fmt.Errorf("%s: %w", op, ErrPaymentNotRefundable)
+		}
+		status = invoice.Status
+	}
+
+	refunded, err := s.apply(ctx, log, payment.ID, models.PaymentEvent{
+		Provider: payment.Provider,
+		EventID:  "refund:" + payment.ID.String(),
+		Status:   paymentStatuses[status],
+		Amount:   payment.Charge,
+		Currency: payment.Currency,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return refunded, nil
+}
+
+// Reconcile settles the payments pending for too long with the status of their invoices, in
+// case their webhooks got lost. Payments whose invoice was never created have failed.
+func (s *PaymentService) Reconcile(ctx context.Context) error {
+	const op = "payment.Reconcile"
+
+	log := s.log.With(slog.String("op", op))
+
+	pending, err := s.paymentRepository.ListPendingPayments(ctx, time.Now().Add(-s.policy.ReconcileAfter), s.policy.BatchSize)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, payment := range pending {
+		// one payment the provider cannot settle must not hold up the rest of the batch
+		if err = s.reconcile(ctx, log, &payment); err != nil {
+			log.Warn("failed to reconcile payment", slog.String("payment_id", payment.ID.String()), sl.Err(err))
+		}
+	}
+
+	return nil
+}
+
+func (s *PaymentService) reconcile(ctx context.Context, log *slog.Logger, payment *models.Payment) error {
+	event, err := s.missedEvent(ctx, payment)
+	if err != nil || event == nil {
+		return err
+	}
+
+	// an invoice refunded or charged back was paid first, which the payment missed as well
+	if event.Status == models.PaymentRefunded || event.Status == models.PaymentChargedBack {
+		paid := *event
+		paid.EventID = "reconcile:" + *payment.InvoiceID + ":" + payments.StatusPaid
+		paid.Status = models.PaymentSucceeded
+		if _, err = s.apply(ctx, log, payment.ID, paid); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.apply(ctx, log, payment.ID, *event)
+	return err
+}
+
+// missedEvent returns the event a pending payment is missing, or nil while its invoice has
+// not settled.
+func (s *PaymentService) missedEvent(ctx context.Context, payment *models.Payment) (*models.PaymentEvent, error) {
+	if payment.InvoiceID == nil {
+		return &models.PaymentEvent{
+			Provider: payment.Provider,
+			EventID:  "reconcile:" + payment.ID.String() + ":" + models.PaymentFailed,
+			Status:   models.PaymentFailed,
+			Currency: payment.Currency,
+		}, nil
+	}
+
+	if payment.Provider != s.provider.Name() {
+		return nil, errPaymentProviderMismatch
+	}
+
+	invoice, err := s.provider.GetInvoice(ctx, *payment.InvoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	status, ok := paymentStatuses[invoice.Status]
+	if !ok {
+		return nil, nil
+	}
+
+	return &models.PaymentEvent{
+		Provider: payment.Provider,
+		EventID:  "reconcile:" + invoice.ID + ":" + invoice.Status,
+		Status:   status,
+		Amount:   invoice.Amount,
+		Currency: invoice.Currency,
+	}, nil
+}
+
+// apply applies a payment event and lets the user know when it moved their funds.
+func (s *PaymentService) apply(ctx context.Context, log *slog.Logger, paymentId uuid.UUID, event models.PaymentEvent) (*models.Payment, error) {
+	payment, changed, err := s.paymentRepository.ApplyPaymentEvent(ctx, paymentId, event)
+	if err != nil {
+		if errors.Is(err, repository.ErrPaymentMismatch) || errors.Is(err, repository.ErrUserNotFound) {
+			log.Error("payment event not applied", slog.String("payment_id", paymentId.String()), sl.Err(err))
+			return nil, fmt.Errorf("%w: %w", ErrPaymentEventNotApplied, err)
+		}
+		if errors.Is(err, repository.ErrPaymentEventEarly) {
+			log.Warn("payment event came early", slog.String("payment_id", paymentId.String()), slog.String("status", event.Status))
+			return nil, fmt.Errorf("%w: %w", ErrPaymentEventEarly, err)
+		}
+		log.Error("failed to apply payment event", slog.String("payment_id", paymentId.String()), sl.Err(err))
+		return nil, err
+	}
+
+	if !changed {
+		return payment, nil
+	}
+
+	log.Info("payment updated", slog.String("payment_id", paymentId.String()), slog.String("status", payment.Status))
+
+	if payment.UserID == nil {
+		return payment, nil
+	}
+
+	data := models.PaymentData{
+		PaymentID: payment.ID,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+		Status:    payment.Status,
+	}
+
+	switch payment.Status {
+	case models.PaymentSucceeded:
+		notify(ctx, log, s.notifier, *payment.UserID, models.NotificationPaymentSucceeded, data)
+	case models.PaymentRefunded, models.PaymentChargedBack:
+		notify(ctx, log, s.notifier, *payment.UserID, models.NotificationPaymentReversed, data)
+	}
+
+	return payment, nil
+}
+
+func (s *PaymentService) newPayment(userId uuid.UUID, input dto.CreateTopUp) (*models.Payment, error) {
+	if input.IdempotencyKey == "" || len(input.IdempotencyKey) > maxIdempotencyKeyLength {
+		return nil, ErrInvalidIdempotencyKey
+	}
+
+	if input.Amount < s.policy.MinAmount || input.Amount > s.policy.MaxAmount {
+		return nil, ErrInvalidTopUpAmount
+	}
+
+	currency := s.currencies.DefaultCurrency()
+	if input.Currency != "" {
+		currency = strings.ToUpper(input.Currency)
+	}
+
+	if !s.currencies.Supports(currency) {
+		return nil, ErrUnsupportedCurrency
+	}
+
+	return &models.Payment{
+		UserID:         &userId,
+		Provider:       s.provider.Name(),
+		Amount:         input.Amount,
+		Currency:       currency,
+		IdempotencyKey: input.IdempotencyKey,
+	}, nil
+}
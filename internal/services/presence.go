@@ -0,0 +1,153 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/logger/sl"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+// MaxPresenceBatch is the most users whose presence can be asked for at once.
+const MaxPresenceBatch = 100
+
+var (
+	ErrNoPresenceIDs         = errors.New("at least one user id is required")
+	ErrPresenceBatchTooLarge = fmt.Errorf("at most %d user ids can be requested at once", MaxPresenceBatch)
+)
+
+type PresenceService struct {
+	log                *slog.Logger
+	presenceRepository PresenceRepository
+	heartbeats         HeartbeatStore
+	onlineWindow       time.Duration
+	awayTimeout        time.Duration
+}
+
+type PresenceRepository interface {
+	UpdateLastSeen(ctx context.Context, lastSeen map[uuid.UUID]time.Time) error
+	VisiblePresence(ctx context.Context, viewerId uuid.UUID, userIds []uuid.UUID) (map[uuid.UUID]*time.Time, error)
+}
+
+type HeartbeatStore interface {
+	RecordHeartbeat(ctx context.Context, userId uuid.UUID, at time.Time, ttl time.Duration) error
+	GetHeartbeats(ctx context.Context, userIds []uuid.UUID) (map[uuid.UUID]time.Time, error)
+	DrainLastSeen(ctx context.Context, before time.Time) (map[uuid.UUID]time.Time, error)
+}
+
+// NewPresenceService returns a new instance of the Presence service. Users are online for
+// onlineWindow after a heartbeat, away until awayTimeout and offline after that.
+func NewPresenceService(log *slog.Logger, presenceRepository PresenceRepository, heartbeats HeartbeatStore, onlineWindow, awayTimeout time.Duration) *PresenceService {
+	return &PresenceService{
+		log:                log,
+		presenceRepository: presenceRepository,
+		heartbeats:         heartbeats,
+		onlineWindow:       onlineWindow,
+		awayTimeout:        awayTimeout,
+	}
+}
+
+func (s *PresenceService) Heartbeat(ctx context.Context, userId uuid.UUID) error {
+	const op = "presence.Heartbeat"
+
+	if err := s.heartbeats.RecordHeartbeat(ctx, userId, time.Now(), s.awayTimeout); err != nil {
+		s.log.Error("failed to record heartbeat", slog.String("op", op), sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// GetPresence returns the presence of each of userIds in the same order. Users whose presence
+// the viewer may not see are reported offline without a last seen time, exactly like users
+// that do not exist.
+func (s *PresenceService) GetPresence(ctx context.Context, viewerId uuid.UUID, userIds []uuid.UUID) ([]models.Presence, error) {
+	const op = "presence.GetPresence"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", viewerId.String()),
+	)
+
+	if len(userIds) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoPresenceIDs)
+	}
+
+	if len(userIds) > MaxPresenceBatch {
+		return nil, fmt.Errorf("%s: %w", op, ErrPresenceBatchTooLarge)
+	}
+
+	visible, err := s.presenceRepository.VisiblePresence(ctx, viewerId, userIds)
+	if err != nil {
+		log.Error("failed to get visible presence", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	visibleIds := make([]uuid.UUID, 0, len(visible))
+	for id := range visible {
+		visibleIds = append(visibleIds, id)
+	}
+
+	heartbeats, err := s.heartbeats.GetHeartbeats(ctx, visibleIds)
+	if err != nil {
+		log.Error("failed to get heartbeats", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	presence := make([]models.Presence, 0, len(userIds))
+	for _, id := range userIds {
+		p := models.Presence{UserID: id, Status: models.PresenceOffline}
+
+		lastSeen, ok := visible[id]
+		if !ok {
+			presence = append(presence, p)
+			continue
+		}
+
+		p.LastSeenAt = lastSeen
+		if beat, ok := heartbeats[id]; ok {
+			p.LastSeenAt = &beat
+			p.Status = s.status(now.Sub(beat))
+		}
+
+		presence = append(presence, p)
+	}
+
+	return presence, nil
+}
+
+// FlushLastSeen persists the heartbeats recorded since the previous flush as last seen times.
+// Times drained by a failed flush are only lost until the users' next heartbeat.
+func (s *PresenceService) FlushLastSeen(ctx context.Context) error {
+	const op = "presence.FlushLastSeen"
+
+	lastSeen, err := s.heartbeats.DrainLastSeen(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.presenceRepository.UpdateLastSeen(ctx, lastSeen); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(lastSeen) > 0 {
+		s.log.Debug("last seen times persisted", slog.String("op", op), slog.Int("count", len(lastSeen)))
+	}
+
+	return nil
+}
+
+func (s *PresenceService) status(sinceHeartbeat time.Duration) string {
+	switch {
+	case sinceHeartbeat <= s.onlineWindow:
+		return models.PresenceOnline
+	case sinceHeartbeat <= s.awayTimeout:
+		return models.PresenceAway
+	default:
+		return models.PresenceOffline
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrCannotBlockSelf           = errors.New("you cannot block yourself")
+	ErrInvalidFriendRequestsFrom = errors.New("friend_requests_from must be everyone, friends_of_friends or nobody")
+)
+
+type PrivacyService struct {
+	log               *slog.Logger
+	privacyRepository PrivacyRepository
+	suggestionCache   SuggestionCache
+}
+
+type PrivacyRepository interface {
+	GetUserIDByUsername(ctx context.Context, username string) (uuid.UUID, error)
+	BlockUser(ctx context.Context, blockerId, blockedId uuid.UUID) error
+	UnblockUser(ctx context.Context, blockerId, blockedId uuid.UUID) error
+	ListBlockedUsers(ctx context.Context, blockerId uuid.UUID, after *cursor.Cursor, limit int) ([]models.BlockedUser, error)
+	GetPrivacySettings(ctx context.Context, userId uuid.UUID) (*models.PrivacySettings, error)
+	SavePrivacySettings(ctx context.Context, settings *models.PrivacySettings) error
+	ListSuggestionAudience(ctx context.Context, userId uuid.UUID) ([]uuid.UUID, error)
+}
+
+// NewPrivacyService returns a new instance of the Privacy service
+func NewPrivacyService(log *slog.Logger, privacyRepository PrivacyRepository, suggestionCache SuggestionCache) *PrivacyService {
+	return &PrivacyService{
+		log:               log,
+		privacyRepository: privacyRepository,
+		suggestionCache:   suggestionCache,
+	}
+}
+
+// Block blocks username for the user. Any friendship or pending request between them is dissolved.
+func (s *PrivacyService) Block(ctx context.Context, userId uuid.UUID, username string) (uuid.UUID, error) {
+	const op = "privacy.Block"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	blockedId, err := s.privacyRepository.GetUserIDByUsername(ctx, username)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if blockedId == userId {
+		return uuid.Nil, fmt.Errorf("%s: %w", op, ErrCannotBlockSelf)
+	}
+
+	if err = s.privacyRepository.BlockUser(ctx, userId, blockedId); err != nil {
+		log.Error("failed to block user", sl.Err(err))
+		return uuid.Nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	forgetSuggestions(ctx, log, s.suggestionCache, userId, blockedId)
+
+	log.Info("user blocked", slog.String("blocked_id", blockedId.String()))
+
+	return blockedId, nil
+}
+
+func (s *PrivacyService) Unblock(ctx context.Context, userId, blockedId uuid.UUID) error {
+	const op = "privacy.Unblock"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	if err := s.privacyRepository.UnblockUser(ctx, userId, blockedId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	forgetSuggestions(ctx, log, s.suggestionCache, userId, blockedId)
+
+	log.Info("user unblocked", slog.String("blocked_id", blockedId.String()))
+
+	return nil
+}
+
+// ListBlocked returns a page of users blocked by the user and the cursor of the next page.
+func (s *PrivacyService) ListBlocked(ctx context.Context, userId uuid.UUID, after string, limit int) ([]models.BlockedUser, string, error) {
+	const op = "privacy.ListBlocked"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	blocked, err := s.privacyRepository.ListBlockedUsers(ctx, userId, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list blocked users", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	blocked, next := paginate(blocked, limit, func(b models.BlockedUser) (time.Time, uuid.UUID) {
+		return b.BlockedAt, b.UserID
+	})
+
+	return blocked, next, nil
+}
+
+// forgetSuggestionsOf drops the cached suggestions that may include the user.
+func (s *PrivacyService) forgetSuggestionsOf(ctx context.Context, log *slog.Logger, userId uuid.UUID) {
+	audience, err := s.privacyRepository.ListSuggestionAudience(ctx, userId)
+	if err != nil {
+		log.Warn("failed to list suggestion audience", sl.Err(err))
+		return
+	}
+
+	if len(audience) > 0 {
+		forgetSuggestions(ctx, log, s.suggestionCache, audience...)
+	}
+}
+
+func (s *PrivacyService) GetSettings(ctx context.Context, userId uuid.UUID) (*models.PrivacySettings, error) {
+	const op = "privacy.GetSettings"
+
+	settings, err := s.privacyRepository.GetPrivacySettings(ctx, userId)
+	if err != nil {
+		s.log.Error("failed to get privacy settings", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return settings, nil
+}
+
+func (s *PrivacyService) UpdateSettings(ctx context.Context, userId uuid.UUID, input dto.UpdatePrivacySettings) (*models.PrivacySettings, error) {
+	const op = "privacy.UpdateSettings"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	settings, err := s.privacyRepository.GetPrivacySettings(ctx, userId)
+	if err != nil {
+		log.Error("failed to get privacy settings", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	requestsFrom := settings.FriendRequestsFrom
+
+	if input.FriendRequestsFrom != nil {
+		switch *input.FriendRequestsFrom {
+		case models.FriendRequestsEveryone, models.FriendRequestsFriendsOfFriends, models.FriendRequestsNobody:
+			settings.FriendRequestsFrom = *input.FriendRequestsFrom
+		default:
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidFriendRequestsFrom)
+		}
+	}
+
+	if input.FriendListVisibility != nil {
+		if !validVisibility(*input.FriendListVisibility) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidVisibility)
+		}
+		settings.FriendListVisibility = *input.FriendListVisibility
+	}
+
+	if input.HideOnlineStatus != nil {
+		settings.HideOnlineStatus = *input.HideOnlineStatus
+	}
+
+	if err = s.privacyRepository.SavePrivacySettings(ctx, settings); err != nil {
+		log.Error("failed to save privacy settings", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// users who accept no friend requests are left out of the suggestions of others
+	if (requestsFrom == models.FriendRequestsNobody) != (settings.FriendRequestsFrom == models.FriendRequestsNobody) {
+		s.forgetSuggestionsOf(ctx, log, userId)
+	}
+
+	log.Info("privacy settings updated")
+
+	return settings, nil
+}
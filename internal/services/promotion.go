@@ -0,0 +1,294 @@
+package services
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidPromoCode deliberately does not tell an unknown code apart from an inactive one.
+	ErrInvalidPromoCode      = errors.New("promo code is invalid or expired")
+	ErrMalformedPromoCode    = errors.New("code must be 3 to 32 letters, digits, dashes or underscores")
+	ErrInvalidPromoKind      = errors.New("kind must be percent or fixed")
+	ErrInvalidPromoValue     = errors.New("value must be a percentage from 1 to 100, or a positive number of minor units")
+	ErrInvalidPromoMinSpend  = errors.New("min spend must not be negative")
+	ErrInvalidPromoWindow    = errors.New("ends_at must be in the future and after starts_at")
+	ErrInvalidPromoLimit     = errors.New("redemption limits must be at least 1")
+	ErrPromoCodeNotAvailable = errors.New("promo code can no longer be redeemed")
+)
+
+var promoCodeRegex = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+type PromotionService struct {
+	log                 *slog.Logger
+	promotionRepository PromotionRepository
+	currencies          Currencies
+	newUserWindow       time.Duration
+}
+
+type PromotionRepository interface {
+	CreatePromoCode(ctx context.Context, promo *models.PromoCode) (*models.PromoCode, error)
+	GetPromoCode(ctx context.Context, promoId uuid.UUID) (*models.PromoCode, error)
+	GetPromoCodeByCode(ctx context.Context, code string) (*models.PromoCode, error)
+	ListPromoCodes(ctx context.Context, after *cursor.Cursor, limit int) ([]models.PromoCode, error)
+	GetPromoStats(ctx context.Context, promoId uuid.UUID) (*models.PromoStats, error)
+	ListPromoRedemptions(ctx context.Context, promoId uuid.UUID, after *cursor.Cursor, limit int) ([]models.PromoRedemption, error)
+	DisablePromoCode(ctx context.Context, promoId uuid.UUID) (*models.PromoCode, error)
+	RedeemPromoCode(ctx context.Context, promoId, userId uuid.UUID, registeredAfter *time.Time) (*models.Discount, error)
+}
+
+// NewPromotionService returns a new instance of the Promotion service. Codes for new users
+// only may be redeemed within newUserWindow of registering.
+func NewPromotionService(log *slog.Logger, promotionRepository PromotionRepository, currencies Currencies, newUserWindow time.Duration) *PromotionService {
+	return &PromotionService{
+		log:                 log,
+		promotionRepository: promotionRepository,
+		currencies:          currencies,
+		newUserWindow:       newUserWindow,
+	}
+}
+
+func (s *PromotionService) CreatePromoCode(ctx context.Context, input dto.CreatePromoCode) (*models.PromoCode, error) {
+	const op = "promotion.CreatePromoCode"
+
+	log := s.log.With(slog.String("op", op))
+
+	promo, err := s.newPromoCode(input)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	created, err := s.promotionRepository.CreatePromoCode(ctx, promo)
+	if err != nil {
+		if !errors.Is(err, repository.ErrPromoCodeTaken) {
+			log.Error("failed to create promo code", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("promo code created", slog.String("promo_id", created.ID.String()))
+
+	return created, nil
+}
+
+// ListPromoCodes returns a page of promo codes, newest first.
+func (s *PromotionService) ListPromoCodes(ctx context.Context, after string, limit int) ([]models.PromoCode, string, error) {
+	const op = "promotion.ListPromoCodes"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	promos, err := s.promotionRepository.ListPromoCodes(ctx, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list promo codes", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	promos, next := paginate(promos, limit, func(p models.PromoCode) (time.Time, uuid.UUID) {
+		return p.CreatedAt, p.ID
+	})
+
+	return promos, next, nil
+}
+
+// GetPromoCode returns a promo code and how it was used.
+func (s *PromotionService) GetPromoCode(ctx context.Context, promoId uuid.UUID) (*models.PromoCode, *models.PromoStats, error) {
+	const op = "promotion.GetPromoCode"
+
+	promo, err := s.promotionRepository.GetPromoCode(ctx, promoId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stats, err := s.promotionRepository.GetPromoStats(ctx, promoId)
+	if err != nil {
+		s.log.Error("failed to get promo stats", slog.String("op", op), sl.Err(err))
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return promo, stats, nil
+}
+
+// ListRedemptions returns a page of the redemptions of a promo code, newest first.
+func (s *PromotionService) ListRedemptions(ctx context.Context, promoId uuid.UUID, after string, limit int) ([]models.PromoRedemption, string, error) {
+	const op = "promotion.ListRedemptions"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = s.promotionRepository.GetPromoCode(ctx, promoId); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	redemptions, err := s.promotionRepository.ListPromoRedemptions(ctx, promoId, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list promo redemptions", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	redemptions, next := paginate(redemptions, limit, func(r models.PromoRedemption) (time.Time, uuid.UUID) {
+		return r.RedeemedAt, r.ID
+	})
+
+	return redemptions, next, nil
+}
+
+// DisablePromoCode stops a promo code from being redeemed. Discounts not used yet are voided.
+func (s *PromotionService) DisablePromoCode(ctx context.Context, promoId uuid.UUID) (*models.PromoCode, error) {
+	const op = "promotion.DisablePromoCode"
+
+	promo, err := s.promotionRepository.DisablePromoCode(ctx, promoId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.Info("promo code disabled", slog.String("op", op), slog.String("promo_id", promoId.String()))
+
+	return promo, nil
+}
+
+// Redeem gives the user the discount of a promo code. The user holds one discount at a time,
+// until a payment uses it or the code ends.
+func (s *PromotionService) Redeem(ctx context.Context, userId uuid.UUID, code string) (*models.Discount, error) {
+	const op = "promotion.Redeem"
+
+	log := s.log.With(slog.String("op", op), slog.String("user_id", userId.String()))
+
+	code = normalizeCode(code)
+	if code == "" {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidPromoCode)
+	}
+
+	promo, err := s.promotionRepository.GetPromoCodeByCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, repository.ErrPromoCodeNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidPromoCode)
+		}
+		log.Error("failed to get promo code", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	if !promo.Active(now) {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidPromoCode)
+	}
+
+	var registeredAfter *time.Time
+	if promo.NewUsersOnly {
+		t := now.Add(-s.newUserWindow)
+		registeredAfter = &t
+	}
+
+	discount, err := s.promotionRepository.RedeemPromoCode(ctx, promo.ID, userId, registeredAfter)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrPromoCodeExhausted):
+			// the code ended, ran out or was disabled since it was read
+			return nil, fmt.Errorf("%s: %w", op, ErrPromoCodeNotAvailable)
+		case errors.Is(err, repository.ErrPromoNotEligible),
+			errors.Is(err, repository.ErrPromoUserLimitReached),
+			errors.Is(err, repository.ErrDiscountAlreadyActive),
+			errors.Is(err, repository.ErrUserNotFound):
+		default:
+			log.Error("failed to redeem promo code", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("promo code redeemed", slog.String("promo_id", promo.ID.String()))
+
+	return discount, nil
+}
+
+// newPromoCode validates the input of a new promo code and fills in its defaults.
+func (s *PromotionService) newPromoCode(input dto.CreatePromoCode) (*models.PromoCode, error) {
+	promo := &models.PromoCode{
+		Kind:         input.Kind,
+		Value:        input.Value,
+		Currency:     input.Currency,
+		MinSpend:     input.MinSpend,
+		NewUsersOnly: input.NewUsersOnly,
+		StartsAt:     input.StartsAt,
+		EndsAt:       input.EndsAt,
+		PerUserLimit: 1,
+		Description:  strings.TrimSpace(input.Description),
+	}
+
+	if input.Code != nil && strings.TrimSpace(*input.Code) != "" {
+		promo.Code = normalizeCode(*input.Code)
+		if !promoCodeRegex.MatchString(promo.Code) {
+			return nil, ErrMalformedPromoCode
+		}
+	} else {
+		code, err := newCode()
+		if err != nil {
+			return nil, err
+		}
+		promo.Code = code
+	}
+
+	switch promo.Kind {
+	case models.PromoPercent:
+		if promo.Value < 1 || promo.Value > 100 {
+			return nil, ErrInvalidPromoValue
+		}
+	case models.PromoFixed:
+		if promo.Value < 1 {
+			return nil, ErrInvalidPromoValue
+		}
+	default:
+		return nil, ErrInvalidPromoKind
+	}
+
+	if promo.Currency == "" {
+		promo.Currency = s.currencies.DefaultCurrency()
+	}
+	if !s.currencies.Supports(promo.Currency) {
+		return nil, ErrUnsupportedCurrency
+	}
+
+	if promo.MinSpend < 0 {
+		return nil, ErrInvalidPromoMinSpend
+	}
+
+	if promo.EndsAt != nil &&
+		(!promo.EndsAt.After(time.Now()) || (promo.StartsAt != nil && !promo.EndsAt.After(*promo.StartsAt))) {
+		return nil, ErrInvalidPromoWindow
+	}
+
+	if input.MaxRedemptions != nil {
+		if *input.MaxRedemptions < 1 {
+			return nil, ErrInvalidPromoLimit
+		}
+		promo.MaxRedemptions = input.MaxRedemptions
+	}
+
+	if input.PerUserLimit != nil {
+		if *input.PerUserLimit < 1 {
+			return nil, ErrInvalidPromoLimit
+		}
+		promo.PerUserLimit = *input.PerUserLimit
+	}
+
+	if utf8.RuneCountInString(promo.Description) > maxDescriptionLength {
+		return nil, ErrDescriptionTooLong
+	}
+
+	return promo, nil
+}
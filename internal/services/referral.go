@@ -0,0 +1,259 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var ErrInvalidReferralCode = errors.New("referral code is invalid")
+
+type ReferralService struct {
+	log                *slog.Logger
+	referralRepository ReferralRepository
+	devices            ReferrerDevices
+	notifier           Notifier
+	policy             ReferralPolicy
+}
+
+type ReferralRepository interface {
+	EnsureReferralCode(ctx context.Context, userId uuid.UUID, code string) (string, error)
+	GetReferrer(ctx context.Context, code string) (*models.Referrer, error)
+	CreateReferral(ctx context.Context, referral *models.Referral) error
+	ListReferrals(ctx context.Context, referrerId uuid.UUID, after *cursor.Cursor, limit int) ([]models.Referral, error)
+	GetReferralStats(ctx context.Context, referrerId uuid.UUID) (*models.ReferralStats, error)
+	GetReferredBy(ctx context.Context, refereeId uuid.UUID) (*models.ReferredBy, error)
+	QualifyingReferrals(ctx context.Context, registeredBefore time.Time, minFriends, limit int) ([]models.Referral, error)
+	CreditReferral(ctx context.Context, referralId uuid.UUID, reward int64, currency string, maxPerReferrer int) (string, error)
+}
+
+// ReferrerDevices lists the devices a referrer signed in from, to tell them apart from the
+// devices of their referees.
+type ReferrerDevices interface {
+	ListDevices(ctx context.Context, userId uuid.UUID) ([]models.Device, error)
+}
+
+// ReferralPolicy decides when and how much a referral is credited.
+type ReferralPolicy struct {
+	// Reward is paid to the referrer for each qualifying referee, in minor units of Currency.
+	Reward   int64
+	Currency string
+	// MaxPerReferrer caps the credited referrals of one referrer; later ones are rejected. Zero
+	// means no cap.
+	MaxPerReferrer int
+	// A referee qualifies once their email is verified, their account is MinAccountAge old and
+	// they have MinFriends friends.
+	MinAccountAge time.Duration
+	MinFriends    int
+	BatchSize     int
+}
+
+func NewReferralService(log *slog.Logger, referralRepository ReferralRepository, devices ReferrerDevices, notifier Notifier, policy ReferralPolicy) *ReferralService {
+	return &ReferralService{
+		log:                log,
+		referralRepository: referralRepository,
+		devices:            devices,
+		notifier:           notifier,
+		policy:             policy,
+	}
+}
+
+// Summary returns the referral code of the user, creating it on first use, the stats of their
+// referrals and who referred them.
+func (s *ReferralService) Summary(ctx context.Context, userId uuid.UUID) (*models.ReferralSummary, error) {
+	const op = "referral.Summary"
+
+	log := s.log.With(slog.String("op", op), slog.String("user_id", userId.String()))
+
+	code, err := newCode()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err = s.referralRepository.EnsureReferralCode(ctx, userId, code)
+	if err != nil {
+		log.Error("failed to get referral code", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stats, err := s.referralRepository.GetReferralStats(ctx, userId)
+	if err != nil {
+		log.Error("failed to get referral stats", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	summary := &models.ReferralSummary{Code: code, Stats: *stats}
+
+	summary.ReferredBy, err = s.referralRepository.GetReferredBy(ctx, userId)
+	if err != nil && !errors.Is(err, repository.ErrReferralNotFound) {
+		log.Error("failed to get referrer", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return summary, nil
+}
+
+// ListReferrals returns a page of the referrals of the user, newest first.
+func (s *ReferralService) ListReferrals(ctx context.Context, userId uuid.UUID, after string, limit int) ([]models.Referral, string, error) {
+	const op = "referral.ListReferrals"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	referrals, err := s.referralRepository.ListReferrals(ctx, userId, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list referrals", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	referrals, next := paginate(referrals, limit, func(r models.Referral) (time.Time, uuid.UUID) {
+		return r.CreatedAt, r.ID
+	})
+
+	return referrals, next, nil
+}
+
+// ResolveCode returns the owner of a referral code, so registration can reject unknown codes
+// before creating the account.
+func (s *ReferralService) ResolveCode(ctx context.Context, code string) (*models.Referrer, error) {
+	const op = "referral.ResolveCode"
+
+	referrer, err := s.referralRepository.GetReferrer(ctx, normalizeCode(code))
+	if err != nil {
+		if errors.Is(err, repository.ErrReferralNotFound) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidReferralCode)
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return referrer, nil
+}
+
+// Refer records that the referee registered through the referrer. Referees with the address or
+// a device of the referrer are recorded as rejected self-referrals and never credited.
+func (s *ReferralService) Refer(ctx context.Context, referrer *models.Referrer, refereeId uuid.UUID, email string, client models.LoginClient) error {
+	const op = "referral.Refer"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("referrer_id", referrer.UserID.String()),
+		slog.String("referee_id", refereeId.String()),
+	)
+
+	family, prefix := clientFingerprint(client)
+
+	referral := &models.Referral{
+		ReferrerID:      referrer.UserID,
+		RefereeID:       refereeId,
+		Status:          models.ReferralPending,
+		UserAgentFamily: family,
+		IPPrefix:        prefix,
+	}
+
+	selfReferral, err := s.isSelfReferral(ctx, referrer, email, family, prefix)
+	if err != nil {
+		log.Error("failed to check self-referral", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if selfReferral {
+		now := time.Now()
+		reason := models.ReferralReasonSelfReferral
+		referral.Status, referral.Reason, referral.ResolvedAt = models.ReferralRejected, &reason, &now
+		log.Warn("self-referral rejected")
+	}
+
+	if err = s.referralRepository.CreateReferral(ctx, referral); err != nil {
+		log.Error("failed to save referral", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// CreditQualified credits the pending referrals whose referees meet the policy and notifies
+// their referrers. It is run periodically.
+func (s *ReferralService) CreditQualified(ctx context.Context) error {
+	const op = "referral.CreditQualified"
+
+	log := s.log.With(slog.String("op", op))
+
+	referrals, err := s.referralRepository.QualifyingReferrals(ctx, time.Now().Add(-s.policy.MinAccountAge), s.policy.MinFriends, s.policy.BatchSize)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, referral := range referrals {
+		status, err := s.referralRepository.CreditReferral(ctx, referral.ID, s.policy.Reward, s.policy.Currency, s.policy.MaxPerReferrer)
+		if err != nil {
+			if errors.Is(err, repository.ErrReferralNotFound) {
+				continue
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("referral resolved", slog.String("referral_id", referral.ID.String()), slog.String("status", status))
+
+		if status == models.ReferralCredited {
+			notify(ctx, log, s.notifier, referral.ReferrerID, models.NotificationReferralCredited, models.ReferralCreditedData{
+				ReferralID:      referral.ID,
+				RefereeUsername: referral.RefereeUsername,
+				Reward:          s.policy.Reward,
+				Currency:        s.policy.Currency,
+			})
+		}
+	}
+
+	return nil
+}
+
+// isSelfReferral reports whether the referee looks like the referrer: the same mailbox behind
+// another alias, or a device the referrer signed in from.
+func (s *ReferralService) isSelfReferral(ctx context.Context, referrer *models.Referrer, email, family, prefix string) (bool, error) {
+	if canonicalEmail(email) == canonicalEmail(referrer.Email) {
+		return true, nil
+	}
+
+	devices, err := s.devices.ListDevices(ctx, referrer.UserID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, device := range devices {
+		if device.UserAgentFamily == family && device.IPPrefix == prefix {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// canonicalEmail maps the aliases of a mailbox to one address: "+tag" suffixes are dropped
+// and, for Gmail, so are the dots in the local part.
+func canonicalEmail(email string) string {
+	local, domain, ok := strings.Cut(strings.ToLower(email), "@")
+	if !ok {
+		return email
+	}
+
+	local, _, _ = strings.Cut(local, "+")
+
+	if domain == "googlemail.com" {
+		domain = "gmail.com"
+	}
+	if domain == "gmail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+
+	return local + "@" + domain
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrCannotTransferToSelf = errors.New("you cannot send money to yourself")
+	// ErrTransferNotAllowed deliberately does not tell a block apart from a missing friendship.
+	ErrTransferNotAllowed    = errors.New("you can only send money to your friends")
+	ErrInvalidTransferAmount = errors.New("amount must be a positive number of minor units")
+	ErrTransferNoteTooLong   = errors.New("note must be at most 140 characters")
+)
+
+const maxTransferNoteLength = 140
+
+// transferLimitWindow is the period the daily transfer limit applies to.
+const transferLimitWindow = 24 * time.Hour
+
+type TransferService struct {
+	log                *slog.Logger
+	transferRepository TransferRepository
+	currencies         Currencies
+	notifier           Notifier
+	dailyLimit         int64
+}
+
+type TransferRepository interface {
+	GetUserIDByUsername(ctx context.Context, username string) (uuid.UUID, error)
+	CreateTransfer(ctx context.Context, transfer *models.Transfer, dailyLimit int64, window time.Duration) (*models.Transfer, bool, error)
+	GetTransferByKey(ctx context.Context, senderId uuid.UUID, idempotencyKey string) (*models.Transfer, error)
+	GetTransfer(ctx context.Context, transferId uuid.UUID) (*models.Transfer, error)
+	ListTransfers(ctx context.Context, userId uuid.UUID, after *cursor.Cursor, limit int) ([]models.Transfer, error)
+	ReverseTransfer(ctx context.Context, transferId uuid.UUID) (*models.Transfer, bool, error)
+}
+
+// Currencies are the currencies the ledger holds funds in.
+type Currencies interface {
+	DefaultCurrency() string
+	Supports(currency string) bool
+}
+
+// NewTransferService returns a service that lets users send at most dailyLimit minor units of
+// a currency a day; zero means no limit.
+func NewTransferService(log *slog.Logger, transferRepository TransferRepository, currencies Currencies, notifier Notifier, dailyLimit int64) *TransferService {
+	return &TransferService{
+		log:                log,
+		transferRepository: transferRepository,
+		currencies:         currencies,
+		notifier:           notifier,
+		dailyLimit:         dailyLimit,
+	}
+}
+
+// Send transfers funds from the user to a friend. Sending again with the same idempotency key
+// returns the first transfer instead of making another one.
+func (s *TransferService) Send(ctx context.Context, userId uuid.UUID, input dto.CreateTransfer) (*models.Transfer, error) {
+	const op = "transfer.Send"
+
+	log := s.log.With(slog.String("op", op), slog.String("user_id", userId.String()))
+
+	transfer, err := s.newTransfer(userId, input)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	recipientId, err := s.transferRepository.GetUserIDByUsername(ctx, input.Recipient)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if recipientId == userId {
+		return nil, fmt.Errorf("%s: %w", op, ErrCannotTransferToSelf)
+	}
+
+	transfer.RecipientID = &recipientId
+
+	// a retry must get its transfer back even if the friendship has ended since
+	existing, err := s.transferRepository.GetTransferByKey(ctx, userId, transfer.IdempotencyKey)
+	if err == nil {
+		return replayedTransfer(op, existing, transfer)
+	}
+	if !errors.Is(err, repository.ErrTransferNotFound) {
+		log.Error("failed to get transfer", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	created, isNew, err := s.transferRepository.CreateTransfer(ctx, transfer, s.dailyLimit, transferLimitWindow)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrTransferNotAllowed):
+			return nil, fmt.Errorf("%s: %w", op, ErrTransferNotAllowed)
+		case errors.Is(err, repository.ErrInsufficientFunds), errors.Is(err, repository.ErrTransferLimitReached):
+		default:
+			log.Error("failed to create transfer", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !isNew {
+		return replayedTransfer(op, created, transfer)
+	}
+
+	log.Info("transfer sent", slog.String("transfer_id", created.ID.String()))
+
+	notify(ctx, log, s.notifier, userId, models.NotificationTransferSent, transferData(created, created.RecipientUsername))
+	notify(ctx, log, s.notifier, recipientId, models.NotificationTransferReceived, transferData(created, created.SenderUsername))
+
+	return created, nil
+}
+
+// ListTransfers returns a page of the transfers the user sent or received, newest first.
+func (s *TransferService) ListTransfers(ctx context.Context, userId uuid.UUID, after string, limit int) ([]models.Transfer, string, error) {
+	const op = "transfer.ListTransfers"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	transfers, err := s.transferRepository.ListTransfers(ctx, userId, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list transfers", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	transfers, next := paginate(transfers, limit, func(t models.Transfer) (time.Time, uuid.UUID) {
+		return t.CreatedAt, t.ID
+	})
+
+	return transfers, next, nil
+}
+
+// GetTransfer returns a transfer the user is a party of. A nil user is an operator, who can
+// see every transfer.
+func (s *TransferService) GetTransfer(ctx context.Context, userId *uuid.UUID, transferId uuid.UUID) (*models.Transfer, error) {
+	const op = "transfer.GetTransfer"
+
+	transfer, err := s.transferRepository.GetTransfer(ctx, transferId)
+	if err != nil {
+		if !errors.Is(err, repository.ErrTransferNotFound) {
+			s.log.Error("failed to get transfer", slog.String("op", op), sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if userId != nil && !isTransferParty(transfer, *userId) {
+		return nil, fmt.Errorf("%s: %w", op, repository.ErrTransferNotFound)
+	}
+
+	return transfer, nil
+}
+
+// ReverseTransfer returns the funds of a transfer to the sender. Only operators may reverse
+// transfers; reversing one again is a no-op.
+func (s *TransferService) ReverseTransfer(ctx context.Context, transferId uuid.UUID) (*models.Transfer, error) {
+	const op = "transfer.ReverseTransfer"
+
+	log := s.log.With(slog.String("op", op), slog.String("transfer_id", transferId.String()))
+
+	transfer, reversed, err := s.transferRepository.ReverseTransfer(ctx, transferId)
+	if err != nil {
+		if !errors.Is(err, repository.ErrTransferNotFound) && !errors.Is(err, repository.ErrInsufficientFunds) &&
+			!errors.Is(err, repository.ErrUserNotFound) {
+			log.Error("failed to reverse transfer", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !reversed {
+		return transfer, nil
+	}
+
+	log.Info("transfer reversed")
+
+	notify(ctx, log, s.notifier, *transfer.SenderID, models.NotificationTransferReversed, transferData(transfer, transfer.RecipientUsername))
+	notify(ctx, log, s.notifier, *transfer.RecipientID, models.NotificationTransferReversed, transferData(transfer, transfer.SenderUsername))
+
+	return transfer, nil
+}
+
+func (s *TransferService) newTransfer(userId uuid.UUID, input dto.CreateTransfer) (*models.Transfer, error) {
+	if input.IdempotencyKey == "" || len(input.IdempotencyKey) > maxIdempotencyKeyLength {
+		return nil, ErrInvalidIdempotencyKey
+	}
+
+	if input.Amount <= 0 {
+		return nil, ErrInvalidTransferAmount
+	}
+
+	currency := s.currencies.DefaultCurrency()
+	if input.Currency != "" {
+		currency = strings.ToUpper(input.Currency)
+	}
+
+	if !s.currencies.Supports(currency) {
+		return nil, ErrUnsupportedCurrency
+	}
+
+	note := strings.TrimSpace(input.Note)
+	if utf8.RuneCountInString(note) > maxTransferNoteLength {
+		return nil, ErrTransferNoteTooLong
+	}
+
+	return &models.Transfer{
+		SenderID:       &userId,
+		Amount:         input.Amount,
+		Currency:       currency,
+		Note:           note,
+		IdempotencyKey: input.IdempotencyKey,
+	}, nil
+}
+
+// replayedTransfer returns the transfer an idempotency key was first used for, unless the
+// retry asks for a different one.
+func replayedTransfer(op string, existing, requested *models.Transfer) (*models.Transfer, error) {
+	if existing.Amount != requested.Amount || existing.Currency != requested.Currency || existing.Note != requested.Note ||
+		existing.RecipientID == nil || *existing.RecipientID != *requested.RecipientID {
+		return nil, fmt.Errorf("%s: %w", op, repository.ErrIdempotencyKeyReused)
+	}
+
+	return existing, nil
+}
+
+func isTransferParty(transfer *models.Transfer, userId uuid.UUID) bool {
+	return (transfer.SenderID != nil && *transfer.SenderID == userId) ||
+		(transfer.RecipientID != nil && *transfer.RecipientID == userId)
+}
+
+func transferData(transfer *models.Transfer, username *string) models.TransferData {
+	data := models.TransferData{
+		TransferID: transfer.ID,
+		Amount:     transfer.Amount,
+		Currency:   transfer.Currency,
+		Note:       transfer.Note,
+	}
+	if username != nil {
+		data.Username = *username
+	}
+	return data
+}
@@ -1,16 +1,37 @@
 package services
 
 import (
+	"boton-back/internal/converter"
 	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
 	"boton-back/internal/repository"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+var (
+	ErrDisplayNameTooLong = errors.New("display name must be at most 50 characters")
+	ErrBioTooLong         = errors.New("bio must be at most 500 characters")
+	ErrInvalidAvatarURL   = errors.New("avatar url must be an absolute http(s) url")
+	ErrInvalidLocale      = errors.New("locale is invalid")
+	ErrInvalidTimezone    = errors.New("timezone is invalid")
+	ErrInvalidBirthday    = errors.New("birthday must be a past date in YYYY-MM-DD format")
+	ErrInvalidVisibility  = errors.New("visibility must be public, friends or private")
+	ErrInvalidSearchQuery = errors.New("search query must be between 1 and 64 characters")
+)
+
+var localeRegex = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
 type UserService struct {
 	log            *slog.Logger
 	userRepository UserRepository
@@ -19,6 +40,13 @@ type UserService struct {
 
 type UserRepository interface {
 	GetUser(ctx context.Context, userId uuid.UUID) (*dto.User, error)
+	GetActiveDiscount(ctx context.Context, userId uuid.UUID) (*models.Discount, error)
+	GetProfile(ctx context.Context, userId uuid.UUID) (*models.Profile, error)
+	GetProfileByUsername(ctx context.Context, username string) (*models.Profile, error)
+	SaveProfile(ctx context.Context, profile *models.Profile) error
+	AreFriends(ctx context.Context, userId, otherId uuid.UUID) (bool, error)
+	IsBlocked(ctx context.Context, blockerId, blockedId uuid.UUID) (bool, error)
+	SearchUsers(ctx context.Context, viewerId uuid.UUID, query string, after *cursor.ScoreCursor, limit int) ([]models.UserSearchResult, error)
 }
 
 // NewUserService return a new instance of the Auth service
@@ -30,7 +58,7 @@ func NewUserService(log *slog.Logger, userRepository UserRepository) *UserServic
 }
 
 func (s *UserService) GetUser(ctx context.Context, userId uuid.UUID) (*dto.User, error) {
-	const op = "auth.GetUser"
+	const op = "user.GetUser"
 
 	log := s.log.With(
 		slog.String("op", op),
@@ -42,18 +70,240 @@ func (s *UserService) GetUser(ctx context.Context, userId uuid.UUID) (*dto.User,
 	user, err := s.userRepository.GetUser(ctx, userId)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
-			s.log.Warn("user not found", err)
+			log.Warn("user not found", sl.Err(err))
 
 			return nil, fmt.Errorf("%s: %w", op, err)
 		}
 
-		s.log.Error("failed to get user", err)
+		log.Error("failed to get user", sl.Err(err))
 
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("user found", slog.String("user_id", user.Username))
+	log.Info("user found", slog.String("username", user.Username))
+
+	discount, err := s.userRepository.GetActiveDiscount(ctx, userId)
+	if err != nil && !errors.Is(err, repository.ErrDiscountNotFound) {
+		log.Error("failed to get discount", sl.Err(err))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	user.Discount = discount
 
 	return user, nil
 
 }
+
+func (s *UserService) GetProfile(ctx context.Context, userId uuid.UUID) (*dto.Profile, error) {
+	const op = "user.GetProfile"
+
+	profile, err := s.userRepository.GetProfile(ctx, userId)
+	if err != nil {
+		if !errors.Is(err, repository.ErrProfileNotFound) {
+			s.log.Error("failed to get profile", slog.String("op", op), sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	result := converter.ToProfileDTO(profile)
+
+	return &result, nil
+}
+
+// UpdateProfile validates and applies a partial profile update and returns the resulting profile.
+func (s *UserService) UpdateProfile(ctx context.Context, userId uuid.UUID, input dto.UpdateProfile) (*dto.Profile, error) {
+	const op = "user.UpdateProfile"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.String("user_id", userId.String()),
+	)
+
+	profile, err := s.userRepository.GetProfile(ctx, userId)
+	if err != nil {
+		if !errors.Is(err, repository.ErrProfileNotFound) {
+			log.Error("failed to get profile", sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = applyProfileUpdate(profile, input); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.userRepository.SaveProfile(ctx, profile); err != nil {
+		log.Error("failed to save profile", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("profile updated")
+
+	result := converter.ToProfileDTO(profile)
+
+	return &result, nil
+}
+
+// GetPublicProfile returns the profile of username as viewerId is allowed to see it.
+func (s *UserService) GetPublicProfile(ctx context.Context, viewerId uuid.UUID, username string) (*dto.PublicProfile, error) {
+	const op = "user.GetPublicProfile"
+
+	profile, err := s.userRepository.GetProfileByUsername(ctx, username)
+	if err != nil {
+		if !errors.Is(err, repository.ErrProfileNotFound) {
+			s.log.Error("failed to get profile", slog.String("op", op), sl.Err(err))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// users who blocked the viewer do not exist for them
+	blocked, err := s.userRepository.IsBlocked(ctx, profile.UserID, viewerId)
+	if err != nil {
+		s.log.Error("failed to check blocks", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if blocked {
+		return nil, fmt.Errorf("%s: %w", op, repository.ErrProfileNotFound)
+	}
+
+	showBirthday, err := s.canSee(ctx, viewerId, profile.UserID, profile.BirthdayVisibility)
+	if err != nil {
+		s.log.Error("failed to check friendship", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	result := converter.ToPublicProfileDTO(profile, showBirthday)
+
+	return &result, nil
+}
+
+// SearchUsers returns a page of users matching query by username or display name, best matches
+// first, and the cursor of the next page.
+func (s *UserService) SearchUsers(ctx context.Context, viewerId uuid.UUID, query string, after string, limit int) ([]models.UserSearchResult, string, error) {
+	const op = "user.SearchUsers"
+
+	query = strings.TrimSpace(query)
+	if n := utf8.RuneCountInString(query); n < 1 || n > 64 {
+		return nil, "", fmt.Errorf("%s: %w", op, ErrInvalidSearchQuery)
+	}
+
+	c, err := cursor.DecodeScore(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	results, err := s.userRepository.SearchUsers(ctx, viewerId, query, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to search users", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(results) <= limit {
+		return results, "", nil
+	}
+
+	results = results[:limit]
+	last := results[len(results)-1]
+
+	return results, cursor.EncodeScore(last.Score, last.UserID), nil
+}
+
+// canSee reports whether viewerId may see a field of ownerId with the given visibility.
+func (s *UserService) canSee(ctx context.Context, viewerId, ownerId uuid.UUID, visibility string) (bool, error) {
+	switch {
+	case viewerId == ownerId || visibility == models.VisibilityPublic:
+		return true, nil
+	case visibility == models.VisibilityFriends:
+		return s.userRepository.AreFriends(ctx, viewerId, ownerId)
+	default:
+		return false, nil
+	}
+}
+
+func applyProfileUpdate(profile *models.Profile, input dto.UpdateProfile) error {
+	if input.DisplayName != nil {
+		if utf8.RuneCountInString(*input.DisplayName) > 50 {
+			return ErrDisplayNameTooLong
+		}
+		profile.DisplayName = nullIfEmpty(*input.DisplayName)
+	}
+
+	if input.Bio != nil {
+		if utf8.RuneCountInString(*input.Bio) > 500 {
+			return ErrBioTooLong
+		}
+		profile.Bio = nullIfEmpty(*input.Bio)
+	}
+
+	if input.AvatarURL != nil {
+		if *input.AvatarURL != "" && !correctURLChecker(*input.AvatarURL) {
+			return ErrInvalidAvatarURL
+		}
+		profile.AvatarURL = nullIfEmpty(*input.AvatarURL)
+	}
+
+	if input.Locale != nil {
+		if *input.Locale != "" && !localeRegex.MatchString(*input.Locale) {
+			return ErrInvalidLocale
+		}
+		profile.Locale = nullIfEmpty(*input.Locale)
+	}
+
+	if input.Timezone != nil {
+		if *input.Timezone != "" {
+			if _, err := time.LoadLocation(*input.Timezone); err != nil || *input.Timezone == "Local" {
+				return ErrInvalidTimezone
+			}
+		}
+		profile.Timezone = nullIfEmpty(*input.Timezone)
+	}
+
+	if input.Birthday != nil {
+		if *input.Birthday == "" {
+			profile.Birthday = nil
+		} else {
+			birthday, err := time.Parse("2006-01-02", *input.Birthday)
+			if err != nil || birthday.After(time.Now()) || birthday.Year() < 1900 {
+				return ErrInvalidBirthday
+			}
+			profile.Birthday = &birthday
+		}
+	}
+
+	if input.BirthdayVisibility != nil {
+		if !validVisibility(*input.BirthdayVisibility) {
+			return ErrInvalidVisibility
+		}
+		profile.BirthdayVisibility = *input.BirthdayVisibility
+	}
+
+	return nil
+}
+
+func validVisibility(v string) bool {
+	switch v {
+	case models.VisibilityPublic, models.VisibilityFriends, models.VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+func correctURLChecker(raw string) bool {
+	if len(raw) > 512 {
+		return false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
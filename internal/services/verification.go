@@ -0,0 +1,174 @@
+package services
+
+import (
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/broker"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/mail"
+	"boton-back/internal/repository"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrEmailAlreadyVerified     = errors.New("email is already verified")
+	ErrInvalidVerificationToken = errors.New("verification link is invalid or expired")
+)
+
+// VerificationService checks that users own their email addresses. Addresses are verified
+// after registering and after every change, by following a link emailed to them.
+type VerificationService struct {
+	log                    *slog.Logger
+	verificationRepository VerificationRepository
+	tokens                 VerificationTokenStore
+	mailer                 Mailer
+	ttl                    time.Duration
+	url                    string
+}
+
+type VerificationRepository interface {
+	GetMailRecipient(ctx context.Context, userId uuid.UUID) (*models.MailRecipient, error)
+	IsEmailVerified(ctx context.Context, userId uuid.UUID) (bool, error)
+	MarkEmailVerified(ctx context.Context, userId uuid.UUID, email string) error
+}
+
+type VerificationTokenStore interface {
+	SaveEmailVerification(ctx context.Context, token string, verification models.EmailVerification, ttl time.Duration) error
+	TakeEmailVerification(ctx context.Context, token string) (*models.EmailVerification, error)
+}
+
+// NewVerificationService returns a new instance of the Verification service. Links point to
+// verificationURL with a token query parameter and expire after ttl.
+func NewVerificationService(log *slog.Logger, verificationRepository VerificationRepository, tokens VerificationTokenStore, mailer Mailer, ttl time.Duration, verificationURL string) *VerificationService {
+	return &VerificationService{
+		log:                    log,
+		verificationRepository: verificationRepository,
+		tokens:                 tokens,
+		mailer:                 mailer,
+		ttl:                    ttl,
+		url:                    verificationURL,
+	}
+}
+
+// Publish emails a verification link to the addresses of new users and to changed addresses.
+// It makes the service a broker.Broker fed by the outbox relay.
+func (s *VerificationService) Publish(ctx context.Context, msg broker.Message) error {
+	const op = "verification.Publish"
+
+	var (
+		userId uuid.UUID
+		email  string
+	)
+
+	switch msg.Type {
+	case models.DomainUserRegistered:
+		var data models.UserRegisteredData
+		if err := json.Unmarshal(msg.Payload, &data); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		userId, email = data.UserID, data.Email
+	case models.DomainUserEmailChanged:
+		var data models.UserEmailChangedData
+		if err := json.Unmarshal(msg.Payload, &data); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		id, err := uuid.Parse(data.UserID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		userId, email = id, data.Email
+	default:
+		return nil
+	}
+
+	if err := s.send(ctx, userId, email); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Resend emails a new verification link to the current address of the user.
+func (s *VerificationService) Resend(ctx context.Context, userId uuid.UUID) error {
+	const op = "verification.Resend"
+
+	verified, err := s.verificationRepository.IsEmailVerified(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if verified {
+		return fmt.Errorf("%s: %w", op, ErrEmailAlreadyVerified)
+	}
+
+	recipient, err := s.verificationRepository.GetMailRecipient(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.send(ctx, userId, recipient.Email); err != nil {
+		s.log.Error("failed to send verification", slog.String("op", op), sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Verify marks the address a link was sent to as verified. The link only works once and only
+// while the address is still the email of the user.
+func (s *VerificationService) Verify(ctx context.Context, token string) error {
+	const op = "verification.Verify"
+
+	log := s.log.With(slog.String("op", op))
+
+	if token == "" {
+		return fmt.Errorf("%s: %w", op, ErrInvalidVerificationToken)
+	}
+
+	verification, err := s.tokens.TakeEmailVerification(ctx, token)
+	if err != nil {
+		if errors.Is(err, repository.ErrVerificationNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidVerificationToken)
+		}
+		log.Error("failed to get verification", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = s.verificationRepository.MarkEmailVerified(ctx, verification.UserID, verification.Email); err != nil {
+		if errors.Is(err, repository.ErrVerificationNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrInvalidVerificationToken)
+		}
+		log.Error("failed to verify email", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email verified", slog.String("user_id", verification.UserID.String()))
+
+	return nil
+}
+
+func (s *VerificationService) send(ctx context.Context, userId uuid.UUID, email string) error {
+	token, err := newLinkToken()
+	if err != nil {
+		return err
+	}
+
+	if err = s.tokens.SaveEmailVerification(ctx, token, models.EmailVerification{UserID: userId, Email: email}, s.ttl); err != nil {
+		return err
+	}
+
+	link, err := tokenLink(s.url, token)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.SendToUser(ctx, userId, mail.TemplateVerification, mail.VerificationData{Link: link, ExpiresIn: s.ttl})
+}
@@ -0,0 +1,467 @@
+package services
+
+import (
+	"boton-back/internal/domain/dto"
+	"boton-back/internal/domain/models"
+	"boton-back/internal/lib/backoff"
+	"boton-back/internal/lib/broker"
+	"boton-back/internal/lib/cursor"
+	"boton-back/internal/lib/logger/sl"
+	"boton-back/internal/lib/webhooksig"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookLease is how long a claimed delivery is hidden from other workers while it is sent.
+	webhookLease = 5 * time.Minute
+	// maxWebhookBackoff caps the delay between attempts of a delivery.
+	maxWebhookBackoff = 6 * time.Hour
+	// webhookUserAgent identifies webhook requests to the receivers.
+	webhookUserAgent = "boton-webhooks/1.0"
+)
+
+var (
+	ErrInvalidWebhookURL    = errors.New("url must be an absolute http or https url")
+	ErrNoWebhookEvents      = errors.New("at least one event type is required")
+	ErrUnknownWebhookEvent  = errors.New("unknown event type")
+	ErrWebhookDisabled      = errors.New("webhook is disabled")
+	ErrUnexpectedStatusCode = errors.New("unexpected status code")
+)
+
+type WebhookService struct {
+	log               *slog.Logger
+	webhookRepository WebhookRepository
+	client            *http.Client
+	batchSize         int
+	maxAttempts       int
+	retryBackoff      time.Duration
+	disableAfter      int
+}
+
+type WebhookRepository interface {
+	CreateWebhookSubscription(ctx context.Context, url, secret string, eventTypes []string) (*models.WebhookSubscription, error)
+	GetWebhookSubscription(ctx context.Context, subscriptionId uuid.UUID) (*models.WebhookSubscription, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	SaveWebhookSubscription(ctx context.Context, subscription *models.WebhookSubscription) error
+	RotateWebhookSecret(ctx context.Context, subscriptionId uuid.UUID, secret string) (*models.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, subscriptionId uuid.UUID) error
+	EnqueueWebhookDeliveries(ctx context.Context, eventId uuid.UUID, eventType string, payload json.RawMessage, createdAt time.Time) (int64, error)
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.PendingWebhook, error)
+	CompleteWebhookDelivery(ctx context.Context, delivery models.PendingWebhook, attempt models.WebhookAttempt) error
+	FailWebhookDelivery(ctx context.Context, delivery models.PendingWebhook, attempt models.WebhookAttempt, nextAttemptAt *time.Time, disableAfter int) (bool, error)
+	ListWebhookDeliveries(ctx context.Context, subscriptionId uuid.UUID, after *cursor.Cursor, limit int) ([]models.WebhookDelivery, error)
+	GetWebhookDelivery(ctx context.Context, deliveryId uuid.UUID) (*models.WebhookDelivery, error)
+	ListWebhookAttempts(ctx context.Context, deliveryId uuid.UUID) ([]models.WebhookAttempt, error)
+	RedeliverWebhook(ctx context.Context, deliveryId uuid.UUID) (*models.WebhookDelivery, error)
+}
+
+// NewWebhookService returns a new instance of the Webhook service. Each request times out after
+// timeout. A failed delivery is retried after retryBackoff, doubling with every attempt, up to
+// maxAttempts; a subscription is disabled after disableAfter failed attempts in a row.
+func NewWebhookService(log *slog.Logger, webhookRepository WebhookRepository, timeout time.Duration, batchSize, maxAttempts int, retryBackoff time.Duration, disableAfter int) *WebhookService {
+	return &WebhookService{
+		log:               log,
+		webhookRepository: webhookRepository,
+		client: &http.Client{
+			Timeout: timeout,
+			// a redirect is reported as a failure instead of resending the signed body elsewhere
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		batchSize:    batchSize,
+		maxAttempts:  maxAttempts,
+		retryBackoff: retryBackoff,
+		disableAfter: disableAfter,
+	}
+}
+
+func (s *WebhookService) CreateSubscription(ctx context.Context, input dto.CreateWebhook) (*models.WebhookSubscription, error) {
+	const op = "webhook.CreateSubscription"
+
+	if err := checkWebhookURL(input.URL); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := checkWebhookEvents(input.EventTypes); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	secret := input.Secret
+	if secret == "" {
+		var err error
+		if secret, err = webhooksig.NewSecret(); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	subscription, err := s.webhookRepository.CreateWebhookSubscription(ctx, input.URL, secret, normalizeWebhookEvents(input.EventTypes))
+	if err != nil {
+		s.log.Error("failed to create webhook subscription", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.Info("webhook subscription created", slog.String("op", op), slog.String("subscription_id", subscription.ID.String()))
+
+	return subscription, nil
+}
+
+func (s *WebhookService) ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	const op = "webhook.ListSubscriptions"
+
+	subscriptions, err := s.webhookRepository.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		s.log.Error("failed to list webhook subscriptions", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscriptions, nil
+}
+
+func (s *WebhookService) GetSubscription(ctx context.Context, subscriptionId uuid.UUID) (*models.WebhookSubscription, error) {
+	const op = "webhook.GetSubscription"
+
+	subscription, err := s.webhookRepository.GetWebhookSubscription(ctx, subscriptionId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+func (s *WebhookService) UpdateSubscription(ctx context.Context, subscriptionId uuid.UUID, input dto.UpdateWebhook) (*models.WebhookSubscription, error) {
+	const op = "webhook.UpdateSubscription"
+
+	subscription, err := s.webhookRepository.GetWebhookSubscription(ctx, subscriptionId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if input.URL != nil {
+		if err = checkWebhookURL(*input.URL); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		subscription.URL = *input.URL
+	}
+
+	if input.EventTypes != nil {
+		if err = checkWebhookEvents(*input.EventTypes); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		subscription.EventTypes = normalizeWebhookEvents(*input.EventTypes)
+	}
+
+	if input.Enabled != nil {
+		switch {
+		case *input.Enabled:
+			subscription.DisabledAt = nil
+			subscription.DisabledReason = nil
+			subscription.ConsecutiveFailures = 0
+		case subscription.DisabledAt == nil:
+			now, reason := time.Now(), "disabled by an administrator"
+			subscription.DisabledAt = &now
+			subscription.DisabledReason = &reason
+		}
+	}
+
+	if err = s.webhookRepository.SaveWebhookSubscription(ctx, subscription); err != nil {
+		s.log.Error("failed to save webhook subscription", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return subscription, nil
+}
+
+// RotateSecret gives a subscription a new signing secret. The old one stops working at once, so
+// the partner has to switch over before the next delivery.
+func (s *WebhookService) RotateSecret(ctx context.Context, subscriptionId uuid.UUID) (*models.WebhookSubscription, error) {
+	const op = "webhook.RotateSecret"
+
+	secret, err := webhooksig.NewSecret()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	subscription, err := s.webhookRepository.RotateWebhookSecret(ctx, subscriptionId, secret)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.Info("webhook secret rotated", slog.String("op", op), slog.String("subscription_id", subscriptionId.String()))
+
+	return subscription, nil
+}
+
+// DeleteSubscription removes a subscription together with its delivery log.
+func (s *WebhookService) DeleteSubscription(ctx context.Context, subscriptionId uuid.UUID) error {
+	const op = "webhook.DeleteSubscription"
+
+	if err := s.webhookRepository.DeleteWebhookSubscription(ctx, subscriptionId); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.Info("webhook subscription deleted", slog.String("op", op), slog.String("subscription_id", subscriptionId.String()))
+
+	return nil
+}
+
+// ListDeliveries returns a page of the delivery log of a subscription, newest first.
+func (s *WebhookService) ListDeliveries(ctx context.Context, subscriptionId uuid.UUID, after string, limit int) ([]models.WebhookDelivery, string, error) {
+	const op = "webhook.ListDeliveries"
+
+	c, err := cursor.Decode(after)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = s.webhookRepository.GetWebhookSubscription(ctx, subscriptionId); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, err := s.webhookRepository.ListWebhookDeliveries(ctx, subscriptionId, c, limit+1)
+	if err != nil {
+		s.log.Error("failed to list webhook deliveries", slog.String("op", op), sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	deliveries, next := paginate(deliveries, limit, func(d models.WebhookDelivery) (time.Time, uuid.UUID) {
+		return d.CreatedAt, d.ID
+	})
+
+	return deliveries, next, nil
+}
+
+// GetDelivery returns a delivery and every attempt made for it.
+func (s *WebhookService) GetDelivery(ctx context.Context, deliveryId uuid.UUID) (*models.WebhookDelivery, []models.WebhookAttempt, error) {
+	const op = "webhook.GetDelivery"
+
+	delivery, err := s.webhookRepository.GetWebhookDelivery(ctx, deliveryId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	attempts, err := s.webhookRepository.ListWebhookAttempts(ctx, deliveryId)
+	if err != nil {
+		s.log.Error("failed to list webhook attempts", slog.String("op", op), sl.Err(err))
+		return nil, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return delivery, attempts, nil
+}
+
+// Redeliver queues a delivery again with a fresh set of attempts, whatever its state.
+func (s *WebhookService) Redeliver(ctx context.Context, deliveryId uuid.UUID) (*models.WebhookDelivery, error) {
+	const op = "webhook.Redeliver"
+
+	delivery, err := s.webhookRepository.GetWebhookDelivery(ctx, deliveryId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	subscription, err := s.webhookRepository.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if subscription.DisabledAt != nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrWebhookDisabled)
+	}
+
+	delivery, err = s.webhookRepository.RedeliverWebhook(ctx, deliveryId)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.log.Info("webhook redelivery queued", slog.String("op", op), slog.String("delivery_id", deliveryId.String()))
+
+	return delivery, nil
+}
+
+// Publish queues a domain event for every subscription to its type. It makes the service a
+// broker.Broker, so the outbox relay hands events over in the same at-least-once way; an event
+// handed over twice is only delivered once.
+func (s *WebhookService) Publish(ctx context.Context, msg broker.Message) error {
+	const op = "webhook.Publish"
+
+	if !slices.Contains(models.WebhookEventTypes, msg.Type) {
+		return nil
+	}
+
+	eventId, err := uuid.Parse(msg.ID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = s.webhookRepository.EnqueueWebhookDeliveries(ctx, eventId, msg.Type, msg.Payload, msg.CreatedAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeliverPending sends the due deliveries, a batch at a time, until none is left.
+func (s *WebhookService) DeliverPending(ctx context.Context) error {
+	const op = "webhook.DeliverPending"
+
+	for ctx.Err() == nil {
+		deliveries, err := s.webhookRepository.ClaimWebhookDeliveries(ctx, s.batchSize, webhookLease)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.deliver(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) < s.batchSize {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// deliver makes one attempt of a delivery and records its outcome.
+func (s *WebhookService) deliver(ctx context.Context, delivery models.PendingWebhook) {
+	log := s.log.With(
+		slog.String("delivery_id", delivery.ID.String()),
+		slog.String("subscription_id", delivery.SubscriptionID.String()),
+		slog.Int("attempt", delivery.Attempts),
+	)
+
+	start := time.Now()
+	statusCode, err := s.send(ctx, delivery)
+
+	attempt := models.WebhookAttempt{
+		DeliveryID:  delivery.ID,
+		AttemptedAt: start,
+		DurationMs:  int(time.Since(start).Milliseconds()),
+	}
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+	}
+
+	if err == nil {
+		if err = s.webhookRepository.CompleteWebhookDelivery(ctx, delivery, attempt); err != nil {
+			log.Error("failed to record webhook delivery", sl.Err(err))
+		}
+		return
+	}
+
+	reason := err.Error()
+	attempt.Error = &reason
+
+	var nextAttemptAt *time.Time
+	if delivery.Attempts < s.maxAttempts {
+		next := time.Now().Add(backoff.Exponential(s.retryBackoff, delivery.Attempts, maxWebhookBackoff))
+		nextAttemptAt = &next
+	}
+
+	disabled, err := s.webhookRepository.FailWebhookDelivery(ctx, delivery, attempt, nextAttemptAt, s.disableAfter)
+	if err != nil {
+		log.Error("failed to record webhook failure", sl.Err(err))
+		return
+	}
+
+	log.Warn("webhook delivery failed", slog.String("reason", reason), slog.Bool("final", nextAttemptAt == nil))
+
+	if disabled {
+		log.Warn("webhook subscription disabled after repeated failures")
+	}
+}
+
+// send posts the signed event to the subscription. Any status outside 2xx is a failure.
+func (s *WebhookService) send(ctx context.Context, delivery models.PendingWebhook) (int, error) {
+	body, err := json.Marshal(struct {
+		ID        uuid.UUID       `json:"id"`
+		Type      string          `json:"type"`
+		CreatedAt time.Time       `json:"created_at"`
+		Data      json.RawMessage `json:"data"`
+	}{
+		ID:        delivery.EventID,
+		Type:      delivery.EventType,
+		CreatedAt: delivery.EventCreatedAt,
+		Data:      delivery.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", webhookUserAgent)
+	req.Header.Set("X-Webhook-Id", delivery.EventID.String())
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set(webhooksig.HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhooksig.HeaderSignature, webhooksig.Sign(delivery.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%w: %d", ErrUnexpectedStatusCode, resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func checkWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+
+	return nil
+}
+
+func checkWebhookEvents(eventTypes []string) error {
+	if len(eventTypes) == 0 {
+		return ErrNoWebhookEvents
+	}
+
+	for _, eventType := range eventTypes {
+		if !slices.Contains(models.WebhookEventTypes, eventType) {
+			return fmt.Errorf("%w: %s", ErrUnknownWebhookEvent, eventType)
+		}
+	}
+
+	return nil
+}
+
+// normalizeWebhookEvents returns a sorted copy of eventTypes without duplicates.
+func normalizeWebhookEvents(eventTypes []string) []string {
+	normalized := slices.Clone(eventTypes)
+	slices.Sort(normalized)
+
+	return slices.Compact(normalized)
+}
@@ -0,0 +1,125 @@
+package workers
+
+import (
+	"boton-back/internal/lib/logger/sl"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Task is a unit of background work run periodically by the Runner.
+type Task func(ctx context.Context) error
+
+type periodic struct {
+	name     string
+	interval time.Duration
+	task     Task
+}
+
+// maxRestartDelay caps the backoff between restarts of a failing long-running task.
+const maxRestartDelay = 30 * time.Second
+
+// Runner runs periodic and long-running background tasks next to the HTTP server.
+type Runner struct {
+	log         *slog.Logger
+	tasks       []periodic
+	longRunning []periodic
+	wg          sync.WaitGroup
+	cancel      context.CancelFunc
+}
+
+func NewRunner(log *slog.Logger) *Runner {
+	return &Runner{log: log}
+}
+
+// Every registers task to be run each interval. It must be called before Start.
+func (r *Runner) Every(name string, interval time.Duration, task Task) {
+	r.tasks = append(r.tasks, periodic{name: name, interval: interval, task: task})
+}
+
+// Run registers a task that runs until its context is cancelled. It is restarted with a growing
+// delay whenever it returns early. It must be called before Start.
+func (r *Runner) Run(name string, task Task) {
+	r.longRunning = append(r.longRunning, periodic{name: name, task: task})
+}
+
+// Start launches all registered tasks. It does not block.
+func (r *Runner) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	for _, p := range r.tasks {
+		r.wg.Add(1)
+		go r.loop(ctx, p)
+	}
+
+	for _, p := range r.longRunning {
+		r.wg.Add(1)
+		go r.keepRunning(ctx, p)
+	}
+
+	r.log.Info("workers started", slog.Int("tasks", len(r.tasks)+len(r.longRunning)))
+}
+
+// Stop cancels all tasks and waits for the running ones to return.
+func (r *Runner) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+
+	r.log.Info("workers stopped")
+}
+
+func (r *Runner) loop(ctx context.Context, p periodic) {
+	defer r.wg.Done()
+
+	log := r.log.With(slog.String("task", p.name))
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.task(ctx); err != nil && ctx.Err() == nil {
+				log.Error("task failed", sl.Err(err))
+			}
+		}
+	}
+}
+
+func (r *Runner) keepRunning(ctx context.Context, p periodic) {
+	defer r.wg.Done()
+
+	log := r.log.With(slog.String("task", p.name))
+
+	delay := time.Second
+	for {
+		started := time.Now()
+
+		err := p.task(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			log.Error("task failed", sl.Err(err))
+		}
+
+		// a task that ran for a while before failing starts over with a short delay
+		if time.Since(started) > maxRestartDelay {
+			delay = time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
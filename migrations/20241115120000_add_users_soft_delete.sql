@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN deleted_at  TIMESTAMP NULL,
+    ADD COLUMN purge_after TIMESTAMP NULL;
+
+-- usernames of deleted accounts may be released, so uniqueness only holds among active users
+ALTER TABLE users DROP CONSTRAINT users_username_key;
+CREATE UNIQUE INDEX idx_users_username_active ON users (username) WHERE deleted_at IS NULL;
+CREATE INDEX idx_users_username ON users (username);
+
+CREATE INDEX idx_users_purge_after ON users (purge_after) WHERE purge_after IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_users_purge_after;
+DROP INDEX IF EXISTS idx_users_username;
+DROP INDEX IF EXISTS idx_users_username_active;
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
+
+ALTER TABLE users
+    DROP COLUMN purge_after,
+    DROP COLUMN deleted_at;
+-- +goose StatementEnd
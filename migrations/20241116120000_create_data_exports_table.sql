@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE data_exports
+(
+    id           UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    user_id      UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    status       VARCHAR(16) NOT NULL DEFAULT 'pending',
+    blob_key     VARCHAR(255) NULL,
+    error        TEXT        NULL,
+    created_at   TIMESTAMP   NOT NULL DEFAULT NOW(),
+    started_at   TIMESTAMP   NULL,
+    completed_at TIMESTAMP   NULL,
+    expires_at   TIMESTAMP   NULL
+);
+
+CREATE INDEX idx_data_exports_user_id ON data_exports (user_id, created_at DESC);
+CREATE INDEX idx_data_exports_status ON data_exports (status, created_at);
+-- one export at a time per user
+CREATE UNIQUE INDEX idx_data_exports_user_active ON data_exports (user_id) WHERE status IN ('pending', 'processing');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS data_exports;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE user_profiles
+(
+    user_id             UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    display_name        VARCHAR(50)  NULL,
+    bio                 VARCHAR(500) NULL,
+    avatar_url          VARCHAR(512) NULL,
+    locale              VARCHAR(16)  NULL,
+    timezone            VARCHAR(64)  NULL,
+    birthday            DATE         NULL,
+    birthday_visibility VARCHAR(16)  NOT NULL DEFAULT 'private',
+    created_at          TIMESTAMP    NOT NULL DEFAULT NOW(),
+    updated_at          TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER set_updated_at
+    BEFORE UPDATE
+    ON user_profiles
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_profiles;
+-- +goose StatementEnd
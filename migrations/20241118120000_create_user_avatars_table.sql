@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE user_avatars
+(
+    user_id      UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    version      VARCHAR(32) NOT NULL,
+    content_type VARCHAR(32) NOT NULL,
+    created_at   TIMESTAMP   NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER set_updated_at
+    BEFORE UPDATE
+    ON user_avatars
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_avatars;
+-- +goose StatementEnd
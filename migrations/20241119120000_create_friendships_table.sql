@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE friendships
+(
+    id           UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    requester_id UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    addressee_id UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    state        VARCHAR(16) NOT NULL DEFAULT 'pending',
+    created_at   TIMESTAMP   NOT NULL DEFAULT NOW(),
+    responded_at TIMESTAMP   NULL,
+    CHECK (requester_id <> addressee_id),
+    CHECK (state IN ('pending', 'accepted'))
+);
+
+-- at most one friendship or request per pair of users, whoever sent it
+CREATE UNIQUE INDEX idx_friendships_pair ON friendships (LEAST(requester_id, addressee_id), GREATEST(requester_id, addressee_id));
+CREATE INDEX idx_friendships_requester ON friendships (requester_id, state);
+CREATE INDEX idx_friendships_addressee ON friendships (addressee_id, state);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS friendships;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE user_blocks
+(
+    blocker_id UUID      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    blocked_id UUID      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX idx_user_blocks_blocked ON user_blocks (blocked_id);
+
+CREATE TABLE user_privacy_settings
+(
+    user_id                UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    friend_requests_from   VARCHAR(32) NOT NULL DEFAULT 'everyone',
+    friend_list_visibility VARCHAR(16) NOT NULL DEFAULT 'friends',
+    created_at             TIMESTAMP   NOT NULL DEFAULT NOW(),
+    updated_at             TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER set_updated_at
+    BEFORE UPDATE
+    ON user_privacy_settings
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_privacy_settings;
+DROP TABLE IF EXISTS user_blocks;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- covering indexes so friend-of-friend walks for mutual friends and suggestions are index-only scans
+CREATE INDEX idx_friendships_accepted_requester ON friendships (requester_id) INCLUDE (addressee_id) WHERE state = 'accepted';
+CREATE INDEX idx_friendships_accepted_addressee ON friendships (addressee_id) INCLUDE (requester_id) WHERE state = 'accepted';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_friendships_accepted_requester;
+DROP INDEX IF EXISTS idx_friendships_accepted_addressee;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- trigram indexes serve both fuzzy (%) and prefix (ILIKE) matching
+CREATE INDEX idx_users_username_trgm ON users USING GIN (username gin_trgm_ops) WHERE deleted_at IS NULL;
+CREATE INDEX idx_user_profiles_display_name_trgm ON user_profiles USING GIN (display_name gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_user_profiles_display_name_trgm;
+DROP INDEX IF EXISTS idx_users_username_trgm;
+-- +goose StatementEnd
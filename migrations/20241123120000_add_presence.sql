@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN last_seen_at TIMESTAMP NULL;
+
+ALTER TABLE user_privacy_settings
+    ADD COLUMN hide_online_status BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE user_privacy_settings
+    DROP COLUMN IF EXISTS hide_online_status;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS last_seen_at;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE notifications
+(
+    id         UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type       VARCHAR(32) NOT NULL,
+    data       JSONB       NOT NULL DEFAULT '{}',
+    read_at    TIMESTAMP   NULL,
+    created_at TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_notifications_user_id ON notifications (user_id, created_at DESC, id DESC);
+CREATE INDEX idx_notifications_user_unread ON notifications (user_id, created_at DESC, id DESC) WHERE read_at IS NULL;
+CREATE INDEX idx_notifications_created_at ON notifications (created_at);
+
+-- types without a row are enabled
+CREATE TABLE notification_preferences
+(
+    user_id UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type    VARCHAR(32) NOT NULL,
+    enabled BOOLEAN     NOT NULL,
+    PRIMARY KEY (user_id, type)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS notifications;
+-- +goose StatementEnd
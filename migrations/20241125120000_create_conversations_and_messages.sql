@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE conversations
+(
+    id         UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    user_low   UUID      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_high  UUID      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (user_low < user_high),
+    UNIQUE (user_low, user_high)
+);
+
+-- one row per side of a conversation; last_message_at is copied here so the inbox of a user is one index scan
+CREATE TABLE conversation_members
+(
+    conversation_id UUID      NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+    user_id         UUID      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    last_message_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_read_at    TIMESTAMP NULL,
+    muted           BOOLEAN   NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX idx_conversation_members_inbox ON conversation_members (user_id, last_message_at DESC, conversation_id DESC);
+
+CREATE TABLE messages
+(
+    id              UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    conversation_id UUID      NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+    sender_id       UUID      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    body            TEXT      NOT NULL,
+    created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+    edited_at       TIMESTAMP NULL,
+    deleted_at      TIMESTAMP NULL
+);
+
+CREATE INDEX idx_messages_conversation_created_at ON messages (conversation_id, created_at DESC, id DESC);
+CREATE INDEX idx_messages_sender_id ON messages (sender_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversation_members;
+DROP TABLE IF EXISTS conversations;
+-- +goose StatementEnd
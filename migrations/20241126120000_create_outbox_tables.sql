@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+-- domain events written in the same transaction as the change they describe, deleted once published
+CREATE TABLE outbox_events
+(
+    id              UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    type            VARCHAR(64) NOT NULL,
+    payload         JSONB       NOT NULL,
+    created_at      TIMESTAMP   NOT NULL DEFAULT NOW(),
+    attempts        INT         NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP   NOT NULL DEFAULT NOW(),
+    last_error      TEXT        NULL
+);
+
+CREATE INDEX idx_outbox_events_next_attempt_at ON outbox_events (next_attempt_at);
+
+CREATE TABLE outbox_dead_letters
+(
+    id         UUID PRIMARY KEY,
+    type       VARCHAR(64) NOT NULL,
+    payload    JSONB       NOT NULL,
+    created_at TIMESTAMP   NOT NULL,
+    attempts   INT         NOT NULL,
+    last_error TEXT        NULL,
+    failed_at  TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS outbox_dead_letters;
+DROP TABLE IF EXISTS outbox_events;
+-- +goose StatementEnd
@@ -0,0 +1,57 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE webhook_subscriptions
+(
+    id                   UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    url                  TEXT      NOT NULL,
+    secret               TEXT      NOT NULL,
+    event_types          TEXT[]    NOT NULL,
+    consecutive_failures INT       NOT NULL DEFAULT 0,
+    disabled_at          TIMESTAMP NULL,
+    disabled_reason      TEXT      NULL,
+    created_at           TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at           TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE webhook_deliveries
+(
+    id               UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    subscription_id  UUID        NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id         UUID        NOT NULL,
+    event_type       VARCHAR(64) NOT NULL,
+    payload          JSONB       NOT NULL,
+    event_created_at TIMESTAMP   NOT NULL,
+    state            VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts         INT         NOT NULL DEFAULT 0,
+    next_attempt_at  TIMESTAMP   NOT NULL DEFAULT NOW(),
+    last_status_code INT         NULL,
+    last_error       TEXT        NULL,
+    created_at       TIMESTAMP   NOT NULL DEFAULT NOW(),
+    delivered_at     TIMESTAMP   NULL,
+    CHECK (state IN ('pending', 'delivered', 'failed')),
+    -- an event relayed twice is delivered once
+    UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE state = 'pending';
+CREATE INDEX idx_webhook_deliveries_subscription ON webhook_deliveries (subscription_id, created_at DESC, id DESC);
+
+CREATE TABLE webhook_delivery_attempts
+(
+    id           UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    delivery_id  UUID      NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    attempted_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    status_code  INT       NULL,
+    error        TEXT      NULL,
+    duration_ms  INT       NOT NULL
+);
+
+CREATE INDEX idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts (delivery_id, attempted_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
+-- +goose StatementEnd
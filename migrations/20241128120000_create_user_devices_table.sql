@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a device is a browser family signing in from a network; a new pair raises a new device alert
+CREATE TABLE user_devices
+(
+    id                UUID PRIMARY KEY          DEFAULT gen_random_uuid(),
+    user_id           UUID             NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent_family VARCHAR(64)      NOT NULL,
+    ip_prefix         VARCHAR(64)      NOT NULL,
+    last_ip           VARCHAR(64)      NOT NULL,
+    country           VARCHAR(8)       NULL,
+    region            VARCHAR(128)     NULL,
+    latitude          DOUBLE PRECISION NULL,
+    longitude         DOUBLE PRECISION NULL,
+    first_seen_at     TIMESTAMP        NOT NULL DEFAULT NOW(),
+    last_seen_at      TIMESTAMP        NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, user_agent_family, ip_prefix)
+);
+
+CREATE INDEX idx_user_devices_user_id ON user_devices (user_id, last_seen_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_devices;
+-- +goose StatementEnd
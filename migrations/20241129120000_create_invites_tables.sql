@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+-- invites created by admins have no creator
+CREATE TABLE invites
+(
+    id         UUID PRIMARY KEY    DEFAULT gen_random_uuid(),
+    code       VARCHAR(32)  NOT NULL UNIQUE,
+    created_by UUID         NULL REFERENCES users (id) ON DELETE SET NULL,
+    email      VARCHAR(255) NULL,
+    max_uses   INT          NOT NULL DEFAULT 1,
+    uses       INT          NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP    NULL,
+    revoked_at TIMESTAMP    NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT NOW(),
+    CHECK (uses <= max_uses)
+);
+
+CREATE INDEX idx_invites_created_by ON invites (created_by, created_at DESC);
+
+-- who registered with which invite, and so who invited whom
+CREATE TABLE invite_redemptions
+(
+    user_id     UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    invite_id   UUID      NOT NULL REFERENCES invites (id) ON DELETE CASCADE,
+    redeemed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_invite_redemptions_invite_id ON invite_redemptions (invite_id, redeemed_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS invite_redemptions;
+DROP TABLE IF EXISTS invites;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- cleared whenever the email changes
+ALTER TABLE users
+    ADD COLUMN email_verified_at TIMESTAMP NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+    DROP COLUMN email_verified_at;
+-- +goose StatementEnd
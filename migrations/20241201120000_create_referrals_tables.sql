@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE referral_codes
+(
+    user_id    UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    code       VARCHAR(32) NOT NULL UNIQUE,
+    created_at TIMESTAMP   NOT NULL DEFAULT NOW()
+);
+
+-- the device a referee registered from is kept to review suspected self-referrals
+CREATE TABLE referrals
+(
+    id                UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    referrer_id       UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    referee_id        UUID        NOT NULL UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+    status            VARCHAR(16) NOT NULL DEFAULT 'pending',
+    reason            VARCHAR(32) NULL,
+    reward            BIGINT      NOT NULL DEFAULT 0,
+    user_agent_family VARCHAR(64) NOT NULL,
+    ip_prefix         VARCHAR(64) NOT NULL,
+    created_at        TIMESTAMP   NOT NULL DEFAULT NOW(),
+    resolved_at       TIMESTAMP   NULL,
+    CHECK (referrer_id <> referee_id),
+    CHECK (status IN ('pending', 'credited', 'rejected'))
+);
+
+CREATE INDEX idx_referrals_referrer_id ON referrals (referrer_id, created_at DESC);
+CREATE INDEX idx_referrals_pending ON referrals (created_at) WHERE status = 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS referrals;
+DROP TABLE IF EXISTS referral_codes;
+-- +goose StatementEnd
@@ -0,0 +1,75 @@
+-- +goose Up
+-- +goose StatementBegin
+-- an account holds the funds of a user, or of the system on the other side of their entries,
+-- in one currency. The balance is the sum of its entries, kept with them in the same transaction.
+CREATE TABLE ledger_accounts
+(
+    id          UUID PRIMARY KEY     DEFAULT gen_random_uuid(),
+    user_id     UUID        NULL REFERENCES users (id) ON DELETE SET NULL,
+    system_code VARCHAR(32) NULL,
+    currency    CHAR(3)     NOT NULL,
+    balance     BIGINT      NOT NULL DEFAULT 0,
+    created_at  TIMESTAMP   NOT NULL DEFAULT NOW(),
+    CHECK (user_id IS NULL OR system_code IS NULL),
+    -- only system accounts may go negative
+    CHECK (system_code IS NOT NULL OR balance >= 0)
+);
+
+CREATE UNIQUE INDEX idx_ledger_accounts_user ON ledger_accounts (user_id, currency) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_ledger_accounts_system ON ledger_accounts (system_code, currency) WHERE system_code IS NOT NULL;
+
+CREATE TABLE ledger_transactions
+(
+    id              UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
+    idempotency_key VARCHAR(128) NOT NULL UNIQUE,
+    request_hash    VARCHAR(64)  NOT NULL,
+    kind            VARCHAR(32)  NOT NULL,
+    description     VARCHAR(255) NOT NULL DEFAULT '',
+    created_at      TIMESTAMP    NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE ledger_entries
+(
+    id             UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    transaction_id UUID      NOT NULL REFERENCES ledger_transactions (id),
+    account_id     UUID      NOT NULL REFERENCES ledger_accounts (id),
+    amount         BIGINT    NOT NULL,
+    currency       CHAR(3)   NOT NULL,
+    balance_after  BIGINT    NOT NULL,
+    created_at     TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (amount <> 0)
+);
+
+CREATE INDEX idx_ledger_entries_transaction_id ON ledger_entries (transaction_id);
+CREATE INDEX idx_ledger_entries_account_id ON ledger_entries (account_id, created_at DESC, id DESC);
+
+-- the journal is append-only: mistakes are corrected by compensating transactions
+CREATE
+OR REPLACE FUNCTION reject_ledger_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger journal is append-only';
+END;
+$$
+LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_transactions_immutable
+    BEFORE UPDATE OR DELETE
+    ON ledger_transactions
+    FOR EACH ROW
+    EXECUTE FUNCTION reject_ledger_change();
+
+CREATE TRIGGER ledger_entries_immutable
+    BEFORE UPDATE OR DELETE
+    ON ledger_entries
+    FOR EACH ROW
+    EXECUTE FUNCTION reject_ledger_change();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS ledger_transactions;
+DROP TABLE IF EXISTS ledger_accounts;
+DROP FUNCTION IF EXISTS reject_ledger_change();
+-- +goose StatementEnd
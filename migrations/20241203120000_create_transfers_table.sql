@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a transfer keeps its parties after they delete their accounts, like the ledger it is posted to
+CREATE TABLE transfers
+(
+    id                      UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
+    sender_id               UUID         NULL REFERENCES users (id) ON DELETE SET NULL,
+    recipient_id            UUID         NULL REFERENCES users (id) ON DELETE SET NULL,
+    amount                  BIGINT       NOT NULL,
+    currency                CHAR(3)      NOT NULL,
+    note                    VARCHAR(140) NOT NULL DEFAULT '',
+    status                  VARCHAR(16)  NOT NULL DEFAULT 'completed',
+    idempotency_key         VARCHAR(128) NOT NULL,
+    transaction_id          UUID         NOT NULL REFERENCES ledger_transactions (id),
+    reversal_transaction_id UUID         NULL REFERENCES ledger_transactions (id),
+    created_at              TIMESTAMP    NOT NULL DEFAULT NOW(),
+    reversed_at             TIMESTAMP    NULL,
+    CHECK (amount > 0),
+    CHECK (status IN ('completed', 'reversed'))
+);
+
+CREATE UNIQUE INDEX idx_transfers_idempotency_key ON transfers (sender_id, idempotency_key);
+CREATE INDEX idx_transfers_sender_id ON transfers (sender_id, created_at DESC);
+CREATE INDEX idx_transfers_recipient_id ON transfers (recipient_id, created_at DESC);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS transfers;
+-- +goose StatementEnd
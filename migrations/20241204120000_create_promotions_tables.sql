@@ -0,0 +1,50 @@
+-- +goose Up
+-- +goose StatementBegin
+-- value is a percentage for percent codes and minor units of currency for fixed ones; min_spend
+-- is the smallest payment, in currency, the discount applies to
+CREATE TABLE promo_codes
+(
+    id              UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
+    code            VARCHAR(32)  NOT NULL UNIQUE,
+    kind            VARCHAR(16)  NOT NULL,
+    value           BIGINT       NOT NULL,
+    currency        CHAR(3)      NOT NULL,
+    min_spend       BIGINT       NOT NULL DEFAULT 0,
+    new_users_only  BOOLEAN      NOT NULL DEFAULT FALSE,
+    starts_at       TIMESTAMP    NULL,
+    ends_at         TIMESTAMP    NULL,
+    max_redemptions INT          NULL,
+    per_user_limit  INT          NOT NULL DEFAULT 1,
+    redemptions     INT          NOT NULL DEFAULT 0,
+    description     VARCHAR(255) NOT NULL DEFAULT '',
+    created_at      TIMESTAMP    NOT NULL DEFAULT NOW(),
+    disabled_at     TIMESTAMP    NULL,
+    CHECK (kind IN ('percent', 'fixed')),
+    CHECK (value > 0 AND (kind <> 'percent' OR value <= 100)),
+    CHECK (min_spend >= 0),
+    CHECK (max_redemptions IS NULL OR redemptions <= max_redemptions),
+    CHECK (per_user_limit > 0)
+);
+
+CREATE INDEX idx_promo_codes_created_at ON promo_codes (created_at DESC, id DESC);
+
+-- a redemption is the discount of a user until a payment uses it
+CREATE TABLE promo_redemptions
+(
+    id          UUID PRIMARY KEY   DEFAULT gen_random_uuid(),
+    promo_id    UUID      NOT NULL REFERENCES promo_codes (id),
+    user_id     UUID      NULL REFERENCES users (id) ON DELETE SET NULL,
+    redeemed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    used_at     TIMESTAMP NULL
+);
+
+CREATE INDEX idx_promo_redemptions_promo_id ON promo_redemptions (promo_id, redeemed_at DESC, id DESC);
+CREATE INDEX idx_promo_redemptions_user_id ON promo_redemptions (user_id, promo_id);
+CREATE INDEX idx_promo_redemptions_unused ON promo_redemptions (user_id) WHERE used_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS promo_redemptions;
+DROP TABLE IF EXISTS promo_codes;
+-- +goose StatementEnd
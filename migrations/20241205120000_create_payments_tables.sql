@@ -0,0 +1,59 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a payment keeps its user after they delete their account, like the ledger it is posted to;
+-- the user pays charge, which is amount less the discount of the redemption
+CREATE TABLE payments
+(
+    id                      UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
+    user_id                 UUID         NULL REFERENCES users (id) ON DELETE SET NULL,
+    provider                VARCHAR(32)  NOT NULL,
+    invoice_id              VARCHAR(128) NULL,
+    checkout_url            TEXT         NULL,
+    amount                  BIGINT       NOT NULL,
+    discount                BIGINT       NOT NULL DEFAULT 0,
+    charge                  BIGINT       NOT NULL,
+    currency                CHAR(3)      NOT NULL,
+    status                  VARCHAR(16)  NOT NULL DEFAULT 'pending',
+    idempotency_key         VARCHAR(128) NOT NULL,
+    redemption_id           UUID         NULL REFERENCES promo_redemptions (id),
+    transaction_id          UUID         NULL REFERENCES ledger_transactions (id),
+    reversal_transaction_id UUID         NULL REFERENCES ledger_transactions (id),
+    created_at              TIMESTAMP    NOT NULL DEFAULT NOW(),
+    updated_at              TIMESTAMP    NOT NULL DEFAULT NOW(),
+    paid_at                 TIMESTAMP    NULL,
+    reversed_at             TIMESTAMP    NULL,
+    CHECK (amount > 0),
+    CHECK (discount >= 0 AND charge = amount - discount AND charge > 0),
+    CHECK (status IN ('pending', 'succeeded', 'failed', 'refunded', 'charged_back'))
+);
+
+CREATE UNIQUE INDEX idx_payments_idempotency_key ON payments (user_id, idempotency_key);
+CREATE UNIQUE INDEX idx_payments_invoice_id ON payments (provider, invoice_id);
+CREATE INDEX idx_payments_user_id ON payments (user_id, created_at DESC, id DESC);
+CREATE INDEX idx_payments_pending ON payments (created_at) WHERE status = 'pending';
+-- a discount is held by one payment at a time; a failed payment keeps its redemption but
+-- releases it, in case it succeeds late
+CREATE UNIQUE INDEX idx_payments_redemption_id ON payments (redemption_id)
+    WHERE redemption_id IS NOT NULL AND status <> 'failed';
+
+-- webhook events a provider delivered, so a redelivered event is applied once
+CREATE TABLE payment_events
+(
+    id          UUID PRIMARY KEY      DEFAULT gen_random_uuid(),
+    provider    VARCHAR(32)  NOT NULL,
+    event_id    VARCHAR(128) NOT NULL,
+    payment_id  UUID         NOT NULL REFERENCES payments (id),
+    status      VARCHAR(16)  NOT NULL,
+    payload     JSONB        NULL,
+    received_at TIMESTAMP    NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, event_id)
+);
+
+CREATE INDEX idx_payment_events_payment_id ON payment_events (payment_id, received_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS payment_events;
+DROP TABLE IF EXISTS payments;
+-- +goose StatementEnd